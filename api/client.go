@@ -17,12 +17,15 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
 
 	"github.com/ollama/ollama/envconfig"
@@ -35,6 +38,9 @@ import (
 type Client struct {
 	base *url.URL
 	http *http.Client
+
+	// apiKey is sent as a bearer token if it's set
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -61,14 +67,77 @@ func checkError(resp *http.Response, body []byte) error {
 //	<scheme>://<host>:<port>
 //
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. If OLLAMA_API_KEY is set, it's sent to the server as a bearer token.
+//
+// For servers using https, OLLAMA_TLS_CA names a PEM file of CA certificates
+// to trust in addition to the system's, and OLLAMA_TLS_CLIENT_CERT and
+// OLLAMA_TLS_CLIENT_KEY name a PEM certificate and key to present to servers
+// that require client certificates.
 func ClientFromEnvironment() (*Client, error) {
+	client := http.DefaultClient
+
+	tlsConfig, err := tlsConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client = &http.Client{Transport: transport}
+	}
+
 	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
+		base:   envconfig.Host(),
+		http:   client,
+		apiKey: envconfig.APIKey(),
 	}, nil
 }
 
+// tlsConfigFromEnvironment returns the TLS config set by OLLAMA_TLS_CA,
+// OLLAMA_TLS_CLIENT_CERT and OLLAMA_TLS_CLIENT_KEY, or nil if none are set
+func tlsConfigFromEnvironment() (*tls.Config, error) {
+	ca, cert, key := envconfig.TLSCA(), envconfig.TLSClientCert(), envconfig.TLSClientKey()
+	if ca == "" && cert == "" && key == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		b, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", ca)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("both OLLAMA_TLS_CLIENT_CERT and OLLAMA_TLS_CLIENT_KEY are required")
+		}
+
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
+
 func NewClient(base *url.URL, http *http.Client) *Client {
 	return &Client{
 		base: base,
@@ -105,6 +174,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -151,6 +223,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -316,6 +391,42 @@ func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
 	return nil
 }
 
+// Alias creates an alias which resolves to another model at request time, or
+// points an existing alias at a different model.
+func (c *Client) Alias(ctx context.Context, req *AliasRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/alias", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteAlias deletes an alias. The model it points to is not affected.
+func (c *Client) DeleteAlias(ctx context.Context, req *AliasRequest) error {
+	if err := c.do(ctx, http.MethodDelete, "/api/alias", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Diff compares two local models layer by layer.
+func (c *Client) Diff(ctx context.Context, req *DiffRequest) (*DiffResponse, error) {
+	var resp DiffResponse
+	if err := c.do(ctx, http.MethodPost, "/api/diff", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RenderTemplate renders a template exactly as a chat or generate request
+// would, without generating a response.
+func (c *Client) RenderTemplate(ctx context.Context, req *TemplateRenderRequest) (*TemplateRenderResponse, error) {
+	var resp TemplateRenderResponse
+	if err := c.do(ctx, http.MethodPost, "/api/template/render", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Delete deletes a model and its data.
 func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	if err := c.do(ctx, http.MethodDelete, "/api/delete", req, nil); err != nil {
@@ -324,6 +435,16 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// PausePull pauses all in-flight pulls of the model named in req. The
+// downloaded data is kept, and pulling the model again with [Client.Pull]
+// resumes from where it was paused.
+func (c *Client) PausePull(ctx context.Context, req *PullRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/pull/pause", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -2,13 +2,24 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestClientFromEnvironment(t *testing.T) {
@@ -253,3 +264,162 @@ func TestClientDo(t *testing.T) {
 		})
 	}
 }
+
+func TestClientAPIKey(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"version":"0.0.0"}`)
+	}))
+	defer ts.Close()
+
+	t.Setenv("OLLAMA_HOST", ts.URL)
+	t.Setenv("OLLAMA_API_KEY", "secret")
+
+	client, err := ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Version(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Heartbeat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.stream(context.Background(), http.MethodPost, "/api/chat", nil, func([]byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(got))
+	}
+
+	for _, h := range got {
+		if h != "Bearer secret" {
+			t.Errorf("expected bearer token, got %q", h)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	// a self-signed client certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "alice"}}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile, caFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%q}`, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_HOST", ts.URL)
+
+	t.Run("ca and client certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA", caFile)
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", certFile)
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", keyFile)
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version, err := client.Version(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != "alice" {
+			t.Errorf("expected the server to see client certificate alice, got %q", version)
+		}
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA", caFile)
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.Version(context.Background()); err == nil {
+			t.Error("expected an error without a client certificate")
+		}
+	})
+
+	t.Run("untrusted server", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", certFile)
+		t.Setenv("OLLAMA_TLS_CLIENT_KEY", keyFile)
+
+		client, err := ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.Version(context.Background()); err == nil {
+			t.Error("expected an error for a server signed by an unknown CA")
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CLIENT_CERT", certFile)
+
+		if _, err := ClientFromEnvironment(); err == nil || !strings.Contains(err.Error(), "OLLAMA_TLS_CLIENT_KEY") {
+			t.Errorf("expected an error for a missing key, got %v", err)
+		}
+	})
+
+	t.Run("invalid ca", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CA", keyFile)
+
+		if _, err := ClientFromEnvironment(); err == nil || !strings.Contains(err.Error(), "no PEM certificates found") {
+			t.Errorf("expected an error for an invalid CA, got %v", err)
+		}
+	})
+}
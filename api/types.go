@@ -279,17 +279,18 @@ type Options struct {
 
 // Runner options which must be set when the model is loaded into memory
 type Runner struct {
-	NumCtx    int   `json:"num_ctx,omitempty"`
-	NumBatch  int   `json:"num_batch,omitempty"`
-	NumGPU    int   `json:"num_gpu,omitempty"`
-	MainGPU   int   `json:"main_gpu,omitempty"`
-	LowVRAM   bool  `json:"low_vram,omitempty"`
-	F16KV     bool  `json:"f16_kv,omitempty"` // Deprecated: This option is ignored
-	LogitsAll bool  `json:"logits_all,omitempty"`
-	VocabOnly bool  `json:"vocab_only,omitempty"`
-	UseMMap   *bool `json:"use_mmap,omitempty"`
-	UseMLock  bool  `json:"use_mlock,omitempty"`
-	NumThread int   `json:"num_thread,omitempty"`
+	NumCtx      int   `json:"num_ctx,omitempty"`
+	NumBatch    int   `json:"num_batch,omitempty"`
+	NumGPU      int   `json:"num_gpu,omitempty"`
+	MainGPU     int   `json:"main_gpu,omitempty"`
+	LowVRAM     bool  `json:"low_vram,omitempty"`
+	F16KV       bool  `json:"f16_kv,omitempty"` // Deprecated: This option is ignored
+	LogitsAll   bool  `json:"logits_all,omitempty"`
+	VocabOnly   bool  `json:"vocab_only,omitempty"`
+	UseMMap     *bool `json:"use_mmap,omitempty"`
+	UseMLock    bool  `json:"use_mlock,omitempty"`
+	NumThread   int   `json:"num_thread,omitempty"`
+	NumParallel int   `json:"num_parallel,omitempty"` // 0 sizes it to the available memory
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -347,6 +348,15 @@ type CreateRequest struct {
 	Stream   *bool  `json:"stream,omitempty"`
 	Quantize string `json:"quantize,omitempty"`
 
+	// TensorTypes overrides the quantization type of the tensors matching
+	// each pattern, e.g. {"token_embd.weight": "Q8_0"}.
+	TensorTypes map[string]string `json:"tensor_types,omitempty"`
+
+	// Imatrix is the digest of a blob of calibration text. If set, the
+	// model is evaluated over it to weight quantization errors by the
+	// importance of each weight. It requires Quantize.
+	Imatrix string `json:"imatrix,omitempty"`
+
 	From       string            `json:"from,omitempty"`
 	Files      map[string]string `json:"files,omitempty"`
 	Adapters   map[string]string `json:"adapters,omitempty"`
@@ -356,6 +366,14 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// Tools are the default tools of the model, used by chat requests which
+	// don't specify any.
+	Tools Tools `json:"tools,omitempty"`
+
+	// Format is the default format of the model's responses, used by chat
+	// requests which don't specify one.
+	Format json.RawMessage `json:"format,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 	// Deprecated: use Quantize instead
@@ -407,6 +425,93 @@ type CopyRequest struct {
 	Destination string `json:"destination"`
 }
 
+// AliasRequest is the request passed to [Client.Alias] and
+// [Client.DeleteAlias].
+type AliasRequest struct {
+	Alias  string `json:"alias"`
+	Target string `json:"target,omitempty"`
+}
+
+// DiffRequest is the request passed to [Client.Diff].
+type DiffRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DiffResponse is the response returned by [Client.Diff]. Apart from Layers,
+// which lists every layer of both models, it only includes values which
+// differ between the two models.
+type DiffResponse struct {
+	From       string       `json:"from"`
+	To         string       `json:"to"`
+	Layers     []LayerDiff  `json:"layers"`
+	ModelInfo  []ValueDiff  `json:"model_info,omitempty"`
+	Tensors    []TensorDiff `json:"tensors,omitempty"`
+	Template   *ValueDiff   `json:"template,omitempty"`
+	System     *ValueDiff   `json:"system,omitempty"`
+	License    *ValueDiff   `json:"license,omitempty"`
+	Parameters []ValueDiff  `json:"parameters,omitempty"`
+}
+
+// LayerDiff compares a layer of one model with the layer of the same media
+// type, in the same position, of another model. Status is one of "added",
+// "removed", "changed" or "unchanged".
+type LayerDiff struct {
+	MediaType string `json:"media_type"`
+	Status    string `json:"status"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	FromSize  int64  `json:"from_size,omitempty"`
+	ToSize    int64  `json:"to_size,omitempty"`
+}
+
+// ValueDiff is a value which differs between two models. From or To is nil
+// if the value is only present in one of the models.
+type ValueDiff struct {
+	Key  string `json:"key"`
+	From any    `json:"from"`
+	To   any    `json:"to"`
+}
+
+// TensorDiff is a tensor whose type or shape differs between two models.
+// From or To is nil if the tensor is only present in one of the models.
+type TensorDiff struct {
+	Name string  `json:"name"`
+	From *Tensor `json:"from"`
+	To   *Tensor `json:"to"`
+}
+
+// TemplateRenderRequest is the request passed to [Client.RenderTemplate].
+// Template, if set, is rendered instead of the template of Model. Messages
+// are rendered like a chat request unless Prompt is set, in which case they
+// are rendered like a generate request.
+type TemplateRenderRequest struct {
+	Model    string    `json:"model,omitempty"`
+	Template string    `json:"template,omitempty"`
+	System   string    `json:"system,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+	Tools    `json:"tools,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	Suffix   string `json:"suffix,omitempty"`
+
+	// Options lists model-specific options, such as num_ctx, which affect
+	// how the messages are truncated
+	Options map[string]any `json:"options,omitempty"`
+
+	// KeepAlive controls how long the model stays loaded after tokenizing
+	// the prompt
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// TemplateRenderResponse is the response returned by [Client.RenderTemplate].
+// TokenCount is only set if the request names a model, which is loaded to
+// tokenize the prompt. Warnings are problems found by linting the template.
+type TemplateRenderResponse struct {
+	Prompt     string   `json:"prompt"`
+	TokenCount int      `json:"token_count,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
 // PullRequest is the request passed to [Client.Pull].
 type PullRequest struct {
 	Model    string `json:"model"`
@@ -415,6 +520,10 @@ type PullRequest struct {
 	Password string `json:"password"`           // Deprecated: ignored
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// LimitRate, if positive, limits the download rate of the pull in
+	// bytes per second.
+	LimitRate int64 `json:"limit_rate,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -426,6 +535,10 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// Reused is the number of bytes of Completed that were copied from
+	// blobs already present locally instead of being downloaded.
+	Reused int64 `json:"reused,omitempty"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -458,6 +571,14 @@ type ListModelResponse struct {
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
 	Details    ModelDetails `json:"details,omitempty"`
+
+	// Root is the models directory the model was found in.
+	Root string `json:"root,omitempty"`
+	// ReadOnly is true if Root is a read-only models directory.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Target is the model an alias points to. It is empty for models
+	// which aren't aliases.
+	Target string `json:"target,omitempty"`
 }
 
 // ProcessModelResponse is a single model description in [ProcessResponse].
@@ -469,6 +590,9 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+	Pinned    bool         `json:"pinned,omitempty"`
+	Slots     int          `json:"slots"`
+	SlotsUsed int          `json:"slots_used"`
 }
 
 type RetrieveModelResponse struct {
@@ -514,6 +638,22 @@ type ModelDetails struct {
 	Families          []string `json:"families"`
 	ParameterSize     string   `json:"parameter_size"`
 	QuantizationLevel string   `json:"quantization_level"`
+
+	// Imatrix describes the importance matrix the model was quantized
+	// with, if any.
+	Imatrix *ImatrixDetails `json:"imatrix,omitempty"`
+}
+
+// ImatrixDetails describes an importance matrix used for quantization.
+type ImatrixDetails struct {
+	// Digest is the digest of the importance matrix
+	Digest string `json:"digest"`
+
+	// Dataset is the digest of the calibration text it was computed over
+	Dataset string `json:"dataset"`
+
+	// Chunks is the number of chunks of the dataset which were evaluated
+	Chunks int `json:"chunks"`
 }
 
 // Tensor describes the metadata for a given tensor.
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -91,7 +93,21 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		defer f.Close()
 	}
 
-	modelfile, err := parser.ParseFile(reader)
+	buildArgs := make(map[string]string)
+	flagArgs, _ := cmd.Flags().GetStringArray("build-arg")
+	for _, arg := range flagArgs {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			// like docker, take the value from the environment
+			v, ok = os.LookupEnv(k)
+			if !ok {
+				return fmt.Errorf("build arg %q has no value", k)
+			}
+		}
+		buildArgs[k] = v
+	}
+
+	modelfile, err := parser.ParseFileWithOptions(reader, parser.ParseOptions{Filename: filename, Args: buildArgs})
 	if err != nil {
 		return err
 	}
@@ -112,6 +128,19 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Quantize = quantize
 	}
 
+	tensorTypes, _ := cmd.Flags().GetStringArray("tensor-type")
+	for _, tt := range tensorTypes {
+		pattern, kind, ok := strings.Cut(tt, "=")
+		if !ok || pattern == "" || kind == "" {
+			return fmt.Errorf("tensor type %q must be of the form PATTERN=TYPE", tt)
+		}
+
+		if req.TensorTypes == nil {
+			req.TensorTypes = make(map[string]string)
+		}
+		req.TensorTypes[pattern] = kind
+	}
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
@@ -139,6 +168,22 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Adapters = fileMap
 	}
 
+	if imatrix, _ := cmd.Flags().GetString("imatrix"); imatrix != "" {
+		if req.Quantize == "" {
+			return errors.New("--imatrix requires --quantize")
+		}
+
+		digest, err := digestForFile(imatrix)
+		if err != nil {
+			return err
+		}
+
+		if _, err := createBlob(cmd, client, imatrix, digest, p); err != nil {
+			return err
+		}
+		req.Imatrix = digest
+	}
+
 	bars := make(map[string]*progress.Bar)
 	fn := func(resp api.ProgressResponse) error {
 		if resp.Digest != "" {
@@ -149,6 +194,18 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				p.Add(resp.Digest, bar)
 			}
 
+			bar.Set(resp.Completed)
+		} else if resp.Total > 0 {
+			// quantization progress
+			bar, ok := bars[resp.Status]
+			if !ok {
+				spinner.Stop()
+
+				bar = progress.NewBar(resp.Status, resp.Total, resp.Completed)
+				bars[resp.Status] = bar
+				p.Add(resp.Status, bar)
+			}
+
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
 			spinner.Stop()
@@ -171,6 +228,21 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func digestForFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 func createBlob(cmd *cobra.Command, client *api.Client, path string, digest string, p *progress.Progress) (string, error) {
 	realPath, err := filepath.EvalSymlinks(path)
 	if err != nil {
@@ -514,17 +586,20 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 
 			var until string
 			delta := time.Since(m.ExpiresAt)
-			if delta > 0 {
+			if m.Pinned {
+				until = "Pinned"
+			} else if delta > 0 {
 				until = "Stopping..."
 			} else {
 				until = format.HumanTime(m.ExpiresAt, "Never")
 			}
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, until})
+			slots := fmt.Sprintf("%d/%d", m.SlotsUsed, m.Slots)
+			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), procStr, slots, until})
 		}
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"NAME", "ID", "SIZE", "PROCESSOR", "UNTIL"})
+	table.SetHeader([]string{"NAME", "ID", "SIZE", "PROCESSOR", "SLOTS", "UNTIL"})
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetHeaderLine(false)
@@ -672,6 +747,10 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 			rows = append(rows, []string{"", "parameters", resp.Details.ParameterSize})
 		}
 		rows = append(rows, []string{"", "quantization", resp.Details.QuantizationLevel})
+		if resp.Details.Imatrix != nil {
+			rows = append(rows, []string{"", "imatrix", resp.Details.Imatrix.Digest[7:19]})
+			rows = append(rows, []string{"", "imatrix dataset", resp.Details.Imatrix.Dataset[7:19]})
+		}
 		return
 	})
 
@@ -787,6 +866,258 @@ func CopyHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func DiffHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Diff(cmd.Context(), &api.DiffRequest{From: args[0], To: args[1]})
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	return showDiff(resp, os.Stdout)
+}
+
+func showDiff(resp *api.DiffResponse, w io.Writer) error {
+	tableRender := func(header string, rows [][]string) {
+		if len(rows) == 0 {
+			return
+		}
+
+		fmt.Fprintln(w, " ", header)
+		table := tablewriter.NewWriter(w)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("    ")
+		table.AppendBulk(rows)
+		table.Render()
+		fmt.Fprintln(w)
+	}
+
+	value := func(v any) string {
+		if v == nil {
+			return "(none)"
+		}
+		return fmt.Sprint(v)
+	}
+
+	digest := func(d string) string {
+		d = strings.TrimPrefix(d, "sha256:")
+		if len(d) > 12 {
+			d = d[:12]
+		}
+		return cmp.Or(d, "(none)")
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n\n", resp.From, resp.To)
+
+	var rows [][]string
+	for _, l := range resp.Layers {
+		rows = append(rows, []string{"", l.Status, strings.TrimPrefix(l.MediaType, "application/vnd.ollama.image."), digest(l.From), digest(l.To)})
+	}
+	tableRender("Layers", rows)
+
+	rows = nil
+	for _, d := range resp.ModelInfo {
+		rows = append(rows, []string{"", d.Key, value(d.From), value(d.To)})
+	}
+	tableRender("Metadata", rows)
+
+	rows = nil
+	for _, d := range resp.Tensors {
+		tensor := func(t *api.Tensor) string {
+			if t == nil {
+				return "(none)"
+			}
+			return fmt.Sprintf("%s %v", t.Type, t.Shape)
+		}
+		rows = append(rows, []string{"", d.Name, tensor(d.From), tensor(d.To)})
+	}
+	tableRender("Tensors", rows)
+
+	rows = nil
+	for _, d := range resp.Parameters {
+		rows = append(rows, []string{"", d.Key, value(d.From), value(d.To)})
+	}
+	tableRender("Parameters", rows)
+
+	for _, d := range []*api.ValueDiff{resp.Template, resp.System, resp.License} {
+		if d == nil {
+			continue
+		}
+
+		fmt.Fprintln(w, " ", strings.ToUpper(d.Key[:1])+d.Key[1:])
+		for _, v := range []struct {
+			prefix string
+			value  any
+		}{{"-", d.From}, {"+", d.To}} {
+			if v.value == nil {
+				continue
+			}
+			for _, line := range strings.Split(fmt.Sprint(v.value), "\n") {
+				fmt.Fprintf(w, "    %s %s\n", v.prefix, line)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+// lintMessages and lintTools exercise every part of a chat template
+var (
+	lintMessages = []api.Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "What's the weather in Paris?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "get_current_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris"}}},
+		}},
+		{Role: "tool", Content: "22 degrees and sunny"},
+		{Role: "assistant", Content: "It's 22 degrees and sunny in Paris."},
+		{Role: "user", Content: "Thanks!"},
+	}
+
+	lintTools = func() api.Tools {
+		var tool api.Tool
+		tool.Type = "function"
+		tool.Function.Name = "get_current_weather"
+		tool.Function.Description = "Get the current weather for a location"
+		tool.Function.Parameters.Type = "object"
+		tool.Function.Parameters.Required = []string{"location"}
+		tool.Function.Parameters.Properties = map[string]struct {
+			Type        api.PropertyType `json:"type"`
+			Items       any              `json:"items,omitempty"`
+			Description string           `json:"description"`
+			Enum        []any            `json:"enum,omitempty"`
+		}{
+			"location": {Type: api.PropertyType{"string"}, Description: "The city to get the weather for"},
+		}
+		return api.Tools{tool}
+	}()
+)
+
+func TemplateLintHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	filename, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return err
+	}
+
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return err
+	}
+
+	var tmpl string
+	switch {
+	case filename != "" && len(args) > 0:
+		return errors.New("specify a model or a template file, not both")
+	case filename != "":
+		bts, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		tmpl = string(bts)
+	case len(args) == 1:
+		resp, err := client.Show(cmd.Context(), &api.ShowRequest{Name: args[0]})
+		if err != nil {
+			return err
+		}
+		tmpl = resp.Template
+	default:
+		return errors.New("a model or a template file is required")
+	}
+
+	if tmpl == "" {
+		return errors.New("the model has no template")
+	}
+
+	resp, err := client.RenderTemplate(cmd.Context(), &api.TemplateRenderRequest{
+		Template: tmpl,
+		Messages: lintMessages,
+		Tools:    lintTools,
+	})
+	if err != nil {
+		return fmt.Errorf("template can't be rendered: %w", err)
+	}
+
+	return showLint(resp, verbose, os.Stdout)
+}
+
+func showLint(resp *api.TemplateRenderResponse, verbose bool, w io.Writer) error {
+	if verbose {
+		fmt.Fprintln(w, "Rendered prompt:")
+		for _, line := range strings.Split(resp.Prompt, "\n") {
+			fmt.Fprintln(w, "   ", line)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(resp.Warnings) == 0 {
+		fmt.Fprintln(w, "no issues found")
+		return nil
+	}
+
+	for _, warning := range resp.Warnings {
+		fmt.Fprintln(w, "warning:", warning)
+	}
+
+	return fmt.Errorf("found %d issues", len(resp.Warnings))
+}
+
+func AliasHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	del, err := cmd.Flags().GetBool("delete")
+	if err != nil {
+		return err
+	}
+
+	if del {
+		if len(args) != 1 {
+			return errors.New("--delete takes exactly one alias")
+		}
+		req := api.AliasRequest{Alias: args[0]}
+		if err := client.DeleteAlias(cmd.Context(), &req); err != nil {
+			return err
+		}
+		fmt.Printf("deleted alias '%s'\n", args[0])
+		return nil
+	}
+
+	if len(args) != 2 {
+		return errors.New("an alias and a target model are required")
+	}
+
+	req := api.AliasRequest{Alias: args[0], Target: args[1]}
+	if err := client.Alias(cmd.Context(), &req); err != nil {
+		return err
+	}
+	fmt.Printf("'%s' now points to '%s'\n", args[0], args[1])
+	return nil
+}
+
 func PullHandler(cmd *cobra.Command, args []string) error {
 	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
@@ -859,6 +1190,12 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 	}
 
 	request := api.PullRequest{Name: args[0], Insecure: insecure}
+	if limitRate, _ := cmd.Flags().GetString("limit-rate"); limitRate != "" {
+		request.LimitRate, err = format.ParseBytes(limitRate)
+		if err != nil {
+			return fmt.Errorf("invalid --limit-rate: %w", err)
+		}
+	}
 	return client.Pull(cmd.Context(), &request, fn)
 }
 
@@ -1261,6 +1598,9 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\"")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().StringArray("tensor-type", nil, "Quantize tensors matching a pattern to a type (e.g. --tensor-type token_embd.weight=q8_0)")
+	createCmd.Flags().String("imatrix", "", "Calibrate quantization with an importance matrix computed over this text file")
+	createCmd.Flags().StringArray("build-arg", nil, "Set a Modelfile ARG (e.g. --build-arg base=llama3.2)")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1316,6 +1656,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pullCmd.Flags().String("limit-rate", "", "Limit the download rate, e.g. 20M (bytes per second)")
 
 	pushCmd := &cobra.Command{
 		Use:     "push MODEL",
@@ -1350,6 +1691,43 @@ func NewCLI() *cobra.Command {
 		RunE:    CopyHandler,
 	}
 
+	diffCmd := &cobra.Command{
+		Use:     "diff MODEL MODEL",
+		Short:   "Compare two models",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    DiffHandler,
+	}
+
+	diffCmd.Flags().Bool("json", false, "Output the differences as JSON")
+
+	aliasCmd := &cobra.Command{
+		Use:     "alias ALIAS TARGET",
+		Short:   "Create or repoint a model alias",
+		Args:    cobra.RangeArgs(1, 2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    AliasHandler,
+	}
+
+	aliasCmd.Flags().BoolP("delete", "d", false, "Delete the alias")
+
+	templateCmd := &cobra.Command{
+		Use:   "template",
+		Short: "Inspect chat templates",
+	}
+
+	templateLintCmd := &cobra.Command{
+		Use:     "lint [MODEL]",
+		Short:   "Check a model's template, or a template file, for common mistakes",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    TemplateLintHandler,
+	}
+
+	templateLintCmd.Flags().StringP("file", "f", "", "Template file to check instead of a model's template")
+	templateLintCmd.Flags().BoolP("verbose", "v", false, "Show the prompt rendered from sample messages")
+	templateCmd.AddCommand(templateLintCmd)
+
 	deleteCmd := &cobra.Command{
 		Use:     "rm MODEL [MODEL...]",
 		Short:   "Remove a model",
@@ -1384,6 +1762,9 @@ func NewCLI() *cobra.Command {
 		listCmd,
 		psCmd,
 		copyCmd,
+		diffCmd,
+		aliasCmd,
+		templateLintCmd,
 		deleteCmd,
 		serveCmd,
 	} {
@@ -1397,7 +1778,9 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_KEEP_ALIVE"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_PULLS"],
 				envVars["OLLAMA_MODELS"],
+				envVars["OLLAMA_MODELS_READONLY"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
@@ -1407,6 +1790,10 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_LLM_LIBRARY"],
 				envVars["OLLAMA_GPU_OVERHEAD"],
 				envVars["OLLAMA_LOAD_TIMEOUT"],
+				envVars["OLLAMA_PULL_RATE_LIMIT"],
+				envVars["OLLAMA_TLS_CERT"],
+				envVars["OLLAMA_TLS_KEY"],
+				envVars["OLLAMA_TLS_CLIENT_CA"],
 			})
 		default:
 			appendEnvDocs(cmd, envs)
@@ -1424,6 +1811,9 @@ func NewCLI() *cobra.Command {
 		listCmd,
 		psCmd,
 		copyCmd,
+		diffCmd,
+		aliasCmd,
+		templateCmd,
 		deleteCmd,
 		runnerCmd,
 	)
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -37,6 +38,37 @@ func TestShowInfo(t *testing.T) {
     parameters      7B      
     quantization    FP16    
 
+`
+
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("imatrix", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showInfo(&api.ShowResponse{
+			Details: api.ModelDetails{
+				Family:            "test",
+				ParameterSize:     "7B",
+				QuantizationLevel: "Q3_K_M",
+				Imatrix: &api.ImatrixDetails{
+					Digest:  "sha256:0123456789abcdef",
+					Dataset: "sha256:fedcba9876543210",
+					Chunks:  32,
+				},
+			},
+		}, false, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := `  Model
+    architecture       test            
+    parameters         7B              
+    quantization       Q3_K_M          
+    imatrix            0123456789ab    
+    imatrix dataset    fedcba987654    
+
 `
 
 		if diff := cmp.Diff(expect, b.String()); diff != "" {
@@ -291,6 +323,52 @@ Weigh anchor!
 	})
 }
 
+func TestShowDiff(t *testing.T) {
+	var b bytes.Buffer
+	if err := showDiff(&api.DiffResponse{
+		From: "a:latest",
+		To:   "b:latest",
+		Layers: []api.LayerDiff{
+			{MediaType: "application/vnd.ollama.image.model", Status: "changed", From: "sha256:0123456789abcdef", To: "sha256:fedcba9876543210"},
+			{MediaType: "application/vnd.ollama.image.system", Status: "added", To: "sha256:aaaaaaaaaaaaaaaa"},
+		},
+		ModelInfo: []api.ValueDiff{{Key: "general.file_type", From: float64(1), To: float64(2)}},
+		Tensors: []api.TensorDiff{
+			{Name: "output.weight", From: &api.Tensor{Type: "F16", Shape: []uint64{2, 2}}, To: &api.Tensor{Type: "Q8_0", Shape: []uint64{2, 2}}},
+		},
+		Parameters: []api.ValueDiff{{Key: "stop", To: []any{"<eos>"}}},
+		System:     &api.ValueDiff{Key: "system", To: "You are\na pirate."},
+	}, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `--- a:latest
++++ b:latest
+
+  Layers
+    changed    model     0123456789ab    fedcba987654    
+    added      system    (none)          aaaaaaaaaaaa    
+
+  Metadata
+    general.file_type    1    2    
+
+  Tensors
+    output.weight    F16 [2 2]    Q8_0 [2 2]    
+
+  Parameters
+    stop    (none)    [<eos>]    
+
+  System
+    + You are
+    + a pirate.
+
+`
+
+	if diff := cmp.Diff(expect, b.String()); diff != "" {
+		t.Errorf("unexpected output (-want +got):\n%s", diff)
+	}
+}
+
 func TestDeleteHandler(t *testing.T) {
 	stopped := false
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -351,6 +429,93 @@ func TestDeleteHandler(t *testing.T) {
 	}
 }
 
+func TestTemplateLintHandler(t *testing.T) {
+	var rendered api.TemplateRenderRequest
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/show":
+			if err := json.NewEncoder(w).Encode(api.ShowResponse{Template: "{{ .Prompt }}"}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		case "/api/template/render":
+			if err := json.NewDecoder(r.Body).Decode(&rendered); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var resp api.TemplateRenderResponse
+			if rendered.Template == "{{ .Prompt }}" {
+				resp.Warnings = []string{"template has no .Response or .Messages so .Response will be appended to it"}
+			}
+
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+	t.Cleanup(mockServer.Close)
+
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().StringP("file", "f", "", "")
+		cmd.Flags().BoolP("verbose", "v", false, "")
+		cmd.SetContext(context.TODO())
+		return cmd
+	}
+
+	t.Run("model", func(t *testing.T) {
+		err := TemplateLintHandler(newCmd(), []string{"test-model"})
+		if err == nil || err.Error() != "found 1 issues" {
+			t.Fatalf("expected an issue, got %v", err)
+		}
+
+		if rendered.Template != "{{ .Prompt }}" || len(rendered.Messages) == 0 || len(rendered.Tools) == 0 {
+			t.Errorf("unexpected render request %+v", rendered)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "template")
+		if err := os.WriteFile(p, []byte("{{ range .Messages }}{{ .Content }}{{ end }}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := newCmd()
+		if err := cmd.Flags().Set("file", p); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := TemplateLintHandler(cmd, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if rendered.Template != "{{ range .Messages }}{{ .Content }}{{ end }}" {
+			t.Errorf("unexpected template %q", rendered.Template)
+		}
+
+		if err := TemplateLintHandler(cmd, []string{"test-model"}); err == nil {
+			t.Error("expected an error for both a model and a file")
+		}
+	})
+}
+
+func TestShowLint(t *testing.T) {
+	var b bytes.Buffer
+	err := showLint(&api.TemplateRenderResponse{Prompt: "a\nb", Warnings: []string{".Prompt is never rendered"}}, true, &b)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := "Rendered prompt:\n    a\n    b\n\nwarning: .Prompt is never rendered\n"
+	if diff := cmp.Diff(b.String(), want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
+
 func TestGetModelfileName(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -670,10 +835,45 @@ func TestCreateHandler(t *testing.T) {
 		name           string
 		modelName      string
 		modelFile      string
+		buildArgs      []string
 		serverResponse map[string]func(w http.ResponseWriter, r *http.Request)
 		expectedError  string
 		expectedOutput string
 	}{
+		{
+			name:      "build args",
+			modelName: "test-model",
+			modelFile: "ARG base=foo\nARG temperature=1\nFROM ${base}\nPARAMETER temperature ${temperature}\n",
+			buildArgs: []string{"base=bar"},
+			serverResponse: map[string]func(w http.ResponseWriter, r *http.Request){
+				"/api/create": func(w http.ResponseWriter, r *http.Request) {
+					req := api.CreateRequest{}
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+
+					if req.From != "bar" {
+						t.Errorf("expected from 'bar', got %s", req.From)
+					}
+
+					if req.Parameters["temperature"] != float64(1) {
+						t.Errorf("expected temperature 1, got %v", req.Parameters["temperature"])
+					}
+
+					if err := json.NewEncoder(w).Encode(api.ProgressResponse{Status: "success"}); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+					}
+				},
+			},
+		},
+		{
+			name:          "undeclared build arg",
+			modelName:     "test-model",
+			modelFile:     "FROM foo\n",
+			buildArgs:     []string{"base=bar"},
+			expectedError: "build args are not declared by an ARG command: base",
+		},
 		{
 			name:      "successful create",
 			modelName: "test-model",
@@ -749,6 +949,13 @@ func TestCreateHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			cmd.Flags().StringArray("build-arg", nil, "")
+			for _, arg := range tt.buildArgs {
+				if err := cmd.Flags().Set("build-arg", arg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
 			cmd.Flags().Bool("insecure", false, "")
 			cmd.SetContext(context.TODO())
 
@@ -787,6 +994,8 @@ func TestCreateHandler(t *testing.T) {
 						t.Errorf("expected output %q, got %q", tt.expectedOutput, got)
 					}
 				}
+			} else if err == nil || err.Error() != tt.expectedError {
+				t.Errorf("expected error %q, got %v", tt.expectedError, err)
 			}
 		})
 	}
@@ -189,6 +189,14 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		conv = &phi3Model{}
 	case "Qwen2ForCausalLM":
 		conv = &qwen2Model{}
+	case "Qwen2VLForConditionalGeneration":
+		conv = &qwen2VLModel{}
+	case "DeepseekV2ForCausalLM", "DeepseekV3ForCausalLM":
+		conv = &deepseek2Model{}
+	case "GraniteForCausalLM", "GraniteMoeForCausalLM":
+		conv = &graniteModel{}
+	case "OlmoForCausalLM", "Olmo2ForCausalLM":
+		conv = &olmoModel{Architecture: p.Architectures[0]}
 	case "BertModel":
 		conv = &bertModel{}
 	case "CohereForCausalLM":
@@ -0,0 +1,190 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type deepseek2Model struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RopeTheta             float32 `json:"rope_theta"`
+	RMSNormEPS            float32 `json:"rms_norm_eps"`
+
+	// multi-head latent attention
+	QLoraRank     uint32 `json:"q_lora_rank"`
+	KVLoraRank    uint32 `json:"kv_lora_rank"`
+	QKNopeHeadDim uint32 `json:"qk_nope_head_dim"`
+	QKRopeHeadDim uint32 `json:"qk_rope_head_dim"`
+	VHeadDim      uint32 `json:"v_head_dim"`
+
+	// mixture of experts
+	MoEIntermediateSize uint32  `json:"moe_intermediate_size"`
+	NRoutedExperts      uint32  `json:"n_routed_experts"`
+	NSharedExperts      uint32  `json:"n_shared_experts"`
+	NumExpertsPerToken  uint32  `json:"num_experts_per_tok"`
+	FirstKDenseReplace  uint32  `json:"first_k_dense_replace"`
+	RoutedScalingFactor float32 `json:"routed_scaling_factor"`
+	NormTopKProb        bool    `json:"norm_topk_prob"`
+	ScoringFunc         string  `json:"scoring_func"`
+
+	RopeScaling struct {
+		Type                          string  `json:"type"`
+		Factor                        float32 `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
+		MScaleAllDim                  float32 `json:"mscale_all_dim"`
+	} `json:"rope_scaling"`
+}
+
+var _ ModelConverter = (*deepseek2Model)(nil)
+
+func (p *deepseek2Model) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "deepseek2"
+	kv["deepseek2.vocab_size"] = p.VocabSize
+	kv["deepseek2.block_count"] = p.HiddenLayers
+	kv["deepseek2.context_length"] = p.MaxPositionEmbeddings
+	kv["deepseek2.embedding_length"] = p.HiddenSize
+	kv["deepseek2.feed_forward_length"] = p.IntermediateSize
+	kv["deepseek2.leading_dense_block_count"] = p.FirstKDenseReplace
+	kv["deepseek2.attention.head_count"] = p.NumAttentionHeads
+	kv["deepseek2.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv["deepseek2.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	kv["deepseek2.rope.freq_base"] = p.RopeTheta
+
+	// the lite models project queries directly rather than through a
+	// low rank projection
+	if p.QLoraRank > 0 {
+		kv["deepseek2.attention.q_lora_rank"] = p.QLoraRank
+	}
+	kv["deepseek2.attention.kv_lora_rank"] = p.KVLoraRank
+	kv["deepseek2.attention.key_length"] = p.QKNopeHeadDim + p.QKRopeHeadDim
+	kv["deepseek2.attention.value_length"] = p.VHeadDim
+	kv["deepseek2.rope.dimension_count"] = p.QKRopeHeadDim
+
+	kv["deepseek2.expert_count"] = p.NRoutedExperts
+	kv["deepseek2.expert_used_count"] = p.NumExpertsPerToken
+	kv["deepseek2.expert_shared_count"] = p.NSharedExperts
+	kv["deepseek2.expert_feed_forward_length"] = p.MoEIntermediateSize
+	kv["deepseek2.expert_weights_scale"] = cmp.Or(p.RoutedScalingFactor, 1)
+	kv["deepseek2.expert_weights_norm"] = p.NormTopKProb
+
+	switch p.ScoringFunc {
+	case "", "softmax":
+		kv["deepseek2.expert_gating_func"] = uint32(1)
+	case "sigmoid":
+		kv["deepseek2.expert_gating_func"] = uint32(2)
+	default:
+		panic(fmt.Sprintf("unknown scoring function %q", p.ScoringFunc))
+	}
+
+	switch p.RopeScaling.Type {
+	case "":
+		// no scaling
+	case "yarn", "deepseek_yarn":
+		kv["deepseek2.rope.scaling.type"] = "yarn"
+		kv["deepseek2.rope.scaling.factor"] = p.RopeScaling.Factor
+		kv["deepseek2.rope.scaling.original_context_length"] = p.RopeScaling.OriginalMaxPositionEmbeddings
+		kv["deepseek2.rope.scaling.yarn_log_multiplier"] = 0.1 * p.RopeScaling.MScaleAllDim
+	default:
+		panic("unknown rope scaling type")
+	}
+
+	// DeepSeek-V2 uses the same pretokenizer as DeepSeek LLM which is
+	// detected from its regular expressions. DeepSeek-V3 uses its own
+	if t.Pre == "default" {
+		kv["tokenizer.ggml.pre"] = "deepseek-v3"
+	}
+
+	return kv
+}
+
+var deepseek2ExpertRE = regexp.MustCompile(`^blk\.(\d+)\.mlp\.experts\.(\d+)\.(gate|up|down)_proj\.weight$`)
+
+func (p *deepseek2Model) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+
+	experts := make(map[string]experts)
+	for _, t := range ts {
+		// DeepSeek-V3 has additional layers for multi-token prediction
+		// which aren't used for inference
+		var block int
+		if _, err := fmt.Sscanf(t.Name(), "blk.%d.", &block); err == nil && block >= int(p.HiddenLayers) {
+			continue
+		}
+
+		// group the experts of each layer and projection into a single
+		// tensor, in order of the experts
+		if m := deepseek2ExpertRE.FindStringSubmatch(t.Name()); m != nil {
+			name := fmt.Sprintf("blk.%s.ffn_%s_exps.weight", m[1], m[3])
+			if experts[name] == nil {
+				experts[name] = make([]Tensor, p.NRoutedExperts)
+			}
+
+			i, _ := strconv.Atoi(m[2])
+			if i < len(experts[name]) {
+				experts[name][i] = t
+			}
+			continue
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	for name, e := range experts {
+		if slices.Contains(e, nil) {
+			panic(fmt.Sprintf("missing experts for %s", name))
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     name,
+			Kind:     e[0].Kind(),
+			Shape:    append([]uint64{uint64(len(e))}, e[0].Shape()...),
+			WriterTo: e,
+		})
+	}
+
+	return out
+}
+
+func (p *deepseek2Model) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_a_proj", "attn_q_a",
+		"self_attn.q_a_layernorm", "attn_q_a_norm",
+		"self_attn.q_b_proj", "attn_q_b",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.kv_a_proj_with_mqa", "attn_kv_a_mqa",
+		"self_attn.kv_a_layernorm", "attn_kv_a_norm",
+		"self_attn.kv_b_proj", "attn_kv_b",
+		"self_attn.o_proj", "attn_output",
+		"post_attention_layernorm", "ffn_norm",
+		"mlp.shared_experts.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_experts.up_proj", "ffn_up_shexp",
+		"mlp.shared_experts.down_proj", "ffn_down_shexp",
+		"mlp.gate.e_score_correction_bias", "exp_probs_b.bias",
+		"mlp.gate.weight", "ffn_gate_inp.weight",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.up_proj", "ffn_up",
+		"mlp.down_proj", "ffn_down",
+	}
+}
@@ -0,0 +1,101 @@
+package convert
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pdevine/tensor"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type graniteModel struct {
+	llamaModel
+	EmbeddingMultiplier float32 `json:"embedding_multiplier"`
+	ResidualMultiplier  float32 `json:"residual_multiplier"`
+	AttentionMultiplier float32 `json:"attention_multiplier"`
+	LogitsScaling       float32 `json:"logits_scaling"`
+
+	// mixture of experts models
+	NumLocalExperts    uint32 `json:"num_local_experts"`
+	NumExpertsPerToken uint32 `json:"num_experts_per_tok"`
+}
+
+var _ ModelConverter = (*graniteModel)(nil)
+
+func (p *graniteModel) arch() string {
+	if p.NumLocalExperts > 0 {
+		return "granitemoe"
+	}
+
+	return "granite"
+}
+
+func (p *graniteModel) KV(t *Tokenizer) ggml.KV {
+	arch := p.arch()
+
+	kv := ggml.KV{}
+	for k, v := range p.llamaModel.KV(t) {
+		if after, ok := strings.CutPrefix(k, "llama."); ok {
+			k = arch + "." + after
+		}
+		kv[k] = v
+	}
+
+	kv["general.architecture"] = arch
+	kv[arch+".embedding_scale"] = p.EmbeddingMultiplier
+	kv[arch+".residual_scale"] = p.ResidualMultiplier
+	kv[arch+".attention.scale"] = p.AttentionMultiplier
+	kv[arch+".logit_scale"] = p.LogitsScaling
+
+	if p.NumLocalExperts > 0 {
+		kv[arch+".expert_count"] = p.NumLocalExperts
+		kv[arch+".expert_used_count"] = p.NumExpertsPerToken
+	}
+
+	// granite tokenizers split digits individually
+	if t.Pre == "default" {
+		kv["tokenizer.ggml.pre"] = "refact"
+	}
+
+	return kv
+}
+
+func (p *graniteModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+
+	var textTensors []Tensor
+	for _, t := range ts {
+		if strings.HasSuffix(t.Name(), ".ffn_gate_up_exps.weight") {
+			// gate and up projections of the experts are fused
+			// [experts, intermediate_size * 2, hidden_size] --> 2 x [experts, intermediate_size, hidden_size]
+			halfDim := int(t.Shape()[1]) / 2
+
+			shape := slices.Clone(t.Shape())
+			shape[1] /= 2
+			for i, name := range []string{"ffn_gate_exps", "ffn_up_exps"} {
+				tt := t.Clone()
+				tt.SetRepacker(sliceRepacker(nil, tensor.S(i*halfDim, (i+1)*halfDim)))
+				out = append(out, ggml.Tensor{
+					Name:     strings.ReplaceAll(tt.Name(), "ffn_gate_up_exps", name),
+					Kind:     tt.Kind(),
+					Shape:    shape,
+					WriterTo: tt,
+				})
+			}
+		} else {
+			textTensors = append(textTensors, t)
+		}
+	}
+
+	return append(out, p.llamaModel.Tensors(textTensors)...)
+}
+
+func (p *graniteModel) Replacements() []string {
+	return append(
+		p.llamaModel.Replacements(),
+		"block_sparse_moe.input_linear", "ffn_gate_up_exps",
+		"block_sparse_moe.output_linear", "ffn_down_exps",
+		"block_sparse_moe.router.layer", "ffn_gate_inp",
+	)
+}
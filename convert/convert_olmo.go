@@ -0,0 +1,107 @@
+package convert
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type olmoModel struct {
+	ModelParameters
+	Architecture          string
+	MaxPositionEmbeddings uint32   `json:"max_position_embeddings"`
+	HiddenSize            uint32   `json:"hidden_size"`
+	HiddenLayers          uint32   `json:"num_hidden_layers"`
+	IntermediateSize      uint32   `json:"intermediate_size"`
+	NumAttentionHeads     uint32   `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32   `json:"num_key_value_heads"`
+	RopeTheta             float32  `json:"rope_theta"`
+	RMSNormEPS            float32  `json:"rms_norm_eps"`
+	ClipQKV               *float32 `json:"clip_qkv"`
+}
+
+var _ ModelConverter = (*olmoModel)(nil)
+
+func (p *olmoModel) arch() string {
+	if p.Architecture == "Olmo2ForCausalLM" {
+		return "olmo2"
+	}
+
+	return "olmo"
+}
+
+func (p *olmoModel) KV(t *Tokenizer) ggml.KV {
+	arch := p.arch()
+
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = arch
+	kv[arch+".block_count"] = p.HiddenLayers
+	kv[arch+".context_length"] = p.MaxPositionEmbeddings
+	kv[arch+".embedding_length"] = p.HiddenSize
+	kv[arch+".feed_forward_length"] = p.IntermediateSize
+	kv[arch+".attention.head_count"] = p.NumAttentionHeads
+	kv[arch+".attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv[arch+".rope.dimension_count"] = p.HiddenSize / p.NumAttentionHeads
+	kv[arch+".rope.freq_base"] = cmp.Or(p.RopeTheta, 10000)
+
+	switch arch {
+	case "olmo":
+		// OLMo uses layer norms without weights or biases
+		kv["olmo.attention.layer_norm_epsilon"] = float32(1e-5)
+		if p.ClipQKV != nil {
+			kv["olmo.attention.clamp_kqv"] = *p.ClipQKV
+		}
+
+		if t.Pre == "default" {
+			kv["tokenizer.ggml.pre"] = "olmo"
+		}
+	case "olmo2":
+		kv["olmo2.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	}
+
+	return kv
+}
+
+func (p *olmoModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		// OLMo uses the same rotary embeddings as llama
+		if p.arch() == "olmo" &&
+			(strings.HasSuffix(t.Name(), "attn_q.weight") || strings.HasSuffix(t.Name(), "attn_k.weight")) {
+			t.SetRepacker((&llamaModel{
+				NumAttentionHeads: p.NumAttentionHeads,
+				NumKeyValueHeads:  p.NumKeyValueHeads,
+			}).repack)
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *olmoModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"self_attn.q_norm", "attn_q_norm",
+		"self_attn.k_norm", "attn_k_norm",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "post_attention_norm",
+		"post_feedforward_layernorm", "post_ffw_norm",
+	}
+}
@@ -33,6 +33,7 @@ type phi3Model struct {
 	MaxPositionEmbeddings         uint32  `json:"max_position_embeddings"`
 	OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
 	SlidingWindow                 uint32  `json:"sliding_window"`
+	PartialRotaryFactor           float32 `json:"partial_rotary_factor"`
 }
 
 var _ ModelConverter = (*phi3Model)(nil)
@@ -47,11 +48,18 @@ func (p *phi3Model) KV(t *Tokenizer) ggml.KV {
 	kv["phi3.attention.head_count"] = cmp.Or(p.NumAttentionHeads, p.NHead)
 	kv["phi3.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NHeadKV)
 	kv["phi3.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
-	kv["phi3.rope.dimension_count"] = p.HiddenSize / cmp.Or(p.NumAttentionHeads, p.NHead)
 	kv["phi3.rope.freq_base"] = p.RopeTheta
-	kv["phi3.rope.scaling.original_context_length"] = p.OriginalMaxPositionEmbeddings
 	kv["phi3.attention.sliding_window"] = p.SlidingWindow
 
+	// Phi-4-mini only rotates part of each head
+	headDim := cmp.Or(p.HiddenSize, p.NEmbd) / cmp.Or(p.NumAttentionHeads, p.NHead)
+	kv["phi3.rope.dimension_count"] = uint32(float32(headDim) * cmp.Or(p.PartialRotaryFactor, 1))
+
+	// Phi-4 has no long context scaling
+	if p.OriginalMaxPositionEmbeddings > 0 {
+		kv["phi3.rope.scaling.original_context_length"] = p.OriginalMaxPositionEmbeddings
+	}
+
 	scale := float64(p.MaxPositionEmbeddings) / float64(p.OriginalMaxPositionEmbeddings)
 
 	switch p.RopeScaling.Type {
@@ -73,7 +81,7 @@ func (p *phi3Model) Tensors(ts []Tensor) []ggml.Tensor {
 
 	out := make([]ggml.Tensor, 0, len(ts)+2)
 	for _, t := range ts {
-		if strings.HasPrefix(t.Name(), "blk.0.") {
+		if strings.HasPrefix(t.Name(), "blk.0.") && len(p.RopeScaling.LongFactor) > 0 {
 			addRopeFactors.Do(func() {
 				out = append(out, ggml.Tensor{
 					Name:     "rope_factors_long.weight",
@@ -0,0 +1,161 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pdevine/tensor"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type qwen2VLModel struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RopeTheta             float32 `json:"rope_theta"`
+	RopeScaling           struct {
+		Type         string  `json:"type"`
+		MRopeSection []int32 `json:"mrope_section"`
+	} `json:"rope_scaling"`
+	RMSNormEPS float32 `json:"rms_norm_eps"`
+
+	VisionModel struct {
+		Depth             uint32  `json:"depth"`
+		EmbedDim          uint32  `json:"embed_dim"`
+		MLPRatio          float32 `json:"mlp_ratio"`
+		NumHeads          uint32  `json:"num_heads"`
+		InChannels        uint32  `json:"in_chans"`
+		PatchSize         uint32  `json:"patch_size"`
+		SpatialMergeSize  uint32  `json:"spatial_merge_size"`
+		TemporalPatchSize uint32  `json:"temporal_patch_size"`
+		WindowSize        uint32  `json:"window_size"`
+		FullAttention     []int32 `json:"fullatt_block_indexes"`
+	} `json:"vision_config"`
+
+	VisionStartTokenID uint32 `json:"vision_start_token_id"`
+	VisionEndTokenID   uint32 `json:"vision_end_token_id"`
+	ImageTokenID       uint32 `json:"image_token_id"`
+}
+
+var _ ModelConverter = (*qwen2VLModel)(nil)
+
+func (q *qwen2VLModel) KV(t *Tokenizer) ggml.KV {
+	kv := q.ModelParameters.KV(t)
+	kv["general.architecture"] = "qwen2vl"
+	kv["qwen2vl.block_count"] = q.HiddenLayers
+	kv["qwen2vl.context_length"] = q.MaxPositionEmbeddings
+	kv["qwen2vl.embedding_length"] = q.HiddenSize
+	kv["qwen2vl.feed_forward_length"] = q.IntermediateSize
+	kv["qwen2vl.attention.head_count"] = q.NumAttentionHeads
+	kv["qwen2vl.attention.head_count_kv"] = q.NumKeyValueHeads
+	kv["qwen2vl.attention.layer_norm_rms_epsilon"] = q.RMSNormEPS
+	kv["qwen2vl.rope.dimension_count"] = q.HiddenSize / q.NumAttentionHeads
+	kv["qwen2vl.rope.freq_base"] = q.RopeTheta
+
+	// sections of the rotary dimensions for the temporal, height and width
+	// positions. ggml expects four sections
+	sections := slices.Clone(q.RopeScaling.MRopeSection)
+	for len(sections) < 4 {
+		sections = append(sections, 0)
+	}
+	kv["qwen2vl.rope.dimension_sections"] = sections
+
+	kv["qwen2vl.vision.block_count"] = q.VisionModel.Depth
+	kv["qwen2vl.vision.embedding_length"] = q.VisionModel.EmbedDim
+	kv["qwen2vl.vision.feed_forward_length"] = uint32(float32(q.VisionModel.EmbedDim) * cmp.Or(q.VisionModel.MLPRatio, 4))
+	kv["qwen2vl.vision.attention.head_count"] = q.VisionModel.NumHeads
+	kv["qwen2vl.vision.attention.layer_norm_epsilon"] = float32(1e-6)
+	kv["qwen2vl.vision.num_channels"] = cmp.Or(q.VisionModel.InChannels, 3)
+	kv["qwen2vl.vision.patch_size"] = q.VisionModel.PatchSize
+	kv["qwen2vl.vision.spatial_merge_size"] = q.VisionModel.SpatialMergeSize
+	kv["qwen2vl.vision.temporal_patch_size"] = q.VisionModel.TemporalPatchSize
+
+	// patches attend within windows of window_size pixels, except in the
+	// full attention layers
+	if q.VisionModel.WindowSize > 0 {
+		kv["qwen2vl.vision.window_size"] = q.VisionModel.WindowSize
+		kv["qwen2vl.vision.fullatt_block_indexes"] = q.VisionModel.FullAttention
+	}
+
+	kv["qwen2vl.vision_start_token_id"] = q.VisionStartTokenID
+	kv["qwen2vl.vision_end_token_id"] = q.VisionEndTokenID
+	kv["qwen2vl.image_token_id"] = q.ImageTokenID
+	return kv
+}
+
+func (q *qwen2VLModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		if strings.HasPrefix(t.Name(), "v.patch_embd.") {
+			// the patch embedding is a 3D convolution over pairs of frames.
+			// ggml can't convolve in 3D so split it into a 2D convolution
+			// for each frame. its shape is [embed_dim, channels, temporal_patch_size, patch_size, patch_size]
+			shape := slices.Delete(slices.Clone(t.Shape()), 2, 3)
+			for i := range t.Shape()[2] {
+				tt := t.Clone()
+				tt.SetRepacker(sliceRepacker(nil, nil, tensor.S(int(i), int(i)+1)))
+				out = append(out, ggml.Tensor{
+					Name:     strings.Replace(tt.Name(), "v.patch_embd.", fmt.Sprintf("v.patch_embd_%d.", i), 1),
+					Kind:     tt.Kind(),
+					Shape:    shape,
+					WriterTo: tt,
+				})
+			}
+
+			continue
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (q *qwen2VLModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		// newer versions of transformers nest the language model
+		"model.language_model.norm", "output_norm",
+		"model.language_model.", "",
+		"model.visual.merger.", "mm.",
+		"model.visual.", "v.",
+		"visual.merger.", "mm.",
+		"visual.", "v.",
+		"model.norm", "output_norm",
+		"model.", "",
+		"embed_tokens", "token_embd",
+		"layers", "blk",
+		"blocks", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.o_proj", "attn_output",
+		"mlp.down_proj", "ffn_down",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "ffn_norm",
+		"patch_embed.proj", "patch_embd",
+		"norm1", "ln1",
+		"norm2", "ln2",
+		"attn.qkv", "attn_qkv",
+		"attn.proj", "attn_out",
+		"mlp.fc1", "ffn_up",
+		"mlp.fc2", "ffn_down",
+		"ln_q", "norm",
+		"mlp.0", "0",
+		"mlp.2", "2",
+	}
+}
@@ -13,10 +13,14 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/x448/float16"
 	"golang.org/x/exp/maps"
 
 	"github.com/ollama/ollama/fs/ggml"
@@ -475,3 +479,923 @@ func generateLoraTestData(t *testing.T, tempDir string) {
 		t.Fatal(err)
 	}
 }
+
+// generateSafetensorsModel writes a model with the config and tensors given.
+// Tensors are F32 and the values of each are its element indices, except
+// those of experts which are the expert's index.
+func generateSafetensorsModel(t *testing.T, tempDir, config string, shapes map[string][]int) {
+	t.Helper()
+
+	expertRE := regexp.MustCompile(`\.experts\.(\d+)\.`)
+
+	td := map[string]*tensorData{}
+	var data bytes.Buffer
+	names := maps.Keys(shapes)
+	slices.Sort(names)
+	for _, name := range names {
+		shape := shapes[name]
+
+		n := 1
+		for _, dim := range shape {
+			n *= dim
+		}
+
+		values := make([]float32, n)
+		for i := range values {
+			values[i] = float32(i)
+			if m := expertRE.FindStringSubmatch(name); m != nil {
+				expert, _ := strconv.Atoi(m[1])
+				values[i] = float32(expert)
+			}
+		}
+
+		td[name] = &tensorData{
+			Offsets: []int{data.Len(), data.Len() + 4*n},
+			Type:    "F32",
+			Shape:   shape,
+		}
+
+		if err := binary.Write(&data, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header, err := json.Marshal(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(header)
+	b.Write(data.Bytes())
+
+	for name, content := range map[string][]byte{
+		"model.safetensors": b.Bytes(),
+		"config.json":       []byte(config),
+		"tokenizer.json":    []byte(`{"model": {"vocab": {"a": 0, "b": 1}}}`),
+	} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConvertArchitectures(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		shapes  map[string][]int
+		kv      map[string]string
+		tensors map[string][]uint64
+		// check checks the values of converted tensors
+		check func(t *testing.T, values func(string) []float32)
+	}{
+		{
+			name: "qwen2vl",
+			config: `{
+				"architectures": ["Qwen2VLForConditionalGeneration"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 1,
+				"num_hidden_layers": 1,
+				"max_position_embeddings": 32,
+				"rms_norm_eps": 1e-06,
+				"rope_theta": 1000000.0,
+				"rope_scaling": {"type": "mrope", "mrope_section": [1, 1, 0]},
+				"vision_config": {
+					"depth": 1,
+					"embed_dim": 4,
+					"mlp_ratio": 4,
+					"num_heads": 2,
+					"in_chans": 3,
+					"patch_size": 2,
+					"spatial_merge_size": 2,
+					"temporal_patch_size": 2,
+					"window_size": 8,
+					"fullatt_block_indexes": [0]
+				},
+				"image_token_id": 1
+			}`,
+			shapes: map[string][]int{
+				"lm_head.weight":                                 {2, 8},
+				"model.embed_tokens.weight":                      {2, 8},
+				"model.norm.weight":                              {8},
+				"model.layers.0.self_attn.q_proj.weight":         {8, 8},
+				"model.layers.0.post_attention_layernorm.weight": {8},
+				"visual.patch_embed.proj.weight":                 {4, 3, 2, 2, 2},
+				"visual.blocks.0.norm1.weight":                   {4},
+				"visual.blocks.0.attn.qkv.weight":                {12, 4},
+				"visual.blocks.0.mlp.fc2.weight":                 {4, 16},
+				"visual.merger.ln_q.weight":                      {4},
+				"visual.merger.mlp.0.weight":                     {16, 16},
+			},
+			kv: map[string]string{
+				"general.architecture":               "qwen2vl",
+				"qwen2vl.block_count":                "1",
+				"qwen2vl.rope.dimension_count":       "4",
+				"qwen2vl.vision.block_count":         "1",
+				"qwen2vl.vision.feed_forward_length": "16",
+				"qwen2vl.image_token_id":             "1",
+				"qwen2vl.vision.window_size":         "8",
+			},
+			tensors: map[string][]uint64{
+				"output.weight":           {8, 2},
+				"token_embd.weight":       {8, 2},
+				"output_norm.weight":      {8},
+				"blk.0.attn_q.weight":     {8, 8},
+				"blk.0.ffn_norm.weight":   {8},
+				"v.patch_embd_0.weight":   {2, 2, 3, 4},
+				"v.patch_embd_1.weight":   {2, 2, 3, 4},
+				"v.blk.0.ln1.weight":      {4},
+				"v.blk.0.attn_qkv.weight": {4, 12},
+				"v.blk.0.ffn_down.weight": {16, 4},
+				"mm.norm.weight":          {4},
+				"mm.0.weight":             {16, 16},
+			},
+			check: func(t *testing.T, values func(string) []float32) {
+				// each frame of the patch embedding is split into its own tensor
+				for frame, name := range []string{"v.patch_embd_0.weight", "v.patch_embd_1.weight"} {
+					var want []float32
+					for i := range 4 * 3 {
+						for j := range 4 {
+							want = append(want, float32(i*8+frame*4+j))
+						}
+					}
+
+					if diff := cmp.Diff(want, values(name)); diff != "" {
+						t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+					}
+				}
+			},
+		},
+		{
+			name: "phi4",
+			config: `{
+				"architectures": ["Phi3ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 1,
+				"num_hidden_layers": 1,
+				"max_position_embeddings": 16384,
+				"rms_norm_eps": 1e-05,
+				"rope_theta": 250000.0,
+				"rope_scaling": null,
+				"sliding_window": null
+			}`,
+			shapes: map[string][]int{
+				"model.embed_tokens.weight":                {2, 8},
+				"model.layers.0.self_attn.qkv_proj.weight": {16, 8},
+			},
+			kv: map[string]string{
+				"general.architecture":          "phi3",
+				"phi3.context_length":           "16384",
+				"phi3.rope.dimension_count":     "4",
+				"phi3.attention.sliding_window": "0",
+			},
+			tensors: map[string][]uint64{
+				"token_embd.weight":     {8, 2},
+				"blk.0.attn_qkv.weight": {8, 16},
+			},
+		},
+		{
+			name: "qwen2",
+			config: `{
+				"architectures": ["Qwen2ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 1,
+				"num_hidden_layers": 1,
+				"max_position_embeddings": 32768,
+				"rms_norm_eps": 1e-06,
+				"rope_theta": 1000000.0,
+				"rope_scaling": {
+					"type": "yarn",
+					"factor": 4.0,
+					"original_max_position_embeddings": 32768
+				}
+			}`,
+			shapes: map[string][]int{
+				"model.embed_tokens.weight":              {2, 8},
+				"model.layers.0.self_attn.q_proj.weight": {8, 8},
+			},
+			kv: map[string]string{
+				"general.architecture":                       "qwen2",
+				"qwen2.rope.scaling.type":                    "yarn",
+				"qwen2.rope.scaling.factor":                  "4",
+				"qwen2.rope.scaling.original_context_length": "32768",
+			},
+			tensors: map[string][]uint64{
+				"token_embd.weight":   {8, 2},
+				"blk.0.attn_q.weight": {8, 8},
+			},
+		},
+		{
+			name: "deepseek3",
+			config: `{
+				"architectures": ["DeepseekV3ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 4,
+				"moe_intermediate_size": 2,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"num_hidden_layers": 2,
+				"num_nextn_predict_layers": 1,
+				"first_k_dense_replace": 1,
+				"n_routed_experts": 11,
+				"n_shared_experts": 1,
+				"num_experts_per_tok": 2,
+				"routed_scaling_factor": 2.5,
+				"norm_topk_prob": true,
+				"scoring_func": "sigmoid",
+				"q_lora_rank": 4,
+				"kv_lora_rank": 4,
+				"qk_nope_head_dim": 2,
+				"qk_rope_head_dim": 2,
+				"v_head_dim": 2,
+				"max_position_embeddings": 64,
+				"rms_norm_eps": 1e-06,
+				"rope_theta": 10000,
+				"rope_scaling": {
+					"type": "yarn",
+					"factor": 40,
+					"original_max_position_embeddings": 4096,
+					"mscale_all_dim": 1.0
+				}
+			}`,
+			shapes: func() map[string][]int {
+				shapes := map[string][]int{
+					"model.layers.0.mlp.gate_proj.weight":                {4, 8},
+					"model.layers.0.self_attn.q_a_proj.weight":           {4, 8},
+					"model.layers.0.self_attn.q_a_layernorm.weight":      {4},
+					"model.layers.0.self_attn.kv_a_proj_with_mqa.weight": {6, 8},
+					"model.layers.1.mlp.gate.weight":                     {11, 8},
+					"model.layers.1.mlp.gate.e_score_correction_bias":    {11},
+					"model.layers.1.mlp.shared_experts.down_proj.weight": {8, 2},
+					"model.layers.2.self_attn.q_a_proj.weight":           {4, 8},
+				}
+
+				for i := range 11 {
+					shapes[fmt.Sprintf("model.layers.1.mlp.experts.%d.gate_proj.weight", i)] = []int{2, 8}
+				}
+
+				return shapes
+			}(),
+			kv: map[string]string{
+				"general.architecture":                       "deepseek2",
+				"deepseek2.block_count":                      "2",
+				"deepseek2.leading_dense_block_count":        "1",
+				"deepseek2.attention.q_lora_rank":            "4",
+				"deepseek2.attention.key_length":             "4",
+				"deepseek2.rope.dimension_count":             "2",
+				"deepseek2.expert_count":                     "11",
+				"deepseek2.expert_weights_scale":             "2.5",
+				"deepseek2.expert_gating_func":               "2",
+				"deepseek2.rope.scaling.yarn_log_multiplier": "0.1",
+				"tokenizer.ggml.pre":                         "deepseek-v3",
+			},
+			tensors: map[string][]uint64{
+				"blk.0.ffn_gate.weight":       {8, 4},
+				"blk.0.attn_q_a.weight":       {8, 4},
+				"blk.0.attn_q_a_norm.weight":  {4},
+				"blk.0.attn_kv_a_mqa.weight":  {8, 6},
+				"blk.1.ffn_gate_inp.weight":   {8, 11},
+				"blk.1.exp_probs_b.bias":      {11},
+				"blk.1.ffn_down_shexp.weight": {2, 8},
+				"blk.1.ffn_gate_exps.weight":  {8, 2, 11},
+			},
+			check: func(t *testing.T, values func(string) []float32) {
+				// experts are stacked in numerical, not lexical, order
+				got := values("blk.1.ffn_gate_exps.weight")
+				for i := range 11 {
+					if got[i*16] != float32(i) {
+						t.Errorf("expert %d = %v, want %d", i, got[i*16], i)
+					}
+				}
+			},
+		},
+		{
+			name: "granitemoe",
+			config: `{
+				"architectures": ["GraniteMoeForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 4,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"num_hidden_layers": 1,
+				"num_local_experts": 2,
+				"num_experts_per_tok": 1,
+				"max_position_embeddings": 4096,
+				"rms_norm_eps": 1e-05,
+				"rope_theta": 10000,
+				"embedding_multiplier": 12.0,
+				"residual_multiplier": 0.22,
+				"attention_multiplier": 0.015625,
+				"logits_scaling": 8.0
+			}`,
+			shapes: map[string][]int{
+				"model.embed_tokens.weight":                            {2, 8},
+				"model.layers.0.self_attn.q_proj.weight":               {8, 8},
+				"model.layers.0.block_sparse_moe.input_linear.weight":  {2, 8, 8},
+				"model.layers.0.block_sparse_moe.output_linear.weight": {2, 8, 4},
+				"model.layers.0.block_sparse_moe.router.layer.weight":  {2, 8},
+			},
+			kv: map[string]string{
+				"general.architecture":            "granitemoe",
+				"granitemoe.block_count":          "1",
+				"granitemoe.embedding_scale":      "12",
+				"granitemoe.attention.scale":      "0.015625",
+				"granitemoe.logit_scale":          "8",
+				"granitemoe.expert_count":         "2",
+				"granitemoe.rope.dimension_count": "4",
+				"tokenizer.ggml.pre":              "refact",
+			},
+			tensors: map[string][]uint64{
+				"token_embd.weight":          {8, 2},
+				"blk.0.attn_q.weight":        {8, 8},
+				"blk.0.ffn_gate_exps.weight": {8, 4, 2},
+				"blk.0.ffn_up_exps.weight":   {8, 4, 2},
+				"blk.0.ffn_down_exps.weight": {4, 8, 2},
+				"blk.0.ffn_gate_inp.weight":  {8, 2},
+			},
+			check: func(t *testing.T, values func(string) []float32) {
+				// the fused projections are split in half along the intermediate dimension
+				for half, name := range []string{"blk.0.ffn_gate_exps.weight", "blk.0.ffn_up_exps.weight"} {
+					var want []float32
+					for expert := range 2 {
+						for i := range 32 {
+							want = append(want, float32(expert*64+half*32+i))
+						}
+					}
+
+					if diff := cmp.Diff(want, values(name)); diff != "" {
+						t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+					}
+				}
+			},
+		},
+		{
+			name: "olmo",
+			config: `{
+				"architectures": ["OlmoForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"num_hidden_layers": 1,
+				"max_position_embeddings": 4096,
+				"clip_qkv": 8.0
+			}`,
+			shapes: map[string][]int{
+				"model.embed_tokens.weight":              {2, 8},
+				"model.layers.0.self_attn.q_proj.weight": {8, 8},
+				"model.layers.0.mlp.up_proj.weight":      {16, 8},
+			},
+			kv: map[string]string{
+				"general.architecture":              "olmo",
+				"olmo.attention.layer_norm_epsilon": "1e-05",
+				"olmo.attention.clamp_kqv":          "8",
+				"olmo.rope.freq_base":               "10000",
+				"tokenizer.ggml.pre":                "olmo",
+			},
+			tensors: map[string][]uint64{
+				"token_embd.weight":   {8, 2},
+				"blk.0.attn_q.weight": {8, 8},
+				"blk.0.ffn_up.weight": {8, 16},
+			},
+		},
+		{
+			name: "olmo2",
+			config: `{
+				"architectures": ["Olmo2ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"num_hidden_layers": 1,
+				"max_position_embeddings": 4096,
+				"rms_norm_eps": 1e-06,
+				"rope_theta": 500000
+			}`,
+			shapes: map[string][]int{
+				"model.norm.weight":                                {8},
+				"model.layers.0.self_attn.q_norm.weight":           {8},
+				"model.layers.0.post_attention_layernorm.weight":   {8},
+				"model.layers.0.post_feedforward_layernorm.weight": {8},
+			},
+			kv: map[string]string{
+				"general.architecture":                   "olmo2",
+				"olmo2.attention.layer_norm_rms_epsilon": "1e-06",
+				"olmo2.rope.freq_base":                   "500000",
+				"tokenizer.ggml.pre":                     "default",
+			},
+			tensors: map[string][]uint64{
+				"output_norm.weight":               {8},
+				"blk.0.attn_q_norm.weight":         {8},
+				"blk.0.post_attention_norm.weight": {8},
+				"blk.0.post_ffw_norm.weight":       {8},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := t.TempDir()
+			generateSafetensorsModel(t, p, tt.config, tt.shapes)
+
+			f, kv, tensors := convertFull(t, os.DirFS(p))
+
+			for k, want := range tt.kv {
+				if got := fmt.Sprintf("%v", kv[k]); got != want {
+					t.Errorf("%s = %s, want %s", k, got, want)
+				}
+			}
+
+			got := make(map[string][]uint64)
+			for _, tensor := range tensors.Items() {
+				got[tensor.Name] = tensor.Shape
+			}
+
+			if diff := cmp.Diff(tt.tensors, got); diff != "" {
+				t.Errorf("tensors mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.check != nil {
+				tt.check(t, func(name string) []float32 {
+					for _, tensor := range tensors.Items() {
+						if tensor.Name == name {
+							return readTensorValues(t, f, tensors.Offset, tensor)
+						}
+					}
+
+					t.Fatalf("missing %s", name)
+					return nil
+				})
+			}
+		})
+	}
+}
+
+func readTensorValues(t *testing.T, f *os.File, offset uint64, tensor *ggml.Tensor) []float32 {
+	t.Helper()
+
+	sr := io.NewSectionReader(f, int64(offset+tensor.Offset), int64(tensor.Size()))
+	switch tensor.Kind {
+	case 0:
+		values := make([]float32, tensor.Size()/4)
+		if err := binary.Read(sr, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+		return values
+	case 1:
+		u16s := make([]uint16, tensor.Size()/2)
+		if err := binary.Read(sr, binary.LittleEndian, u16s); err != nil {
+			t.Fatal(err)
+		}
+
+		values := make([]float32, len(u16s))
+		for i := range u16s {
+			values[i] = float16.Frombits(u16s[i]).Float32()
+		}
+		return values
+	default:
+		t.Fatalf("unexpected kind %d for %s", tensor.Kind, tensor.Name)
+		return nil
+	}
+}
+
+// TestConvertConfigs checks the converters against the configurations and
+// tensor names of released models. Tensor shapes are kept small so the
+// tensors aren't repacked
+func TestConvertConfigs(t *testing.T) {
+	layer := func(i int, names ...string) []string {
+		for j := range names {
+			names[j] = fmt.Sprintf("model.layers.%d.%s", i, names[j])
+		}
+		return names
+	}
+
+	cases := []struct {
+		name    string
+		conv    ModelConverter
+		tensors []string
+		kv      map[string]any
+		want    []string
+	}{
+		{
+			name: "Qwen2-VL-2B-Instruct",
+			conv: &qwen2VLModel{},
+			tensors: slices.Concat(
+				[]string{
+					"model.embed_tokens.weight",
+					"model.norm.weight",
+					"visual.patch_embed.proj.weight",
+					"visual.blocks.0.norm1.weight",
+					"visual.blocks.0.norm1.bias",
+					"visual.blocks.0.norm2.weight",
+					"visual.blocks.0.norm2.bias",
+					"visual.blocks.0.attn.qkv.weight",
+					"visual.blocks.0.attn.qkv.bias",
+					"visual.blocks.0.attn.proj.weight",
+					"visual.blocks.0.attn.proj.bias",
+					"visual.blocks.0.mlp.fc1.weight",
+					"visual.blocks.0.mlp.fc1.bias",
+					"visual.blocks.0.mlp.fc2.weight",
+					"visual.blocks.0.mlp.fc2.bias",
+					"visual.merger.ln_q.weight",
+					"visual.merger.ln_q.bias",
+					"visual.merger.mlp.0.weight",
+					"visual.merger.mlp.0.bias",
+					"visual.merger.mlp.2.weight",
+					"visual.merger.mlp.2.bias",
+				},
+				layer(0,
+					"input_layernorm.weight",
+					"self_attn.q_proj.weight",
+					"self_attn.q_proj.bias",
+					"self_attn.k_proj.weight",
+					"self_attn.k_proj.bias",
+					"self_attn.v_proj.weight",
+					"self_attn.v_proj.bias",
+					"self_attn.o_proj.weight",
+					"mlp.gate_proj.weight",
+					"mlp.up_proj.weight",
+					"mlp.down_proj.weight",
+					"post_attention_layernorm.weight",
+				),
+			),
+			kv: map[string]any{
+				"general.architecture":                     "qwen2vl",
+				"qwen2vl.block_count":                      uint32(28),
+				"qwen2vl.context_length":                   uint32(32768),
+				"qwen2vl.embedding_length":                 uint32(1536),
+				"qwen2vl.feed_forward_length":              uint32(8960),
+				"qwen2vl.attention.head_count":             uint32(12),
+				"qwen2vl.attention.head_count_kv":          uint32(2),
+				"qwen2vl.attention.layer_norm_rms_epsilon": float32(1e-6),
+				"qwen2vl.rope.dimension_count":             uint32(128),
+				"qwen2vl.rope.freq_base":                   float32(1e6),
+				"qwen2vl.rope.dimension_sections":          []int32{16, 24, 24, 0},
+				"qwen2vl.vision.block_count":               uint32(32),
+				"qwen2vl.vision.embedding_length":          uint32(1280),
+				"qwen2vl.vision.feed_forward_length":       uint32(5120),
+				"qwen2vl.vision.attention.head_count":      uint32(16),
+				"qwen2vl.vision.num_channels":              uint32(3),
+				"qwen2vl.vision.patch_size":                uint32(14),
+				"qwen2vl.vision.spatial_merge_size":        uint32(2),
+				"qwen2vl.vision.temporal_patch_size":       uint32(2),
+				"qwen2vl.vision_start_token_id":            uint32(151652),
+				"qwen2vl.vision_end_token_id":              uint32(151653),
+				"qwen2vl.image_token_id":                   uint32(151655),
+				"qwen2vl.vision.window_size":               nil,
+			},
+			want: []string{
+				"token_embd.weight",
+				"output_norm.weight",
+				"blk.0.attn_norm.weight",
+				"blk.0.attn_q.weight",
+				"blk.0.attn_q.bias",
+				"blk.0.attn_k.weight",
+				"blk.0.attn_k.bias",
+				"blk.0.attn_v.weight",
+				"blk.0.attn_v.bias",
+				"blk.0.attn_output.weight",
+				"blk.0.ffn_gate.weight",
+				"blk.0.ffn_up.weight",
+				"blk.0.ffn_down.weight",
+				"blk.0.ffn_norm.weight",
+				"v.patch_embd_0.weight",
+				"v.patch_embd_1.weight",
+				"v.blk.0.ln1.weight",
+				"v.blk.0.ln1.bias",
+				"v.blk.0.ln2.weight",
+				"v.blk.0.ln2.bias",
+				"v.blk.0.attn_qkv.weight",
+				"v.blk.0.attn_qkv.bias",
+				"v.blk.0.attn_out.weight",
+				"v.blk.0.attn_out.bias",
+				"v.blk.0.ffn_up.weight",
+				"v.blk.0.ffn_up.bias",
+				"v.blk.0.ffn_down.weight",
+				"v.blk.0.ffn_down.bias",
+				"mm.norm.weight",
+				"mm.norm.bias",
+				"mm.0.weight",
+				"mm.0.bias",
+				"mm.2.weight",
+				"mm.2.bias",
+			},
+		},
+		{
+			name: "phi-4",
+			conv: &phi3Model{},
+			tensors: slices.Concat(
+				[]string{
+					"model.embed_tokens.weight",
+					"model.norm.weight",
+					"lm_head.weight",
+				},
+				layer(0,
+					"input_layernorm.weight",
+					"self_attn.qkv_proj.weight",
+					"self_attn.o_proj.weight",
+					"mlp.gate_up_proj.weight",
+					"mlp.down_proj.weight",
+					"post_attention_layernorm.weight",
+				),
+			),
+			kv: map[string]any{
+				"general.architecture":                      "phi3",
+				"phi3.context_length":                       uint32(16384),
+				"phi3.embedding_length":                     uint32(5120),
+				"phi3.feed_forward_length":                  uint32(17920),
+				"phi3.block_count":                          uint32(40),
+				"phi3.attention.head_count":                 uint32(40),
+				"phi3.attention.head_count_kv":              uint32(10),
+				"phi3.attention.layer_norm_rms_epsilon":     float32(1e-5),
+				"phi3.rope.dimension_count":                 uint32(128),
+				"phi3.rope.freq_base":                       float32(250000),
+				"phi3.rope.scaling.original_context_length": uint32(16384),
+				"phi3.rope.scaling.attn_factor":             nil,
+				"phi3.attention.sliding_window":             uint32(0),
+			},
+			// phi-4 doesn't scale its rotary embeddings so there are
+			// no rope factors
+			want: []string{
+				"token_embd.weight",
+				"output_norm.weight",
+				"output.weight",
+				"blk.0.attn_norm.weight",
+				"blk.0.attn_qkv.weight",
+				"blk.0.attn_output.weight",
+				"blk.0.ffn_up.weight",
+				"blk.0.ffn_down.weight",
+				"blk.0.ffn_norm.weight",
+			},
+		},
+		{
+			name: "DeepSeek-V2-Lite",
+			conv: &deepseek2Model{},
+			tensors: func() []string {
+				attention := []string{
+					"input_layernorm.weight",
+					"self_attn.q_proj.weight",
+					"self_attn.kv_a_proj_with_mqa.weight",
+					"self_attn.kv_a_layernorm.weight",
+					"self_attn.kv_b_proj.weight",
+					"self_attn.o_proj.weight",
+					"post_attention_layernorm.weight",
+				}
+
+				// the first layer is dense, the rest are mixtures of experts
+				tensors := slices.Concat(
+					[]string{
+						"model.embed_tokens.weight",
+						"model.norm.weight",
+						"lm_head.weight",
+					},
+					layer(0, slices.Concat(attention, []string{
+						"mlp.gate_proj.weight",
+						"mlp.up_proj.weight",
+						"mlp.down_proj.weight",
+					})...),
+					layer(1, slices.Concat(attention, []string{
+						"mlp.gate.weight",
+						"mlp.shared_experts.gate_proj.weight",
+						"mlp.shared_experts.up_proj.weight",
+						"mlp.shared_experts.down_proj.weight",
+					})...),
+				)
+
+				for i := range 64 {
+					tensors = append(tensors, layer(1,
+						fmt.Sprintf("mlp.experts.%d.gate_proj.weight", i),
+						fmt.Sprintf("mlp.experts.%d.up_proj.weight", i),
+						fmt.Sprintf("mlp.experts.%d.down_proj.weight", i),
+					)...)
+				}
+
+				return tensors
+			}(),
+			kv: map[string]any{
+				"general.architecture":                           "deepseek2",
+				"deepseek2.vocab_size":                           uint32(102400),
+				"deepseek2.block_count":                          uint32(27),
+				"deepseek2.context_length":                       uint32(163840),
+				"deepseek2.embedding_length":                     uint32(2048),
+				"deepseek2.feed_forward_length":                  uint32(10944),
+				"deepseek2.leading_dense_block_count":            uint32(1),
+				"deepseek2.attention.head_count":                 uint32(16),
+				"deepseek2.attention.head_count_kv":              uint32(16),
+				"deepseek2.attention.layer_norm_rms_epsilon":     float32(1e-6),
+				"deepseek2.attention.q_lora_rank":                nil,
+				"deepseek2.attention.kv_lora_rank":               uint32(512),
+				"deepseek2.attention.key_length":                 uint32(192),
+				"deepseek2.attention.value_length":               uint32(128),
+				"deepseek2.rope.dimension_count":                 uint32(64),
+				"deepseek2.rope.freq_base":                       float32(10000),
+				"deepseek2.expert_count":                         uint32(64),
+				"deepseek2.expert_used_count":                    uint32(6),
+				"deepseek2.expert_shared_count":                  uint32(2),
+				"deepseek2.expert_feed_forward_length":           uint32(1408),
+				"deepseek2.expert_weights_scale":                 float32(1),
+				"deepseek2.expert_weights_norm":                  false,
+				"deepseek2.expert_gating_func":                   uint32(1),
+				"deepseek2.rope.scaling.type":                    "yarn",
+				"deepseek2.rope.scaling.factor":                  float32(40),
+				"deepseek2.rope.scaling.original_context_length": uint32(4096),
+				"deepseek2.rope.scaling.yarn_log_multiplier":     0.1 * float32(0.707),
+			},
+			want: []string{
+				"token_embd.weight",
+				"output_norm.weight",
+				"output.weight",
+				"blk.0.attn_norm.weight",
+				"blk.0.attn_q.weight",
+				"blk.0.attn_kv_a_mqa.weight",
+				"blk.0.attn_kv_a_norm.weight",
+				"blk.0.attn_kv_b.weight",
+				"blk.0.attn_output.weight",
+				"blk.0.ffn_norm.weight",
+				"blk.0.ffn_gate.weight",
+				"blk.0.ffn_up.weight",
+				"blk.0.ffn_down.weight",
+				"blk.1.attn_norm.weight",
+				"blk.1.attn_q.weight",
+				"blk.1.attn_kv_a_mqa.weight",
+				"blk.1.attn_kv_a_norm.weight",
+				"blk.1.attn_kv_b.weight",
+				"blk.1.attn_output.weight",
+				"blk.1.ffn_norm.weight",
+				"blk.1.ffn_gate_inp.weight",
+				"blk.1.ffn_gate_shexp.weight",
+				"blk.1.ffn_up_shexp.weight",
+				"blk.1.ffn_down_shexp.weight",
+				"blk.1.ffn_gate_exps.weight",
+				"blk.1.ffn_up_exps.weight",
+				"blk.1.ffn_down_exps.weight",
+			},
+		},
+		{
+			name: "granite-3.1-2b-instruct",
+			conv: &graniteModel{},
+			tensors: slices.Concat(
+				[]string{
+					"model.embed_tokens.weight",
+					"model.norm.weight",
+				},
+				layer(0,
+					"input_layernorm.weight",
+					"self_attn.q_proj.weight",
+					"self_attn.k_proj.weight",
+					"self_attn.v_proj.weight",
+					"self_attn.o_proj.weight",
+					"mlp.gate_proj.weight",
+					"mlp.up_proj.weight",
+					"mlp.down_proj.weight",
+					"post_attention_layernorm.weight",
+				),
+			),
+			kv: map[string]any{
+				"general.architecture":                     "granite",
+				"granite.vocab_size":                       uint32(49155),
+				"granite.block_count":                      uint32(40),
+				"granite.context_length":                   uint32(131072),
+				"granite.embedding_length":                 uint32(2048),
+				"granite.feed_forward_length":              uint32(8192),
+				"granite.attention.head_count":             uint32(32),
+				"granite.attention.head_count_kv":          uint32(8),
+				"granite.attention.layer_norm_rms_epsilon": float32(1e-5),
+				"granite.rope.dimension_count":             uint32(64),
+				"granite.rope.freq_base":                   float32(5e6),
+				"granite.embedding_scale":                  float32(12),
+				"granite.residual_scale":                   float32(0.22),
+				"granite.attention.scale":                  float32(0.015625),
+				"granite.logit_scale":                      float32(8),
+				"granite.expert_count":                     nil,
+			},
+			want: []string{
+				"token_embd.weight",
+				"output_norm.weight",
+				"blk.0.attn_norm.weight",
+				"blk.0.attn_q.weight",
+				"blk.0.attn_k.weight",
+				"blk.0.attn_v.weight",
+				"blk.0.attn_output.weight",
+				"blk.0.ffn_gate.weight",
+				"blk.0.ffn_up.weight",
+				"blk.0.ffn_down.weight",
+				"blk.0.ffn_norm.weight",
+			},
+		},
+		{
+			name: "OLMo-2-1124-7B",
+			conv: &olmoModel{Architecture: "Olmo2ForCausalLM"},
+			tensors: slices.Concat(
+				[]string{
+					"model.embed_tokens.weight",
+					"model.norm.weight",
+					"lm_head.weight",
+				},
+				layer(0,
+					"self_attn.q_proj.weight",
+					"self_attn.k_proj.weight",
+					"self_attn.v_proj.weight",
+					"self_attn.o_proj.weight",
+					"self_attn.q_norm.weight",
+					"self_attn.k_norm.weight",
+					"mlp.gate_proj.weight",
+					"mlp.up_proj.weight",
+					"mlp.down_proj.weight",
+					"post_attention_layernorm.weight",
+					"post_feedforward_layernorm.weight",
+				),
+			),
+			kv: map[string]any{
+				"general.architecture":                   "olmo2",
+				"olmo2.block_count":                      uint32(32),
+				"olmo2.context_length":                   uint32(4096),
+				"olmo2.embedding_length":                 uint32(4096),
+				"olmo2.feed_forward_length":              uint32(11008),
+				"olmo2.attention.head_count":             uint32(32),
+				"olmo2.attention.head_count_kv":          uint32(32),
+				"olmo2.attention.layer_norm_rms_epsilon": float32(1e-6),
+				"olmo2.rope.dimension_count":             uint32(128),
+				"olmo2.rope.freq_base":                   float32(500000),
+			},
+			want: []string{
+				"token_embd.weight",
+				"output_norm.weight",
+				"output.weight",
+				"blk.0.attn_q.weight",
+				"blk.0.attn_k.weight",
+				"blk.0.attn_v.weight",
+				"blk.0.attn_output.weight",
+				"blk.0.attn_q_norm.weight",
+				"blk.0.attn_k_norm.weight",
+				"blk.0.ffn_gate.weight",
+				"blk.0.ffn_up.weight",
+				"blk.0.ffn_down.weight",
+				"blk.0.post_attention_norm.weight",
+				"blk.0.post_ffw_norm.weight",
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := os.ReadFile(filepath.Join("testdata", "configs", tt.name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := json.Unmarshal(config, tt.conv); err != nil {
+				t.Fatal(err)
+			}
+
+			kv := tt.conv.KV(&Tokenizer{Vocabulary: &Vocabulary{Model: "gpt2"}, Pre: "default"})
+			for k, want := range tt.kv {
+				if diff := cmp.Diff(want, kv[k]); diff != "" {
+					t.Errorf("%s mismatch (-want +got):\n%s", k, diff)
+				}
+			}
+
+			shapes := make(map[string][]int)
+			for _, name := range tt.tensors {
+				switch {
+				case strings.Contains(name, "patch_embed"):
+					// [embed_dim, channels, temporal_patch_size, patch_size, patch_size]
+					shapes[name] = []int{1, 1, 2, 1, 1}
+				case strings.HasSuffix(name, ".bias"), strings.Contains(name, "norm"), strings.Contains(name, "ln_q"):
+					shapes[name] = []int{1}
+				default:
+					shapes[name] = []int{1, 1}
+				}
+			}
+
+			tempDir := t.TempDir()
+			generateSafetensorsModel(t, tempDir, string(config), shapes)
+
+			ts, err := parseTensors(os.DirFS(tempDir), strings.NewReplacer(tt.conv.Replacements()...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, t := range tt.conv.Tensors(ts) {
+				names = append(names, t.Name)
+			}
+
+			slices.Sort(names)
+			want := slices.Sorted(slices.Values(tt.want))
+			if diff := cmp.Diff(want, names); diff != "" {
+				t.Errorf("tensors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"io/fs"
 	"strings"
+
+	"github.com/pdevine/tensor"
+	"github.com/pdevine/tensor/native"
 )
 
 type Tensor interface {
@@ -59,6 +62,31 @@ func (t *tensorBase) SetRepacker(fn Repacker) {
 
 type Repacker func(string, []float32, []uint64) ([]float32, error)
 
+// sliceRepacker returns a Repacker which keeps the slice of each dimension
+// of a tensor, e.g. to split a fused tensor into its parts.
+func sliceRepacker(slice ...tensor.Slice) Repacker {
+	return func(_ string, data []float32, shape []uint64) ([]float32, error) {
+		dims := make([]int, len(shape))
+		for i, dim := range shape {
+			dims[i] = int(dim)
+		}
+
+		var t tensor.Tensor = tensor.New(tensor.WithShape(dims...), tensor.WithBacking(data))
+		t, err := t.Slice(slice...)
+		if err != nil {
+			return nil, err
+		}
+
+		t = tensor.Materialize(t)
+		// flatten tensor so it can be return as a vector
+		if err := t.Reshape(t.Shape().TotalSize()); err != nil {
+			return nil, err
+		}
+
+		return native.VectorF32(t.(*tensor.Dense))
+	}
+}
+
 func parseTensors(fsys fs.FS, replacer *strings.Replacer) ([]Tensor, error) {
 	patterns := []struct {
 		Pattern string
@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ollama/ollama/format"
 )
 
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
@@ -94,6 +96,18 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// ReadOnlyModels returns additional models directories which are searched, in order, after the Models directory. Models in these directories are never modified or removed.
+// ReadOnlyModels can be configured via the OLLAMA_MODELS_READONLY environment variable as a list of paths separated by the OS path list separator (":" on most systems, ";" on Windows).
+func ReadOnlyModels() (dirs []string) {
+	for _, dir := range filepath.SplitList(Var("OLLAMA_MODELS_READONLY")) {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -134,6 +148,52 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// PullRateLimit returns the maximum combined download rate, in bytes per second, for all model pulls. PullRateLimit can be configured via the OLLAMA_PULL_RATE_LIMIT environment variable using sizes such as "20M".
+// Default is 0, which means no limit.
+func PullRateLimit() int64 {
+	if s := Var("OLLAMA_PULL_RATE_LIMIT"); s != "" {
+		n, err := format.ParseBytes(s)
+		if err != nil {
+			slog.Warn("invalid environment variable, using default", "key", "OLLAMA_PULL_RATE_LIMIT", "value", s, "default", 0)
+			return 0
+		}
+		return n
+	}
+
+	return 0
+}
+
+// AuditMaxSize returns the size, in bytes, at which the audit log is rotated. AuditMaxSize can be configured via the OLLAMA_AUDIT_MAX_SIZE environment variable using sizes such as "100M".
+// Default is 100MB.
+func AuditMaxSize() int64 {
+	const defaultSize = 100 * format.MegaByte
+	if s := Var("OLLAMA_AUDIT_MAX_SIZE"); s != "" {
+		n, err := format.ParseBytes(s)
+		if err != nil || n <= 0 {
+			slog.Warn("invalid environment variable, using default", "key", "OLLAMA_AUDIT_MAX_SIZE", "value", s, "default", defaultSize)
+			return defaultSize
+		}
+		return n
+	}
+
+	return defaultSize
+}
+
+// TraceEndpoint returns the URL that traces are exported to with OTLP over HTTP. TraceEndpoint can be configured via the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT environment variable,
+// or OTEL_EXPORTER_OTLP_ENDPOINT, which has "/v1/traces" appended.
+// Default is no endpoint, which disables tracing.
+func TraceEndpoint() string {
+	if s := Var("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); s != "" {
+		return s
+	}
+
+	if s := Var("OTEL_EXPORTER_OTLP_ENDPOINT"); s != "" {
+		return strings.TrimRight(s, "/") + "/v1/traces"
+	}
+
+	return ""
+}
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -172,6 +232,28 @@ var (
 	ContextLength = Uint("OLLAMA_CONTEXT_LENGTH", 4096)
 	// DisableTokenTag allows specifying a tag whose content should not be sent
 	DisableTokenTag = String("OLLAMA_DISABLE_TOKEN_TAG")
+	// Preload is the path of a JSON file listing models to load at startup and keep loaded
+	Preload = String("OLLAMA_PRELOAD")
+	// APIKeys is the path of a JSON file of hashed API keys that requests must have one of
+	APIKeys = String("OLLAMA_API_KEYS")
+	// APIKey is the API key that clients send to the server
+	APIKey = String("OLLAMA_API_KEY")
+	// AuditLog is the path of a JSONL file that requests are logged to
+	AuditLog = String("OLLAMA_AUDIT_LOG")
+	// AuditBodies includes prompts and responses in the audit log
+	AuditBodies = Bool("OLLAMA_AUDIT_BODIES")
+	// AuditRedact is the path of a file of regular expressions, one per line, whose matches are redacted from the audit log
+	AuditRedact = String("OLLAMA_AUDIT_REDACT")
+	// TLSCert and TLSKey are the paths of the PEM certificate and key the server listens for TLS with
+	TLSCert = String("OLLAMA_TLS_CERT")
+	TLSKey  = String("OLLAMA_TLS_KEY")
+	// TLSClientCA is the path of PEM CA certificates that the server requires client certificates to be signed by
+	TLSClientCA = String("OLLAMA_TLS_CLIENT_CA")
+	// TLSCA is the path of PEM CA certificates that clients trust the server's certificate to be signed by, in addition to the system's
+	TLSCA = String("OLLAMA_TLS_CA")
+	// TLSClientCert and TLSClientKey are the paths of the PEM certificate and key that clients present to the server
+	TLSClientCert = String("OLLAMA_TLS_CLIENT_CERT")
+	TLSClientKey  = String("OLLAMA_TLS_CLIENT_KEY")
 )
 
 func String(s string) func() string {
@@ -213,6 +295,10 @@ var (
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// MaxPulls sets the maximum number of models pulled at once. Additional pulls are queued. MaxPulls can be configured via the OLLAMA_MAX_PULLS environment variable.
+	MaxPulls = Uint("OLLAMA_MAX_PULLS", 0)
+	// AuditMaxFiles sets the number of rotated audit logs that are kept. AuditMaxFiles can be configured via the OLLAMA_AUDIT_MAX_FILES environment variable.
+	AuditMaxFiles = Uint("OLLAMA_AUDIT_MAX_FILES", 5)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -240,26 +326,40 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
-		"OLLAMA_CONTEXT_LENGTH":    {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 4096)"},
-		"OLLAMA_NEW_ENGINE":        {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
-		"OLLAMA_DISABLE_TOKEN_TAG": {"OLLAMA_DISABLE_TOKEN_TAG", DisableTokenTag(), "Specify a tag whose content should not be sent (e.g., 'think')"},
+		"OLLAMA_API_KEYS":             {"OLLAMA_API_KEYS", APIKeys(), "Path of a JSON file of hashed API keys required to use the server"},
+		"OLLAMA_AUDIT_LOG":            {"OLLAMA_AUDIT_LOG", AuditLog(), "Path of a JSONL file to log requests to"},
+		"OLLAMA_AUDIT_BODIES":         {"OLLAMA_AUDIT_BODIES", AuditBodies(), "Include prompts and responses in the audit log"},
+		"OLLAMA_AUDIT_REDACT":         {"OLLAMA_AUDIT_REDACT", AuditRedact(), "Path of a file of regular expressions to redact from the audit log"},
+		"OLLAMA_AUDIT_MAX_SIZE":       {"OLLAMA_AUDIT_MAX_SIZE", AuditMaxSize(), "Size at which the audit log is rotated, e.g. 100M (default: 100M)"},
+		"OLLAMA_AUDIT_MAX_FILES":      {"OLLAMA_AUDIT_MAX_FILES", AuditMaxFiles(), "Number of rotated audit logs to keep (default: 5)"},
+		"OLLAMA_DEBUG":                {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":      {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":        {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_GPU_OVERHEAD":         {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_HOST":                 {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":           {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":          {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":         {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":    {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":            {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_PULLS":            {"OLLAMA_MAX_PULLS", MaxPulls(), "Maximum number of models pulled at once (default: unlimited)"},
+		"OLLAMA_PULL_RATE_LIMIT":      {"OLLAMA_PULL_RATE_LIMIT", PullRateLimit(), "Maximum combined download rate for pulls in bytes per second, e.g. 20M (default: unlimited)"},
+		"OLLAMA_MODELS":               {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_MODELS_READONLY":      {"OLLAMA_MODELS_READONLY", ReadOnlyModels(), "A list of read-only models directories searched after OLLAMA_MODELS"},
+		"OLLAMA_NOHISTORY":            {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":              {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":         {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":              {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
+		"OLLAMA_PRELOAD":              {"OLLAMA_PRELOAD", Preload(), "Path to a JSON file of models to load at startup, optionally pinned in memory"},
+		"OLLAMA_TLS_CERT":             {"OLLAMA_TLS_CERT", TLSCert(), "Path of a PEM certificate to serve TLS with"},
+		"OLLAMA_TLS_KEY":              {"OLLAMA_TLS_KEY", TLSKey(), "Path of the PEM private key of OLLAMA_TLS_CERT"},
+		"OLLAMA_TLS_CLIENT_CA":        {"OLLAMA_TLS_CLIENT_CA", TLSClientCA(), "Path of PEM CA certificates to require client certificates from"},
+		"OLLAMA_SCHED_SPREAD":         {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_MULTIUSER_CACHE":      {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_CONTEXT_LENGTH":       {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 4096)"},
+		"OLLAMA_NEW_ENGINE":           {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_DISABLE_TOKEN_TAG":    {"OLLAMA_DISABLE_TOKEN_TAG", DisableTokenTag(), "Specify a tag whose content should not be sent (e.g., 'think')"},
+		"OTEL_EXPORTER_OTLP_ENDPOINT": {"OTEL_EXPORTER_OTLP_ENDPOINT", TraceEndpoint(), "OpenTelemetry collector to export traces to with OTLP over HTTP"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -2,6 +2,8 @@ package envconfig
 
 import (
 	"math"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -292,3 +294,62 @@ func TestContextLength(t *testing.T) {
 		})
 	}
 }
+
+func TestPullRateLimit(t *testing.T) {
+	cases := map[string]int64{
+		"":        0,
+		"1000":    1000,
+		"20M":     20_000_000,
+		"1.5G":    1_500_000_000,
+		"invalid": 0,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_PULL_RATE_LIMIT", k)
+			if i := PullRateLimit(); i != v {
+				t.Errorf("%s: expected %d, got %d", k, v, i)
+			}
+		})
+	}
+}
+
+func TestReadOnlyModels(t *testing.T) {
+	sep := string(filepath.ListSeparator)
+	cases := map[string][]string{
+		"":                                      nil,
+		"/mnt/models":                           {"/mnt/models"},
+		"/mnt/a" + sep + "/mnt/b":               {"/mnt/a", "/mnt/b"},
+		"/mnt/a" + sep + sep + " /mnt/b " + sep: {"/mnt/a", "/mnt/b"},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS_READONLY", k)
+			if dirs := ReadOnlyModels(); !slices.Equal(dirs, v) {
+				t.Errorf("%s: expected %q, got %q", k, v, dirs)
+			}
+		})
+	}
+}
+
+func TestTraceEndpoint(t *testing.T) {
+	cases := []struct {
+		endpoint, traces, want string
+	}{
+		{"", "", ""},
+		{"http://localhost:4318", "", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318/", "", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318", "http://collector:4318/traces", "http://collector:4318/traces"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.endpoint+tt.traces, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.endpoint)
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", tt.traces)
+			if got := TraceEndpoint(); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
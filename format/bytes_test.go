@@ -89,3 +89,43 @@ func TestHumanBytes2(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		err      bool
+	}{
+		{"0", 0, false},
+		{"100", 100, false},
+		{"100B", 100, false},
+		{"20M", 20 * MegaByte, false},
+		{"20MB", 20 * MegaByte, false},
+		{"1.5G", 1500 * MegaByte, false},
+		{"512k", 512 * KiloByte, false},
+		{"2MiB", 2 * MebiByte, false},
+		{" 1 GB ", GigaByte, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"10X", 0, true},
+		{"-1M", 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseBytes(tc.input)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("ParseBytes(%q) = %d, want %d", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
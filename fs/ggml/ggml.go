@@ -128,6 +128,18 @@ func (kv KV) OllamaEngineRequired() bool {
 	}, kv.Architecture())
 }
 
+// OllamaEngineRequired reports whether the model can only be run by the
+// Ollama engine. Qwen2-VL converted by llama.cpp keeps its vision model in
+// a separate projector, which only the compatibility engine can load, so
+// only files with the vision model require the Ollama engine.
+func (f GGML) OllamaEngineRequired() bool {
+	if f.KV().Architecture() == "qwen2vl" {
+		return len(f.Tensors().Items("v.")) > 0
+	}
+
+	return f.KV().OllamaEngineRequired()
+}
+
 type valueTypes interface {
 	uint8 | int8 | uint16 | int16 |
 		uint32 | int32 | uint64 | int64 |
@@ -442,9 +454,17 @@ func (f GGML) GraphSize(context, batch uint64, numParallel int, kvCacheType stri
 			4*batch*(embedding+vocab)+embedding*vocab*105/128,
 		)
 
-		if ffnGateExpsWeight, ok := layers["blk.0"]["ffn_gate_exps.weight"]; ok {
+		// experts may not be in every layer so find the largest
+		var ffnGateExpsWeight *Tensor
+		for i := range f.KV().BlockCount() {
+			if t, ok := layers[fmt.Sprintf("blk.%d", i)]["ffn_gate_exps.weight"]; ok && (ffnGateExpsWeight == nil || t.Size() > ffnGateExpsWeight.Size()) {
+				ffnGateExpsWeight = t
+			}
+		}
+
+		if ffnGateExpsWeight != nil {
 			// mixtral 8x22b
-			ff := uint64(f.KV().Uint("feed_forward_length"))
+			ff := ffnGateExpsWeight.Shape[1]
 			partialOffload = max(
 				3*ffnGateExpsWeight.Size()+4*batch*(2*ff+headsKV+embedding+context+embeddingHeads*headsKV),
 				4*(context*batch*heads+context*embeddingHeads*headsKV+batch*1024+embeddingHeads*headsKV*batch),
@@ -643,6 +663,15 @@ func (llm GGML) VisionGraphSize() (weights, graphSize uint64) {
 	case "llama4":
 		// vision graph is computed independently in the same schedule
 		// and is negligible compared to the worst case text graph
+	case "qwen2vl":
+		// images are processed at their native resolution up to a maximum
+		// number of pixels
+		maxPixels := uint64(llm.KV().Uint("vision.max_pixels", 14*14*4*1280))
+		numPatches = maxPixels / (patchSize * patchSize)
+
+		graphSize = 4 * (maxPixels*numChannels +
+			embeddingLength*patchSize +
+			numPatches*numPatches*headCount)
 	}
 
 	return weights, graphSize
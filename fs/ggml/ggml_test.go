@@ -269,3 +269,31 @@ func TestKeyValue(t *testing.T) {
 		t.Errorf("unexpected uint8s (-got +want):\n%s", diff)
 	}
 }
+
+func TestOllamaEngineRequired(t *testing.T) {
+	cases := []struct {
+		name    string
+		kv      KV
+		tensors []string
+		want    bool
+	}{
+		{"llama", KV{"general.architecture": "llama"}, []string{"token_embd.weight"}, false},
+		{"gemma3", KV{"general.architecture": "gemma3"}, []string{"token_embd.weight"}, true},
+		{"qwen2vl", KV{"general.architecture": "qwen2vl"}, []string{"token_embd.weight", "v.patch_embd_0.weight"}, true},
+		{"qwen2vl without vision", KV{"general.architecture": "qwen2vl"}, []string{"token_embd.weight"}, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var tensors []*Tensor
+			for _, name := range tt.tensors {
+				tensors = append(tensors, &Tensor{Name: name})
+			}
+
+			f := GGML{model: &gguf{kv: tt.kv, tensors: tensors}}
+			if got := f.OllamaEngineRequired(); got != tt.want {
+				t.Errorf("OllamaEngineRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
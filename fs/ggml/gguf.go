@@ -535,7 +535,7 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		if err := ggufWriteTensorInfo(ws, t); err != nil {
 			return err
 		}
-		s += t.Size()
+		s = t.Offset + t.Size()
 	}
 
 	for _, t := range ts {
@@ -559,10 +559,26 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
@@ -574,32 +590,74 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 	case []float32:
 		err = writeGGUFArray(ws, ggufTypeFloat32, v)
 	case []string:
-		if err := binary.Write(ws, binary.LittleEndian, ggufTypeArray); err != nil {
-			return err
+		err = writeGGUFStrings(ws, v)
+	// arrays as read by Decode, which must not have been truncated
+	case *array[uint8]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeUint8, v)
+	case *array[int8]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeInt8, v)
+	case *array[uint16]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeUint16, v)
+	case *array[int16]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeInt16, v)
+	case *array[uint32]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeUint32, v)
+	case *array[int32]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeInt32, v)
+	case *array[uint64]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeUint64, v)
+	case *array[int64]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeInt64, v)
+	case *array[float32]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeFloat32, v)
+	case *array[float64]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeFloat64, v)
+	case *array[bool]:
+		err = writeGGUFDecodedArray(ws, k, ggufTypeBool, v)
+	case *array[string]:
+		if len(v.values) != v.size {
+			return fmt.Errorf("array '%s' was truncated when decoded", k)
 		}
+		err = writeGGUFStrings(ws, v.values)
+	default:
+		return fmt.Errorf("improper type for '%s'", k)
+	}
+
+	return err
+}
+
+func writeGGUFStrings(ws io.Writer, v []string) error {
+	if err := binary.Write(ws, binary.LittleEndian, ggufTypeArray); err != nil {
+		return err
+	}
+
+	if err := binary.Write(ws, binary.LittleEndian, ggufTypeString); err != nil {
+		return err
+	}
 
-		if err := binary.Write(ws, binary.LittleEndian, ggufTypeString); err != nil {
+	if err := binary.Write(ws, binary.LittleEndian, uint64(len(v))); err != nil {
+		return err
+	}
+
+	for _, e := range v {
+		if err := binary.Write(ws, binary.LittleEndian, uint64(len(e))); err != nil {
 			return err
 		}
 
-		if err := binary.Write(ws, binary.LittleEndian, uint64(len(v))); err != nil {
+		if err := binary.Write(ws, binary.LittleEndian, []byte(e)); err != nil {
 			return err
 		}
+	}
 
-		for _, e := range v {
-			if err := binary.Write(ws, binary.LittleEndian, uint64(len(e))); err != nil {
-				return err
-			}
+	return nil
+}
 
-			if err := binary.Write(ws, binary.LittleEndian, []byte(e)); err != nil {
-				return err
-			}
-		}
-	default:
-		return fmt.Errorf("improper type for '%s'", k)
+func writeGGUFDecodedArray[T any](w io.Writer, k string, t uint32, a *array[T]) error {
+	if len(a.values) != a.size {
+		return fmt.Errorf("array '%s' was truncated when decoded", k)
 	}
 
-	return err
+	return writeGGUFArray(w, t, a.values)
 }
 
 func ggufWriteTensorInfo(ws io.WriteSeeker, t Tensor) error {
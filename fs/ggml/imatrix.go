@@ -0,0 +1,157 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Imatrix is an importance matrix: the mean of the squared activations of
+// each column of a model's weights over a calibration dataset. It's read and
+// written in the format of llama.cpp's imatrix tool so importance matrices
+// can be exchanged with it.
+type Imatrix struct {
+	// Entries are the importance of the columns of weights by tensor name
+	Entries map[string]ImatrixEntry
+
+	// Chunks is the number of chunks of the dataset which were evaluated
+	Chunks int
+
+	// Dataset identifies the calibration dataset, e.g. by file name or
+	// digest
+	Dataset string
+}
+
+type ImatrixEntry struct {
+	// Calls is the number of times the weight was evaluated
+	Calls int
+
+	// Values are the mean squared activations of each column
+	Values []float32
+}
+
+// maxImatrixValues limits the size of entries read so corrupt files fail
+// early rather than allocating huge amounts of memory
+const maxImatrixValues = 1 << 24
+
+// ReadImatrix reads an importance matrix from r.
+func ReadImatrix(r io.Reader) (*Imatrix, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return nil, fmt.Errorf("invalid imatrix: %d entries", n)
+	}
+
+	m := Imatrix{Entries: make(map[string]ImatrixEntry, n)}
+	for range n {
+		name, err := readImatrixString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var calls, size int32
+		if err := binary.Read(r, binary.LittleEndian, &calls); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+
+		if size < 0 || size > maxImatrixValues {
+			return nil, fmt.Errorf("invalid imatrix: %s has %d values", name, size)
+		}
+
+		values := make([]float32, size)
+		if err := binary.Read(r, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+
+		// values are stored multiplied by the number of calls
+		if calls > 0 {
+			for i := range values {
+				values[i] /= float32(calls)
+			}
+		}
+
+		m.Entries[name] = ImatrixEntry{Calls: int(calls), Values: values}
+	}
+
+	// older files end here
+	var chunks int32
+	if err := binary.Read(r, binary.LittleEndian, &chunks); errors.Is(err, io.EOF) {
+		return &m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m.Chunks = int(chunks)
+
+	dataset, err := readImatrixString(r)
+	if err != nil {
+		return nil, err
+	}
+	m.Dataset = dataset
+
+	return &m, nil
+}
+
+func readImatrixString(r io.Reader) (string, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+
+	if n < 0 || n > 1<<16 {
+		return "", fmt.Errorf("invalid imatrix: string of length %d", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// WriteTo writes m to w. Entries are written in order of their names so the
+// output is deterministic.
+func (m *Imatrix) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	write := func(v any) {
+		// writes to a bytes.Buffer can't fail
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	writeString := func(s string) {
+		write(int32(len(s)))
+		b.WriteString(s)
+	}
+
+	write(int32(len(m.Entries)))
+	for _, name := range slices.Sorted(maps.Keys(m.Entries)) {
+		e := m.Entries[name]
+		writeString(name)
+		write(int32(e.Calls))
+		write(int32(len(e.Values)))
+
+		values := slices.Clone(e.Values)
+		if e.Calls > 0 {
+			for i := range values {
+				values[i] *= float32(e.Calls)
+			}
+		}
+		write(values)
+	}
+
+	write(int32(m.Chunks))
+	writeString(m.Dataset)
+
+	return b.WriteTo(w)
+}
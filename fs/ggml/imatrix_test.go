@@ -0,0 +1,84 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImatrix(t *testing.T) {
+	m := Imatrix{
+		Entries: map[string]ImatrixEntry{
+			"blk.0.attn_q.weight": {Calls: 4, Values: []float32{0.5, 1, 2, 0}},
+			"output.weight":       {Calls: 2, Values: []float32{3, 4}},
+			"blk.0.ffn_up.weight": {Values: []float32{}},
+		},
+		Chunks:  4,
+		Dataset: "sha256:0123",
+	}
+
+	var b bytes.Buffer
+	n, err := m.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo = %d, wrote %d", n, b.Len())
+	}
+
+	// entries are written in order of their names
+	raw := b.Bytes()
+	var first int32
+	if err := binary.Read(bytes.NewReader(raw[4:]), binary.LittleEndian, &first); err != nil {
+		t.Fatal(err)
+	}
+	if name := string(raw[8 : 8+first]); name != "blk.0.attn_q.weight" {
+		t.Errorf("first entry = %q, want entries in order", name)
+	}
+
+	got, err := ReadImatrix(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&m, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("without dataset", func(t *testing.T) {
+		// files written by older versions of llama.cpp end after the entries
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, int32(1))
+		binary.Write(&b, binary.LittleEndian, int32(len("output.weight")))
+		b.WriteString("output.weight")
+		binary.Write(&b, binary.LittleEndian, int32(2))
+		binary.Write(&b, binary.LittleEndian, int32(2))
+		binary.Write(&b, binary.LittleEndian, []float32{2, 6})
+
+		got, err := ReadImatrix(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Imatrix{Entries: map[string]ImatrixEntry{"output.weight": {Calls: 2, Values: []float32{1, 3}}}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range [][]byte{
+			nil,
+			{0xff, 0xff, 0xff, 0xff},
+			raw[:20],
+			raw[:len(raw)-2],
+		} {
+			if _, err := ReadImatrix(bytes.NewReader(b)); err == nil {
+				t.Errorf("expected an error for %v", b)
+			}
+		}
+	})
+}
@@ -0,0 +1,1231 @@
+package ggml
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/x448/float16"
+)
+
+// tensor types, i.e. the ggml_type of a tensor's data
+const (
+	tensorTypeF32  uint32 = 0
+	tensorTypeF16  uint32 = 1
+	tensorTypeQ4_0 uint32 = 2
+	tensorTypeQ8_0 uint32 = 8
+	tensorTypeQ3_K uint32 = 11
+	tensorTypeQ4_K uint32 = 12
+	tensorTypeQ5_K uint32 = 13
+	tensorTypeQ6_K uint32 = 14
+	tensorTypeBF16 uint32 = 30
+)
+
+// tensorTypes are the tensor types which can be read and written by
+// [Quantize], by name
+var tensorTypes = map[string]uint32{
+	"F32":  tensorTypeF32,
+	"F16":  tensorTypeF16,
+	"BF16": tensorTypeBF16,
+	"Q8_0": tensorTypeQ8_0,
+	"Q4_0": tensorTypeQ4_0,
+	"Q3_K": tensorTypeQ3_K,
+	"Q4_K": tensorTypeQ4_K,
+	"Q5_K": tensorTypeQ5_K,
+	"Q6_K": tensorTypeQ6_K,
+}
+
+// quantizeSources are the tensor types [Quantize] can convert from. Tensors
+// quantized with other types can't be requantized.
+var quantizeSources = []uint32{tensorTypeF32, tensorTypeF16, tensorTypeBF16, tensorTypeQ8_0}
+
+// QuantizeFileTypes are the file types [Quantize] can produce.
+var QuantizeFileTypes = []string{"Q8_0", "Q4_0", "Q3_K_M", "Q4_K_M", "Q5_K_M", "Q6_K"}
+
+// ErrQuantizeUnsupported is returned by [Quantize] for tensors whose type it
+// cannot read.
+var ErrQuantizeUnsupported = errors.New("unsupported tensor type for quantization")
+
+type QuantizeOptions struct {
+	// TensorTypes overrides the type of tensors whose names match a
+	// pattern, in the syntax of [path.Match], e.g. "token_embd.weight": "Q8_0"
+	// or "blk.*.attn_v.weight": "Q6_K". A tensor's name takes precedence
+	// over patterns, and if several patterns match, the longest wins. Only
+	// tensors which would otherwise be quantized can be overridden.
+	TensorTypes map[string]string
+
+	// Imatrix weights the quantization error of each column of the
+	// tensors it has entries for by its importance. Without it, larger
+	// weights are considered more important. It's most useful for low bit
+	// quantizations such as Q3_K_M.
+	Imatrix *Imatrix
+
+	// Progress is called after each tensor is written with the number of
+	// bytes of tensor data of the input read so far, and in total.
+	Progress func(tensor string, completed, total uint64)
+}
+
+// Quantize reads a GGUF model from rs and writes it to ws with its tensors
+// quantized to ft, which must be one of [QuantizeFileTypes]. The input may
+// be F32, F16, BF16 or Q8_0.
+//
+// Like llama.cpp, some tensors are kept in higher precision depending on
+// ft: norms and other one dimensional tensors are left as they are, and the
+// output tensor and some attention and feed forward tensors of K-quants use
+// Q6_K.
+func Quantize(ws io.WriteSeeker, rs io.ReadSeeker, ft fileType, opts QuantizeOptions) error {
+	if !slices.Contains(QuantizeFileTypes, ft.String()) {
+		return fmt.Errorf("quantizing to %s is not supported, use one of %s", ft, strings.Join(QuantizeFileTypes, ", "))
+	}
+
+	overrides := make(map[string]uint32, len(opts.TensorTypes))
+	for pattern, name := range opts.TensorTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid tensor pattern %q: %w", pattern, err)
+		}
+
+		kind, ok := tensorTypes[strings.ToUpper(name)]
+		if !ok {
+			return fmt.Errorf("unsupported tensor type %q for %q, use one of %s", name, pattern, strings.Join(slices.Sorted(maps.Keys(tensorTypes)), ", "))
+		}
+		overrides[pattern] = kind
+	}
+
+	f, _, err := Decode(rs, -1)
+	if err != nil {
+		return err
+	}
+
+	q := quantizer{
+		fileType:  ft,
+		overrides: overrides,
+		layers:    int(f.KV().BlockCount()),
+	}
+
+	items := f.Tensors().Items()
+	q.tiedOutput = !slices.ContainsFunc(items, func(t *Tensor) bool { return t.Name == "output.weight" })
+
+	var total uint64
+	for _, t := range items {
+		total += t.Size()
+	}
+
+	var completed uint64
+	ts := make([]Tensor, len(items))
+	for i, t := range items {
+		kind := q.tensorType(t)
+		if kind != t.Kind && !slices.Contains(quantizeSources, t.Kind) {
+			return fmt.Errorf("%w: %s is %s", ErrQuantizeUnsupported, t.Name, tensorTypeName(t.Kind))
+		}
+
+		var importance []float32
+		if opts.Imatrix != nil && kind != t.Kind {
+			if e, ok := opts.Imatrix.Entries[t.Name]; ok {
+				if uint64(len(e.Values)) != t.Shape[0] {
+					return fmt.Errorf("imatrix for %s has %d values, expected %d", t.Name, len(e.Values), t.Shape[0])
+				}
+				importance = e.Values
+			}
+		}
+
+		ts[i] = Tensor{
+			Name:  t.Name,
+			Kind:  kind,
+			Shape: t.Shape,
+			WriterTo: &quantizeWriter{
+				rs:         rs,
+				offset:     int64(f.Tensors().Offset + t.Offset),
+				src:        t,
+				kind:       kind,
+				importance: importance,
+				done: func() {
+					completed += t.Size()
+					if opts.Progress != nil {
+						opts.Progress(t.Name, completed, total)
+					}
+				},
+			},
+		}
+	}
+
+	kv := maps.Clone(f.KV())
+	kv["general.file_type"] = ft.Value()
+	kv["general.quantization_version"] = uint32(2)
+	if opts.Imatrix != nil {
+		kv["quantize.imatrix.dataset"] = opts.Imatrix.Dataset
+		kv["quantize.imatrix.entries_count"] = int32(len(opts.Imatrix.Entries))
+		kv["quantize.imatrix.chunks_count"] = int32(opts.Imatrix.Chunks)
+	}
+	return WriteGGUF(ws, kv, ts)
+}
+
+type quantizer struct {
+	fileType  fileType
+	overrides map[string]uint32
+
+	// layers is the number of blocks of the model
+	layers int
+
+	// tiedOutput is set if the model has no output tensor, in which case
+	// the token embeddings are used as the output and quantized like it
+	tiedOutput bool
+}
+
+// tensorType returns the type t is quantized to.
+func (q quantizer) tensorType(t *Tensor) uint32 {
+	if !quantizable(t) {
+		return t.Kind
+	}
+
+	kind := q.defaultType(t)
+
+	if k, ok := q.overrides[t.Name]; ok {
+		kind = k
+	} else {
+		var pattern string
+		for p, k := range q.overrides {
+			if ok, _ := path.Match(p, t.Name); ok && (len(p) > len(pattern) || len(p) == len(pattern) && p < pattern) {
+				pattern, kind = p, k
+			}
+		}
+	}
+
+	// rows must be a whole number of blocks, otherwise fall back to a type
+	// with smaller blocks
+	for _, fallback := range []uint32{kind, tensorTypeQ8_0, tensorTypeF16} {
+		if t.Shape[0]%(Tensor{Kind: fallback}).blockSize() == 0 {
+			return fallback
+		}
+	}
+
+	return tensorTypeF16
+}
+
+func (q quantizer) defaultType(t *Tensor) uint32 {
+	name := t.Name
+	if q.tiedOutput && name == "token_embd.weight" {
+		name = "output.weight"
+	}
+
+	switch q.fileType {
+	case fileTypeQ8_0:
+		return tensorTypeQ8_0
+	case fileTypeQ4_0:
+		if name == "output.weight" {
+			return tensorTypeQ6_K
+		}
+		return tensorTypeQ4_0
+	case fileTypeQ6_K:
+		return tensorTypeQ6_K
+	case fileTypeQ3_K_M:
+		switch {
+		case name == "output.weight":
+			return tensorTypeQ6_K
+		case strings.HasSuffix(name, "attn_v.weight"):
+			if t.block() < 2 {
+				return tensorTypeQ5_K
+			}
+			return tensorTypeQ4_K
+		case strings.HasSuffix(name, "ffn_down.weight"):
+			if t.block() < q.layers/16 {
+				return tensorTypeQ5_K
+			}
+			return tensorTypeQ4_K
+		case strings.HasSuffix(name, "attn_output.weight"), strings.HasSuffix(name, "attn_qkv.weight"):
+			return tensorTypeQ4_K
+		}
+		return tensorTypeQ3_K
+	}
+
+	// Q4_K_M and Q5_K_M
+	kind, more := tensorTypeQ4_K, tensorTypeQ5_K
+	if q.fileType == fileTypeQ5_K_M {
+		kind, more = tensorTypeQ5_K, tensorTypeQ6_K
+	}
+
+	switch {
+	case name == "output.weight":
+		return tensorTypeQ6_K
+	case strings.HasSuffix(name, "attn_v.weight"), strings.HasSuffix(name, "ffn_down.weight"):
+		if q.useMoreBits(t.block()) {
+			return tensorTypeQ6_K
+		}
+	case strings.HasSuffix(name, "attn_qkv.weight"):
+		return more
+	}
+
+	return kind
+}
+
+// useMoreBits reports whether block i is one of the blocks which are more
+// sensitive to quantization: the first and last eighth and every third in
+// between.
+func (q quantizer) useMoreBits(i int) bool {
+	n := q.layers
+	return i >= 0 && (i < n/8 || i >= 7*n/8 || (i-n/8)%3 == 2)
+}
+
+// quantizable reports whether t should be quantized. Only weights with at
+// least two dimensions are, except those which are sensitive to precision
+// and small anyway.
+func quantizable(t *Tensor) bool {
+	if !strings.HasSuffix(t.Name, ".weight") || len(t.Shape) < 2 {
+		return false
+	}
+
+	for _, s := range []string{"_norm.", "ffn_gate_inp.", "pos_embd.", "token_types.", "rel_pos"} {
+		if strings.Contains(t.Name, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+var tensorTypeNames = func() map[uint32]string {
+	names := make(map[uint32]string, len(tensorTypes))
+	for name, kind := range tensorTypes {
+		names[kind] = name
+	}
+	return names
+}()
+
+func tensorTypeName(kind uint32) string {
+	if name, ok := tensorTypeNames[kind]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("type %d", kind)
+}
+
+// quantizeWriter writes the data of tensor src converted to kind.
+type quantizeWriter struct {
+	rs     io.ReadSeeker
+	offset int64
+	src    *Tensor
+	kind   uint32
+
+	// importance is the importance of each column of src, if known
+	importance []float32
+
+	done func()
+}
+
+// quantizeChunkSize is the number of elements converted at a time
+const quantizeChunkSize = 1 << 20
+
+func (w *quantizeWriter) WriteTo(dst io.Writer) (int64, error) {
+	if _, err := w.rs.Seek(w.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if w.kind == w.src.Kind {
+		n, err := io.CopyN(dst, w.rs, int64(w.src.Size()))
+		if err == nil {
+			w.done()
+		}
+		return n, err
+	}
+
+	cols := w.src.Shape[0]
+	rows := w.src.parameters() / cols
+	srcRow := Tensor{Kind: w.src.Kind, Shape: []uint64{cols}}.Size()
+	dstRow := Tensor{Kind: w.kind, Shape: []uint64{cols}}.Size()
+
+	chunk := max(1, quantizeChunkSize/cols)
+	in := make([]byte, chunk*srcRow)
+	out := make([]byte, chunk*dstRow)
+
+	var written int64
+	for row := uint64(0); row < rows; row += chunk {
+		n := min(chunk, rows-row)
+		if _, err := io.ReadFull(w.rs, in[:n*srcRow]); err != nil {
+			return written, err
+		}
+
+		// rows are independent so they're converted in parallel
+		var wg sync.WaitGroup
+		workers := min(uint64(runtime.NumCPU()), n)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f := make([]float32, cols)
+				for r := i; r < n; r += workers {
+					dequantize(w.src.Kind, in[r*srcRow:(r+1)*srcRow], f)
+					quantize(w.kind, f, w.importance, out[r*dstRow:(r+1)*dstRow])
+				}
+			}()
+		}
+		wg.Wait()
+
+		m, err := dst.Write(out[:n*dstRow])
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	w.done()
+	return written, nil
+}
+
+// dequantize decodes the blocks of type kind in b into f.
+func dequantize(kind uint32, b []byte, f []float32) {
+	switch kind {
+	case tensorTypeF32:
+		for i := range f {
+			f[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+	case tensorTypeF16:
+		for i := range f {
+			f[i] = fp16(b[2*i:])
+		}
+	case tensorTypeBF16:
+		for i := range f {
+			f[i] = math.Float32frombits(uint32(binary.LittleEndian.Uint16(b[2*i:])) << 16)
+		}
+	case tensorTypeQ8_0:
+		for i := 0; i < len(f); i += 32 {
+			dequantizeQ8_0(b[i/32*34:], f[i:i+32])
+		}
+	case tensorTypeQ4_0:
+		for i := 0; i < len(f); i += 32 {
+			dequantizeQ4_0(b[i/32*18:], f[i:i+32])
+		}
+	case tensorTypeQ3_K:
+		for i := 0; i < len(f); i += 256 {
+			dequantizeQ3_K(b[i/256*110:], f[i:i+256])
+		}
+	case tensorTypeQ4_K:
+		for i := 0; i < len(f); i += 256 {
+			dequantizeQ4_K(b[i/256*144:], f[i:i+256])
+		}
+	case tensorTypeQ5_K:
+		for i := 0; i < len(f); i += 256 {
+			dequantizeQ5_K(b[i/256*176:], f[i:i+256])
+		}
+	case tensorTypeQ6_K:
+		for i := 0; i < len(f); i += 256 {
+			dequantizeQ6_K(b[i/256*210:], f[i:i+256])
+		}
+	default:
+		panic(fmt.Sprintf("cannot dequantize %s", tensorTypeName(kind)))
+	}
+}
+
+// quantize encodes f into blocks of type kind in b. f is a row of a tensor
+// and w, if not nil, is the importance of each of its columns, which weights
+// the quantization error.
+func quantize(kind uint32, f, w []float32, b []byte) {
+	// block returns the importance of the values of block i of n values
+	block := func(i, n int) []float32 {
+		if w == nil {
+			return nil
+		}
+		return w[i : i+n]
+	}
+
+	switch kind {
+	case tensorTypeF32:
+		for i, v := range f {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+		}
+	case tensorTypeF16:
+		for i, v := range f {
+			putFP16(b[2*i:], v)
+		}
+	case tensorTypeBF16:
+		for i, v := range f {
+			binary.LittleEndian.PutUint16(b[2*i:], bf16(v))
+		}
+	case tensorTypeQ8_0:
+		for i := 0; i < len(f); i += 32 {
+			quantizeQ8_0(f[i:i+32], b[i/32*34:])
+		}
+	case tensorTypeQ4_0:
+		var sigma2 float32
+		if w != nil {
+			sigma2 = sumSquares(f) / float32(len(f))
+		}
+		for i := 0; i < len(f); i += 32 {
+			quantizeQ4_0(f[i:i+32], block(i, 32), sigma2, b[i/32*18:])
+		}
+	case tensorTypeQ3_K:
+		for i := 0; i < len(f); i += 256 {
+			quantizeQ3_K(f[i:i+256], block(i, 256), b[i/256*110:])
+		}
+	case tensorTypeQ4_K:
+		for i := 0; i < len(f); i += 256 {
+			quantizeQ4_K(f[i:i+256], block(i, 256), b[i/256*144:])
+		}
+	case tensorTypeQ5_K:
+		for i := 0; i < len(f); i += 256 {
+			quantizeQ5_K(f[i:i+256], block(i, 256), b[i/256*176:])
+		}
+	case tensorTypeQ6_K:
+		for i := 0; i < len(f); i += 256 {
+			quantizeQ6_K(f[i:i+256], block(i, 256), b[i/256*210:])
+		}
+	default:
+		panic(fmt.Sprintf("cannot quantize to %s", tensorTypeName(kind)))
+	}
+}
+
+func sumSquares(f []float32) float32 {
+	var sum float32
+	for _, v := range f {
+		sum += v * v
+	}
+	return sum
+}
+
+func fp16(b []byte) float32 {
+	return float16.Frombits(binary.LittleEndian.Uint16(b)).Float32()
+}
+
+func putFP16(b []byte, f float32) {
+	binary.LittleEndian.PutUint16(b, float16.Fromfloat32(f).Bits())
+}
+
+// bf16 rounds f to the nearest bfloat16, like ggml.
+func bf16(f float32) uint16 {
+	u := math.Float32bits(f)
+	if u&0x7fffffff > 0x7f800000 {
+		// quiet NaN
+		return uint16(u>>16) | 64
+	}
+	return uint16((u + (0x7fff + ((u >> 16) & 1))) >> 16)
+}
+
+// nearestInt rounds f to the nearest integer, ties to even, like ggml's
+// nearest_int.
+func nearestInt(f float32) int {
+	return int(math.RoundToEven(float64(f)))
+}
+
+// groupMaxEps is the smallest maximum value of a group of weights which isn't
+// quantized to zeros
+const groupMaxEps = 1e-15
+
+// The quantization functions below follow the implementations of ggml so
+// their output is identical, up to differences in floating point rounding:
+// quantize_row_*_ref, or quantize_row_*_impl given the importance of the
+// values.
+
+// Q8_0 blocks are 32 int8 weights with an F16 scale.
+func quantizeQ8_0(x []float32, y []byte) {
+	var amax float32
+	for _, v := range x {
+		amax = max(amax, float32(math.Abs(float64(v))))
+	}
+
+	d := amax / 127
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	putFP16(y, d)
+	for j, v := range x {
+		y[2+j] = byte(int8(math.Round(float64(v * id))))
+	}
+}
+
+func dequantizeQ8_0(x []byte, y []float32) {
+	d := fp16(x)
+	for j := range y {
+		y[j] = float32(int8(x[2+j])) * d
+	}
+}
+
+// Q4_0 blocks are 32 4-bit weights with an F16 scale. sigma2 is the mean
+// square of the row's values, which is only used with importance qw.
+func quantizeQ4_0(x, qw []float32, sigma2 float32, y []byte) {
+	if qw != nil {
+		var weights [32]float32
+		for j, v := range x {
+			weights[j] = qw[j] * float32(math.Sqrt(float64(sigma2+v*v)))
+		}
+
+		var l [32]uint8
+		putFP16(y, makeQXQuants(8, x, l[:], weights[:]))
+		for j := range 16 {
+			y[2+j] = l[j] | l[j+16]<<4
+		}
+		return
+	}
+
+	var amax, vmax float32
+	for _, v := range x {
+		if a := float32(math.Abs(float64(v))); a > amax {
+			amax, vmax = a, v
+		}
+	}
+
+	d := vmax / -8
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	putFP16(y, d)
+	for j := range 16 {
+		x0 := min(15, int(x[j]*id+8.5))
+		x1 := min(15, int(x[16+j]*id+8.5))
+		y[2+j] = byte(x0) | byte(x1)<<4
+	}
+}
+
+func dequantizeQ4_0(x []byte, y []float32) {
+	d := fp16(x)
+	for j := range 16 {
+		y[j] = float32(int(x[2+j]&0xf)-8) * d
+		y[j+16] = float32(int(x[2+j]>>4)-8) * d
+	}
+}
+
+// makeQKX2Quants quantizes x to unsigned integers up to nmax with a scale
+// and a minimum, searching for the pair minimizing the weighted error. It
+// returns the scale and the negated minimum.
+func makeQKX2Quants(nmax int, x, weights []float32, l, laux []uint8, rmin, rdelta float32, nstep int) (float32, float32) {
+	vmin, vmax := x[0], x[0]
+	sumW := weights[0]
+	sumX := sumW * x[0]
+	for i := 1; i < len(x); i++ {
+		vmin = min(vmin, x[i])
+		vmax = max(vmax, x[i])
+		sumW += weights[i]
+		sumX += weights[i] * x[i]
+	}
+	vmin = min(vmin, 0)
+
+	if vmax == vmin {
+		clear(l)
+		return 0, -vmin
+	}
+
+	iscale := float32(nmax) / (vmax - vmin)
+	scale := 1 / iscale
+	var bestError float32
+	for i, v := range x {
+		l[i] = uint8(max(0, min(nmax, nearestInt(iscale*(v-vmin)))))
+		diff := scale*float32(l[i]) + vmin - v
+		bestError += weights[i] * diff * diff
+	}
+
+	for is := 0; is <= nstep; is++ {
+		iscale := (rmin + rdelta*float32(is) + float32(nmax)) / (vmax - vmin)
+		var sumL, sumL2, sumXL float32
+		for i, v := range x {
+			li := max(0, min(nmax, nearestInt(iscale*(v-vmin))))
+			laux[i] = uint8(li)
+			w := weights[i]
+			sumL += w * float32(li)
+			sumL2 += w * float32(li) * float32(li)
+			sumXL += w * float32(li) * v
+		}
+
+		d := sumW*sumL2 - sumL*sumL
+		if d > 0 {
+			thisScale := (sumW*sumXL - sumX*sumL) / d
+			thisMin := (sumL2*sumX - sumL*sumXL) / d
+			if thisMin > 0 {
+				thisMin = 0
+				thisScale = sumXL / sumL2
+			}
+
+			var curError float32
+			for i, v := range x {
+				diff := thisScale*float32(laux[i]) + thisMin - v
+				curError += weights[i] * diff * diff
+			}
+
+			if curError < bestError {
+				copy(l, laux)
+				bestError = curError
+				scale = thisScale
+				vmin = thisMin
+			}
+		}
+	}
+
+	return scale, -vmin
+}
+
+// makeQXQuants quantizes x to signed integers in [-nmax, nmax), stored
+// offset by nmax, and returns the scale. The error is weighted by qw, or by
+// the square of the values if it's nil.
+func makeQXQuants(nmax int, x []float32, l []uint8, qw []float32) float32 {
+	var vmax, amax float32
+	for _, v := range x {
+		if a := float32(math.Abs(float64(v))); a > amax {
+			amax, vmax = a, v
+		}
+	}
+
+	if amax < groupMaxEps {
+		clear(l)
+		return 0
+	}
+
+	weight := func(i int) float32 {
+		if qw != nil {
+			return qw[i]
+		}
+		return x[i] * x[i]
+	}
+
+	quants := func(iscale float32) (sumLX, sumL2 float32) {
+		for i, v := range x {
+			li := max(-nmax, min(nmax-1, nearestInt(iscale*v)))
+			w := weight(i)
+			sumLX += w * v * float32(li)
+			sumL2 += w * float32(li) * float32(li)
+		}
+		return
+	}
+
+	set := func(iscale float32) {
+		for i, v := range x {
+			l[i] = uint8(nmax + max(-nmax, min(nmax-1, nearestInt(iscale*v))))
+		}
+	}
+
+	iscale := -float32(nmax) / vmax
+	set(iscale)
+	sumLX, sumL2 := quants(iscale)
+
+	var scale float32
+	if sumL2 != 0 {
+		scale = sumLX / sumL2
+	}
+
+	best := scale * sumLX
+	for is := -9; is <= 9; is++ {
+		if is == 0 {
+			continue
+		}
+
+		iscale := -(float32(nmax) + 0.1*float32(is)) / vmax
+		sumLX, sumL2 := quants(iscale)
+		if sumL2 > 0 && sumLX*sumLX > best*sumL2 {
+			set(iscale)
+			scale = sumLX / sumL2
+			best = scale * sumLX
+		}
+	}
+
+	return scale
+}
+
+// makeQ3Quants quantizes the 16 values x to signed integers in [-nmax,
+// nmax), stored offset by nmax, refining them one at a time to minimize the
+// error weighted by the square of the values. It returns the scale.
+func makeQ3Quants(nmax int, x []float32, l []uint8) float32 {
+	var vmax, amax float32
+	for _, v := range x {
+		if a := float32(math.Abs(float64(v))); a > amax {
+			amax, vmax = a, v
+		}
+	}
+
+	if amax < groupMaxEps {
+		clear(l)
+		return 0
+	}
+
+	iscale := -float32(nmax) / vmax
+	var q [16]int
+	var sumLX, sumL2 float32
+	for i, v := range x {
+		q[i] = max(-nmax, min(nmax-1, nearestInt(iscale*v)))
+		w := v * v
+		sumLX += w * v * float32(q[i])
+		sumL2 += w * float32(q[i]) * float32(q[i])
+	}
+
+	for range 5 {
+		var changed bool
+		for i, v := range x {
+			w := v * v
+			slx := sumLX - w*v*float32(q[i])
+			if slx <= 0 {
+				continue
+			}
+
+			sl2 := sumL2 - w*float32(q[i])*float32(q[i])
+			if li := max(-nmax, min(nmax-1, nearestInt(v*sl2/slx))); li != q[i] {
+				slx += w * v * float32(li)
+				sl2 += w * float32(li) * float32(li)
+				if sl2 > 0 && slx*slx*sumL2 > sumLX*sumLX*sl2 {
+					q[i], sumLX, sumL2 = li, slx, sl2
+					changed = true
+				}
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	for i := range x {
+		l[i] = uint8(q[i] + nmax)
+	}
+
+	return sumLX / sumL2
+}
+
+// makeQPQuants quantizes the non-negative x to unsigned integers up to nmax,
+// minimizing the error weighted by qw, and returns the scale.
+func makeQPQuants(nmax int, x []float32, l []uint8, qw []float32) float32 {
+	var vmax float32
+	for _, v := range x {
+		vmax = max(vmax, v)
+	}
+
+	if vmax == 0 {
+		clear(l)
+		return 0
+	}
+
+	iscale := float32(nmax) / vmax
+	for i, v := range x {
+		l[i] = uint8(nearestInt(iscale * v))
+	}
+
+	scale := 1 / iscale
+	var bestError float32
+	for i, v := range x {
+		diff := v - scale*float32(l[i])
+		bestError += qw[i] * diff * diff
+	}
+
+	for is := -4; is <= 4; is++ {
+		if is == 0 {
+			continue
+		}
+
+		iscaleIs := (0.1*float32(is) + float32(nmax)) / vmax
+		scaleIs := 1 / iscaleIs
+		var curError float32
+		for i, v := range x {
+			li := min(nmax, nearestInt(iscaleIs*v))
+			diff := v - scaleIs*float32(li)
+			curError += qw[i] * diff * diff
+		}
+
+		if curError < bestError {
+			bestError = curError
+			iscale = iscaleIs
+		}
+	}
+
+	var sumLX, sumL2 float32
+	for i, v := range x {
+		li := min(nmax, nearestInt(iscale*v))
+		l[i] = uint8(li)
+		sumLX += qw[i] * v * float32(li)
+		sumL2 += qw[i] * float32(li) * float32(li)
+	}
+
+	for range 5 {
+		var changed bool
+		for i, v := range x {
+			w, li := qw[i], float32(l[i])
+			slx := sumLX - w*v*li
+			sl2 := sumL2 - w*li*li
+			if slx <= 0 || sl2 <= 0 {
+				continue
+			}
+
+			if li := min(nmax, nearestInt(v*sl2/slx)); li != int(l[i]) {
+				slx += w * v * float32(li)
+				sl2 += w * float32(li) * float32(li)
+				if slx*slx*sumL2 > sumLX*sumLX*sl2 {
+					l[i], sumLX, sumL2 = uint8(li), slx, sl2
+					changed = true
+				}
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	return sumLX / sumL2
+}
+
+// Q3_K blocks are 256 3-bit weights in 16 sub-blocks with 6-bit scales, and
+// an F16 super-block scale. The low two bits of the weights are packed into
+// qs and the high bit into hmask.
+func quantizeQ3_K(x, qw []float32, y []byte) {
+	hmask, qs, sc := y[:32], y[32:96], y[96:108]
+
+	var l [256]uint8
+	var scales [16]float32
+	var ls [16]uint8
+	clear(sc)
+	if qw != nil {
+		sigma2 := 2 * sumSquares(x) / 256
+
+		var weights [16]float32
+		var sw [16]float32
+		for j := range 16 {
+			for i := range 16 {
+				v := x[16*j+i]
+				weights[i] = qw[16*j+i] * float32(math.Sqrt(float64(sigma2+v*v)))
+				sw[j] += weights[i]
+			}
+
+			scales[j] = makeQXQuants(4, x[16*j:16*j+16], l[16*j:16*j+16], weights[:])
+		}
+
+		putFP16(y[108:], makeQXQuants(32, scales[:], ls[:], sw[:]))
+	} else {
+		var maxScale, amax float32
+		for j := range 16 {
+			scales[j] = makeQ3Quants(4, x[16*j:16*j+16], l[16*j:16*j+16])
+			if a := float32(math.Abs(float64(scales[j]))); a > amax {
+				amax, maxScale = a, scales[j]
+			}
+		}
+
+		putFP16(y[108:], 0)
+		if maxScale != 0 {
+			iscale := -32 / maxScale
+			for j := range 16 {
+				ls[j] = uint8(max(-32, min(31, nearestInt(iscale*scales[j]))) + 32)
+			}
+			putFP16(y[108:], 1/iscale)
+		}
+	}
+
+	for j, s := range ls {
+		if j < 8 {
+			sc[j] = s & 0xf
+		} else {
+			sc[j-8] |= (s & 0xf) << 4
+		}
+		sc[8+j%4] |= (s >> 4) << (2 * (j / 4))
+	}
+
+	d := fp16(y[108:])
+	for j := range 16 {
+		d := d * float32(q3Scale(j, sc))
+		if d == 0 {
+			continue
+		}
+
+		for i := range 16 {
+			l[16*j+i] = uint8(4 + max(-4, min(3, nearestInt(x[16*j+i]/d))))
+		}
+	}
+
+	clear(hmask)
+	for j := range 256 {
+		if l[j] > 3 {
+			hmask[j%32] |= 1 << (j / 32)
+			l[j] -= 4
+		}
+	}
+
+	for j := 0; j < 256; j += 128 {
+		for i := range 32 {
+			qs[j/4+i] = l[j+i] | l[j+i+32]<<2 | l[j+i+64]<<4 | l[j+i+96]<<6
+		}
+	}
+}
+
+// q3Scale unpacks the 6-bit scale of sub-block j of a Q3_K block.
+func q3Scale(j int, scales []byte) int {
+	s := scales[j%8]
+	if j >= 8 {
+		s >>= 4
+	}
+	return int(s&0xf|(scales[8+j%4]>>(2*(j/4))&3)<<4) - 32
+}
+
+func dequantizeQ3_K(x []byte, y []float32) {
+	hmask, qs, sc := x[:32], x[32:96], x[96:108]
+	d := fp16(x[108:])
+	for i := range y {
+		q := int(qs[i/128*32+i%32]>>(2*(i%128/32))) & 3
+		if hmask[i%32]&(1<<(i/32)) == 0 {
+			q -= 4
+		}
+		y[i] = d * float32(q3Scale(i/16, sc)) * float32(q)
+	}
+}
+
+// scaleMinK4 unpacks the 6-bit scale and minimum of sub-block j of a Q4_K or
+// Q5_K block.
+func scaleMinK4(j int, q []byte) (uint8, uint8) {
+	if j < 4 {
+		return q[j] & 63, q[j+4] & 63
+	}
+
+	return (q[j+4] & 0xf) | ((q[j-4] >> 6) << 4), (q[j+4] >> 4) | ((q[j] >> 6) << 4)
+}
+
+// quantizeK quantizes a block of 256 weights as 8 sub-blocks of 32 unsigned
+// integers up to nmax, with 6-bit scales and minimums, which are packed into
+// scales. It returns the quantized values, and the super-block scale and
+// minimum. rmin and nstep control the search for the sub-block scales
+// without importance qw.
+func quantizeK(x, qw []float32, nmax int, rmin float32, nstep int, scales []byte) (l [256]uint8, d, dmin float32) {
+	var laux [32]uint8
+	var weights [32]float32
+	var subScales, mins [8]float32
+	var ls, lm [8]uint8
+	if qw != nil {
+		sigma2 := 2 * sumSquares(x) / 256
+
+		var sw [8]float32
+		for j := range 8 {
+			xs := x[32*j : 32*j+32]
+			for i, v := range xs {
+				weights[i] = qw[32*j+i] * float32(math.Sqrt(float64(sigma2+v*v)))
+				sw[j] += weights[i]
+			}
+
+			subScales[j], mins[j] = makeQKX2Quants(nmax, xs, weights[:], l[32*j:32*j+32], laux[:], -0.9, 0.05, 36)
+		}
+
+		d = makeQPQuants(63, subScales[:], ls[:], sw[:])
+		dmin = makeQPQuants(63, mins[:], lm[:], sw[:])
+	} else {
+		var maxScale, maxMin float32
+		for j := range 8 {
+			xs := x[32*j : 32*j+32]
+			avX := float32(math.Sqrt(float64(sumSquares(xs) / 32)))
+			for i, v := range xs {
+				weights[i] = avX + float32(math.Abs(float64(v)))
+			}
+
+			subScales[j], mins[j] = makeQKX2Quants(nmax, xs, weights[:], l[32*j:32*j+32], laux[:], rmin, 0.1, nstep)
+			maxScale = max(maxScale, subScales[j])
+			maxMin = max(maxMin, mins[j])
+		}
+
+		var invScale, invMin float32
+		if maxScale > 0 {
+			invScale = 63 / maxScale
+		}
+		if maxMin > 0 {
+			invMin = 63 / maxMin
+		}
+
+		for j := range 8 {
+			ls[j] = uint8(min(63, nearestInt(invScale*subScales[j])))
+			lm[j] = uint8(min(63, nearestInt(invMin*mins[j])))
+		}
+
+		d, dmin = maxScale/63, maxMin/63
+	}
+
+	clear(scales[:12])
+	for j := range 8 {
+		if j < 4 {
+			scales[j] = ls[j]
+			scales[j+4] = lm[j]
+		} else {
+			scales[j+4] = (ls[j] & 0xf) | ((lm[j] & 0xf) << 4)
+			scales[j-4] |= (ls[j] >> 4) << 6
+			scales[j] |= (lm[j] >> 4) << 6
+		}
+	}
+
+	// the scales are stored as F16 so requantize with the rounded values
+	d = float16.Fromfloat32(d).Float32()
+	dmin = float16.Fromfloat32(dmin).Float32()
+	for j := range 8 {
+		sc, m := scaleMinK4(j, scales)
+		d := d * float32(sc)
+		if d == 0 {
+			continue
+		}
+
+		dm := dmin * float32(m)
+		for i := range 32 {
+			l[32*j+i] = uint8(max(0, min(nmax, nearestInt((x[32*j+i]+dm)/d))))
+		}
+	}
+
+	return l, d, dmin
+}
+
+// Q4_K blocks are 256 4-bit weights in 8 sub-blocks with 6-bit scales and
+// minimums, and F16 super-block scales.
+func quantizeQ4_K(x, qw []float32, y []byte) {
+	l, d, dmin := quantizeK(x, qw, 15, -1, 20, y[4:16])
+	putFP16(y, d)
+	putFP16(y[2:], dmin)
+
+	qs := y[16:144]
+	for j := 0; j < 256; j += 64 {
+		for i := range 32 {
+			qs[i] = l[j+i] | l[j+i+32]<<4
+		}
+		qs = qs[32:]
+	}
+}
+
+func dequantizeQ4_K(x []byte, y []float32) {
+	d, dmin := fp16(x), fp16(x[2:])
+	scales, qs := x[4:16], x[16:144]
+	for j, is := 0, 0; j < 256; j, is = j+64, is+2 {
+		sc, m := scaleMinK4(is, scales)
+		d1, m1 := d*float32(sc), dmin*float32(m)
+		sc, m = scaleMinK4(is+1, scales)
+		d2, m2 := d*float32(sc), dmin*float32(m)
+		for i := range 32 {
+			y[j+i] = d1*float32(qs[i]&0xf) - m1
+			y[j+i+32] = d2*float32(qs[i]>>4) - m2
+		}
+		qs = qs[32:]
+	}
+}
+
+// Q5_K blocks are like Q4_K with the fifth bit of each weight stored
+// separately.
+func quantizeQ5_K(x, qw []float32, y []byte) {
+	l, d, dmin := quantizeK(x, qw, 31, -0.5, 15, y[4:16])
+	putFP16(y, d)
+	putFP16(y[2:], dmin)
+
+	qh, qs := y[16:48], y[48:176]
+	clear(qh)
+	m1, m2 := uint8(1), uint8(2)
+	for n := 0; n < 256; n += 64 {
+		for j := range 32 {
+			l1, l2 := l[n+j], l[n+j+32]
+			if l1 > 15 {
+				l1 -= 16
+				qh[j] |= m1
+			}
+			if l2 > 15 {
+				l2 -= 16
+				qh[j] |= m2
+			}
+			qs[j] = l1 | l2<<4
+		}
+		m1 <<= 2
+		m2 <<= 2
+		qs = qs[32:]
+	}
+}
+
+func dequantizeQ5_K(x []byte, y []float32) {
+	d, dmin := fp16(x), fp16(x[2:])
+	scales, qh, qs := x[4:16], x[16:48], x[48:176]
+	u1, u2 := uint8(1), uint8(2)
+	for j, is := 0, 0; j < 256; j, is = j+64, is+2 {
+		sc, m := scaleMinK4(is, scales)
+		d1, m1 := d*float32(sc), dmin*float32(m)
+		sc, m = scaleMinK4(is+1, scales)
+		d2, m2 := d*float32(sc), dmin*float32(m)
+		for i := range 32 {
+			h1, h2 := 0, 0
+			if qh[i]&u1 != 0 {
+				h1 = 16
+			}
+			if qh[i]&u2 != 0 {
+				h2 = 16
+			}
+			y[j+i] = d1*float32(int(qs[i]&0xf)+h1) - m1
+			y[j+i+32] = d2*float32(int(qs[i]>>4)+h2) - m2
+		}
+		qs = qs[32:]
+		u1 <<= 2
+		u2 <<= 2
+	}
+}
+
+// Q6_K blocks are 256 6-bit weights in 16 sub-blocks with 8-bit scales, and
+// an F16 super-block scale.
+func quantizeQ6_K(x, qw []float32, y []byte) {
+	var l [256]uint8
+	var scales [16]float32
+	var maxScale, maxAbsScale float32
+	for ib := range 16 {
+		var w []float32
+		if qw != nil {
+			w = qw[16*ib : 16*ib+16]
+		}
+
+		scale := makeQXQuants(32, x[16*ib:16*ib+16], l[16*ib:16*ib+16], w)
+		scales[ib] = scale
+		if a := float32(math.Abs(float64(scale))); a > maxAbsScale {
+			maxAbsScale, maxScale = a, scale
+		}
+	}
+
+	clear(y[:210])
+	if maxAbsScale < groupMaxEps {
+		return
+	}
+
+	ql, qh, sc := y[:128], y[128:192], y[192:208]
+
+	iscale := -128 / maxScale
+	putFP16(y[208:], 1/iscale)
+	for ib := range 16 {
+		sc[ib] = byte(int8(min(127, nearestInt(iscale*scales[ib]))))
+	}
+
+	d := fp16(y[208:])
+	for j := range 16 {
+		d := d * float32(int8(sc[j]))
+		if d == 0 {
+			continue
+		}
+
+		for i := range 16 {
+			l[16*j+i] = uint8(32 + max(-32, min(31, nearestInt(x[16*j+i]/d))))
+		}
+	}
+
+	for j := 0; j < 256; j += 128 {
+		for i := range 32 {
+			q1, q2 := l[j+i]&0xf, l[j+i+32]&0xf
+			q3, q4 := l[j+i+64]&0xf, l[j+i+96]&0xf
+			ql[i] = q1 | q3<<4
+			ql[i+32] = q2 | q4<<4
+			qh[i] = l[j+i]>>4 | (l[j+i+32]>>4)<<2 | (l[j+i+64]>>4)<<4 | (l[j+i+96]>>4)<<6
+		}
+		ql = ql[64:]
+		qh = qh[32:]
+	}
+}
+
+func dequantizeQ6_K(x []byte, y []float32) {
+	ql, qh, sc := x[:128], x[128:192], x[192:208]
+	d := fp16(x[208:])
+	for n := 0; n < 256; n += 128 {
+		for l := range 32 {
+			is := l / 16
+			q1 := int(ql[l]&0xf|(qh[l]&3)<<4) - 32
+			q2 := int(ql[l+32]&0xf|(qh[l]>>2&3)<<4) - 32
+			q3 := int(ql[l]>>4|(qh[l]>>4&3)<<4) - 32
+			q4 := int(ql[l+32]>>4|(qh[l]>>6&3)<<4) - 32
+			y[n+l] = d * float32(int8(sc[is])) * float32(q1)
+			y[n+l+32] = d * float32(int8(sc[is+2])) * float32(q2)
+			y[n+l+64] = d * float32(int8(sc[is+4])) * float32(q3)
+			y[n+l+96] = d * float32(int8(sc[is+6])) * float32(q4)
+		}
+		ql = ql[64:]
+		qh = qh[32:]
+		sc = sc[8:]
+	}
+}
@@ -0,0 +1,448 @@
+package ggml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func randomFloats(r *rand.Rand, n int) []float32 {
+	f := make([]float32, n)
+	for i := range f {
+		f[i] = float32(r.NormFloat64())
+	}
+	return f
+}
+
+// rmse returns the root mean square error between a and b, relative to the
+// root mean square of a.
+func rmse(a, b []float32) float64 {
+	var sumErr, sum float64
+	for i := range a {
+		d := float64(a[i] - b[i])
+		sumErr += d * d
+		sum += float64(a[i]) * float64(a[i])
+	}
+	return math.Sqrt(sumErr / sum)
+}
+
+func TestQuantizeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	x := randomFloats(r, 4096)
+
+	cases := []struct {
+		kind    uint32
+		maxRMSE float64
+	}{
+		{tensorTypeF32, 0},
+		{tensorTypeF16, 0.001},
+		{tensorTypeBF16, 0.005},
+		{tensorTypeQ8_0, 0.01},
+		{tensorTypeQ6_K, 0.03},
+		{tensorTypeQ5_K, 0.05},
+		{tensorTypeQ4_K, 0.1},
+		{tensorTypeQ4_0, 0.15},
+		{tensorTypeQ3_K, 0.25},
+	}
+
+	for _, tt := range cases {
+		t.Run(tensorTypeName(tt.kind), func(t *testing.T) {
+			b := make([]byte, Tensor{Kind: tt.kind, Shape: []uint64{uint64(len(x))}}.Size())
+			quantize(tt.kind, x, nil, b)
+
+			y := make([]float32, len(x))
+			dequantize(tt.kind, b, y)
+
+			if e := rmse(x, y); e > tt.maxRMSE {
+				t.Errorf("rmse = %f, want <= %f", e, tt.maxRMSE)
+			}
+
+			// zeros stay zeros
+			zeros := make([]float32, len(x))
+			quantize(tt.kind, zeros, nil, b)
+			dequantize(tt.kind, b, y)
+			if e := rmse(zeros, y); e != 0 && !math.IsNaN(e) {
+				t.Errorf("zeros dequantized to %v", y[:8])
+			}
+		})
+	}
+}
+
+// writeQuantizeModel writes a GGUF model with tensors of random values for
+// quantization tests. token_embd.weight and output.weight are F32, blocks
+// are F16.
+func writeQuantizeModel(t *testing.T, kind uint32) (string, map[string][]float32) {
+	t.Helper()
+
+	r := rand.New(rand.NewPCG(3, 4))
+	values := make(map[string][]float32)
+
+	var ts []Tensor
+	add := func(name string, kind uint32, shape ...uint64) {
+		n := uint64(1)
+		for _, d := range shape {
+			n *= d
+		}
+
+		f := randomFloats(r, int(n))
+		values[name] = f
+
+		tt := Tensor{Name: name, Kind: kind, Shape: shape}
+		b := make([]byte, tt.Size())
+		quantize(kind, f, nil, b)
+		tt.WriterTo = bytes.NewReader(b)
+		ts = append(ts, tt)
+	}
+
+	add("token_embd.weight", tensorTypeF32, 256, 4)
+	for i := range 8 {
+		add(blockName(i, "attn_norm.weight"), tensorTypeF32, 256)
+		add(blockName(i, "attn_v.weight"), kind, 256, 2)
+		add(blockName(i, "ffn_down.weight"), kind, 256, 2)
+		add(blockName(i, "ffn_up.weight"), kind, 96, 2)
+	}
+	add("output_norm.weight", tensorTypeF32, 256)
+	add("output.weight", tensorTypeF32, 256, 4)
+
+	p := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteGGUF(f, KV{
+		"general.architecture":  "llama",
+		"general.file_type":     uint32(1),
+		"llama.block_count":     uint32(8),
+		"llama.context_length":  uint32(2048),
+		"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+		"tokenizer.ggml.scores": []float32{0, 1, 2},
+	}, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	return p, values
+}
+
+func blockName(i int, name string) string {
+	return fmt.Sprintf("blk.%d.%s", i, name)
+}
+
+func quantizeFile(t *testing.T, src string, ft fileType, opts QuantizeOptions) (*GGML, *os.File) {
+	t.Helper()
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "quantized.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+
+	if err := Quantize(out, in, ft, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := out.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _, err := Decode(out, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, out
+}
+
+func tensorKinds(g *GGML) map[string]string {
+	kinds := make(map[string]string)
+	for _, t := range g.Tensors().Items() {
+		kinds[t.Name] = tensorTypeName(t.Kind)
+	}
+	return kinds
+}
+
+// readTensor returns the dequantized values of the tensor name of g.
+func readTensor(t *testing.T, g *GGML, f *os.File, name string) []float32 {
+	t.Helper()
+
+	for _, tt := range g.Tensors().Items() {
+		if tt.Name == name {
+			b := make([]byte, tt.Size())
+			if _, err := f.ReadAt(b, int64(g.Tensors().Offset+tt.Offset)); err != nil {
+				t.Fatal(err)
+			}
+
+			v := make([]float32, tt.parameters())
+			dequantize(tt.Kind, b, v)
+			return v
+		}
+	}
+
+	t.Fatalf("tensor %s not found", name)
+	return nil
+}
+
+func TestQuantize(t *testing.T) {
+	src, values := writeQuantizeModel(t, tensorTypeF16)
+
+	// blocks 0 and 7 use more bits, as does every third in between
+	moreBits := map[int]bool{0: true, 3: true, 6: true, 7: true}
+
+	expect := func(kind, more, output, fallback string) map[string]string {
+		want := map[string]string{
+			"token_embd.weight":  kind,
+			"output_norm.weight": "F32",
+			"output.weight":      output,
+		}
+		for i := range 8 {
+			want[blockName(i, "attn_norm.weight")] = "F32"
+			want[blockName(i, "attn_v.weight")] = kind
+			want[blockName(i, "ffn_down.weight")] = kind
+			if moreBits[i] {
+				want[blockName(i, "attn_v.weight")] = more
+				want[blockName(i, "ffn_down.weight")] = more
+			}
+			want[blockName(i, "ffn_up.weight")] = fallback
+		}
+		return want
+	}
+
+	// Q3_K_M uses more bits for the first two attn_v blocks instead
+	q3 := expect("Q3_K", "Q3_K", "Q6_K", "Q8_0")
+	for i := range 8 {
+		q3[blockName(i, "attn_v.weight")] = "Q4_K"
+		q3[blockName(i, "ffn_down.weight")] = "Q4_K"
+	}
+	q3[blockName(0, "attn_v.weight")] = "Q5_K"
+	q3[blockName(1, "attn_v.weight")] = "Q5_K"
+
+	cases := []struct {
+		ft      fileType
+		want    map[string]string
+		maxRMSE float64
+	}{
+		{fileTypeQ8_0, expect("Q8_0", "Q8_0", "Q8_0", "Q8_0"), 0.01},
+		{fileTypeQ3_K_M, q3, 0.25},
+		{fileTypeQ4_0, expect("Q4_0", "Q4_0", "Q6_K", "Q4_0"), 0.15},
+		{fileTypeQ4_K_M, expect("Q4_K", "Q6_K", "Q6_K", "Q8_0"), 0.1},
+		{fileTypeQ5_K_M, expect("Q5_K", "Q6_K", "Q6_K", "Q8_0"), 0.05},
+		{fileTypeQ6_K, expect("Q6_K", "Q6_K", "Q6_K", "Q8_0"), 0.03},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.ft.String(), func(t *testing.T) {
+			var progress []uint64
+			var total uint64
+			g, f := quantizeFile(t, src, tt.ft, QuantizeOptions{
+				Progress: func(_ string, completed, n uint64) {
+					progress = append(progress, completed)
+					total = n
+				},
+			})
+
+			if diff := cmp.Diff(tt.want, tensorKinds(g)); diff != "" {
+				t.Errorf("tensor types mismatch (-want +got):\n%s", diff)
+			}
+
+			kv := g.KV()
+			if got := kv.FileType(); got != tt.ft {
+				t.Errorf("file type = %s, want %s", got, tt.ft)
+			}
+			if diff := cmp.Diff([]string{"a", "b", "c"}, kv.Strings("tokenizer.ggml.tokens")); diff != "" {
+				t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+			}
+			if got := kv.ContextLength(); got != 2048 {
+				t.Errorf("context length = %d, want 2048", got)
+			}
+
+			if len(progress) != len(tt.want) || progress[len(progress)-1] != total {
+				t.Errorf("progress = %v, want %d updates ending at %d", progress, len(tt.want), total)
+			}
+
+			for name, v := range values {
+				if e := rmse(v, readTensor(t, g, f, name)); e > tt.maxRMSE {
+					t.Errorf("%s: rmse = %f, want <= %f", name, e, tt.maxRMSE)
+				}
+			}
+		})
+	}
+}
+
+func TestQuantizeOverrides(t *testing.T) {
+	src, _ := writeQuantizeModel(t, tensorTypeF16)
+
+	g, _ := quantizeFile(t, src, fileTypeQ4_K_M, QuantizeOptions{
+		TensorTypes: map[string]string{
+			"token_embd.weight":      "q8_0",
+			"blk.*.attn_v.weight":    "Q5_K",
+			"blk.1.attn_v.weight":    "F16",
+			"blk.*.attn_norm.weight": "Q4_K",
+		},
+	})
+
+	kinds := tensorKinds(g)
+	for name, want := range map[string]string{
+		"token_embd.weight":     "Q8_0",
+		"blk.0.attn_v.weight":   "Q5_K",
+		"blk.1.attn_v.weight":   "F16",
+		"blk.2.attn_v.weight":   "Q5_K",
+		"blk.0.ffn_down.weight": "Q6_K",
+		// norms aren't quantized
+		"blk.0.attn_norm.weight": "F32",
+	} {
+		if kinds[name] != want {
+			t.Errorf("%s: type = %s, want %s", name, kinds[name], want)
+		}
+	}
+
+	for _, opts := range []QuantizeOptions{
+		{TensorTypes: map[string]string{"[": "Q8_0"}},
+		{TensorTypes: map[string]string{"output.weight": "Q2_K"}},
+	} {
+		in, err := os.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+
+		out, err := os.Create(filepath.Join(t.TempDir(), "out.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		if err := Quantize(out, in, fileTypeQ4_K_M, opts); err == nil {
+			t.Errorf("expected an error for %v", opts.TensorTypes)
+		}
+	}
+}
+
+func TestQuantizeImatrix(t *testing.T) {
+	src, values := writeQuantizeModel(t, tensorTypeF16)
+
+	// the first columns of each tensor are far more important
+	imatrix := Imatrix{Entries: make(map[string]ImatrixEntry), Chunks: 4, Dataset: "calibration.txt"}
+	importance := func(name string) []float32 {
+		cols := 256
+		if strings.Contains(name, "ffn_up") {
+			cols = 96
+		}
+
+		w := make([]float32, cols)
+		for i := range w {
+			w[i] = 0.01
+			if i%32 < 4 {
+				w[i] = 100
+			}
+		}
+		return w
+	}
+
+	for name := range values {
+		if !strings.Contains(name, "_norm") {
+			imatrix.Entries[name] = ImatrixEntry{Calls: 4, Values: importance(name)}
+		}
+	}
+
+	// weightedRMSE returns the error of the values of name of g weighted
+	// by their importance
+	weightedRMSE := func(g *GGML, f *os.File, name string) float64 {
+		want, got := values[name], readTensor(t, g, f, name)
+		w := importance(name)
+
+		var sumErr, sum float64
+		for i := range want {
+			d := float64(want[i] - got[i])
+			sumErr += float64(w[i%len(w)]) * d * d
+			sum += float64(w[i%len(w)]) * float64(want[i]) * float64(want[i])
+		}
+		return math.Sqrt(sumErr / sum)
+	}
+
+	g, f := quantizeFile(t, src, fileTypeQ3_K_M, QuantizeOptions{})
+	gi, fi := quantizeFile(t, src, fileTypeQ3_K_M, QuantizeOptions{Imatrix: &imatrix})
+
+	for _, name := range []string{"token_embd.weight", "blk.4.attn_v.weight", "blk.4.ffn_down.weight"} {
+		if e, ei := weightedRMSE(g, f, name), weightedRMSE(gi, fi, name); ei >= e {
+			t.Errorf("%s: weighted rmse with imatrix = %f, want < %f", name, ei, e)
+		}
+	}
+
+	kv := gi.KV()
+	if got := kv["quantize.imatrix.dataset"]; got != "calibration.txt" {
+		t.Errorf("imatrix dataset = %v, want calibration.txt", got)
+	}
+	if got := kv["quantize.imatrix.chunks_count"]; got != int32(4) {
+		t.Errorf("imatrix chunks = %v, want 4", got)
+	}
+	if _, ok := g.KV()["quantize.imatrix.dataset"]; ok {
+		t.Error("unexpected imatrix dataset without imatrix")
+	}
+
+	// entries must have a value per column
+	imatrix.Entries["output.weight"] = ImatrixEntry{Calls: 1, Values: []float32{1}}
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := Quantize(out, in, fileTypeQ3_K_M, QuantizeOptions{Imatrix: &imatrix}); err == nil {
+		t.Error("expected an error for an imatrix of the wrong size")
+	}
+}
+
+func TestQuantizeRequantize(t *testing.T) {
+	src, values := writeQuantizeModel(t, tensorTypeF16)
+
+	_, q8 := quantizeFile(t, src, fileTypeQ8_0, QuantizeOptions{})
+	g, f := quantizeFile(t, q8.Name(), fileTypeQ4_K_M, QuantizeOptions{})
+
+	if got := tensorKinds(g)["blk.1.attn_v.weight"]; got != "Q4_K" {
+		t.Errorf("type = %s, want Q4_K", got)
+	}
+
+	for name, v := range values {
+		if e := rmse(v, readTensor(t, g, f, name)); e > 0.1 {
+			t.Errorf("%s: rmse = %f, want <= 0.1", name, e)
+		}
+	}
+
+	// K-quants can't be requantized
+	in, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := Quantize(out, in, fileTypeQ8_0, QuantizeOptions{}); !errors.Is(err, ErrQuantizeUnsupported) {
+		t.Errorf("err = %v, want %v", err, ErrQuantizeUnsupported)
+	}
+}
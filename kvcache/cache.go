@@ -51,6 +51,11 @@ type Cache interface {
 	// maxBatch: The maximum number of tokens that can occur in a single batch
 	Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int)
 
+	// Grow increases the number of cache entries stored per sequence to
+	// capacity, keeping the entries already stored. The cache is unchanged if
+	// it already stores capacity entries or the storage can't be allocated.
+	Grow(capacity int) error
+
 	// Close closes the cache and frees resources associated with it
 	Close()
 
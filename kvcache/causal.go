@@ -53,6 +53,9 @@ type Causal struct {
 
 	// ** cache metadata **
 
+	// maximum number of sequences and batch size the cache is sized for
+	maxSequences, maxBatch int
+
 	// for each possible location in the cache, stores the position and set of sequences
 	// that reference the data there
 	cells []cacheCell
@@ -130,18 +133,91 @@ func (c *Causal) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity
 		c.config.MaskDType = ml.DTypeF32
 	}
 
+	c.maxSequences = maxSequences
+	c.maxBatch = maxBatch
+	c.cells = make([]cacheCell, c.cacheSize(capacity))
+
+	c.DType = dtype
+	c.cellRanges = make(map[int]cellRange)
+	c.backend = backend
+}
+
+// cacheSize returns the number of cells needed to store capacity entries
+// for each sequence
+func (c *Causal) cacheSize(capacity int) int {
 	var cacheSize int
 	if c.windowSize == math.MaxInt32 || capacity < int(c.windowSize) {
-		cacheSize = maxSequences * capacity
+		cacheSize = c.maxSequences * capacity
 	} else {
-		cacheSize = (maxSequences * int(c.windowSize)) + maxBatch
+		cacheSize = (c.maxSequences * int(c.windowSize)) + c.maxBatch
 	}
-	cacheSize = roundUp(cacheSize, c.config.CachePadding)
-	c.cells = make([]cacheCell, cacheSize)
+	return roundUp(cacheSize, c.config.CachePadding)
+}
 
-	c.DType = dtype
-	c.cellRanges = make(map[int]cellRange)
-	c.backend = backend
+func (c *Causal) Grow(capacity int) (err error) {
+	cacheSize := c.cacheSize(capacity)
+	if cacheSize <= len(c.cells) {
+		return nil
+	}
+
+	ctxs := make(map[int]ml.Context)
+	keys := make(map[int]ml.Tensor)
+	values := make(map[int]ml.Tensor)
+
+	// the backend panics if it can't allocate the new tensors, in which case
+	// the cache keeps its current storage
+	defer func() {
+		if r := recover(); r != nil {
+			for _, ctx := range ctxs {
+				ctx.Close()
+			}
+			err = fmt.Errorf("unable to grow kv cache to %v cells: %v", cacheSize, r)
+		}
+	}()
+
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	for i, key := range c.keys {
+		if key == nil {
+			continue
+		}
+
+		ctxs[i] = c.backend.NewContextSize(2).Layer(i)
+
+		kHeadDim := key.Dim(0)
+		numKVHeads := key.Dim(1)
+
+		keys[i] = ctxs[i].Zeros(c.DType, kHeadDim, numKVHeads, cacheSize)
+		ctx.Forward(key.Copy(ctx, keys[i].View(ctx, 0, kHeadDim*numKVHeads*len(c.cells))))
+
+		value := c.values[i]
+		if c.config.PermutedV {
+			vHeadDim := value.Dim(1)
+			elemSize := value.Stride(0)
+
+			values[i] = ctxs[i].Zeros(c.DType, cacheSize, vHeadDim, numKVHeads)
+			ctx.Forward(value.Copy(ctx, values[i].View(ctx, 0, len(c.cells), cacheSize*elemSize, vHeadDim*numKVHeads)))
+		} else {
+			vHeadDim := value.Dim(0)
+
+			values[i] = ctxs[i].Zeros(c.DType, vHeadDim, numKVHeads, cacheSize)
+			ctx.Forward(value.Copy(ctx, values[i].View(ctx, 0, vHeadDim*numKVHeads*len(c.cells))))
+		}
+	}
+
+	ctx.Compute()
+
+	for _, ctx := range c.ctxs {
+		ctx.Close()
+	}
+
+	c.ctxs = ctxs
+	c.keys = keys
+	c.values = values
+	c.cells = append(c.cells, make([]cacheCell, cacheSize-len(c.cells))...)
+
+	return nil
 }
 
 func (c *Causal) SetConfig(config ml.CacheConfig) {
@@ -685,6 +761,42 @@ func (c *Causal) shift(seq int, beginIndex, offset int32) error {
 	return nil
 }
 
+// UpdateKeys replaces the keys stored in the cache with the result of fn,
+// which is passed the position of each cell rather than a shift. This is
+// for models whose keys depend on more than their position, such as rotary
+// embeddings with factors that change with the context length.
+func (c *Causal) UpdateKeys(fn shiftFn) error {
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	positions := make([]int32, len(c.cells))
+	for i, cell := range c.cells {
+		positions[i] = cell.pos
+	}
+
+	positionIDs, err := ctx.Input().FromIntSlice(positions, len(positions))
+	if err != nil {
+		return err
+	}
+
+	for i, key := range c.keys {
+		if key == nil {
+			continue
+		}
+
+		updated, err := fn(ctx, i, key, positionIDs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Forward(updated.Copy(ctx, key))
+	}
+
+	ctx.Compute()
+
+	return nil
+}
+
 func (c *Causal) Remove(seq int, beginIndex, endIndex int32) error {
 	// TODO(jessegross): We should check to see if removing the middle of the sequence will
 	// cause the sliding window to encompass tokens that we no longer have. If so, then we
@@ -1,6 +1,7 @@
 package kvcache
 
 import (
+	"errors"
 	"math"
 	"slices"
 	"testing"
@@ -332,6 +333,66 @@ func TestCopy(t *testing.T) {
 	testCache(t, backend, cache, tests)
 }
 
+func TestGrow(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 1, 4, 4)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{1, 2, 3, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	err := cache.StartForward(backend.NewContext(), input.Batch{Positions: []int32{4}, Sequences: []int{0}}, false)
+	if !errors.Is(err, ErrKvCacheFull) {
+		t.Fatalf("expected cache to be full, got %v", err)
+	}
+
+	if err := cache.Grow(8); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.cells) != 8 {
+		t.Errorf("have %v cells; want 8", len(cache.cells))
+	}
+
+	tests = []testCase{
+		{
+			name:          "Grown",
+			in:            []float32{5, 6},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 0},
+			pos:           []int32{4, 5},
+			expected:      []float32{1, 2, 3, 4, 5, 6},
+			expectedShape: []int{1, 1, 6},
+			expectedMask:  []float32{0, 0, 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	// caches never shrink
+	if err := cache.Grow(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.cells) != 8 {
+		t.Errorf("have %v cells; want 8", len(cache.cells))
+	}
+}
+
 func testCache(t *testing.T, backend ml.Backend, cache Cache, tests []testCase) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
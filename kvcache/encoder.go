@@ -74,6 +74,12 @@ func (c *EncoderCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, ca
 	c.backend = backend
 }
 
+// Grow is a no-op because the encoder cache stores a single encoder output
+// regardless of the context size
+func (c *EncoderCache) Grow(capacity int) error {
+	return nil
+}
+
 func (c *EncoderCache) SetConfig(config ml.CacheConfig) {
 	if c.config != nil {
 		panic("config cannot be changed after being previously set, either by the model or backend")
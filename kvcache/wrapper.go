@@ -29,6 +29,16 @@ func (c *WrapperCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, ca
 	}
 }
 
+func (c *WrapperCache) Grow(capacity int) error {
+	for _, cache := range c.caches {
+		if err := cache.Grow(capacity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (c *WrapperCache) SetConfig(config ml.CacheConfig) {
 	for _, cache := range c.caches {
 		cache.SetConfig(config)
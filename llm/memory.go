@@ -120,10 +120,15 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	}
 
 	layers := f.Tensors().GroupLayers()
-	// add one layer worth of memory as a buffer
-	if blk0, ok := layers["blk.0"]; ok {
-		layerSize = blk0.Size()
-	} else {
+	// add one layer worth of memory as a buffer. mixture of experts models
+	// may have dense leading layers so use the largest layer
+	for i := range int(f.KV().BlockCount()) {
+		if blk, ok := layers[fmt.Sprintf("blk.%d", i)]; ok {
+			layerSize = max(layerSize, blk.Size())
+		}
+	}
+
+	if layerSize == 0 {
 		slog.Warn("model missing blk.0 layer size")
 	}
 
@@ -229,10 +234,12 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 
 		if opts.NumGPU >= 0 && layerCount >= opts.NumGPU {
 			// Stop allocating on GPU(s) once we hit the users target NumGPU
+			overflow += layerSize
 			continue
 		}
 
 		// distribute the layers across the GPU(s) that have space
+		var offloaded bool
 		for j := len(gpusWithSpace); j > 0; j-- {
 			g := gpusWithSpace[i%j]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
@@ -240,18 +247,21 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 				gpuAllocations[g.i] += layerSize
 				layerCounts[g.i]++
 				layerCount++
+				offloaded = true
 				break
 			} else {
 				gpusWithSpace = append(gpusWithSpace[:i%j], gpusWithSpace[i%j+1:]...)
 			}
 		}
+
+		// layers can differ in size, e.g. dense and expert layers, so
+		// account for each layer left on the CPU
+		if !offloaded {
+			overflow += layerSize
+		}
 	}
 	if layerCount >= int(f.KV().BlockCount()) {
 		fullyLoaded = true
-	} else {
-		for i := layerCount; i < int(f.KV().BlockCount()); i++ {
-			overflow += layerSize
-		}
 	}
 
 	// Determine if we need to consider output then find where it fits
@@ -129,3 +129,69 @@ func TestEstimateGPULayers(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimateGPULayersExperts(t *testing.T) {
+	t.Setenv("OLLAMA_DEBUG", "1")
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "") // Ensure default f16
+	t.Setenv("OLLAMA_CONTEXT_LENGTH", "2048")
+
+	f, err := os.CreateTemp(t.TempDir(), "experts")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// a dense first layer followed by mixture of experts layers
+	tensors := []ggml.Tensor{
+		{Name: "blk.0.attn.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))},
+		{Name: "blk.0.ffn_gate.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{16, 32}, WriterTo: bytes.NewReader(make([]byte, 4*16*32))},
+	}
+	for i := 1; i < 4; i++ {
+		tensors = append(tensors,
+			ggml.Tensor{Name: fmt.Sprintf("blk.%d.attn.weight", i), Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))},
+			ggml.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i), Kind: uint32(0), Offset: uint64(0), Shape: []uint64{16, 32, 8}, WriterTo: bytes.NewReader(make([]byte, 4*16*32*8))},
+		)
+	}
+	tensors = append(tensors, ggml.Tensor{Name: "output.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+
+	err = ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(32),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(4),
+		"llama.expert_count":            uint32(8),
+		"llama.expert_used_count":       uint32(2),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors)
+	require.NoError(t, err)
+
+	f16, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	layers := f16.Tensors().GroupLayers()
+	denseSize, expertSize := layers["blk.0"].Size(), layers["blk.1"].Size()
+	require.Less(t, denseSize, expertSize)
+
+	opts := api.DefaultOptions()
+	opts.NumGPU = 1
+	kv, graphPartialOffload, _ := f16.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), 1, "")
+
+	gpus := []discover.GpuInfo{
+		{
+			Library:       "cuda",
+			MinimumMemory: 2048,
+		},
+	}
+	gpus[0].FreeMemory = 1 << 40
+
+	estimate := EstimateGPULayers(gpus, f16, []string{}, opts, 1)
+	assert.Equal(t, 1, estimate.Layers)
+
+	// the buffer is the size of the largest layer rather than the first
+	assert.Equal(t, []uint64{2048 + expertSize + kv[0] + denseSize + kv[0] + graphPartialOffload}, estimate.GPUSizes)
+
+	// each layer left on the CPU is accounted for by its own size
+	assert.Equal(t, 3*(expertSize+kv[1]), estimate.TotalSize-estimate.VRAMSize)
+}
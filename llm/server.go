@@ -17,6 +17,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,6 +32,7 @@ import (
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/tracing"
 )
 
 type LlamaServer interface {
@@ -40,6 +42,7 @@ type LlamaServer interface {
 	Embedding(ctx context.Context, input string) ([]float32, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
+	GrowContext(ctx context.Context, numCtx int, gpus discover.GpuInfoList, f *ggml.GGML) error
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
@@ -55,6 +58,7 @@ type llmServer struct {
 	options     api.Options
 	numParallel int
 	modelPath   string
+	projectors  []string
 
 	// llamaModel is an instance of the cgo llama.cpp model definition
 	// nil if this server is running the new engine
@@ -271,7 +275,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 
 	var llamaModel *llama.Model
 	var textProcessor model.TextProcessor
-	if envconfig.NewEngine() || f.KV().OllamaEngineRequired() {
+	if envconfig.NewEngine() || f.OllamaEngineRequired() {
 		textProcessor, err = model.NewTextProcessor(modelPath)
 		if err != nil {
 			// To prepare for opt-out mode, instead of treating this as an error, we fallback to the old runner
@@ -354,6 +358,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			status:        NewStatusWriter(os.Stderr),
 			options:       opts,
 			modelPath:     modelPath,
+			projectors:    projectors,
 			llamaModel:    llamaModel,
 			textProcessor: textProcessor,
 			estimate:      estimate,
@@ -573,6 +578,9 @@ func (s *llmServer) Ping(ctx context.Context) error {
 }
 
 func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "llm.wait_until_running")
+	defer span.End()
+
 	start := time.Now()
 	stallDuration := envconfig.LoadTimeout()    // If no progress happens
 	stallTimer := time.Now().Add(stallDuration) // give up if we stall
@@ -703,6 +711,7 @@ type CompletionResponse struct {
 	DoneReason         DoneReason    `json:"done_reason"`
 	Done               bool          `json:"done"`
 	PromptEvalCount    int           `json:"prompt_eval_count"`
+	PromptCachedCount  int           `json:"prompt_cached_count"` // prompt inputs loaded from the cache
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
 	EvalCount          int           `json:"eval_count"`
 	EvalDuration       time.Duration `json:"eval_duration"`
@@ -736,6 +745,9 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		req.Options = &opts
 	}
 
+	ctx, span := tracing.Start(ctx, "llm.completion", "num_predict", req.Options.NumPredict, "images", len(req.Images))
+	defer span.End()
+
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting completion request due to client closing the connection")
@@ -774,6 +786,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("error creating POST request: %v", err)
 	}
 	serverReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, serverReq.Header)
 
 	res, err := http.DefaultClient.Do(serverReq)
 	if err != nil {
@@ -975,6 +988,9 @@ type TokenizeResponse struct {
 }
 
 func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error) {
+	_, span := tracing.Start(ctx, "llm.tokenize", "bytes", len(content))
+	defer span.End()
+
 	s.llamaModelLock.Lock()
 	defer s.llamaModelLock.Unlock()
 
@@ -1030,6 +1046,75 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return "", fmt.Errorf("no tokenizer configured")
 }
 
+type ContextRequest struct {
+	// Size is the size of the kv cache across all parallel sequences
+	Size int `json:"size"`
+}
+
+// GrowContext grows the kv cache of a model running on the Ollama engine to
+// numCtx, the context size across all parallel sequences, without reloading
+// it. gpus are the GPUs to check the larger cache fits in, with the memory
+// the model already uses counted as available. The model keeps its current
+// context if the cache can't grow in place.
+func (s *llmServer) GrowContext(ctx context.Context, numCtx int, gpus discover.GpuInfoList, f *ggml.GGML) error {
+	if s.textProcessor == nil {
+		return errors.New("the llama engine can't grow the context of a loaded model")
+	}
+
+	if numCtx <= s.options.NumCtx {
+		return nil
+	}
+
+	// estimate with the GPUs in the order the model was loaded on them, so
+	// the per GPU sizes line up with s.gpus
+	available := make(discover.GpuInfoList, 0, len(s.gpus))
+	for _, gpu := range s.gpus {
+		i := slices.IndexFunc(gpus, func(g discover.GpuInfo) bool { return g.Library == gpu.Library && g.ID == gpu.ID })
+		if i < 0 {
+			return fmt.Errorf("gpu %s is no longer available", gpu.ID)
+		}
+		available = append(available, gpus[i])
+	}
+
+	opts := s.options
+	opts.NumCtx = numCtx
+	estimate := EstimateGPULayers(available, f, s.projectors, opts, s.numParallel)
+	if estimate.Layers < s.estimate.Layers {
+		return fmt.Errorf("not enough memory to grow the context to %d with %d layers offloaded", numCtx, s.estimate.Layers)
+	}
+
+	if available[0].Library == "cpu" && estimate.TotalSize > s.estimate.TotalSize+available[0].FreeMemory {
+		return fmt.Errorf("not enough system memory to grow the context to %d", numCtx)
+	}
+
+	data, err := json.Marshal(ContextRequest{Size: numCtx})
+	if err != nil {
+		return fmt.Errorf("error marshaling context data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/context", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error creating context request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("do context request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	slog.Info("grew context", "model", s.modelPath, "from", s.options.NumCtx, "to", numCtx)
+	s.options.NumCtx = numCtx
+	s.estimate = estimate
+	return nil
+}
+
 func (s *llmServer) Close() error {
 	s.llamaModelLock.Lock()
 	if s.llamaModel != nil {
@@ -0,0 +1,312 @@
+// Package metrics implements counters, gauges, and histograms that are
+// written in the Prometheus text exposition format.
+//
+// Metrics are created from a [Registry] with the names of their labels, and
+// label values are passed positionally when a metric is updated:
+//
+//	var r metrics.Registry
+//	requests := r.Counter("requests_total", "Requests served", "model")
+//	requests.Inc("llama3.2")
+//	r.WriteTo(w)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is a set of metrics. The zero value is an empty registry ready
+// to use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes the metrics in the registry in the order they were
+// created.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// desc is the name, help text, and label names of a metric and the series
+// recorded for it keyed by their label values
+type desc[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newDesc[T any](name, help, kind string, labels []string) desc[T] {
+	return desc[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+	}
+}
+
+// get returns the series for labelValues, creating it with init if it
+// doesn't exist. d.mu must be held.
+func (d *desc[T]) get(labelValues []string, init func() *T) *T {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", d.name, len(d.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := d.series[key]
+	if !ok {
+		s = init()
+		d.series[key] = s
+		d.values[key] = slices.Clone(labelValues)
+	}
+	return s
+}
+
+// each calls fn for each series sorted by label values. d.mu must be held.
+func (d *desc[T]) each(fn func(labelValues []string, s *T)) {
+	keys := make([]string, 0, len(d.series))
+	for k := range d.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		fn(d.values[k], d.series[k])
+	}
+}
+
+func (d *desc[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escape(d.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// sample writes a sample of the metric named name with the labels of d and
+// any extra label pairs
+func (d *desc[T]) sample(w *bufio.Writer, name string, labelValues []string, value float64, extra ...string) {
+	w.WriteString(name)
+
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escape(labelValues[i], true)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1], true)+`"`)
+	}
+
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func escape(s string, quoted bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quoted {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Counter is a metric that only increases, such as a number of requests
+type Counter struct {
+	desc[float64]
+}
+
+// Counter creates a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{newDesc[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter with labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s can't decrease", c.name))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues, func() *float64 { return new(float64) }) += v
+}
+
+// Value returns the value of the counter with labelValues.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.get(labelValues, func() *float64 { return new(float64) })
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	c.each(func(labelValues []string, v *float64) {
+		c.sample(w, c.name, labelValues, *v)
+	})
+}
+
+// Gauge is a metric that can go up and down, such as memory in use
+type Gauge struct {
+	desc[float64]
+}
+
+// Gauge creates a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newDesc[float64](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge with labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, func() *float64 { return new(float64) }) = v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+	g.each(func(labelValues []string, v *float64) {
+		g.sample(w, g.name, labelValues, *v)
+	})
+}
+
+// Histogram is a metric that counts observations, such as request
+// latencies, in buckets
+type Histogram struct {
+	desc[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram creates a histogram with the given upper bounds of its buckets,
+// in increasing order, and label names. A bucket for +Inf is always added.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: %s buckets must be in increasing order", name))
+	}
+
+	h := &Histogram{newDesc[histogram](name, help, "histogram", labels), buckets}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the histogram with labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	})
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	h.each(func(labelValues []string, s *histogram) {
+		// buckets are cumulative
+		var count uint64
+		for i, le := range h.buckets {
+			count += s.counts[i]
+			h.sample(w, h.name+"_bucket", labelValues, float64(count), "le", formatFloat(le))
+		}
+		h.sample(w, h.name+"_bucket", labelValues, float64(s.count), "le", "+Inf")
+		h.sample(w, h.name+"_sum", labelValues, s.sum)
+		h.sample(w, h.name+"_count", labelValues, float64(s.count))
+	})
+}
+
+// ExponentialBuckets returns count buckets where the first is start and each
+// is factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteTo(t *testing.T) {
+	var r Registry
+	requests := r.Counter("requests_total", "Requests served", "model")
+	queue := r.Gauge("queue_depth", "Queued requests")
+	latency := r.Histogram("latency_seconds", "Request latency", []float64{0.1, 1}, "model")
+
+	requests.Inc("llama3.2")
+	requests.Add(2, "llama3.2")
+	requests.Inc(`qwen"2.5`)
+	queue.Set(3)
+	latency.Observe(0.05, "llama3.2")
+	latency.Observe(0.5, "llama3.2")
+	latency.Observe(1, "llama3.2")
+	latency.Observe(10, "llama3.2")
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := requests.Value("llama3.2"); v != 3 {
+		t.Errorf("expected 3, got %v", v)
+	}
+
+	if n != int64(b.Len()) {
+		t.Errorf("wrote %d bytes, counted %d", b.Len(), n)
+	}
+
+	want := `# HELP requests_total Requests served
+# TYPE requests_total counter
+requests_total{model="llama3.2"} 3
+requests_total{model="qwen\"2.5"} 1
+# HELP queue_depth Queued requests
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{model="llama3.2",le="0.1"} 1
+latency_seconds_bucket{model="llama3.2",le="1"} 3
+latency_seconds_bucket{model="llama3.2",le="+Inf"} 4
+latency_seconds_sum{model="llama3.2"} 11.55
+latency_seconds_count{model="llama3.2"} 4
+`
+
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	var r Registry
+	c := r.Counter("requests_total", "Requests served", "model")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for missing label values")
+		}
+	}()
+
+	c.Inc()
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	if diff := cmp.Diff([]float64{1, 2, 4, 8}, ExponentialBuckets(1, 2, 4)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -49,6 +49,31 @@ type CacheConfig struct {
 	MaskBatchPadding int
 }
 
+// BackendActivations is implemented by backends which can record the inputs
+// of matrix multiplications with the model's weights. The statistics are
+// used to calibrate quantization.
+type BackendActivations interface {
+	// CollectActivations starts recording the activations of graphs
+	// computed afterwards.
+	CollectActivations()
+
+	// Activations returns the activations recorded so far by weight name.
+	Activations() map[string]Activations
+}
+
+// Activations are statistics of the inputs of a weight
+type Activations struct {
+	// SumSquares is the sum of the squared inputs of each of the weight's
+	// columns
+	SumSquares []float32
+
+	// Count is the number of inputs summed, e.g. tokens
+	Count int
+
+	// Calls is the number of computed graphs which used the weight
+	Calls int
+}
+
 // BackendParams controls how the backend loads and executes models
 type BackendParams struct {
 	// Progress is a callback function that allows reporting percentage completion
@@ -131,6 +156,7 @@ type Tensor interface {
 	Neg(ctx Context) Tensor
 	Add(ctx Context, t2 Tensor) Tensor
 	Mul(ctx Context, t2 Tensor) Tensor
+	Div(ctx Context, t2 Tensor) Tensor
 	Mulmat(ctx Context, t2 Tensor) Tensor
 	MulmatFullPrec(ctx Context, t2 Tensor) Tensor
 	MulmatID(ctx Context, t2, ids Tensor) Tensor
@@ -140,10 +166,18 @@ type Tensor interface {
 	RMSNorm(ctx Context, weight Tensor, eps float32) Tensor
 	Scale(ctx Context, s float64) Tensor
 
+	// SumRows sums each row of the tensor
+	SumRows(ctx Context) Tensor
+
 	AvgPool2D(ctx Context, k, s int, p float32) Tensor
 	Conv2D(ctx Context, weight Tensor, s0, s1, p0, p1, d0, d1 int) Tensor
 
-	RoPE(ctx Context, positionIDs, ropeFactors Tensor, dim, ropeType uint32, base, scale float32) Tensor
+	RoPE(ctx Context, positionIDs, ropeFactors Tensor, dim, ropeType uint32, base, scale float32, opts ...RoPEOptions) Tensor
+
+	// RoPEMulti rotates sections of dim by separate positions, such as the
+	// temporal, height and width of an image. positionIDs holds four positions
+	// for each row of t, ordered by section.
+	RoPEMulti(ctx Context, positionIDs, ropeFactors Tensor, dim uint32, sections [4]int, ropeType uint32, base, scale float32) Tensor
 	IM2Col(ctx Context, weight Tensor, s0, s1, p0, p1, d0, d1 int) Tensor
 
 	Sin(ctx Context) Tensor
@@ -174,6 +208,25 @@ type Tensor interface {
 	TopK(ctx Context, k int) Tensor
 }
 
+// RoPEOptions are the parameters of rotary position embeddings which are
+// only needed to extend the context of some models, such as with YaRN
+type RoPEOptions struct {
+	// OriginalContextLength is the context length the model was trained
+	// with before it was extended. It defaults to 131072
+	OriginalContextLength int
+
+	// ExtrapolationFactor is the amount of YaRN extrapolation to mix in.
+	// YaRN is disabled when it's 0
+	ExtrapolationFactor float32
+
+	// AttentionFactor scales the magnitude of the rotations. It defaults to 1
+	AttentionFactor float32
+
+	// BetaFast and BetaSlow are the YaRN corrections of the rotations.
+	// They default to 32 and 1
+	BetaFast, BetaSlow float32
+}
+
 // ScaledDotProductAttention implements a fused attention
 // operation equivalent to following code on a tensor named
 // query:
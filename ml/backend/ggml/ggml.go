@@ -9,6 +9,7 @@ package ggml
 import "C"
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -61,6 +62,10 @@ type Backend struct {
 
 	// maxGraphNodes is the maximum allowed number of graph nodes in this scheduler
 	maxGraphNodes int
+
+	// activations, if not nil, are the statistics of the inputs of weights
+	// recorded for calibration
+	activations map[string]*ml.Activations
 }
 
 func New(ctx context.Context, r *os.File, params ml.BackendParams) (ml.Backend, error) {
@@ -438,6 +443,20 @@ func (b *Backend) Get(name string) ml.Tensor {
 	return nil
 }
 
+func (b *Backend) CollectActivations() {
+	if b.activations == nil {
+		b.activations = make(map[string]*ml.Activations)
+	}
+}
+
+func (b *Backend) Activations() map[string]ml.Activations {
+	m := make(map[string]ml.Activations, len(b.activations))
+	for name, a := range b.activations {
+		m[name] = ml.Activations{SumSquares: slices.Clone(a.SumSquares), Count: a.Count, Calls: a.Calls}
+	}
+	return m
+}
+
 func (b *Backend) NewContext() ml.Context {
 	return b.NewContextSize(b.maxGraphNodes)
 }
@@ -448,6 +467,7 @@ func (b *Backend) NewContextSize(n int) ml.Context {
 	}
 
 	var allocatedBuffers []*C.struct_ggml_backend_buffer
+	var activations []activation
 
 	return &Context{
 		b:             b,
@@ -457,6 +477,7 @@ func (b *Backend) NewContextSize(n int) ml.Context {
 			no_alloc: true,
 		}),
 		allocatedBuffers: &allocatedBuffers,
+		activations:      &activations,
 	}
 }
 
@@ -483,6 +504,18 @@ type Context struct {
 
 	// maxGraphNodes is the maximum allowed number of graph nodes in this context
 	maxGraphNodes int
+
+	// activations are the statistics of the inputs of weights to record
+	// once the graph is computed
+	activations *[]activation
+}
+
+// activation is the sum of the squared inputs of the columns of a weight in
+// a graph
+type activation struct {
+	name  string
+	t     *C.struct_ggml_tensor
+	count int
 }
 
 func (c *Context) Input() ml.Context {
@@ -493,6 +526,7 @@ func (c *Context) Input() ml.Context {
 			buft:             c.b.input,
 			allocatedBuffers: c.allocatedBuffers,
 			maxGraphNodes:    c.maxGraphNodes,
+			activations:      c.activations,
 		}
 	}
 
@@ -507,12 +541,37 @@ func (c *Context) Layer(i int) ml.Context {
 			buft:             buft,
 			allocatedBuffers: c.allocatedBuffers,
 			maxGraphNodes:    c.maxGraphNodes,
+			activations:      c.activations,
 		}
 	}
 
 	return c
 }
 
+// recordActivations adds the sum of the squares of the columns of t2 to the
+// graph if w is a weight and activations are being collected.
+func (c *Context) recordActivations(w, t2 *C.struct_ggml_tensor) {
+	if c.b.activations == nil {
+		return
+	}
+
+	name := C.GoString(C.ggml_get_name(w))
+	if c.b.tensors[name] != w {
+		return
+	}
+
+	if !C.ggml_is_contiguous(t2) {
+		t2 = C.ggml_cont(c.ctx, t2)
+	}
+
+	rows := C.ggml_nelements(t2) / t2.ne[0]
+	sq := C.ggml_reshape_2d(c.ctx, C.ggml_sqr(c.ctx, t2), t2.ne[0], rows)
+	sum := C.ggml_sum_rows(c.ctx, C.ggml_cont(c.ctx, C.ggml_transpose(c.ctx, sq)))
+	C.ggml_set_output(sum)
+
+	*c.activations = append(*c.activations, activation{name: name, t: sum, count: int(rows)})
+}
+
 func (c *Context) Forward(tensors ...ml.Tensor) ml.Context {
 	if c.graph == nil {
 		c.graph = C.ggml_new_graph_custom(c.ctx, C.size_t(c.maxGraphNodes), false)
@@ -526,6 +585,10 @@ func (c *Context) Forward(tensors ...ml.Tensor) ml.Context {
 }
 
 func (c *Context) Compute(tensors ...ml.Tensor) {
+	for _, a := range *c.activations {
+		C.ggml_build_forward_expand(c.graph, a.t)
+	}
+
 	C.ggml_backend_sched_graph_compute_async(c.b.sched, c.graph)
 	C.ggml_backend_sched_reset(c.b.sched)
 
@@ -542,6 +605,26 @@ func (c *Context) Compute(tensors ...ml.Tensor) {
 			t.(*Tensor).sync = sync
 		}
 	}
+
+	if len(*c.activations) > 0 {
+		sync()
+		for _, a := range *c.activations {
+			sum := (&Tensor{t: a.t, sync: sync}).Floats()
+
+			stats, ok := c.b.activations[a.name]
+			if !ok {
+				stats = &ml.Activations{SumSquares: make([]float32, len(sum))}
+				c.b.activations[a.name] = stats
+			}
+
+			for i, v := range sum {
+				stats.SumSquares[i] += v
+			}
+			stats.Count += a.count
+			stats.Calls++
+		}
+		*c.activations = nil
+	}
 }
 
 func (c *Context) Reserve() error {
@@ -867,7 +950,22 @@ func (t *Tensor) Mul(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	}
 }
 
+func (t *Tensor) Div(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_div(ctx.(*Context).ctx, t.t, t2.(*Tensor).t),
+	}
+}
+
+func (t *Tensor) SumRows(ctx ml.Context) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_sum_rows(ctx.(*Context).ctx, t.t),
+	}
+}
+
 func (t *Tensor) Mulmat(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
+	ctx.(*Context).recordActivations(t.t, t2.(*Tensor).t)
 	return &Tensor{
 		b: t.b,
 		t: C.ggml_mul_mat(ctx.(*Context).ctx, t.t, t2.(*Tensor).t),
@@ -1071,11 +1169,16 @@ const (
 	ropeTypeVision C.int = 24
 )
 
-func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDim, ropeType uint32, ropeBase, ropeScale float32) ml.Tensor {
+func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDim, ropeType uint32, ropeBase, ropeScale float32, opts ...ml.RoPEOptions) ml.Tensor {
 	if ropeFactors == nil {
 		ropeFactors = &Tensor{b: t.b}
 	}
 
+	var o ml.RoPEOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
 	dequant := t.t
 	if C.ggml_is_quantized(t.t._type) {
 		dequant = C.ggml_cast(ctx.(*Context).ctx, t.t, C.GGML_TYPE_F32)
@@ -1087,6 +1190,35 @@ func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDi
 			ctx.(*Context).ctx, dequant, positionIDs.(*Tensor).t, ropeFactors.(*Tensor).t,
 			C.int(ropeDim),
 			C.int(ropeType),
+			C.int(cmp.Or(o.OriginalContextLength, 131072)),
+			C.float(ropeBase),
+			C.float(ropeScale),
+			C.float(o.ExtrapolationFactor),
+			C.float(cmp.Or(o.AttentionFactor, 1)),
+			C.float(cmp.Or(o.BetaFast, 32)),
+			C.float(cmp.Or(o.BetaSlow, 1)),
+		),
+	}
+}
+
+func (t *Tensor) RoPEMulti(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDim uint32, sections [4]int, ropeType uint32, ropeBase, ropeScale float32) ml.Tensor {
+	if ropeFactors == nil {
+		ropeFactors = &Tensor{b: t.b}
+	}
+
+	dequant := t.t
+	if C.ggml_is_quantized(t.t._type) {
+		dequant = C.ggml_cast(ctx.(*Context).ctx, t.t, C.GGML_TYPE_F32)
+	}
+
+	cSections := [4]C.int{C.int(sections[0]), C.int(sections[1]), C.int(sections[2]), C.int(sections[3])}
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_rope_multi(
+			ctx.(*Context).ctx, dequant, positionIDs.(*Tensor).t, ropeFactors.(*Tensor).t,
+			C.int(ropeDim),
+			&cSections[0],
+			C.int(ropeType),
 			131072, // YaRN n_ctx_train
 			C.float(ropeBase),
 			C.float(ropeScale),
@@ -0,0 +1,98 @@
+package nn
+
+import "github.com/ollama/ollama/ml"
+
+type MoEGating int
+
+const (
+	// MoEGatingSoftmax weights experts by the softmax of their router logits
+	MoEGatingSoftmax MoEGating = iota
+
+	// MoEGatingSigmoid weights experts by the sigmoid of their router logits
+	MoEGatingSigmoid
+)
+
+type MoEOptions struct {
+	NumExperts, NumExpertsUsed int
+	Gating                     MoEGating
+
+	// NormalizeWeights scales the weights of the selected experts to sum to 1
+	NormalizeWeights bool
+
+	// WeightsScale multiplies the weights of the selected experts if it isn't 0
+	WeightsScale float32
+}
+
+// SharedExpert is a feed forward network which is applied to every input in
+// addition to the routed experts, optionally gated by Gate
+type SharedExpert struct {
+	Gate   *Linear `gguf:"ffn_gate_shexp"`
+	Up     *Linear `gguf:"ffn_up_shexp"`
+	Down   *Linear `gguf:"ffn_down_shexp"`
+	Router *Linear `gguf:"ffn_gate_inp_shexp"`
+}
+
+func (e *SharedExpert) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	t := e.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, e.Up.Forward(ctx, hiddenState))
+	t = e.Down.Forward(ctx, t)
+	if e.Router != nil {
+		t = t.Mul(ctx, e.Router.Forward(ctx, hiddenState).Sigmoid(ctx))
+	}
+
+	return t
+}
+
+// SparseMoE is a mixture of experts feed forward network. Router selects the
+// top experts for each input, whose outputs are summed by their weights.
+type SparseMoE struct {
+	Router *Linear   `gguf:"ffn_gate_inp"`
+	Gate   ml.Tensor `gguf:"ffn_gate_exps.weight"`
+	Up     ml.Tensor `gguf:"ffn_up_exps.weight"`
+	Down   ml.Tensor `gguf:"ffn_down_exps.weight"`
+
+	SharedExpert *SharedExpert
+}
+
+func (moe *SparseMoE) Forward(ctx ml.Context, hiddenState ml.Tensor, opts MoEOptions) ml.Tensor {
+	hiddenDim, batchSize := hiddenState.Dim(0), hiddenState.Dim(1)
+
+	routerLogits := moe.Router.Forward(ctx, hiddenState)
+
+	var probs ml.Tensor
+	switch opts.Gating {
+	case MoEGatingSigmoid:
+		probs = routerLogits.Sigmoid(ctx)
+	default:
+		probs = routerLogits.Softmax(ctx)
+	}
+
+	experts := probs.TopK(ctx, opts.NumExpertsUsed)
+	weights := probs.Reshape(ctx, 1, opts.NumExperts, batchSize).Rows(ctx, experts)
+
+	if opts.NormalizeWeights {
+		weights = weights.Reshape(ctx, opts.NumExpertsUsed, batchSize)
+		weights = weights.Div(ctx, weights.SumRows(ctx))
+		weights = weights.Reshape(ctx, 1, opts.NumExpertsUsed, batchSize)
+	}
+
+	if opts.WeightsScale != 0 {
+		weights = weights.Scale(ctx, float64(opts.WeightsScale))
+	}
+
+	hiddenState = hiddenState.Reshape(ctx, hiddenDim, 1, batchSize)
+	up := moe.Up.MulmatID(ctx, hiddenState, experts)
+	gate := moe.Gate.MulmatID(ctx, hiddenState, experts)
+	down := moe.Down.MulmatID(ctx, gate.SILU(ctx).Mul(ctx, up), experts)
+	down = down.Mul(ctx, weights)
+
+	t := down.View(ctx, 0, hiddenDim, down.Stride(2), batchSize)
+	for i := 1; i < opts.NumExpertsUsed; i++ {
+		t = t.Add(ctx, down.View(ctx, i*down.Stride(1), hiddenDim, down.Stride(2), batchSize))
+	}
+
+	if moe.SharedExpert != nil {
+		t = t.Add(ctx, moe.SharedExpert.Forward(ctx, hiddenState.Reshape(ctx, hiddenDim, batchSize)))
+	}
+
+	return t
+}
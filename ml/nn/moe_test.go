@@ -0,0 +1,226 @@
+package nn
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+
+	_ "github.com/ollama/ollama/ml/backend"
+)
+
+const (
+	hiddenSize = 4
+	ffnSize    = 6
+	numExperts = 3
+	batchSize  = 5
+)
+
+func values(n, seed int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = float32(math.Sin(float64(seed+i))) / 2
+	}
+
+	return s
+}
+
+func routerWeights() []float32 {
+	return values(hiddenSize*numExperts, 300)
+}
+
+// backend returns a backend with a dense feed forward network and experts
+// which are copies of it, except the down projection of expert e is scaled
+// by e+1
+func backend(t *testing.T) ml.Backend {
+	t.Helper()
+
+	gate, up, down := values(hiddenSize*ffnSize, 0), values(hiddenSize*ffnSize, 100), values(ffnSize*hiddenSize, 200)
+
+	var gateExps, upExps, downExps []float32
+	for e := range numExperts {
+		gateExps = append(gateExps, gate...)
+		upExps = append(upExps, up...)
+		for _, v := range down {
+			downExps = append(downExps, v*float32(e+1))
+		}
+	}
+
+	tensor := func(name string, values []float32, shape ...uint64) fsggml.Tensor {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+
+		return fsggml.Tensor{Name: name, Kind: 0, Shape: shape, WriterTo: &b}
+	}
+
+	p := filepath.Join(t.TempDir(), "moe.gguf")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fsggml.WriteGGUF(f, fsggml.KV{"general.architecture": "test"}, []fsggml.Tensor{
+		tensor("ffn_gate.weight", gate, hiddenSize, ffnSize),
+		tensor("ffn_up.weight", up, hiddenSize, ffnSize),
+		tensor("ffn_down.weight", down, ffnSize, hiddenSize),
+		tensor("ffn_gate_inp.weight", routerWeights(), hiddenSize, numExperts),
+		tensor("ffn_gate_exps.weight", gateExps, hiddenSize, ffnSize, numExperts),
+		tensor("ffn_up_exps.weight", upExps, hiddenSize, ffnSize, numExperts),
+		tensor("ffn_down_exps.weight", downExps, ffnSize, hiddenSize, numExperts),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := ml.NewBackend(context.TODO(), r, ml.BackendParams{NumThreads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// route returns the weight of each expert for x, as SparseMoE should select
+// them
+func route(router, x []float32, opts MoEOptions) []float32 {
+	probs := make([]float32, numExperts)
+	for e := range numExperts {
+		for i := range hiddenSize {
+			probs[e] += router[e*hiddenSize+i] * x[i]
+		}
+	}
+
+	switch opts.Gating {
+	case MoEGatingSigmoid:
+		for e, v := range probs {
+			probs[e] = float32(1 / (1 + math.Exp(-float64(v))))
+		}
+	default:
+		var sum float32
+		for e, v := range probs {
+			probs[e] = float32(math.Exp(float64(v)))
+			sum += probs[e]
+		}
+
+		for e := range probs {
+			probs[e] /= sum
+		}
+	}
+
+	order := []int{0, 1, 2}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(probs[b], probs[a]) })
+
+	weights := make([]float32, numExperts)
+	var sum float32
+	for _, e := range order[:opts.NumExpertsUsed] {
+		weights[e] = probs[e]
+		sum += probs[e]
+	}
+
+	for e := range weights {
+		if opts.NormalizeWeights {
+			weights[e] /= sum
+		}
+
+		if opts.WeightsScale != 0 {
+			weights[e] *= opts.WeightsScale
+		}
+	}
+
+	return weights
+}
+
+func TestSparseMoE(t *testing.T) {
+	b := backend(t)
+
+	experts := &SparseMoE{
+		Router: &Linear{Weight: b.Get("ffn_gate_inp.weight")},
+		Gate:   b.Get("ffn_gate_exps.weight"),
+		Up:     b.Get("ffn_up_exps.weight"),
+		Down:   b.Get("ffn_down_exps.weight"),
+	}
+
+	mlp := &SharedExpert{
+		Gate: &Linear{Weight: b.Get("ffn_gate.weight")},
+		Up:   &Linear{Weight: b.Get("ffn_up.weight")},
+		Down: &Linear{Weight: b.Get("ffn_down.weight")},
+	}
+
+	router := routerWeights()
+	x := values(hiddenSize*batchSize, 400)
+
+	cases := []struct {
+		name   string
+		opts   MoEOptions
+		shared bool
+	}{
+		{"softmax", MoEOptions{NumExperts: numExperts, NumExpertsUsed: 2}, false},
+		{"normalized", MoEOptions{NumExperts: numExperts, NumExpertsUsed: 2, NormalizeWeights: true}, false},
+		{"sigmoid", MoEOptions{NumExperts: numExperts, NumExpertsUsed: 2, Gating: MoEGatingSigmoid, NormalizeWeights: true, WeightsScale: 2.5}, false},
+		{"top1", MoEOptions{NumExperts: numExperts, NumExpertsUsed: 1}, false},
+		{"shared", MoEOptions{NumExperts: numExperts, NumExpertsUsed: 2, NormalizeWeights: true}, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := b.NewContext()
+			defer ctx.Close()
+
+			input, err := ctx.Input().FromFloatSlice(x, hiddenSize, batchSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			moe := *experts
+			if tt.shared {
+				moe.SharedExpert = mlp
+			}
+
+			got := moe.Forward(ctx, input, tt.opts)
+			want := mlp.Forward(ctx, input)
+			ctx.Forward(got, want).Compute(got, want)
+
+			if !slices.Equal(got.Shape(), []int{hiddenSize, batchSize}) {
+				t.Fatalf("unexpected shape %v", got.Shape())
+			}
+
+			gotValues, wantValues := got.Floats(), want.Floats()
+			for j := range batchSize {
+				weights := route(router, x[j*hiddenSize:(j+1)*hiddenSize], tt.opts)
+
+				// the experts are scaled copies of the dense network
+				var scale float32
+				for e, w := range weights {
+					scale += w * float32(e+1)
+				}
+
+				if tt.shared {
+					scale += 1
+				}
+
+				for i := range hiddenSize {
+					want := wantValues[j*hiddenSize+i] * scale
+					if got := gotValues[j*hiddenSize+i]; math.Abs(float64(got-want)) > 1e-4 {
+						t.Errorf("input %d value %d = %v, want %v", j, i, got, want)
+					}
+				}
+			}
+		})
+	}
+}
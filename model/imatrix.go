@@ -0,0 +1,107 @@
+package model
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+type CalibrateOptions struct {
+	// ChunkSize is the number of tokens evaluated at a time, as
+	// independent sequences. It defaults to 512.
+	ChunkSize int
+
+	// Progress is called after each chunk with the number of chunks
+	// evaluated so far, and in total.
+	Progress func(completed, total int)
+}
+
+// Calibrate evaluates text with m and returns the importance matrix of m's
+// weights, i.e. the mean squared activations of their columns, which is used
+// to weight quantization errors. m's backend must implement
+// [ml.BackendActivations].
+func Calibrate(m Model, text string, opts CalibrateOptions) (*fsggml.Imatrix, error) {
+	b, ok := m.Backend().(ml.BackendActivations)
+	if !ok {
+		return nil, errors.New("backend does not support collecting activations")
+	}
+
+	tp, ok := m.(TextProcessor)
+	if !ok {
+		return nil, errors.New("model does not support text input")
+	}
+
+	tokens, err := tp.Encode(text, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// each chunk starts like a prompt
+	var prefix []int32
+	if v := tp.Vocabulary(); v.AddBOS {
+		prefix = append(prefix, v.BOS)
+	}
+
+	chunkSize := cmp.Or(opts.ChunkSize, 512)
+	n := chunkSize - len(prefix)
+	chunks := len(tokens) / n
+	if chunks == 0 {
+		return nil, fmt.Errorf("calibration dataset is too short: %d tokens, need at least %d", len(tokens), n)
+	}
+
+	cache := m.Config().Cache
+	if cache != nil {
+		cache.Init(m.Backend(), ml.DTypeF16, 1, chunkSize, chunkSize)
+		defer cache.Close()
+	}
+
+	b.CollectActivations()
+
+	batch := input.Batch{
+		Positions: make([]int32, chunkSize),
+		Sequences: make([]int, chunkSize),
+		Outputs:   []int32{int32(chunkSize - 1)},
+	}
+	for i := range batch.Positions {
+		batch.Positions[i] = int32(i)
+	}
+
+	for i := range chunks {
+		if cache != nil {
+			if err := cache.Remove(0, 0, math.MaxInt32); err != nil {
+				return nil, err
+			}
+		}
+
+		inputs := slices.Concat(prefix, tokens[i*n:(i+1)*n])
+
+		ctx := m.Backend().NewContext()
+		_, err := Forward(ctx, m, inputs, batch)
+		ctx.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(i+1, chunks)
+		}
+	}
+
+	imatrix := fsggml.Imatrix{Entries: make(map[string]fsggml.ImatrixEntry), Chunks: chunks}
+	for name, a := range b.Activations() {
+		values := make([]float32, len(a.SumSquares))
+		for i, v := range a.SumSquares {
+			values[i] = v / float32(a.Count)
+		}
+
+		imatrix.Entries[name] = fsggml.ImatrixEntry{Calls: a.Calls, Values: values}
+	}
+
+	return &imatrix, nil
+}
@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"os"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -65,6 +66,15 @@ type MultimodalProcessor interface {
 	PostTokenize([]input.Input) ([]input.Input, error)
 }
 
+// TensorValidator is implemented by models which only support some of the
+// tensor layouts used by model files of their architecture, such as files
+// converted by older versions of llama.cpp. ValidateTensors returns an error
+// for files the model can't run so they're run by the compatibility engine
+// instead. has reports whether the file has the named tensor.
+type TensorValidator interface {
+	ValidateTensors(has func(name string) bool) error
+}
+
 // Base implements the common fields and methods for all models
 type Base struct {
 	b ml.Backend
@@ -119,6 +129,12 @@ func New(ctx context.Context, modelPath string, params ml.BackendParams) (Model,
 		return nil, err
 	}
 
+	if v, ok := m.(TensorValidator); ok {
+		if err := v.ValidateTensors(func(name string) bool { return b.Get(name) != nil }); err != nil {
+			return nil, err
+		}
+	}
+
 	base := Base{b: b, config: m.Config()}
 
 	v := reflect.ValueOf(m)
@@ -136,7 +152,22 @@ func NewTextProcessor(s string) (TextProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
-	return getTextProcessor(meta.KV())
+
+	tp, err := getTextProcessor(meta.KV())
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := tp.(TensorValidator); ok {
+		has := func(name string) bool {
+			return slices.ContainsFunc(meta.Tensors().Items(), func(t *fsggml.Tensor) bool { return t.Name == name })
+		}
+		if err := v.ValidateTensors(has); err != nil {
+			return nil, err
+		}
+	}
+
+	return tp, nil
 }
 
 func getTextProcessor(kv fsggml.KV) (TextProcessor, error) {
@@ -0,0 +1,233 @@
+package bert
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ollama/ollama/fs"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+const ropeTypeNeox = 2
+
+type Options struct {
+	hiddenSize, numHeads int
+	eps, ropeBase        float32
+	poolingType          PoolingType
+}
+
+// Model is a bidirectional encoder which embeds each sequence in a batch.
+// BERT has absolute position embeddings while NomicBERT rotates the queries
+// and keys.
+type Model struct {
+	model.Base
+	model.WordPiece
+
+	TokenEmbedding     *nn.Embedding `gguf:"token_embd"`
+	TypeEmbedding      *nn.Embedding `gguf:"token_types"`
+	PositionEmbedding  *nn.Embedding `gguf:"position_embd"`
+	TokenEmbeddingNorm *nn.LayerNorm `gguf:"token_embd_norm"`
+
+	Layers []Layer `gguf:"blk"`
+
+	*Options
+}
+
+func New(c fs.Config) (model.Model, error) {
+	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "bert") {
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	if c.Bool("attention.causal", false) {
+		return nil, fmt.Errorf("causal attention not supported")
+	}
+
+	m := Model{
+		WordPiece: model.NewWordPiece(
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Ints("tokenizer.ggml.token_type"),
+				BOS:    int32(c.Uint("tokenizer.ggml.cls_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+				EOS:    int32(c.Uint("tokenizer.ggml.seperator_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", true),
+			},
+		),
+		Layers: make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize:  int(c.Uint("embedding_length")),
+			numHeads:    int(c.Uint("attention.head_count")),
+			eps:         c.Float("attention.layer_norm_epsilon"),
+			ropeBase:    c.Float("rope.freq_base", 1000),
+			poolingType: PoolingType(c.Uint("pooling_type")),
+		},
+	}
+
+	return &m, nil
+}
+
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	QKV    *nn.Linear `gguf:"attn_qkv"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs, mask ml.Tensor, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	var q, k, v ml.Tensor
+	if sa.QKV != nil {
+		qkv := sa.QKV.Forward(ctx, hiddenState)
+		chunk := func(i int) ml.Tensor {
+			return qkv.View(ctx, i*opts.hiddenSize*qkv.Stride(0),
+				headDim, headDim*qkv.Stride(0),
+				opts.numHeads, qkv.Stride(1),
+				batchSize)
+		}
+
+		q, k, v = chunk(0), chunk(1), chunk(2)
+	} else {
+		q = sa.Query.Forward(ctx, hiddenState).Reshape(ctx, headDim, opts.numHeads, batchSize)
+		k = sa.Key.Forward(ctx, hiddenState).Reshape(ctx, headDim, opts.numHeads, batchSize)
+		v = sa.Value.Forward(ctx, hiddenState).Reshape(ctx, headDim, opts.numHeads, batchSize)
+	}
+
+	if positionIDs != nil {
+		q = q.RoPE(ctx, positionIDs, nil, uint32(headDim), ropeTypeNeox, opts.ropeBase, 1)
+		k = k.RoPE(ctx, positionIDs, nil, uint32(headDim), ropeTypeNeox, opts.ropeBase, 1)
+	}
+
+	// every input attends to all others in its sequence
+	q = q.Permute(ctx, 0, 2, 1, 3)
+	k = k.Permute(ctx, 0, 2, 1, 3)
+	v = v.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	kq := k.MulmatFullPrec(ctx, q)
+	kq = kq.Scale(ctx, 1.0/math.Sqrt(float64(headDim)))
+	if mask != nil {
+		kq = kq.Add(ctx, mask)
+	}
+	kq = kq.Softmax(ctx)
+
+	kqv := v.Mulmat(ctx, kq)
+	kqv = kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Gate *nn.Linear `gguf:"ffn_gate"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	// NomicBERT has a gated feed forward network
+	if mlp.Gate != nil {
+		hiddenState = mlp.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	} else {
+		hiddenState = mlp.Up.Forward(ctx, hiddenState).GELU(ctx)
+	}
+
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+// Layer normalizes the outputs of attention and the feed forward network
+// after adding them to their inputs
+type Layer struct {
+	SelfAttention   *SelfAttention
+	AttentionNorm   *nn.LayerNorm `gguf:"attn_output_norm"`
+	MLP             *MLP
+	LayerOutputNorm *nn.LayerNorm `gguf:"layer_output_norm"`
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, mask ml.Tensor, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, mask, opts)
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState.Add(ctx, residual), opts.eps)
+	residual = hiddenState
+
+	hiddenState = l.MLP.Forward(ctx, hiddenState)
+	return l.LayerOutputNorm.Forward(ctx, hiddenState.Add(ctx, residual), opts.eps)
+}
+
+// sequenceMask returns a mask which prevents inputs from attending to other
+// sequences in the batch, or nil if there is only one sequence
+func sequenceMask(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	n := len(batch.Sequences)
+
+	single := true
+	for _, s := range batch.Sequences {
+		single = single && s == batch.Sequences[0]
+	}
+
+	if single {
+		return nil, nil
+	}
+
+	mask := make([]float32, n*n)
+	for i := range n {
+		for j := range n {
+			if batch.Sequences[i] != batch.Sequences[j] {
+				mask[i*n+j] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	return ctx.Input().FromFloatSlice(mask, n, n)
+}
+
+// Forward returns the pooled embeddings of each sequence in the batch, in the
+// order they first appear, or the embeddings of the outputs if the model
+// isn't pooled
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	positions, err := ctx.Input().FromIntSlice(batch.Positions, len(batch.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	mask, err := sequenceMask(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, batch.Inputs)
+	if m.TypeEmbedding != nil {
+		// all inputs are of the first token type
+		types, err := ctx.Input().FromIntSlice(make([]int32, len(batch.Positions)), len(batch.Positions))
+		if err != nil {
+			return nil, err
+		}
+
+		hiddenState = hiddenState.Add(ctx, m.TypeEmbedding.Forward(ctx, types))
+	}
+
+	var positionIDs ml.Tensor
+	if m.PositionEmbedding != nil {
+		hiddenState = hiddenState.Add(ctx, m.PositionEmbedding.Forward(ctx, positions))
+	} else {
+		positionIDs = positions
+	}
+
+	hiddenState = m.TokenEmbeddingNorm.Forward(ctx, hiddenState, m.eps)
+
+	for _, layer := range m.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, positionIDs, mask, m.Options)
+	}
+
+	return m.poolingType.Forward(ctx, hiddenState, batch)
+}
+
+func init() {
+	model.Register("bert", New)
+	model.Register("nomic-bert", New)
+}
@@ -0,0 +1,156 @@
+package bert
+
+import (
+	"slices"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+const hiddenSize = 8
+
+// synthetic returns a small encoder of arch with deterministic weights
+func synthetic(t *testing.T, arch string) *Model {
+	t.Helper()
+
+	kv := fsggml.KV{
+		"general.architecture":                 arch,
+		arch + ".block_count":                  uint32(1),
+		arch + ".context_length":               uint32(16),
+		arch + ".embedding_length":             uint32(hiddenSize),
+		arch + ".feed_forward_length":          uint32(16),
+		arch + ".attention.head_count":         uint32(2),
+		arch + ".attention.layer_norm_epsilon": float32(1e-12),
+		arch + ".attention.causal":             false,
+		arch + ".pooling_type":                 uint32(PoolingTypeMean),
+		"tokenizer.ggml.model":                 "bert",
+		"tokenizer.ggml.tokens":                []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "▁a", "▁b"},
+		"tokenizer.ggml.token_type":            []int32{3, 3, 3, 3, 1, 1},
+		"tokenizer.ggml.cls_token_id":          uint32(2),
+		"tokenizer.ggml.seperator_token_id":    uint32(3),
+	}
+
+	ts := []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{hiddenSize, 6}},
+		{Name: "token_types.weight", Shape: []uint64{hiddenSize, 2}},
+		{Name: "token_embd_norm.weight", Shape: []uint64{hiddenSize}},
+		{Name: "token_embd_norm.bias", Shape: []uint64{hiddenSize}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{hiddenSize, hiddenSize}},
+		{Name: "blk.0.attn_output_norm.weight", Shape: []uint64{hiddenSize}},
+		{Name: "blk.0.attn_output_norm.bias", Shape: []uint64{hiddenSize}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{hiddenSize, 16}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, hiddenSize}},
+		{Name: "blk.0.layer_output_norm.weight", Shape: []uint64{hiddenSize}},
+		{Name: "blk.0.layer_output_norm.bias", Shape: []uint64{hiddenSize}},
+	}
+
+	switch arch {
+	case "bert":
+		ts = append(ts, []testutil.Tensor{
+			{Name: "position_embd.weight", Shape: []uint64{hiddenSize, 16}},
+			{Name: "blk.0.attn_q.weight", Shape: []uint64{hiddenSize, hiddenSize}},
+			{Name: "blk.0.attn_q.bias", Shape: []uint64{hiddenSize}},
+			{Name: "blk.0.attn_k.weight", Shape: []uint64{hiddenSize, hiddenSize}},
+			{Name: "blk.0.attn_k.bias", Shape: []uint64{hiddenSize}},
+			{Name: "blk.0.attn_v.weight", Shape: []uint64{hiddenSize, hiddenSize}},
+			{Name: "blk.0.attn_v.bias", Shape: []uint64{hiddenSize}},
+			{Name: "blk.0.ffn_up.bias", Shape: []uint64{16}},
+			{Name: "blk.0.ffn_down.bias", Shape: []uint64{hiddenSize}},
+		}...)
+	case "nomic-bert":
+		ts = append(ts, []testutil.Tensor{
+			{Name: "blk.0.attn_qkv.weight", Shape: []uint64{hiddenSize, 3 * hiddenSize}},
+			{Name: "blk.0.ffn_gate.weight", Shape: []uint64{hiddenSize, 16}},
+		}...)
+	}
+
+	return testutil.Synthetic(t, kv, ts).(*Model)
+}
+
+// embed returns the embeddings of sequences, which are batched together
+func embed(t *testing.T, m *Model, sequences ...[]int32) [][]float32 {
+	t.Helper()
+
+	var tokens []int32
+	var batch input.Batch
+	for s, sequence := range sequences {
+		for i, token := range sequence {
+			tokens = append(tokens, token)
+			batch.Positions = append(batch.Positions, int32(i))
+			batch.Sequences = append(batch.Sequences, s)
+			batch.Outputs = append(batch.Outputs, int32(len(batch.Outputs)))
+		}
+	}
+
+	values, dims := testutil.Forward(t, m, tokens, batch)
+	if dims[0] != hiddenSize {
+		t.Fatalf("unexpected embeddings shape %v", dims)
+	}
+
+	var result [][]float32
+	for i := range dims[1] {
+		result = append(result, values[i*hiddenSize:(i+1)*hiddenSize])
+	}
+
+	return result
+}
+
+func equal(t *testing.T, name string, got, want []float32) {
+	t.Helper()
+
+	if !testutil.Close(got, want, 1e-4) {
+		t.Errorf("%s mismatch: got %v, want %v", name, got, want)
+	}
+}
+
+func TestModel(t *testing.T) {
+	for _, arch := range []string{"bert", "nomic-bert"} {
+		t.Run(arch, func(t *testing.T) {
+			m := synthetic(t, arch)
+
+			ids, err := m.Encode("a b", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []int32{2, 4, 5, 3}; !slices.Equal(ids, want) {
+				t.Fatalf("encode = %v, want %v", ids, want)
+			}
+
+			first, second := ids, []int32{2, 5, 3}
+
+			m.poolingType = PoolingTypeNone
+			inputs := embed(t, m, first)
+
+			// inputs only attend to their own sequence
+			batched := embed(t, m, first, second)
+			for i := range first {
+				equal(t, "batched", batched[i], inputs[i])
+			}
+
+			m.poolingType = PoolingTypeMean
+			mean := make([]float32, hiddenSize)
+			for _, e := range inputs {
+				for j, v := range e {
+					mean[j] += v / float32(len(inputs))
+				}
+			}
+
+			pooled := embed(t, m, first, second)
+			if len(pooled) != 2 {
+				t.Fatalf("expected 2 embeddings, got %d", len(pooled))
+			}
+
+			equal(t, "mean", pooled[0], mean)
+			equal(t, "mean", pooled[1], embed(t, m, second)[0])
+
+			m.poolingType = PoolingTypeCLS
+			equal(t, "cls", embed(t, m, first, second)[0], inputs[0])
+
+			m.poolingType = PoolingTypeLast
+			equal(t, "last", embed(t, m, first, second)[0], inputs[len(inputs)-1])
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package bert
+
+import (
+	"fmt"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+// PoolingType is how the embeddings of a sequence's inputs are combined
+type PoolingType uint32
+
+const (
+	PoolingTypeNone PoolingType = iota
+	PoolingTypeMean
+	PoolingTypeCLS
+	PoolingTypeLast
+)
+
+func (p PoolingType) String() string {
+	switch p {
+	case PoolingTypeNone:
+		return "none"
+	case PoolingTypeMean:
+		return "mean"
+	case PoolingTypeCLS:
+		return "cls"
+	case PoolingTypeLast:
+		return "last"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint32(p))
+	}
+}
+
+// Forward pools hiddenState, which holds the embeddings of each input in
+// batch, into an embedding for each sequence
+func (p PoolingType) Forward(ctx ml.Context, hiddenState ml.Tensor, batch input.Batch) (ml.Tensor, error) {
+	if p == PoolingTypeNone {
+		outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+		if err != nil {
+			return nil, err
+		}
+
+		return hiddenState.Rows(ctx, outputs), nil
+	}
+
+	// indices of the inputs of each sequence, in the order they first appear
+	var sequences [][]int32
+	index := make(map[int]int)
+	for i, s := range batch.Sequences {
+		j, ok := index[s]
+		if !ok {
+			j = len(sequences)
+			index[s] = j
+			sequences = append(sequences, nil)
+		}
+
+		sequences[j] = append(sequences[j], int32(i))
+	}
+
+	switch p {
+	case PoolingTypeMean:
+		// average with a matrix that weights each input by the length of
+		// its sequence
+		n := len(batch.Sequences)
+		weights := make([]float32, n*len(sequences))
+		for j, inputs := range sequences {
+			for _, i := range inputs {
+				weights[j*n+int(i)] = 1 / float32(len(inputs))
+			}
+		}
+
+		t, err := ctx.Input().FromFloatSlice(weights, n, len(sequences))
+		if err != nil {
+			return nil, err
+		}
+
+		hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+		return hiddenState.Mulmat(ctx, t), nil
+	case PoolingTypeCLS, PoolingTypeLast:
+		rows := make([]int32, len(sequences))
+		for j, inputs := range sequences {
+			if p == PoolingTypeCLS {
+				rows[j] = inputs[0]
+			} else {
+				rows[j] = inputs[len(inputs)-1]
+			}
+		}
+
+		t, err := ctx.Input().FromIntSlice(rows, len(rows))
+		if err != nil {
+			return nil, err
+		}
+
+		return hiddenState.Rows(ctx, t), nil
+	default:
+		return nil, fmt.Errorf("unsupported pooling type %s", p)
+	}
+}
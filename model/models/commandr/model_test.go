@@ -0,0 +1,92 @@
+package commandr
+
+import (
+	"math"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+// synthetic returns a small Command-R model with deterministic weights
+func synthetic(t *testing.T, logitScale float32, qkNorm bool) model.Model {
+	t.Helper()
+
+	kv := fsggml.KV{
+		"general.architecture":                   "command-r",
+		"command-r.block_count":                  uint32(1),
+		"command-r.context_length":               uint32(64),
+		"command-r.embedding_length":             uint32(8),
+		"command-r.feed_forward_length":          uint32(16),
+		"command-r.attention.head_count":         uint32(2),
+		"command-r.attention.head_count_kv":      uint32(1),
+		"command-r.attention.layer_norm_epsilon": float32(1e-5),
+		"command-r.rope.freq_base":               float32(10000),
+		"command-r.logit_scale":                  logitScale,
+		"tokenizer.ggml.model":                   "gpt2",
+		"tokenizer.ggml.tokens":                  []string{"a", "b", "c", "d"},
+		"tokenizer.ggml.token_type":              []int32{1, 1, 1, 1},
+		"tokenizer.ggml.merges":                  []string{},
+	}
+
+	ts := []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{8, 4}},
+		{Name: "output_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{8, 8}},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{8, 4}},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{8, 4}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{8, 8}},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{8, 16}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{8, 16}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, 8}},
+	}
+
+	if qkNorm {
+		ts = append(ts,
+			testutil.Tensor{Name: "blk.0.attn_q_norm.weight", Shape: []uint64{4, 2}},
+			testutil.Tensor{Name: "blk.0.attn_k_norm.weight", Shape: []uint64{4, 1}},
+		)
+	}
+
+	return testutil.Synthetic(t, kv, ts)
+}
+
+func forward(t *testing.T, m model.Model) []float32 {
+	t.Helper()
+
+	cache := m.Config().Cache
+	cache.Init(m.Backend(), ml.DTypeF16, 1, 64, 64)
+	t.Cleanup(cache.Close)
+
+	values, dims := testutil.Forward(t, m, []int32{0, 1, 2, 3}, testutil.Sequence([]int32{0, 1, 2, 3}, 3))
+	if dims != [2]int{4, 1} {
+		t.Fatalf("unexpected logits shape %v", dims)
+	}
+
+	return values
+}
+
+func TestLogitScale(t *testing.T) {
+	unscaled := forward(t, synthetic(t, 1, false))
+	scaled := forward(t, synthetic(t, 0.0625, false))
+
+	for i := range unscaled {
+		if want := unscaled[i] * 0.0625; math.Abs(float64(scaled[i]-want)) > 1e-6 {
+			t.Errorf("logit %d = %v, want %v", i, scaled[i], want)
+		}
+	}
+}
+
+func TestQKNorm(t *testing.T) {
+	m := synthetic(t, 0.0625, true).(*Model)
+
+	sa := m.Layers[0].SelfAttention
+	if sa.QueryNorm == nil || sa.KeyNorm == nil {
+		t.Fatal("expected query and key norms")
+	}
+
+	forward(t, m)
+}
@@ -3,7 +3,6 @@ package llama
 import (
 	"fmt"
 	"math"
-	"strings"
 
 	"github.com/ollama/ollama/fs"
 	"github.com/ollama/ollama/kvcache"
@@ -17,11 +16,12 @@ type Options struct {
 	hiddenSize, numHeads, numKVHeads int
 	eps, ropeBase, ropeScale         float32
 	ropeDim                          uint32
+	moeOptions                       nn.MoEOptions
 }
 
 type Model struct {
 	model.Base
-	model.BytePairEncoding
+	model.TextProcessor
 
 	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
 	Layers         []Layer       `gguf:"blk"`
@@ -32,24 +32,36 @@ type Model struct {
 }
 
 func New(c fs.Config) (model.Model, error) {
-	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "gpt2") {
+	vocabulary := model.Vocabulary{
+		Values: c.Strings("tokenizer.ggml.tokens"),
+		Scores: c.Floats("tokenizer.ggml.scores"),
+		Types:  c.Ints("tokenizer.ggml.token_type"),
+		Merges: c.Strings("tokenizer.ggml.merges"),
+		BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+		AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+		EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+		AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+	}
+
+	// Llama 3 uses byte pair encoding while Llama 2 and Mixtral use SentencePiece
+	var processor model.TextProcessor
+	switch c.String("tokenizer.ggml.model") {
+	case "gpt2":
+		bpe := model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&vocabulary,
+		)
+		processor = &bpe
+	case "llama":
+		spm := model.NewSentencePieceModel(&vocabulary)
+		processor = &spm
+	default:
 		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
 	}
 
 	m := Model{
-		BytePairEncoding: model.NewBytePairEncoding(
-			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
-			&model.Vocabulary{
-				Values: c.Strings("tokenizer.ggml.tokens"),
-				Types:  c.Ints("tokenizer.ggml.token_type"),
-				Merges: c.Strings("tokenizer.ggml.merges"),
-				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
-				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
-				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
-				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
-			},
-		),
-		Layers: make([]Layer, c.Uint("block_count")),
+		TextProcessor: processor,
+		Layers:        make([]Layer, c.Uint("block_count")),
 		Options: &Options{
 			hiddenSize: int(c.Uint("embedding_length")),
 			numHeads:   int(c.Uint("attention.head_count")),
@@ -58,14 +70,47 @@ func New(c fs.Config) (model.Model, error) {
 			ropeBase:   c.Float("rope.freq_base"),
 			ropeScale:  c.Float("rope.freq_scale", 1),
 			ropeDim:    c.Uint("rope.dimension_count"),
+			moeOptions: nn.MoEOptions{
+				NumExperts:       int(c.Uint("expert_count")),
+				NumExpertsUsed:   int(c.Uint("expert_used_count")),
+				NormalizeWeights: true,
+			},
 		},
 	}
 
+	// Mixtral replaces the feed forward network of each layer with a
+	// mixture of experts
+	for i := range m.Layers {
+		if m.moeOptions.NumExperts > 0 {
+			m.Layers[i].MLP = &MoE{}
+		} else {
+			m.Layers[i].MLP = &MLP{}
+		}
+	}
+
 	m.Cache = kvcache.NewCausalCache(m.Shift)
 
 	return &m, nil
 }
 
+// ValidateTensors checks the experts of a Mixtral model are merged into a
+// tensor per layer, as older conversions store each expert separately
+func (m *Model) ValidateTensors(has func(string) bool) error {
+	if m.moeOptions.NumExperts == 0 {
+		return nil
+	}
+
+	for i := range m.Layers {
+		for _, name := range []string{"ffn_gate_exps", "ffn_up_exps", "ffn_down_exps"} {
+			if name := fmt.Sprintf("blk.%d.%s.weight", i, name); !has(name) {
+				return fmt.Errorf("mixture of experts model is missing tensor %s", name)
+			}
+		}
+	}
+
+	return nil
+}
+
 type SelfAttention struct {
 	Query       *nn.Linear `gguf:"attn_q"`
 	Key         *nn.Linear `gguf:"attn_k"`
@@ -112,11 +157,23 @@ func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml
 	return mlp.Down.Forward(ctx, hiddenState)
 }
 
+type MoE struct {
+	*nn.SparseMoE
+}
+
+func (moe *MoE) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml.Tensor {
+	return moe.SparseMoE.Forward(ctx, hiddenState, opts.moeOptions)
+}
+
+type FeedForward interface {
+	Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml.Tensor
+}
+
 type Layer struct {
 	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
 	SelfAttention *SelfAttention
 	MLPNorm       *nn.RMSNorm `gguf:"ffn_norm"`
-	MLP           *MLP
+	MLP           FeedForward
 }
 
 func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
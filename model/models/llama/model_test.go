@@ -0,0 +1,125 @@
+package llama
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+// mixtral returns a small Mixtral model with deterministic weights
+func mixtral(t *testing.T) *Model {
+	t.Helper()
+	return testutil.Synthetic(t, mixtralKV(), mixtralTensors(true)).(*Model)
+}
+
+func mixtralKV() fsggml.KV {
+	return fsggml.KV{
+		"general.architecture":                   "llama",
+		"llama.block_count":                      uint32(2),
+		"llama.context_length":                   uint32(64),
+		"llama.embedding_length":                 uint32(8),
+		"llama.feed_forward_length":              uint32(16),
+		"llama.attention.head_count":             uint32(2),
+		"llama.attention.head_count_kv":          uint32(1),
+		"llama.attention.layer_norm_rms_epsilon": float32(1e-5),
+		"llama.rope.dimension_count":             uint32(4),
+		"llama.rope.freq_base":                   float32(1e6),
+		"llama.expert_count":                     uint32(4),
+		"llama.expert_used_count":                uint32(2),
+		"tokenizer.ggml.model":                   "llama",
+		"tokenizer.ggml.tokens":                  []string{"<unk>", "<s>", "</s>", "▁a", "▁b", "c"},
+		"tokenizer.ggml.scores":                  []float32{0, 0, 0, -1, -2, -3},
+		"tokenizer.ggml.token_type":              []int32{2, 3, 3, 1, 1, 1},
+		"tokenizer.ggml.bos_token_id":            uint32(1),
+		"tokenizer.ggml.eos_token_id":            uint32(2),
+	}
+}
+
+// mixtralTensors returns the tensors of the model returned by mixtralKV with
+// the experts of each layer merged into a tensor, or separate as in older
+// conversions
+func mixtralTensors(merged bool) []testutil.Tensor {
+	ts := []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{8, 6}},
+		{Name: "output_norm.weight", Shape: []uint64{8}},
+		{Name: "output.weight", Shape: []uint64{8, 6}},
+	}
+
+	for _, blk := range []string{"blk.0", "blk.1"} {
+		ts = append(ts, []testutil.Tensor{
+			{Name: blk + ".attn_norm.weight", Shape: []uint64{8}},
+			{Name: blk + ".attn_q.weight", Shape: []uint64{8, 8}},
+			{Name: blk + ".attn_k.weight", Shape: []uint64{8, 4}},
+			{Name: blk + ".attn_v.weight", Shape: []uint64{8, 4}},
+			{Name: blk + ".attn_output.weight", Shape: []uint64{8, 8}},
+			{Name: blk + ".ffn_norm.weight", Shape: []uint64{8}},
+			{Name: blk + ".ffn_gate_inp.weight", Shape: []uint64{8, 4}},
+		}...)
+
+		if merged {
+			ts = append(ts, []testutil.Tensor{
+				{Name: blk + ".ffn_gate_exps.weight", Shape: []uint64{8, 16, 4}},
+				{Name: blk + ".ffn_up_exps.weight", Shape: []uint64{8, 16, 4}},
+				{Name: blk + ".ffn_down_exps.weight", Shape: []uint64{16, 8, 4}},
+			}...)
+			continue
+		}
+
+		for e := range 4 {
+			ts = append(ts, []testutil.Tensor{
+				{Name: fmt.Sprintf("%s.ffn_gate.%d.weight", blk, e), Shape: []uint64{8, 16}},
+				{Name: fmt.Sprintf("%s.ffn_up.%d.weight", blk, e), Shape: []uint64{8, 16}},
+				{Name: fmt.Sprintf("%s.ffn_down.%d.weight", blk, e), Shape: []uint64{16, 8}},
+			}...)
+		}
+	}
+
+	return ts
+}
+
+func TestMixtral(t *testing.T) {
+	m := mixtral(t)
+
+	for i, layer := range m.Layers {
+		moe, ok := layer.MLP.(*MoE)
+		if !ok || moe.SparseMoE == nil || moe.Gate == nil || moe.Up == nil || moe.Down == nil || moe.Router == nil {
+			t.Fatalf("layer %d doesn't have a mixture of experts: %+v", i, layer.MLP)
+		}
+	}
+
+	tokens, err := m.Encode("a b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) == 0 || tokens[0] != 1 {
+		t.Fatalf("expected tokens to start with bos, got %v", tokens)
+	}
+
+	cache := m.Config().Cache
+	cache.Init(m.Backend(), ml.DTypeF16, 1, 64, 64)
+	defer cache.Close()
+
+	_, dims := testutil.Forward(t, m, tokens, testutil.Sequence(tokens, int32(len(tokens)-1)))
+	if dims != [2]int{6, 1} {
+		t.Fatalf("unexpected logits shape %v", dims)
+	}
+}
+
+func TestMixtralSeparateExperts(t *testing.T) {
+	p := testutil.Write(t, mixtralKV(), mixtralTensors(false))
+
+	// the model is left to the compatibility engine
+	if _, err := model.NewTextProcessor(p); err == nil {
+		t.Error("expected an error creating a text processor")
+	}
+
+	if _, err := model.New(context.TODO(), p, ml.BackendParams{NumThreads: 1}); err == nil {
+		t.Error("expected an error loading the model")
+	}
+}
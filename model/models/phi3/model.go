@@ -0,0 +1,261 @@
+package phi3
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+
+	"github.com/ollama/ollama/fs"
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+const ropeTypeNeox = 2
+
+type Options struct {
+	hiddenSize, numHeads, numKVHeads int
+	eps, ropeBase, ropeScale         float32
+	ropeDim                          uint32
+	ropeOptions                      ml.RoPEOptions
+	originalContextLength            int
+}
+
+type Model struct {
+	model.Base
+	model.TextProcessor
+
+	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
+	Layers         []Layer       `gguf:"blk"`
+	OutputNorm     *nn.RMSNorm   `gguf:"output_norm"`
+	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+
+	*Options
+}
+
+func New(c fs.Config) (model.Model, error) {
+	vocabulary := model.Vocabulary{
+		Values: c.Strings("tokenizer.ggml.tokens"),
+		Scores: c.Floats("tokenizer.ggml.scores"),
+		Types:  c.Ints("tokenizer.ggml.token_type"),
+		Merges: c.Strings("tokenizer.ggml.merges"),
+		BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+		AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+		EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+		AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+	}
+
+	// Phi-3 uses a SentencePiece tokenizer while Phi-4 uses byte pair encoding
+	var processor model.TextProcessor
+	switch c.String("tokenizer.ggml.model") {
+	case "llama":
+		spm := model.NewSentencePieceModel(&vocabulary)
+		processor = &spm
+	case "gpt2":
+		bpe := model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&vocabulary,
+		)
+		processor = &bpe
+	default:
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	m := Model{
+		TextProcessor: processor,
+		Layers:        make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize: int(c.Uint("embedding_length")),
+			numHeads:   int(c.Uint("attention.head_count")),
+			numKVHeads: int(c.Uint("attention.head_count_kv")),
+			eps:        c.Float("attention.layer_norm_rms_epsilon"),
+			ropeBase:   c.Float("rope.freq_base", 10000),
+			ropeScale:  c.Float("rope.freq_scale", 1),
+			ropeDim:    c.Uint("rope.dimension_count"),
+			ropeOptions: ml.RoPEOptions{
+				AttentionFactor: c.Float("rope.scaling.attn_factor", 1),
+			},
+			originalContextLength: int(c.Uint("rope.scaling.original_context_length")),
+		},
+	}
+
+	m.Cache = &contextCache{Causal: kvcache.NewCausalCache(m.Shift), model: &m}
+
+	return &m, nil
+}
+
+// contextCache records the context length of each sequence, which determines
+// which rotary embedding factors are used
+type contextCache struct {
+	*kvcache.Causal
+	numCtx int
+	model  *Model
+}
+
+func (c *contextCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {
+	c.numCtx = capacity
+	c.Causal.Init(backend, dtype, maxSequences, capacity, maxBatch)
+}
+
+func (c *contextCache) Grow(capacity int) error {
+	if err := c.Causal.Grow(capacity); err != nil {
+		return err
+	}
+
+	// the keys already stored were rotated with the factors for short
+	// contexts, so rotate them back and again with the long factors
+	if original := c.model.originalContextLength; original > 0 && c.numCtx <= original && capacity > original {
+		if err := c.Causal.UpdateKeys(c.model.lengthenKeys); err != nil {
+			return err
+		}
+	}
+
+	c.numCtx = max(c.numCtx, capacity)
+	return nil
+}
+
+// ropeFactors returns the factors of layer for long contexts if the context
+// length is longer than the original context length of the model
+func (m *Model) ropeFactors(layer int) ml.Tensor {
+	sa := m.Layers[layer].SelfAttention
+	if c, ok := m.Cache.(*contextCache); ok && m.originalContextLength > 0 && c.numCtx > m.originalContextLength {
+		return sa.RopeFactorsLong
+	}
+
+	return sa.RopeFactorsShort
+}
+
+type SelfAttention struct {
+	QKV    *nn.Linear `gguf:"attn_qkv"`
+	Output *nn.Linear `gguf:"attn_output"`
+
+	// LongRoPE rescales the frequencies of the rotary embeddings for
+	// contexts longer than the original context length. The backend
+	// repeats the factors for each layer.
+	RopeFactorsLong  ml.Tensor `gguf:"rope_factors_long.weight"`
+	RopeFactorsShort ml.Tensor `gguf:"rope_factors_short.weight"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs, ropeFactors ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	qkv := sa.QKV.Forward(ctx, hiddenState)
+
+	// views of the query, key and value in the fused projection
+	chunk := func(offset, numHeads int) ml.Tensor {
+		return qkv.View(ctx, offset*qkv.Stride(0),
+			headDim, headDim*qkv.Stride(0),
+			numHeads, qkv.Stride(1),
+			batchSize)
+	}
+
+	q := chunk(0, opts.numHeads)
+	q = q.RoPE(ctx, positionIDs, ropeFactors, opts.ropeDim, ropeTypeNeox, opts.ropeBase, opts.ropeScale, opts.ropeOptions)
+
+	k := chunk(opts.hiddenSize, opts.numKVHeads)
+	k = k.RoPE(ctx, positionIDs, ropeFactors, opts.ropeDim, ropeTypeNeox, opts.ropeBase, opts.ropeScale, opts.ropeOptions)
+
+	v := chunk(opts.hiddenSize+opts.numKVHeads*headDim, opts.numKVHeads)
+
+	scaleFactor := 1.0 / math.Sqrt(float64(headDim))
+	kqv := nn.Attention(ctx, q, k, v, scaleFactor, cache)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	return key.RoPE(ctx, shift, m.ropeFactors(layer), m.ropeDim, ropeTypeNeox, m.ropeBase, m.ropeScale, m.ropeOptions), nil
+}
+
+// lengthenKeys changes the rotary embeddings of keys at positions from the
+// factors for short contexts to those for long contexts. A negative scale
+// rotates the keys back.
+func (m *Model) lengthenKeys(ctx ml.Context, layer int, key, positions ml.Tensor) (ml.Tensor, error) {
+	sa := m.Layers[layer].SelfAttention
+
+	inverse := m.ropeOptions
+	inverse.AttentionFactor = 1 / cmp.Or(m.ropeOptions.AttentionFactor, 1)
+	key = key.RoPE(ctx, positions, sa.RopeFactorsShort, m.ropeDim, ropeTypeNeox, m.ropeBase, -m.ropeScale, inverse)
+
+	return key.RoPE(ctx, positions, sa.RopeFactorsLong, m.ropeDim, ropeTypeNeox, m.ropeBase, m.ropeScale, m.ropeOptions), nil
+}
+
+type MLP struct {
+	// the gate and up projections are fused
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.Up.Forward(ctx, hiddenState)
+
+	n := hiddenState.Dim(0) / 2
+	gate := hiddenState.View(ctx, 0, n, hiddenState.Stride(1), hiddenState.Dim(1)).Contiguous(ctx)
+	up := hiddenState.View(ctx, n*hiddenState.Stride(0), n, hiddenState.Stride(1), hiddenState.Dim(1)).Contiguous(ctx)
+
+	return mlp.Down.Forward(ctx, gate.SILU(ctx).Mul(ctx, up))
+}
+
+type Layer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+	MLPNorm       *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP           *MLP
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, ropeFactors, outputs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, ropeFactors, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = l.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	positions, err := ctx.Input().FromIntSlice(batch.Positions, len(batch.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, batch.Inputs)
+
+	for i, layer := range m.Layers {
+		m.Cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(m.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = layer.Forward(ctx, hiddenState, positions, m.ropeFactors(i), lastLayerOutputs, m.Cache, m.Options)
+	}
+
+	hiddenState = m.OutputNorm.Forward(ctx, hiddenState, m.eps)
+	return m.Output.Forward(ctx, hiddenState), nil
+}
+
+func init() {
+	model.Register("phi3", New)
+}
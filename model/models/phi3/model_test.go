@@ -0,0 +1,142 @@
+package phi3
+
+import (
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+// synthetic returns a small Phi-3 model with deterministic weights and
+// LongRoPE factors
+func synthetic(t *testing.T) *Model {
+	t.Helper()
+
+	kv := fsggml.KV{
+		"general.architecture":                      "phi3",
+		"phi3.block_count":                          uint32(1),
+		"phi3.context_length":                       uint32(64),
+		"phi3.embedding_length":                     uint32(8),
+		"phi3.feed_forward_length":                  uint32(16),
+		"phi3.attention.head_count":                 uint32(2),
+		"phi3.attention.head_count_kv":              uint32(1),
+		"phi3.attention.layer_norm_rms_epsilon":     float32(1e-5),
+		"phi3.rope.dimension_count":                 uint32(4),
+		"phi3.rope.freq_base":                       float32(10000),
+		"phi3.rope.scaling.attn_factor":             float32(1.2),
+		"phi3.rope.scaling.original_context_length": uint32(32),
+		"tokenizer.ggml.model":                      "gpt2",
+		"tokenizer.ggml.tokens":                     []string{"a", "b", "c", "d"},
+		"tokenizer.ggml.token_type":                 []int32{1, 1, 1, 1},
+		"tokenizer.ggml.merges":                     []string{},
+	}
+
+	return testutil.Synthetic(t, kv, []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{8, 4}},
+		{Name: "output_norm.weight", Shape: []uint64{8}},
+		{Name: "output.weight", Shape: []uint64{8, 4}},
+		{Name: "rope_factors_long.weight", Shape: []uint64{2}},
+		{Name: "rope_factors_short.weight", Shape: []uint64{2}},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.attn_qkv.weight", Shape: []uint64{8, 16}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{8, 8}},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{8, 32}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, 8}},
+	}).(*Model)
+}
+
+func TestModel(t *testing.T) {
+	cases := []struct {
+		name   string
+		numCtx int
+		long   bool
+	}{
+		{"short", 32, false},
+		{"long", 64, true},
+	}
+
+	logits := make(map[string][]float32)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			m := synthetic(t)
+			if m.ropeOptions.AttentionFactor != 1.2 {
+				t.Errorf("attention factor = %v, want 1.2", m.ropeOptions.AttentionFactor)
+			}
+
+			m.Cache.Init(m.Backend(), ml.DTypeF16, 1, tt.numCtx, tt.numCtx)
+			defer m.Cache.Close()
+
+			sa := m.Layers[0].SelfAttention
+			if sa.RopeFactorsLong == nil || sa.RopeFactorsShort == nil {
+				t.Fatal("expected rope factors")
+			}
+
+			want := sa.RopeFactorsShort
+			if tt.long {
+				want = sa.RopeFactorsLong
+			}
+
+			if got := m.ropeFactors(0); got != want {
+				t.Errorf("rope factors = %v, want %v", got, want)
+			}
+
+			values, dims := testutil.Forward(t, m, []int32{0, 1, 2, 3}, testutil.Sequence([]int32{0, 1, 2, 3}, 0, 3))
+			if dims != [2]int{4, 2} {
+				t.Fatalf("unexpected logits shape %v", dims)
+			}
+
+			logits[tt.name] = values
+		})
+	}
+
+	short, long := logits["short"], logits["long"]
+	if len(short) != 8 || len(long) != 8 {
+		t.FailNow()
+	}
+
+	// the first input only attends to itself, so its rotation doesn't
+	// change its logits
+	if !testutil.Close(short[:4], long[:4], 1e-5) {
+		t.Errorf("logits of the first input differ: short %v, long %v", short[:4], long[:4])
+	}
+
+	if testutil.Close(short[4:], long[4:], 1e-5) {
+		t.Errorf("long rope factors didn't change the logits: %v", long[4:])
+	}
+}
+
+func TestGrow(t *testing.T) {
+	tokens := []int32{0, 1, 2, 3, 1}
+
+	// the whole sequence with a long context
+	m := synthetic(t)
+	m.Cache.Init(m.Backend(), ml.DTypeF32, 1, 64, 64)
+	defer m.Cache.Close()
+
+	want, _ := testutil.Forward(t, m, tokens, testutil.Sequence(tokens, 4))
+
+	// the start of the sequence with a short context, grown past the
+	// original context length for the rest
+	m = synthetic(t)
+	m.Cache.Init(m.Backend(), ml.DTypeF32, 1, 32, 64)
+	defer m.Cache.Close()
+
+	testutil.Forward(t, m, tokens[:4], testutil.Sequence(tokens[:4], 3))
+
+	if err := m.Cache.Grow(64); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.ropeFactors(0); got != m.Layers[0].SelfAttention.RopeFactorsLong {
+		t.Errorf("rope factors = %v, want the long factors", got)
+	}
+
+	batch := input.Batch{Positions: []int32{4}, Sequences: []int{0}, Outputs: []int32{0}}
+	got, _ := testutil.Forward(t, m, tokens[4:], batch)
+	if !testutil.Close(got, want, 1e-6) {
+		t.Errorf("logits after growing = %v, want %v", got, want)
+	}
+}
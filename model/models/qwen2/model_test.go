@@ -0,0 +1,122 @@
+package qwen2
+
+import (
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+// synthetic returns a small Qwen2 model with deterministic weights
+func synthetic(t *testing.T, kv fsggml.KV) *Model {
+	t.Helper()
+
+	return testutil.Synthetic(t, kv, []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{8, 4}},
+		{Name: "output_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{8, 8}},
+		{Name: "blk.0.attn_q.bias", Shape: []uint64{8}},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{8, 4}},
+		{Name: "blk.0.attn_k.bias", Shape: []uint64{4}},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{8, 4}},
+		{Name: "blk.0.attn_v.bias", Shape: []uint64{4}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{8, 8}},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{8}},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{8, 16}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{8, 16}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, 8}},
+	}).(*Model)
+}
+
+func TestModel(t *testing.T) {
+	kv := fsggml.KV{
+		"general.architecture":                   "qwen2",
+		"qwen2.block_count":                      uint32(1),
+		"qwen2.context_length":                   uint32(64),
+		"qwen2.embedding_length":                 uint32(8),
+		"qwen2.feed_forward_length":              uint32(16),
+		"qwen2.attention.head_count":             uint32(2),
+		"qwen2.attention.head_count_kv":          uint32(1),
+		"qwen2.attention.layer_norm_rms_epsilon": float32(1e-6),
+		"qwen2.rope.freq_base":                   float32(1e6),
+		"tokenizer.ggml.model":                   "gpt2",
+		"tokenizer.ggml.tokens":                  []string{"a", "b", "c", "d"},
+		"tokenizer.ggml.token_type":              []int32{1, 1, 1, 1},
+		"tokenizer.ggml.merges":                  []string{},
+	}
+
+	cases := []struct {
+		name    string
+		kv      fsggml.KV
+		scale   float32
+		options ml.RoPEOptions
+	}{
+		{
+			name:  "default",
+			scale: 1,
+		},
+		{
+			name: "yarn",
+			kv: fsggml.KV{
+				"qwen2.rope.scaling.type":                    "yarn",
+				"qwen2.rope.scaling.factor":                  float32(4),
+				"qwen2.rope.scaling.original_context_length": uint32(16),
+			},
+			scale:   0.25,
+			options: ml.RoPEOptions{OriginalContextLength: 16, ExtrapolationFactor: 1},
+		},
+	}
+
+	logits := make(map[string][]float32)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := fsggml.KV{}
+			for k, v := range kv {
+				merged[k] = v
+			}
+			for k, v := range tt.kv {
+				merged[k] = v
+			}
+
+			m := synthetic(t, merged)
+			if m.ropeScale != tt.scale {
+				t.Errorf("rope scale = %v, want %v", m.ropeScale, tt.scale)
+			}
+
+			if m.ropeOptions != tt.options {
+				t.Errorf("rope options = %+v, want %+v", m.ropeOptions, tt.options)
+			}
+
+			if m.ropeDim != 4 {
+				t.Errorf("rope dimensions = %d, want 4", m.ropeDim)
+			}
+
+			m.Cache.Init(m.Backend(), ml.DTypeF16, 1, 64, 64)
+			defer m.Cache.Close()
+
+			values, dims := testutil.Forward(t, m, []int32{0, 1, 2, 3}, testutil.Sequence([]int32{0, 1, 2, 3}, 0, 3))
+			if dims != [2]int{4, 2} {
+				t.Fatalf("unexpected logits shape %v", dims)
+			}
+
+			logits[tt.name] = values
+		})
+	}
+
+	unscaled, yarn := logits["default"], logits["yarn"]
+	if len(unscaled) != 8 || len(yarn) != 8 {
+		t.FailNow()
+	}
+
+	// the first input only attends to itself, so its rotation doesn't
+	// change its logits
+	if !testutil.Close(unscaled[:4], yarn[:4], 1e-5) {
+		t.Errorf("logits of the first input differ: default %v, yarn %v", unscaled[:4], yarn[:4])
+	}
+
+	if testutil.Close(unscaled[4:], yarn[4:], 1e-5) {
+		t.Errorf("yarn didn't change the logits: %v", yarn[4:])
+	}
+}
@@ -1,6 +1,7 @@
 package qwen2vl
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"math"
 
+	"github.com/ollama/ollama/fs"
 	"github.com/ollama/ollama/model/imageproc"
 )
 
@@ -50,25 +52,61 @@ func smartResize(size image.Point, factor, minPixels, maxPixels int) image.Point
 	return image.Point{int(xBar), int(yBar)}
 }
 
-func resizeImage(img image.Image, format string, size image.Point) image.Image {
-	if format == "png" {
-		img = imageproc.Composite(img)
-	}
-
-	return imageproc.Resize(img, size, imageproc.ResizeBilinear)
-}
-
 func Preprocess(imageData io.Reader) ([]float32, map[string]any, error) {
-	img, format, err := image.Decode(imageData)
+	img, _, err := image.Decode(imageData)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	size := smartResize(img.Bounds().Max, DefaultFactor, DefaultMinPixels, DefaultMaxPixels)
-	img = resizeImage(img, format, size)
+	p := ImageProcessor{
+		patchSize:        DefaultFactor / 2,
+		spatialMergeSize: 2,
+		numChannels:      3,
+		minPixels:        DefaultMinPixels,
+		maxPixels:        DefaultMaxPixels,
+	}
 
-	data := imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true)
+	data, size, err := p.ProcessImage(img)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	opts := map[string]any{}
+	opts := map[string]any{"width": size.X, "height": size.Y}
 	return data, opts, nil
 }
+
+type ImageProcessor struct {
+	patchSize        int
+	spatialMergeSize int
+	numChannels      int
+	minPixels        int
+	maxPixels        int
+}
+
+func newImageProcessor(c fs.Config) ImageProcessor {
+	return ImageProcessor{
+		patchSize:        int(c.Uint("vision.patch_size", 14)),
+		spatialMergeSize: int(c.Uint("vision.spatial_merge_size", 2)),
+		numChannels:      int(c.Uint("vision.num_channels", 3)),
+		minPixels:        int(c.Uint("vision.min_pixels", DefaultMinPixels)),
+		maxPixels:        int(c.Uint("vision.max_pixels", DefaultMaxPixels)),
+	}
+}
+
+// ProcessImage resizes an image so that both of its sides are a multiple of
+// the size of the merged patches and normalizes its pixel values. It returns
+// the normalized image data and its size in pixels.
+func (p *ImageProcessor) ProcessImage(img image.Image) ([]float32, image.Point, error) {
+	factor := p.patchSize * p.spatialMergeSize
+
+	size := img.Bounds().Size()
+	if size.X < factor || size.Y < factor {
+		return nil, image.Point{}, fmt.Errorf("image is too small: %dx%d, must be at least %dx%d", size.X, size.Y, factor, factor)
+	} else if max(size.X, size.Y)/min(size.X, size.Y) > 200 {
+		return nil, image.Point{}, errors.New("aspect ratio must be less than 200:1")
+	}
+
+	size = smartResize(size, factor, p.minPixels, p.maxPixels)
+	img = imageproc.Resize(imageproc.Composite(img), size, imageproc.ResizeBilinear)
+	return imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true), size, nil
+}
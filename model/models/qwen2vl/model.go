@@ -0,0 +1,240 @@
+package qwen2vl
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/fs"
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Model struct {
+	model.Base
+	*TextModel
+	*VisionModel `gguf:"v,vision"`
+	*PatchMerger `gguf:"mm"`
+
+	ImageProcessor
+
+	visionStartToken int32
+	visionEndToken   int32
+	imageToken       int32
+}
+
+// Implement MultimodalProcessor interface
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+// Implement TextProcessor interface
+var _ model.TextProcessor = (*Model)(nil)
+
+func New(c fs.Config) (model.Model, error) {
+	textModel, err := NewTextModel(c)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Model{
+		TextModel:        textModel,
+		VisionModel:      newVisionModel(c),
+		ImageProcessor:   newImageProcessor(c),
+		visionStartToken: int32(c.Uint("vision_start_token_id", 151652)),
+		visionEndToken:   int32(c.Uint("vision_end_token_id", 151653)),
+		imageToken:       int32(c.Uint("image_token_id", 151655)),
+	}
+
+	m.Cache = &positionCache{
+		Causal: kvcache.NewCausalCache(m.TextModel.Shift),
+		images: make(map[int][]imagePosition),
+	}
+
+	return m, nil
+}
+
+// positionCache records where the images of each sequence are stored. Text
+// after an image continues from the image's position plus the larger of its
+// rows and columns rather than its number of patches, so the rotary
+// positions of later inputs are offset from their positions in the cache.
+type positionCache struct {
+	*kvcache.Causal
+	images map[int][]imagePosition
+}
+
+// imagePosition is an image stored at cache positions [start, end), after
+// which the rotary positions are offset by offset
+type imagePosition struct {
+	start, end int32
+	offset     int32
+}
+
+// offset returns the difference between the rotary position and the cache
+// position of the input at pos in seq
+func (c *positionCache) offset(seq int, pos int32) int32 {
+	var offset int32
+	for _, img := range c.images[seq] {
+		if pos < img.end {
+			break
+		}
+		offset = img.offset
+	}
+
+	return offset
+}
+
+func (c *positionCache) add(seq int, start int32, grid image.Point) {
+	images := slices.DeleteFunc(c.images[seq], func(img imagePosition) bool { return img.start >= start })
+	c.images[seq] = append(images, imagePosition{
+		start:  start,
+		end:    start + int32(grid.X*grid.Y),
+		offset: c.offset(seq, start) + int32(max(grid.X, grid.Y)-grid.X*grid.Y),
+	})
+}
+
+func (c *positionCache) CopyPrefix(srcSeq, dstSeq int, len int32) {
+	c.Causal.CopyPrefix(srcSeq, dstSeq, len)
+
+	var images []imagePosition
+	for _, img := range c.images[srcSeq] {
+		if img.start < len {
+			images = append(images, img)
+		}
+	}
+	c.images[dstSeq] = images
+}
+
+func (c *positionCache) Remove(seq int, beginIndex, endIndex int32) error {
+	if err := c.Causal.Remove(seq, beginIndex, endIndex); err != nil {
+		return err
+	}
+
+	if endIndex == math.MaxInt32 {
+		c.images[seq] = slices.DeleteFunc(c.images[seq], func(img imagePosition) bool { return img.start >= beginIndex })
+		return nil
+	}
+
+	// the inputs after a removed image keep its offset as their keys were
+	// shifted by the number of inputs removed
+	for i, img := range c.images[seq] {
+		if img.start >= endIndex {
+			c.images[seq][i].start += beginIndex - endIndex
+			c.images[seq][i].end += beginIndex - endIndex
+		} else if img.start >= beginIndex {
+			c.images[seq][i].start, c.images[seq][i].end = beginIndex, beginIndex
+		}
+	}
+
+	return nil
+}
+
+// positions returns the temporal, height and width positions of each input
+// in batch. Text is at the same position in each, while the patches of an
+// image start at the position of the image and advance along its rows and
+// columns.
+func (c *positionCache) positions(batch input.Batch) []int32 {
+	for _, mi := range batch.Multimodal {
+		c.add(batch.Sequences[mi.Index], batch.Positions[mi.Index], mi.Multimodal.(*imageFeatures).grid)
+	}
+
+	n := len(batch.Positions)
+
+	s := make([]int32, 4*n)
+	for i, p := range batch.Positions {
+		p += c.offset(batch.Sequences[i], p)
+		s[i], s[n+i], s[2*n+i] = p, p, p
+	}
+
+	for _, mi := range batch.Multimodal {
+		f := mi.Multimodal.(*imageFeatures)
+		start := s[mi.Index]
+		for i := range f.grid.X * f.grid.Y {
+			s[mi.Index+i] = start
+			s[n+mi.Index+i] = start + int32(i/f.grid.X)
+			s[2*n+mi.Index+i] = start + int32(i%f.grid.X)
+		}
+	}
+
+	return s
+}
+
+// imageFeatures are the embeddings of an image's merged patches, which form
+// a grid of grid.X columns and grid.Y rows
+type imageFeatures struct {
+	tensor ml.Tensor
+	grid   image.Point
+}
+
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.VisionModel.Layers) == 0 {
+		return nil, model.ErrNoVisionModel
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(multimodalData))
+	if err != nil {
+		return nil, err
+	}
+
+	f32s, size, err := m.ImageProcessor.ProcessImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	pixelValues, err := ctx.Input().FromFloatSlice(f32s, size.X, size.Y, m.ImageProcessor.numChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	factor := m.ImageProcessor.patchSize * m.ImageProcessor.spatialMergeSize
+	return &imageFeatures{
+		tensor: m.VisionModel.Forward(ctx, pixelValues, m.PatchMerger, size),
+		grid:   image.Point{size.X / factor, size.Y / factor},
+	}, nil
+}
+
+// PostTokenize arranges Qwen2-VL's inputs for the forward pass. Each image
+// is replaced by a placeholder for each of its merged patches, surrounded by
+// the vision start and end tokens:
+// <|vision_start|><|image_pad|>...<|image_pad|><|vision_end|>
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+		} else {
+			features := inp.Multimodal.(*imageFeatures)
+			numPatches := features.tensor.Dim(1)
+
+			result = append(result,
+				input.Input{Token: m.visionStartToken},
+				// image data is on the first placeholder
+				input.Input{Token: m.imageToken, Multimodal: features, MultimodalHash: inp.MultimodalHash, SameBatch: numPatches},
+			)
+			result = append(result, slices.Repeat([]input.Input{{Token: m.imageToken}}, numPatches-1)...)
+			result = append(result, input.Input{Token: m.visionEndToken})
+		}
+	}
+
+	return result, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	s := m.Cache.(*positionCache).positions(batch)
+	positionIDs, err := ctx.Input().FromIntSlice(s, len(s))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, batch.Inputs, positionIDs, outputs, batch, m.Cache), nil
+}
+
+func init() {
+	model.Register("qwen2vl", New)
+}
@@ -0,0 +1,359 @@
+package qwen2vl
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+func TestPositions(t *testing.T) {
+	m := synthetic(t, nil)
+
+	newCache := func(t *testing.T) *positionCache {
+		c := m.Config().Cache.(*positionCache)
+		c.Init(m.Backend(), ml.DTypeF16, 2, 32, 32)
+		t.Cleanup(c.Close)
+		clear(c.images)
+		return c
+	}
+
+	// text, the vision start token, an image of 3x2 merged patches, the
+	// vision end token and text
+	batch := input.Batch{
+		Positions: []int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		Sequences: make([]int, 10),
+		Multimodal: []input.MultimodalIndex{
+			{Index: 2, Multimodal: &imageFeatures{grid: image.Point{3, 2}}},
+		},
+	}
+
+	// position ids of the sequence from get_rope_index in transformers
+	want := []int32{
+		// temporal
+		0, 1, 2, 2, 2, 2, 2, 2, 5, 6,
+		// height
+		0, 1, 2, 2, 2, 3, 3, 3, 5, 6,
+		// width
+		0, 1, 2, 3, 4, 2, 3, 4, 5, 6,
+		// unused
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	text := func(seq int, positions ...int32) input.Batch {
+		return input.Batch{Positions: positions, Sequences: slices.Repeat([]int{seq}, len(positions))}
+	}
+
+	cases := []struct {
+		name  string
+		setup func(*positionCache)
+		batch input.Batch
+		want  []int32
+	}{
+		{
+			name:  "next batch",
+			batch: text(0, 10, 11),
+			want:  []int32{7, 8, 7, 8, 7, 8, 0, 0},
+		},
+		{
+			name:  "copied prefix",
+			setup: func(c *positionCache) { c.CopyPrefix(0, 1, 10) },
+			batch: text(1, 10),
+			want:  []int32{7, 7, 7, 0},
+		},
+		{
+			name:  "other sequence",
+			batch: text(1, 10),
+			want:  []int32{10, 10, 10, 0},
+		},
+		{
+			name: "image removed",
+			setup: func(c *positionCache) {
+				if err := c.Remove(0, 2, math.MaxInt32); err != nil {
+					t.Fatal(err)
+				}
+			},
+			batch: text(0, 2),
+			want:  []int32{2, 2, 2, 0},
+		},
+		{
+			name: "shifted",
+			setup: func(c *positionCache) {
+				if err := c.Remove(0, 0, 2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			batch: text(0, 8),
+			want:  []int32{5, 5, 5, 0},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache(t)
+			if diff := cmp.Diff(want, c.positions(batch)); diff != "" {
+				t.Errorf("positions mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+
+			if diff := cmp.Diff(tt.want, c.positions(tt.batch)); diff != "" {
+				t.Errorf("positions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// synthetic returns a small Qwen2-VL model with deterministic weights and
+// the metadata in kv in addition to its own
+func synthetic(t *testing.T, kv fsggml.KV) model.Model {
+	t.Helper()
+
+	const (
+		vocabSize    = 4
+		textHidden   = 8
+		visionHidden = 8
+		patchSize    = 2
+		mergeSize    = 2
+	)
+
+	config := fsggml.KV{
+		"general.architecture":                        "qwen2vl",
+		"qwen2vl.block_count":                         uint32(1),
+		"qwen2vl.context_length":                      uint32(64),
+		"qwen2vl.embedding_length":                    uint32(textHidden),
+		"qwen2vl.feed_forward_length":                 uint32(16),
+		"qwen2vl.attention.head_count":                uint32(2),
+		"qwen2vl.attention.head_count_kv":             uint32(1),
+		"qwen2vl.attention.layer_norm_rms_epsilon":    float32(1e-6),
+		"qwen2vl.rope.dimension_count":                uint32(4),
+		"qwen2vl.rope.freq_base":                      float32(1e6),
+		"qwen2vl.rope.dimension_sections":             []int32{1, 1, 0, 0},
+		"qwen2vl.vision.block_count":                  uint32(1),
+		"qwen2vl.vision.embedding_length":             uint32(visionHidden),
+		"qwen2vl.vision.feed_forward_length":          uint32(32),
+		"qwen2vl.vision.attention.head_count":         uint32(2),
+		"qwen2vl.vision.attention.layer_norm_epsilon": float32(1e-6),
+		"qwen2vl.vision.num_channels":                 uint32(3),
+		"qwen2vl.vision.patch_size":                   uint32(patchSize),
+		"qwen2vl.vision.spatial_merge_size":           uint32(mergeSize),
+		"qwen2vl.vision.temporal_patch_size":          uint32(2),
+		"qwen2vl.vision.min_pixels":                   uint32(16 * 16),
+		"qwen2vl.vision.max_pixels":                   uint32(16 * 16),
+		"qwen2vl.vision_start_token_id":               uint32(1),
+		"qwen2vl.vision_end_token_id":                 uint32(2),
+		"qwen2vl.image_token_id":                      uint32(3),
+		"tokenizer.ggml.model":                        "gpt2",
+		"tokenizer.ggml.tokens":                       []string{"a", "<|vision_start|>", "<|vision_end|>", "<|image_pad|>"},
+		"tokenizer.ggml.token_type":                   []int32{1, 3, 3, 3},
+		"tokenizer.ggml.merges":                       []string{},
+	}
+	maps.Copy(config, kv)
+
+	return testutil.Synthetic(t, config, []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{textHidden, vocabSize}},
+		{Name: "output_norm.weight", Shape: []uint64{textHidden}},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{textHidden}},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{textHidden, textHidden}},
+		{Name: "blk.0.attn_q.bias", Shape: []uint64{textHidden}},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{textHidden, 4}},
+		{Name: "blk.0.attn_k.bias", Shape: []uint64{4}},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{textHidden, 4}},
+		{Name: "blk.0.attn_v.bias", Shape: []uint64{4}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{textHidden, textHidden}},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{textHidden}},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{textHidden, 16}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{textHidden, 16}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, textHidden}},
+		{Name: "v.patch_embd_0.weight", Shape: []uint64{patchSize, patchSize, 3, visionHidden}},
+		{Name: "v.patch_embd_1.weight", Shape: []uint64{patchSize, patchSize, 3, visionHidden}},
+		{Name: "v.blk.0.ln1.weight", Shape: []uint64{visionHidden}},
+		{Name: "v.blk.0.ln1.bias", Shape: []uint64{visionHidden}},
+		{Name: "v.blk.0.attn_qkv.weight", Shape: []uint64{visionHidden, 3 * visionHidden}},
+		{Name: "v.blk.0.attn_qkv.bias", Shape: []uint64{3 * visionHidden}},
+		{Name: "v.blk.0.attn_out.weight", Shape: []uint64{visionHidden, visionHidden}},
+		{Name: "v.blk.0.attn_out.bias", Shape: []uint64{visionHidden}},
+		{Name: "v.blk.0.ln2.weight", Shape: []uint64{visionHidden}},
+		{Name: "v.blk.0.ln2.bias", Shape: []uint64{visionHidden}},
+		{Name: "v.blk.0.ffn_up.weight", Shape: []uint64{visionHidden, 32}},
+		{Name: "v.blk.0.ffn_up.bias", Shape: []uint64{32}},
+		{Name: "v.blk.0.ffn_down.weight", Shape: []uint64{32, visionHidden}},
+		{Name: "v.blk.0.ffn_down.bias", Shape: []uint64{visionHidden}},
+		{Name: "mm.norm.weight", Shape: []uint64{visionHidden}},
+		{Name: "mm.norm.bias", Shape: []uint64{visionHidden}},
+		{Name: "mm.0.weight", Shape: []uint64{4 * visionHidden, 4 * visionHidden}},
+		{Name: "mm.0.bias", Shape: []uint64{4 * visionHidden}},
+		{Name: "mm.2.weight", Shape: []uint64{4 * visionHidden, textHidden}},
+		{Name: "mm.2.bias", Shape: []uint64{textHidden}},
+	})
+}
+
+func TestModel(t *testing.T) {
+	m := synthetic(t, nil)
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 12))
+	for y := range 12 {
+		for x := range 20 {
+			img.Set(x, y, color.RGBA{uint8(x * 12), uint8(y * 20), 128, 255})
+		}
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	encoded, err := m.(model.MultimodalProcessor).EncodeMultimodal(ctx, b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := encoded.(*imageFeatures)
+	if features.grid.X*features.grid.Y != features.tensor.Dim(1) {
+		t.Fatalf("grid %v doesn't match %d patches", features.grid, features.tensor.Dim(1))
+	}
+
+	if features.tensor.Dim(0) != 8 {
+		t.Fatalf("expected embeddings of 8, got %d", features.tensor.Dim(0))
+	}
+
+	inputs, err := m.(model.MultimodalProcessor).PostTokenize([]input.Input{
+		{Token: 0},
+		{Multimodal: features, MultimodalHash: 1},
+		{Token: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numPatches := features.tensor.Dim(1)
+	if len(inputs) != numPatches+4 {
+		t.Fatalf("expected %d inputs, got %d", numPatches+4, len(inputs))
+	}
+
+	tokens := make([]int32, len(inputs))
+	for i, inp := range inputs {
+		tokens[i] = inp.Token
+	}
+
+	want := slices.Concat([]int32{0, 1}, slices.Repeat([]int32{3}, numPatches), []int32{2, 0})
+	if diff := cmp.Diff(want, tokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+
+	if inputs[2].Multimodal == nil || inputs[2].SameBatch != numPatches {
+		t.Errorf("image isn't on the first placeholder: %+v", inputs[2])
+	}
+
+	cache := m.Config().Cache
+	cache.Init(m.Backend(), ml.DTypeF16, 1, 64, 64)
+	defer cache.Close()
+
+	batch := input.Batch{
+		Positions:  make([]int32, len(inputs)),
+		Sequences:  make([]int, len(inputs)),
+		Multimodal: []input.MultimodalIndex{{Index: 2, Multimodal: features}},
+		Outputs:    []int32{int32(len(inputs) - 1)},
+	}
+	for i := range batch.Positions {
+		batch.Positions[i] = int32(i)
+	}
+
+	logits, err := model.Forward(ctx, m, tokens, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logits.Dim(0) != 4 || logits.Dim(1) != 1 {
+		t.Fatalf("unexpected logits shape %v", logits.Shape())
+	}
+
+	testutil.Finite(t, logits.Floats())
+	testutil.Finite(t, features.tensor.Floats())
+}
+
+func TestWindowAttention(t *testing.T) {
+	// two images which only differ in their right halves
+	encode := func(t *testing.T, m model.Model, right uint8) [][]float32 {
+		t.Helper()
+
+		img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		for y := range 16 {
+			for x := range 16 {
+				c := color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255}
+				if x >= 8 {
+					c.B = right
+				}
+				img.Set(x, y, c)
+			}
+		}
+
+		var b bytes.Buffer
+		if err := png.Encode(&b, img); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := m.Backend().NewContext()
+		defer ctx.Close()
+
+		encoded, err := m.(model.MultimodalProcessor).EncodeMultimodal(ctx, b.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		features := encoded.(*imageFeatures)
+		if features.grid != (image.Point{4, 4}) {
+			t.Fatalf("unexpected grid %v", features.grid)
+		}
+
+		ctx.Forward(features.tensor).Compute(features.tensor)
+		values := features.tensor.Floats()
+		testutil.Finite(t, values)
+
+		// the merged patches of the left half of each row
+		dim := features.tensor.Dim(0)
+		var left [][]float32
+		for y := range 4 {
+			for x := range 2 {
+				i := (y*4 + x) * dim
+				left = append(left, values[i:i+dim])
+			}
+		}
+		return left
+	}
+
+	t.Run("windows", func(t *testing.T) {
+		// windows of 4x4 patches, or 2x2 merged patches
+		m := synthetic(t, fsggml.KV{"qwen2vl.vision.window_size": uint32(8)})
+		if diff := cmp.Diff(encode(t, m, 0), encode(t, m, 255)); diff != "" {
+			t.Errorf("left half depends on the right half (-a +b):\n%s", diff)
+		}
+	})
+
+	t.Run("full attention", func(t *testing.T) {
+		m := synthetic(t, fsggml.KV{
+			"qwen2vl.vision.window_size":           uint32(8),
+			"qwen2vl.vision.fullatt_block_indexes": []int32{0},
+		})
+		if cmp.Equal(encode(t, m, 0), encode(t, m, 255)) {
+			t.Error("expected the left half to depend on the right half")
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package qwen2vl
+
+import (
+	"image"
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/fs"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+const ropeTypeVision = 24
+
+type VisionSelfAttention struct {
+	QKV    *nn.Linear `gguf:"attn_qkv"`
+	Output *nn.Linear `gguf:"attn_out"`
+}
+
+func (sa *VisionSelfAttention) Forward(ctx ml.Context, hiddenStates, positionIDs, mask ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	numPatches := hiddenStates.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	qkv := sa.QKV.Forward(ctx, hiddenStates)
+
+	// views of the query, key and value in the fused projection
+	chunk := func(i int) ml.Tensor {
+		return qkv.View(ctx, i*opts.hiddenSize*qkv.Stride(0),
+			headDim, headDim*qkv.Stride(0),
+			opts.numHeads, qkv.Stride(1),
+			numPatches)
+	}
+
+	// half of each head is rotated by the row of the patch and half by
+	// its column
+	sections := [4]int{headDim / 4, headDim / 4, headDim / 4, headDim / 4}
+
+	query := chunk(0).RoPEMulti(ctx, positionIDs, nil, uint32(headDim/2), sections, ropeTypeVision, opts.ropeBase, 1)
+	key := chunk(1).RoPEMulti(ctx, positionIDs, nil, uint32(headDim/2), sections, ropeTypeVision, opts.ropeBase, 1)
+	value := chunk(2)
+
+	query = query.Permute(ctx, 0, 2, 1, 3)
+	key = key.Permute(ctx, 0, 2, 1, 3)
+	value = value.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	scores := key.MulmatFullPrec(ctx, query)
+	scores = scores.Scale(ctx, 1./math.Sqrt(float64(headDim)))
+	if mask != nil {
+		scores = scores.Add(ctx, mask)
+	}
+	scores = scores.Softmax(ctx)
+
+	attention := value.Mulmat(ctx, scores)
+	attention = attention.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	attention = attention.Reshape(ctx, opts.hiddenSize, numPatches)
+	return sa.Output.Forward(ctx, attention)
+}
+
+type VisionMLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *VisionMLP) Forward(ctx ml.Context, hiddenStates ml.Tensor) ml.Tensor {
+	hiddenStates = mlp.Up.Forward(ctx, hiddenStates)
+
+	// quick gelu: x * sigmoid(1.702 * x)
+	hiddenStates = hiddenStates.Mul(ctx, hiddenStates.Scale(ctx, 1.702).Sigmoid(ctx))
+	return mlp.Down.Forward(ctx, hiddenStates)
+}
+
+type VisionEncoderLayer struct {
+	Norm1         *nn.LayerNorm `gguf:"ln1"`
+	SelfAttention *VisionSelfAttention
+	Norm2         *nn.LayerNorm `gguf:"ln2"`
+	MLP           *VisionMLP
+}
+
+func (e *VisionEncoderLayer) Forward(ctx ml.Context, hiddenStates, positionIDs, mask ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	residual := hiddenStates
+	hiddenStates = e.Norm1.Forward(ctx, hiddenStates, opts.eps)
+	hiddenStates = e.SelfAttention.Forward(ctx, hiddenStates, positionIDs, mask, opts)
+	hiddenStates = hiddenStates.Add(ctx, residual)
+
+	residual = hiddenStates
+	hiddenStates = e.Norm2.Forward(ctx, hiddenStates, opts.eps)
+	hiddenStates = e.MLP.Forward(ctx, hiddenStates)
+	return hiddenStates.Add(ctx, residual)
+}
+
+type VisionModelOptions struct {
+	hiddenSize       int
+	numHeads         int
+	patchSize        int
+	spatialMergeSize int
+	eps              float32
+	ropeBase         float32
+
+	// windowSize is the size in patches of the windows patches attend
+	// within, except in fullAttentionLayers. If zero, patches attend to
+	// the whole image in every layer
+	windowSize          int
+	fullAttentionLayers []int32
+}
+
+// PatchMerger merges each window of spatialMergeSize x spatialMergeSize
+// patches and projects them into the text model's embedding space
+type PatchMerger struct {
+	Norm *nn.LayerNorm `gguf:"norm"`
+	MLP0 *nn.Linear    `gguf:"0"`
+	MLP2 *nn.Linear    `gguf:"2"`
+}
+
+func (pm *PatchMerger) Forward(ctx ml.Context, hiddenStates ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	windowSize := opts.spatialMergeSize * opts.spatialMergeSize
+
+	hiddenStates = pm.Norm.Forward(ctx, hiddenStates, opts.eps)
+	hiddenStates = hiddenStates.Reshape(ctx, opts.hiddenSize*windowSize, hiddenStates.Dim(1)/windowSize)
+	hiddenStates = pm.MLP0.Forward(ctx, hiddenStates).GELU(ctx)
+	return pm.MLP2.Forward(ctx, hiddenStates)
+}
+
+type VisionModel struct {
+	// the patch embedding is a 3D convolution over pairs of frames which
+	// is split into a 2D convolution for each frame
+	PatchEmbedding0 *nn.Conv2D           `gguf:"patch_embd_0"`
+	PatchEmbedding1 *nn.Conv2D           `gguf:"patch_embd_1"`
+	Layers          []VisionEncoderLayer `gguf:"blk"`
+
+	*VisionModelOptions
+}
+
+// Forward encodes an image of size pixels and returns its merged patches in
+// row major order
+func (m *VisionModel) Forward(ctx ml.Context, pixelValues ml.Tensor, merger *PatchMerger, size image.Point) ml.Tensor {
+	patchesX := size.X / m.patchSize
+	patchesY := size.Y / m.patchSize
+	numPatches := patchesX * patchesY
+	mergeSize := m.spatialMergeSize
+
+	// a still image is a pair of identical frames
+	hiddenStates := m.PatchEmbedding0.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1)
+	hiddenStates = hiddenStates.Add(ctx, m.PatchEmbedding1.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1))
+
+	// reorder the patches so that each window of patches to be merged is
+	// contiguous: [patchesX, patchesY, hiddenSize] -> [hiddenSize, mergeSize, mergeSize, patchesX/mergeSize, patchesY/mergeSize]
+	hiddenStates = hiddenStates.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+	hiddenStates = hiddenStates.Reshape(ctx, m.hiddenSize*mergeSize, patchesX/mergeSize, mergeSize, patchesY/mergeSize)
+	hiddenStates = hiddenStates.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	hiddenStates = hiddenStates.Reshape(ctx, m.hiddenSize, numPatches)
+
+	// the row and column of each patch, in the same order
+	positions := make([]int32, 4*numPatches)
+	var i int
+	for y := 0; y < patchesY; y += mergeSize {
+		for x := 0; x < patchesX; x += mergeSize {
+			for dy := range mergeSize {
+				for dx := range mergeSize {
+					positions[i] = int32(y + dy)
+					positions[numPatches+i] = int32(x + dx)
+					positions[2*numPatches+i] = int32(y + dy)
+					positions[3*numPatches+i] = int32(x + dx)
+					i++
+				}
+			}
+		}
+	}
+
+	positionIDs, err := ctx.Input().FromIntSlice(positions, len(positions))
+	if err != nil {
+		panic(err)
+	}
+
+	var windowMask ml.Tensor
+	if m.windowSize > 0 {
+		windowMask = m.windowMask(ctx, positions)
+	}
+
+	for i, layer := range m.Layers {
+		mask := windowMask
+		if slices.Contains(m.fullAttentionLayers, int32(i)) {
+			mask = nil
+		}
+
+		hiddenStates = layer.Forward(ctx, hiddenStates, positionIDs, mask, m.VisionModelOptions)
+	}
+
+	return merger.Forward(ctx, hiddenStates, m.VisionModelOptions)
+}
+
+// windowMask returns a mask over patches with the rows and columns in
+// positions which only lets patches attend to patches in the same window
+func (m *VisionModel) windowMask(ctx ml.Context, positions []int32) ml.Tensor {
+	numPatches := len(positions) / 4
+	rows, cols := positions[:numPatches], positions[numPatches:2*numPatches]
+	windowSize := int32(m.windowSize)
+
+	mask := make([]float32, numPatches*numPatches)
+	for q := range numPatches {
+		for k := range numPatches {
+			if rows[q]/windowSize != rows[k]/windowSize || cols[q]/windowSize != cols[k]/windowSize {
+				mask[q*numPatches+k] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	t, err := ctx.Input().FromFloatSlice(mask, numPatches, numPatches)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func newVisionModel(c fs.Config) *VisionModel {
+	patchSize := int(c.Uint("vision.patch_size", 14))
+	return &VisionModel{
+		Layers: make([]VisionEncoderLayer, c.Uint("vision.block_count", 32)),
+		VisionModelOptions: &VisionModelOptions{
+			hiddenSize:          int(c.Uint("vision.embedding_length", 1280)),
+			numHeads:            int(c.Uint("vision.attention.head_count", 16)),
+			patchSize:           patchSize,
+			spatialMergeSize:    int(c.Uint("vision.spatial_merge_size", 2)),
+			eps:                 c.Float("vision.attention.layer_norm_epsilon", 1e-6),
+			ropeBase:            c.Float("vision.rope.freq_base", 10000),
+			windowSize:          int(c.Uint("vision.window_size")) / patchSize,
+			fullAttentionLayers: c.Ints("vision.fullatt_block_indexes"),
+		},
+	}
+}
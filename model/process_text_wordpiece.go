@@ -0,0 +1,190 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// wordPieceMaxRunes is the length of the longest word that is split into
+// pieces. Longer words are unknown.
+const wordPieceMaxRunes = 100
+
+// WordPiece is the tokenizer of BERT models. Words are split greedily into
+// the longest pieces in the vocabulary. Like SentencePiece, pieces that start
+// a word are prefixed with a phantom space.
+type WordPiece struct {
+	vocab *Vocabulary
+	unk   int32
+}
+
+var _ TextProcessor = (*WordPiece)(nil)
+
+func NewWordPiece(vocab *Vocabulary) WordPiece {
+	unk := vocab.Encode("[UNK]")
+	for i, t := range vocab.Types {
+		if t == TOKEN_TYPE_UNKNOWN {
+			unk = int32(i)
+			break
+		}
+	}
+
+	return WordPiece{
+		vocab: vocab,
+		unk:   unk,
+	}
+}
+
+func (wpm WordPiece) Vocabulary() *Vocabulary {
+	return wpm.vocab
+}
+
+func (wpm WordPiece) Is(id int32, special Special) bool {
+	return wpm.vocab.Is(id, special)
+}
+
+// words normalizes s and splits it into words on whitespace and punctuation.
+// Text is lowercased and accents are removed, as in uncased BERT models.
+func (wpm WordPiece) words(s string) []string {
+	var words []string
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			words = append(words, sb.String())
+			sb.Reset()
+		}
+	}
+
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case r == 0 || r == unicode.ReplacementChar:
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.Is(unicode.Mn, r), unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+		case isWordPiecePunct(r), unicode.Is(unicode.Han, r):
+			// punctuation and CJK characters are words of their own
+			flush()
+			words = append(words, string(r))
+		default:
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+
+	flush()
+	return words
+}
+
+func isWordPiecePunct(r rune) bool {
+	// all non-alphanumeric ASCII characters are punctuation, such as $ and ^
+	if r >= 33 && r <= 47 || r >= 58 && r <= 64 || r >= 91 && r <= 96 || r >= 123 && r <= 126 {
+		return true
+	}
+
+	return unicode.IsPunct(r)
+}
+
+// pieces returns the longest pieces of word that are in the vocabulary, from
+// left to right, or the unknown token if it can't be split
+func (wpm WordPiece) pieces(word string) []int32 {
+	runes := []rune(word)
+	if len(runes) > wordPieceMaxRunes {
+		return []int32{wpm.unk}
+	}
+
+	var ids []int32
+	for start := 0; start < len(runes); {
+		id := int32(-1)
+		end := len(runes)
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start == 0 {
+				piece = spmWhitespaceSep + piece
+			}
+
+			if id = wpm.vocab.Encode(piece); id >= 0 {
+				break
+			}
+		}
+
+		if id < 0 {
+			return []int32{wpm.unk}
+		}
+
+		ids = append(ids, id)
+		start = end
+	}
+
+	return ids
+}
+
+func (wpm WordPiece) Encode(s string, addSpecial bool) ([]int32, error) {
+	fragments := []fragment{{value: s}}
+	for _, special := range wpm.vocab.SpecialVocabulary() {
+		id := wpm.vocab.Encode(special)
+		for i := 0; i < len(fragments); i++ {
+			frag := fragments[i]
+			if len(frag.ids) > 0 {
+				continue
+			}
+
+			var middle []fragment
+			switch i := strings.Index(frag.value, special); {
+			case i < 0:
+				middle = append(middle, frag)
+			case i > 0:
+				middle = append(middle, fragment{value: frag.value[:i]})
+				fallthrough
+			default:
+				middle = append(middle, fragment{value: special, ids: []int32{id}})
+				if rest := frag.value[i+len(special):]; rest != "" {
+					middle = append(middle, fragment{value: rest})
+				}
+			}
+
+			fragments = append(fragments[:i], append(middle, fragments[i+1:]...)...)
+		}
+	}
+
+	var ids []int32
+	for _, frag := range fragments {
+		if len(frag.ids) > 0 {
+			ids = append(ids, frag.ids...)
+			continue
+		}
+
+		for _, word := range wpm.words(frag.value) {
+			ids = append(ids, wpm.pieces(word)...)
+		}
+	}
+
+	if addSpecial {
+		if wpm.vocab.AddBOS {
+			ids = append([]int32{wpm.vocab.BOS}, ids...)
+		}
+
+		if wpm.vocab.AddEOS {
+			ids = append(ids, wpm.vocab.EOS)
+		}
+	}
+
+	return ids, nil
+}
+
+func (wpm WordPiece) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		piece := wpm.vocab.Decode(id)
+		word, ok := strings.CutPrefix(piece, spmWhitespaceSep)
+		// special tokens are words too
+		if ok || wpm.vocab.Types[id] == TOKEN_TYPE_CONTROL {
+			if sb.Len() > 0 {
+				sb.WriteString(" ")
+			}
+		}
+
+		sb.WriteString(word)
+	}
+
+	return sb.String(), nil
+}
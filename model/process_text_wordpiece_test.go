@@ -0,0 +1,153 @@
+package model
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadWordPieceVocab loads a BERT vocabulary, converting its tokens to phantom
+// space pieces as the converter does
+func loadWordPieceVocab(t *testing.T) WordPiece {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", "bert", "vocab.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	v := Vocabulary{AddBOS: true, AddEOS: true}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		token := scanner.Text()
+		switch {
+		case strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]"):
+			v.Types = append(v.Types, TOKEN_TYPE_CONTROL)
+		case strings.HasPrefix(token, "##"):
+			token = token[2:]
+			v.Types = append(v.Types, TOKEN_TYPE_NORMAL)
+		default:
+			token = spmWhitespaceSep + token
+			v.Types = append(v.Types, TOKEN_TYPE_NORMAL)
+		}
+
+		v.Values = append(v.Values, token)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	v.BOS = v.Encode("[CLS]")
+	v.EOS = v.Encode("[SEP]")
+	return NewWordPiece(&v)
+}
+
+func TestWordPiece(t *testing.T) {
+	tokenizer := loadWordPieceVocab(t)
+
+	cases := []struct {
+		in     string
+		want   []string
+		decode string
+	}{
+		{
+			in:     "Hello, World!",
+			want:   []string{"[CLS]", "hello", ",", "world", "!", "[SEP]"},
+			decode: "[CLS] hello , world ! [SEP]",
+		},
+		{
+			in:     "The quick brown fox jumped over the lazy dogs.",
+			want:   []string{"[CLS]", "the", "quick", "brown", "fox", "jump", "##ed", "over", "the", "lazy", "dog", "##s", ".", "[SEP]"},
+			decode: "[CLS] the quick brown fox jumped over the lazy dogs . [SEP]",
+		},
+		{
+			in:     "unaffable",
+			want:   []string{"[CLS]", "un", "##aff", "##able", "[SEP]"},
+			decode: "[CLS] unaffable [SEP]",
+		},
+		{
+			// accents are removed
+			in:     "Naïve café",
+			want:   []string{"[CLS]", "naive", "cafe", "[SEP]"},
+			decode: "[CLS] naive cafe [SEP]",
+		},
+		{
+			// words that can't be split into pieces are unknown
+			in:     "a xyz jumps",
+			want:   []string{"[CLS]", "a", "[UNK]", "jump", "##s", "[SEP]"},
+			decode: "[CLS] a [UNK] jumps [SEP]",
+		},
+		{
+			in:     "中国",
+			want:   []string{"[CLS]", "中", "国", "[SEP]"},
+			decode: "[CLS] 中 国 [SEP]",
+		},
+		{
+			// the longest piece is preferred
+			in:     "abab\t\n",
+			want:   []string{"[CLS]", "ab", "##a", "##b", "[SEP]"},
+			decode: "[CLS] abab [SEP]",
+		},
+		{
+			in:     "[MASK] is in the world",
+			want:   []string{"[CLS]", "[MASK]", "is", "in", "the", "world", "[SEP]"},
+			decode: "[CLS] [MASK] is in the world [SEP]",
+		},
+		{
+			in:     strings.Repeat("a", wordPieceMaxRunes+1),
+			want:   []string{"[CLS]", "[UNK]", "[SEP]"},
+			decode: "[CLS] [UNK] [SEP]",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			ids, err := tokenizer.Encode(tt.in, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var pieces []string
+			for _, id := range ids {
+				piece := tokenizer.vocab.Decode(id)
+				if word, ok := strings.CutPrefix(piece, spmWhitespaceSep); ok {
+					piece = word
+				} else if tokenizer.vocab.Types[id] == TOKEN_TYPE_NORMAL {
+					piece = "##" + piece
+				}
+
+				pieces = append(pieces, piece)
+			}
+
+			if !slices.Equal(pieces, tt.want) {
+				t.Errorf("encode %q = %v, want %v", tt.in, pieces, tt.want)
+			}
+
+			s, err := tokenizer.Decode(ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s != tt.decode {
+				t.Errorf("decode %v = %q, want %q", ids, s, tt.decode)
+			}
+		})
+	}
+
+	t.Run("no special", func(t *testing.T) {
+		ids, err := tokenizer.Encode("hello", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int32{tokenizer.vocab.Encode(spmWhitespaceSep + "hello")}; !slices.Equal(ids, want) {
+			t.Errorf("encode = %v, want %v", ids, want)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+// Package testutil builds small models with deterministic weights for
+// testing model implementations.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+
+	_ "github.com/ollama/ollama/ml/backend"
+)
+
+// Tensor is the name and shape of a tensor in a synthetic model
+type Tensor struct {
+	Name  string
+	Shape []uint64
+}
+
+// Synthetic writes a model with the metadata in kv and tensors filled with
+// deterministic values, then loads it. The values only depend on the order
+// of tensors so models with the same tensors have the same weights.
+func Synthetic(t *testing.T, kv fsggml.KV, tensors []Tensor) model.Model {
+	t.Helper()
+
+	m, err := model.New(context.TODO(), Write(t, kv, tensors), ml.BackendParams{NumThreads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// Write writes a model like [Synthetic] without loading it and returns its
+// path.
+func Write(t *testing.T, kv fsggml.KV, tensors []Tensor) string {
+	t.Helper()
+
+	var seed int
+	ts := make([]fsggml.Tensor, len(tensors))
+	for i, tensor := range tensors {
+		n := uint64(1)
+		for _, dim := range tensor.Shape {
+			n *= dim
+		}
+
+		values := make([]float32, n)
+		for j := range values {
+			seed++
+			values[j] = float32(math.Sin(float64(seed))) / 4
+		}
+
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+
+		ts[i] = fsggml.Tensor{Name: tensor.Name, Kind: 0, Shape: tensor.Shape, WriterTo: &b}
+	}
+
+	p := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fsggml.WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// Forward runs batch through m and returns its outputs, failing t if any
+// aren't finite. There are dims[1] outputs of dims[0] values each.
+func Forward(t *testing.T, m model.Model, tokens []int32, batch input.Batch) (values []float32, dims [2]int) {
+	t.Helper()
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	outputs, err := model.Forward(ctx, m, tokens, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values = outputs.Floats()
+	Finite(t, values)
+	return values, [2]int{outputs.Dim(0), outputs.Dim(1)}
+}
+
+// Finite fails t if any of values are NaN or infinite
+func Finite(t *testing.T, values []float32) {
+	t.Helper()
+
+	for i, v := range values {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			t.Fatalf("value %d is not finite: %v", i, v)
+		}
+	}
+}
+
+// Sequence returns a batch of tokens at positions 0 to len(tokens)-1 of
+// one sequence, with outputs for the inputs at outputs
+func Sequence(tokens []int32, outputs ...int32) input.Batch {
+	batch := input.Batch{
+		Positions: make([]int32, len(tokens)),
+		Sequences: make([]int, len(tokens)),
+		Outputs:   outputs,
+	}
+	for i := range batch.Positions {
+		batch.Positions[i] = int32(i)
+	}
+
+	return batch
+}
+
+// Close returns whether a and b are the same length and each value is within
+// tolerance of the other
+func Close(a, b []float32, tolerance float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
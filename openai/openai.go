@@ -198,8 +198,14 @@ func NewError(code int, message string) ErrorResponse {
 	switch code {
 	case http.StatusBadRequest:
 		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
 	case http.StatusNotFound:
 		etype = "not_found_error"
+	case http.StatusTooManyRequests:
+		etype = "rate_limit_error"
 	default:
 		etype = "api_error"
 	}
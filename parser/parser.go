@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -93,6 +94,39 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 		case "message":
 			role, msg, _ := strings.Cut(c.Args, ": ")
 			messages = append(messages, api.Message{Role: role, Content: msg})
+		case "tool":
+			bts, err := readJSONArg(c.Args, relativeDir)
+			if err != nil {
+				return nil, fmt.Errorf("TOOL: %w", err)
+			}
+
+			// a TOOL is either a single tool or a list of tools
+			if bytes.HasPrefix(bts, []byte("[")) {
+				var tools api.Tools
+				if err := json.Unmarshal(bts, &tools); err != nil {
+					return nil, fmt.Errorf("TOOL: %w", err)
+				}
+				req.Tools = append(req.Tools, tools...)
+			} else {
+				var tool api.Tool
+				if err := json.Unmarshal(bts, &tool); err != nil {
+					return nil, fmt.Errorf("TOOL: %w", err)
+				}
+				req.Tools = append(req.Tools, tool)
+			}
+		case "format":
+			if c.Args == "json" {
+				req.Format = json.RawMessage(`"json"`)
+				break
+			}
+
+			bts, err := readJSONArg(c.Args, relativeDir)
+			if err != nil {
+				return nil, fmt.Errorf("FORMAT: %w", err)
+			}
+			req.Format = bts
+		case "include", "arg":
+			return nil, fmt.Errorf("unresolved %s command: parse the Modelfile with ParseFileWithOptions", strings.ToUpper(c.Name))
 		default:
 			if slices.Contains(deprecatedParameters, c.Name) {
 				fmt.Printf("warning: parameter %s is deprecated\n", c.Name)
@@ -129,6 +163,29 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 	return req, nil
 }
 
+// readJSONArg returns the JSON value of a TOOL or FORMAT command, which is
+// either inline or, with an @ prefix, read from a file.
+func readJSONArg(arg, relativeDir string) ([]byte, error) {
+	bts := []byte(arg)
+	if name, ok := strings.CutPrefix(arg, "@"); ok {
+		path, err := expandPath(name, relativeDir)
+		if err != nil {
+			return nil, err
+		}
+
+		bts, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, bts); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
 func fileDigestMap(path string) (map[string]string, error) {
 	fl := make(map[string]string)
 
@@ -286,11 +343,13 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter":
+	case "license", "template", "system", "adapter", "tool", "format":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
 		fmt.Fprintf(&sb, "MESSAGE %s %s", role, quote(message))
+	case "include", "arg":
+		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), c.Args)
 	default:
 		fmt.Fprintf(&sb, "PARAMETER %s %s", c.Name, quote(c.Args))
 	}
@@ -312,29 +371,59 @@ const (
 var (
 	errMissingFrom        = errors.New("no FROM line")
 	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", or \"message\"")
+	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", \"message\", \"tool\", \"format\", \"include\", or \"arg\"")
 )
 
 type ParserError struct {
+	// Filename is the file the error occurred in. It is empty when parsing
+	// a Modelfile that wasn't read from a named file.
+	Filename   string
 	LineNumber int
 	Msg        string
 }
 
 func (e *ParserError) Error() string {
-	if e.LineNumber > 0 {
+	switch {
+	case e.Filename != "" && e.LineNumber > 0:
+		return fmt.Sprintf("%s (line %d): %s", e.Filename, e.LineNumber, e.Msg)
+	case e.Filename != "":
+		return fmt.Sprintf("%s: %s", e.Filename, e.Msg)
+	case e.LineNumber > 0:
 		return fmt.Sprintf("(line %d): %s", e.LineNumber, e.Msg)
 	}
 	return e.Msg
 }
 
+// ParseFile parses a Modelfile. INCLUDE and ARG commands are returned as-is;
+// use [ParseFileWithOptions] to resolve them.
 func ParseFile(r io.Reader) (*Modelfile, error) {
+	f, _, err := parseFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range f.Commands {
+		// FROM may come from an included file
+		if cmd.Name == "model" || cmd.Name == "include" {
+			return f, nil
+		}
+	}
+
+	return nil, errMissingFrom
+}
+
+// parseFile parses the commands of a Modelfile, which need not contain a
+// FROM command, and returns them with the line number each command starts on.
+func parseFile(r io.Reader) (*Modelfile, []int, error) {
 	var cmd Command
 	var curr state
 	var currLine int = 1
+	var cmdLine int
 	var b bytes.Buffer
 	var role string
 
 	var f Modelfile
+	var lines []int
 
 	tr := unicode.BOMOverride(unicode.UTF8.NewDecoder())
 	br := bufio.NewReader(transform.NewReader(r, tr))
@@ -344,7 +433,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if isNewline(r) {
@@ -353,9 +442,9 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 		next, r, err := parseRuneForState(r, curr)
 		if errors.Is(err, io.ErrUnexpectedEOF) {
-			return nil, fmt.Errorf("%w: %s", err, b.String())
+			return nil, nil, fmt.Errorf("%w: %s", err, b.String())
 		} else if err != nil {
-			return nil, &ParserError{
+			return nil, nil, &ParserError{
 				LineNumber: currLine,
 				Msg:        err.Error(),
 			}
@@ -366,7 +455,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 			switch curr {
 			case stateName:
 				if !isValidCommand(b.String()) {
-					return nil, &ParserError{
+					return nil, nil, &ParserError{
 						LineNumber: currLine,
 						Msg:        errInvalidCommand.Error(),
 					}
@@ -390,20 +479,24 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 				cmd.Name = b.String()
 			case stateMessage:
 				if !isValidMessageRole(b.String()) {
-					return nil, &ParserError{
+					return nil, nil, &ParserError{
 						LineNumber: currLine,
 						Msg:        errInvalidMessageRole.Error(),
 					}
 				}
 
 				role = b.String()
-			case stateComment, stateNil:
+			case stateNil:
+				if next == stateName {
+					cmdLine = currLine
+				}
+			case stateComment:
 				// pass
 			case stateValue:
 				s, ok := unquote(strings.TrimSpace(b.String()))
 				if !ok || isSpace(r) {
 					if _, err := b.WriteRune(r); err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 
 					continue
@@ -416,6 +509,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 				cmd.Args = s
 				f.Commands = append(f.Commands, cmd)
+				lines = append(lines, cmdLine)
 			}
 
 			b.Reset()
@@ -424,7 +518,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 		if strconv.IsPrint(r) {
 			if _, err := b.WriteRune(r); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
@@ -436,7 +530,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 	case stateValue:
 		s, ok := unquote(strings.TrimSpace(b.String()))
 		if !ok {
-			return nil, io.ErrUnexpectedEOF
+			return nil, nil, io.ErrUnexpectedEOF
 		}
 
 		if role != "" {
@@ -445,17 +539,12 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 		cmd.Args = s
 		f.Commands = append(f.Commands, cmd)
+		lines = append(lines, cmdLine)
 	default:
-		return nil, io.ErrUnexpectedEOF
+		return nil, nil, io.ErrUnexpectedEOF
 	}
 
-	for _, cmd := range f.Commands {
-		if cmd.Name == "model" {
-			return &f, nil
-		}
-	}
-
-	return nil, errMissingFrom
+	return &f, lines, nil
 }
 
 func parseRuneForState(r rune, cs state) (state, rune, error) {
@@ -530,6 +619,13 @@ func quote(s string) string {
 }
 
 func unquote(s string) (string, bool) {
+	// a Jinja template may be marked outside of its quotes, as in
+	// TEMPLATE jinja:"""..."""
+	if rest, ok := strings.CutPrefix(s, "jinja:"); ok && strings.HasPrefix(rest, `"`) {
+		s, ok := unquote(rest)
+		return "jinja:" + s, ok
+	}
+
 	// TODO: single quotes
 	if len(s) >= 3 && s[:3] == `"""` {
 		if len(s) >= 6 && s[len(s)-3:] == `"""` {
@@ -572,7 +668,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "parameter", "message", "tool", "format", "include", "arg":
 		return true
 	default:
 		return false
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"unicode/utf16"
@@ -455,6 +456,28 @@ TEMPLATE """
 			},
 			nil,
 		},
+		{
+			`
+FROM foo
+TEMPLATE jinja:"""
+{% for message in messages %}{{ message.content }}{% endfor %}
+"""`,
+			[]Command{
+				{Name: "model", Args: "foo"},
+				{Name: "template", Args: "jinja:\n{% for message in messages %}{{ message.content }}{% endfor %}\n"},
+			},
+			nil,
+		},
+		{
+			`
+FROM foo
+TEMPLATE jinja:"{{ messages[0].content }}"`,
+			[]Command{
+				{Name: "model", Args: "foo"},
+				{Name: "template", Args: "jinja:{{ messages[0].content }}"},
+			},
+			nil,
+		},
 	}
 
 	for _, c := range cases {
@@ -830,3 +853,100 @@ func TestCreateRequestFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateRequestToolsFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tools.json"), []byte(`[
+  {"type": "function", "function": {"name": "get_time", "description": "Get the time"}},
+  {"type": "function", "function": {"name": "get_date", "description": "Get the date"}}
+]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "schema.json"), []byte(`{
+  "type": "object",
+  "properties": {"name": {"type": "string"}}
+}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("inline", func(t *testing.T) {
+		input := `FROM test
+TOOL {"type": "function", "function": {"name": "get_weather", "description": "Get the weather"}}
+TOOL """{
+  "type": "function",
+  "function": {"name": "get_time", "description": "Get the time"}
+}"""
+FORMAT json
+`
+		p, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := p.CreateRequest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `[{"type":"function","function":{"name":"get_weather","description":"Get the weather","parameters":{"type":"","required":null,"properties":null}}},{"type":"function","function":{"name":"get_time","description":"Get the time","parameters":{"type":"","required":null,"properties":null}}}]`
+		if diff := cmp.Diff(want, req.Tools.String()); diff != "" {
+			t.Errorf("tools mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(`"json"`, string(req.Format)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		input := `FROM test
+TOOL @tools.json
+FORMAT @schema.json
+`
+		p, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := p.CreateRequest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(req.Tools) != 2 || req.Tools[0].Function.Name != "get_time" || req.Tools[1].Function.Name != "get_date" {
+			t.Errorf("unexpected tools: %s", req.Tools)
+		}
+		if diff := cmp.Diff(`{"type":"object","properties":{"name":{"type":"string"}}}`, string(req.Format)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		input := `FROM test
+TOOL {"type":"function","function":{"name":"get_weather","description":"Get the weather"}}
+FORMAT {"type":"object","properties":{"name":{"type":"string"}}}
+`
+		p, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(input, p.String()); diff != "" {
+			t.Errorf("String() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	for _, input := range []string{
+		"FROM test\nTOOL {not json}\n",
+		"FROM test\nTOOL @missing.json\n",
+		"FROM test\nFORMAT yaml\n",
+	} {
+		p, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := p.CreateRequest(dir); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ParseOptions controls how [ParseFileWithOptions] resolves INCLUDE and ARG
+// commands.
+type ParseOptions struct {
+	// Filename is the name of the Modelfile being parsed. It is reported in
+	// errors, and relative INCLUDE paths are resolved against its directory.
+	// If empty, they are resolved against the current directory.
+	Filename string
+
+	// Args overrides the default values of ARG commands, like the
+	// --build-arg flag of ollama create. Every arg must be declared by an
+	// ARG command.
+	Args map[string]string
+}
+
+var (
+	errIncludeCycle    = errors.New("include cycle")
+	errUndefinedArg    = errors.New("undefined variable")
+	errInvalidArgName  = errors.New("ARG name must start with a letter or underscore and contain only letters, numbers and underscores")
+	errUndeclaredArgs  = errors.New("build args are not declared by an ARG command")
+	errMaxIncludeDepth = errors.New("includes are nested too deeply")
+)
+
+// maxIncludeDepth limits how deeply INCLUDE commands may be nested.
+const maxIncludeDepth = 32
+
+var (
+	argNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	argRefRE  = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+)
+
+// ParseFileWithOptions parses a Modelfile like [ParseFile] and resolves its
+// INCLUDE and ARG commands:
+//
+//   - INCLUDE path splices in the commands of another Modelfile fragment,
+//     which needn't contain a FROM command. Relative paths are resolved
+//     against the directory of the including file.
+//   - ARG name=default declares a variable, overridable with opts.Args. Its
+//     value replaces ${name} in the arguments of later FROM, ADAPTER,
+//     PARAMETER and INCLUDE commands, including those of later includes.
+//     Once an ARG has been declared, $$ is replaced with a literal $ in
+//     those commands; commands before the first ARG are left as they are.
+//     TEMPLATE, SYSTEM, LICENSE, MESSAGE, TOOL and FORMAT are never
+//     expanded.
+//
+// The returned Modelfile contains neither INCLUDE nor ARG commands, so its
+// String method returns the fully resolved Modelfile.
+func ParseFileWithOptions(r io.Reader, opts ParseOptions) (*Modelfile, error) {
+	res := resolver{
+		overrides: opts.Args,
+		declared:  make(map[string]bool),
+		vars:      make(map[string]string),
+		used:      make(map[string]bool),
+	}
+
+	dir := "."
+	if opts.Filename != "" {
+		abs, err := filepath.Abs(opts.Filename)
+		if err != nil {
+			return nil, err
+		}
+		res.stack = append(res.stack, abs)
+		dir = filepath.Dir(abs)
+	}
+
+	if err := res.resolve(r, opts.Filename, dir); err != nil {
+		return nil, err
+	}
+
+	var undeclared []string
+	for k := range opts.Args {
+		if !res.used[k] {
+			undeclared = append(undeclared, k)
+		}
+	}
+	if len(undeclared) > 0 {
+		slices.Sort(undeclared)
+		return nil, fmt.Errorf("%w: %s", errUndeclaredArgs, strings.Join(undeclared, ", "))
+	}
+
+	for _, cmd := range res.f.Commands {
+		if cmd.Name == "model" {
+			return &res.f, nil
+		}
+	}
+
+	return nil, errMissingFrom
+}
+
+type resolver struct {
+	f Modelfile
+
+	overrides map[string]string
+	// declared tracks the ARG names seen so far, and vars those which
+	// have a value
+	declared map[string]bool
+	vars     map[string]string
+	// used tracks which overrides were declared by an ARG command
+	used map[string]bool
+
+	// stack holds the absolute paths of the files being resolved, outermost
+	// first, to detect include cycles
+	stack []string
+}
+
+func (res *resolver) resolve(r io.Reader, filename, dir string) error {
+	f, lines, err := parseFile(r)
+	if err != nil {
+		var pErr *ParserError
+		if errors.As(err, &pErr) {
+			if pErr.Filename == "" {
+				pErr.Filename = filename
+			}
+			return pErr
+		}
+		if filename != "" {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		return err
+	}
+
+	for i, cmd := range f.Commands {
+		errorf := func(err error) error {
+			return &ParserError{Filename: filename, LineNumber: lines[i], Msg: err.Error()}
+		}
+
+		switch cmd.Name {
+		case "arg":
+			name, value, hasDefault := strings.Cut(cmd.Args, "=")
+			name = strings.TrimSpace(name)
+			if !argNameRE.MatchString(name) {
+				return errorf(errInvalidArgName)
+			}
+			res.declared[name] = true
+
+			if v, ok := res.overrides[name]; ok {
+				res.vars[name] = v
+				res.used[name] = true
+			} else if hasDefault {
+				s, ok := unquote(strings.TrimSpace(value))
+				if !ok {
+					return errorf(io.ErrUnexpectedEOF)
+				}
+				if res.vars[name], err = res.expand(s); err != nil {
+					return errorf(err)
+				}
+			}
+			continue
+		}
+
+		switch cmd.Name {
+		case "template", "system", "license", "message", "tool", "format":
+			// templates, prompts and JSON schemas are taken literally as
+			// they often contain $
+		default:
+			cmd.Args, err = res.expand(cmd.Args)
+			if err != nil {
+				return errorf(err)
+			}
+		}
+
+		if cmd.Name != "include" {
+			res.f.Commands = append(res.f.Commands, cmd)
+			continue
+		}
+
+		path, err := expandPath(cmd.Args, dir)
+		if err != nil {
+			return errorf(err)
+		}
+
+		if slices.Contains(res.stack, path) {
+			chain := append(slices.Clone(res.stack), path)
+			return errorf(fmt.Errorf("%w: %s", errIncludeCycle, strings.Join(chain, " -> ")))
+		}
+		if len(res.stack) >= maxIncludeDepth {
+			return errorf(errMaxIncludeDepth)
+		}
+
+		if err := res.include(path, cmd.Args); err != nil {
+			var pErr *ParserError
+			if errors.As(err, &pErr) {
+				return err
+			}
+			return errorf(err)
+		}
+	}
+
+	return nil
+}
+
+func (res *resolver) include(path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res.stack = append(res.stack, path)
+	defer func() { res.stack = res.stack[:len(res.stack)-1] }()
+
+	return res.resolve(f, name, filepath.Dir(path))
+}
+
+// expand replaces ${name} in s with the value of the ARG name, and $$ with $.
+// s is returned unchanged if no ARG has been declared yet.
+func (res *resolver) expand(s string) (string, error) {
+	if len(res.declared) == 0 {
+		return s, nil
+	}
+
+	var err error
+	s = argRefRE.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+
+		name := m[2 : len(m)-1]
+		v, ok := res.vars[name]
+		if !ok && err == nil {
+			if res.declared[name] {
+				err = fmt.Errorf("ARG %s has no value, set one with --build-arg %s=<value>", name, name)
+			} else {
+				known := slices.Sorted(maps.Keys(res.declared))
+				err = fmt.Errorf("%w %q, declared ARGs are: %s", errUndefinedArg, name, strings.Join(known, ", "))
+			}
+		}
+		return v
+	})
+	return s, err
+}
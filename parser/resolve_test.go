@@ -0,0 +1,260 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeModelfiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func parseModelfile(t *testing.T, dir, name string, args map[string]string) (*Modelfile, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	return ParseFileWithOptions(f, ParseOptions{Filename: filepath.Join(dir, name), Args: args})
+}
+
+func TestParseFileWithOptions(t *testing.T) {
+	dir := writeModelfiles(t, map[string]string{
+		"Modelfile": `ARG base=llama3.2
+ARG temperature=0.7
+FROM ${base}
+INCLUDE common/stops.mf
+PARAMETER temperature ${temperature}
+PARAMETER stop "$$"
+SYSTEM """You cost $5 an hour, not ${price}."""
+`,
+		"common/stops.mf": `# shared stop parameters
+PARAMETER stop <|eot_id|>
+INCLUDE template.mf
+`,
+		"common/template.mf": `TEMPLATE """{{ $system := .System }}{{ $system }} {{ .Prompt }} (${base})"""
+`,
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		f, err := parseModelfile(t, dir, "Modelfile", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]Command{
+			{Name: "model", Args: "llama3.2"},
+			{Name: "stop", Args: "<|eot_id|>"},
+			{Name: "template", Args: "{{ $system := .System }}{{ $system }} {{ .Prompt }} (${base})"},
+			{Name: "temperature", Args: "0.7"},
+			{Name: "stop", Args: "$"},
+			{Name: "system", Args: "You cost $5 an hour, not ${price}."},
+		}, f.Commands); diff != "" {
+			t.Errorf("commands mismatch (-want +got):\n%s", diff)
+		}
+
+		want := `FROM llama3.2
+PARAMETER stop <|eot_id|>
+TEMPLATE {{ $system := .System }}{{ $system }} {{ .Prompt }} (${base})
+PARAMETER temperature 0.7
+PARAMETER stop $
+SYSTEM You cost $5 an hour, not ${price}.
+`
+		if diff := cmp.Diff(want, f.String()); diff != "" {
+			t.Errorf("String() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		f, err := parseModelfile(t, dir, "Modelfile", map[string]string{"base": "qwen2.5", "temperature": "0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]Command{
+			{Name: "model", Args: "qwen2.5"},
+			{Name: "stop", Args: "<|eot_id|>"},
+			{Name: "template", Args: "{{ $system := .System }}{{ $system }} {{ .Prompt }} (${base})"},
+			{Name: "temperature", Args: "0"},
+			{Name: "stop", Args: "$"},
+			{Name: "system", Args: "You cost $5 an hour, not ${price}."},
+		}, f.Commands); diff != "" {
+			t.Errorf("commands mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("undeclared override", func(t *testing.T) {
+		_, err := parseModelfile(t, dir, "Modelfile", map[string]string{"nope": "x"})
+		if !errors.Is(err, errUndeclaredArgs) {
+			t.Fatalf("err = %v, want %v", err, errUndeclaredArgs)
+		}
+	})
+}
+
+func TestParseFileWithOptionsNoArgs(t *testing.T) {
+	// without ARG commands, values are left untouched
+	input := `FROM llama3.2
+SYSTEM """Costs ${price} or $$5."""
+`
+	f, err := ParseFileWithOptions(strings.NewReader(input), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]Command{
+		{Name: "model", Args: "llama3.2"},
+		{Name: "system", Args: "Costs ${price} or $$5."},
+	}, f.Commands); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFileWithOptionsErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		files    map[string]string
+		filename string
+		line     int
+		msg      string
+	}{
+		{
+			name: "undefined",
+			files: map[string]string{
+				"Modelfile": "ARG a=1\nFROM llama3.2\nPARAMETER temperature ${b}\n",
+			},
+			filename: "Modelfile",
+			line:     3,
+			msg:      "undefined variable",
+		},
+		{
+			name: "undefined in include",
+			files: map[string]string{
+				"Modelfile": "ARG a=1\nFROM llama3.2\nINCLUDE fragment\n",
+				"fragment":  "# comment\n\nADAPTER ${b}\n",
+			},
+			filename: "fragment",
+			line:     3,
+			msg:      "undefined variable",
+		},
+		{
+			name: "invalid command in include",
+			files: map[string]string{
+				"Modelfile": "FROM llama3.2\nINCLUDE fragment\n",
+				"fragment":  "SYSTEM hi\nBAD command\n",
+			},
+			filename: "fragment",
+			line:     2,
+			msg:      "command must be one of",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"Modelfile": "FROM llama3.2\nINCLUDE a\n",
+				"a":         "INCLUDE b\n",
+				"b":         "SYSTEM hi\nINCLUDE a\n",
+			},
+			filename: "b",
+			line:     2,
+			msg:      "include cycle",
+		},
+		{
+			name: "self",
+			files: map[string]string{
+				"Modelfile": "FROM llama3.2\nINCLUDE Modelfile\n",
+			},
+			filename: "Modelfile",
+			line:     2,
+			msg:      "include cycle",
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"Modelfile": "FROM llama3.2\n\nINCLUDE missing\n",
+			},
+			filename: "Modelfile",
+			line:     3,
+			msg:      "missing",
+		},
+		{
+			name: "invalid arg",
+			files: map[string]string{
+				"Modelfile": "ARG 1a=b\nFROM llama3.2\n",
+			},
+			filename: "Modelfile",
+			line:     1,
+			msg:      "ARG name must",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModelfiles(t, tt.files)
+			_, err := parseModelfile(t, dir, "Modelfile", nil)
+
+			var pErr *ParserError
+			if !errors.As(err, &pErr) {
+				t.Fatalf("expected a *ParserError, got %v", err)
+			}
+			if filepath.Base(pErr.Filename) != tt.filename || pErr.LineNumber != tt.line {
+				t.Errorf("error at %s:%d, want %s:%d", pErr.Filename, pErr.LineNumber, tt.filename, tt.line)
+			}
+			if !strings.Contains(pErr.Msg, tt.msg) {
+				t.Errorf("msg = %q, want it to contain %q", pErr.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseFileWithOptionsMissingFrom(t *testing.T) {
+	dir := writeModelfiles(t, map[string]string{
+		"Modelfile": "INCLUDE fragment\n",
+		"fragment":  "SYSTEM hi\n",
+	})
+
+	if _, err := parseModelfile(t, dir, "Modelfile", nil); !errors.Is(err, errMissingFrom) {
+		t.Fatalf("err = %v, want %v", err, errMissingFrom)
+	}
+}
+
+func TestParseFileUnresolved(t *testing.T) {
+	input := `ARG base=llama3.2
+INCLUDE common.mf
+`
+	f, err := ParseFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]Command{
+		{Name: "arg", Args: "base=llama3.2"},
+		{Name: "include", Args: "common.mf"},
+	}, f.Commands); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(input, f.String()); diff != "" {
+		t.Errorf("String() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := f.CreateRequest(""); err == nil {
+		t.Error("expected an error creating a request from an unresolved Modelfile")
+	}
+}
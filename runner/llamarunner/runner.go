@@ -23,9 +23,11 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/runner/common"
+	"github.com/ollama/ollama/tracing"
 )
 
 // input is an element of the prompt to process, either
@@ -90,6 +92,7 @@ type Sequence struct {
 	startGenerationTime time.Time
 	numDecoded          int
 	numPromptInputs     int
+	numCachedInputs     int
 }
 
 type NewSequenceParams struct {
@@ -551,6 +554,9 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "runner.completion")
+	defer span.End()
+
 	var req llm.CompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -623,6 +629,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
 				return
 			}
+			seq.numCachedInputs = seq.numPromptInputs - len(seq.inputs)
 
 			seq.crossAttention = s.image.NeedCrossAttention(seq.cache.Inputs...)
 
@@ -657,10 +664,17 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 
 				flusher.Flush()
 			} else {
+				if seq.numDecoded > 0 {
+					tracing.Record(ctx, "runner.prefill", seq.startProcessingTime, seq.startGenerationTime, "prompt_tokens", seq.numPromptInputs, "cached_tokens", seq.numCachedInputs)
+					tracing.Record(ctx, "runner.decode", seq.startGenerationTime, time.Now(), "tokens", seq.numDecoded)
+				}
+				span.SetAttributes("done_reason", seq.doneReason.String())
+
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
 					Done:               true,
 					DoneReason:         seq.doneReason,
 					PromptEvalCount:    seq.numPromptInputs,
+					PromptCachedCount:  seq.numCachedInputs,
 					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
 					EvalCount:          seq.numDecoded,
 					EvalDuration:       time.Since(seq.startGenerationTime),
@@ -850,6 +864,9 @@ func Execute(args []string) error {
 		},
 	})
 	slog.SetDefault(slog.New(handler))
+
+	shutdownTracing := tracing.Configure(envconfig.TraceEndpoint(), "ollama-runner")
+	defer shutdownTracing(context.Background())
 	slog.Info("starting go runner")
 
 	llama.BackendInit()
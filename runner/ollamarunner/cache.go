@@ -73,6 +73,23 @@ func (c *InputCache) Close() {
 	c.cache.Close()
 }
 
+// Grow increases the context window of each slot to numCtx, keeping the
+// inputs already stored in the cache
+func (c *InputCache) Grow(numCtx int32) error {
+	if numCtx <= c.numCtx {
+		return nil
+	}
+
+	if c.cache != nil {
+		if err := c.cache.Grow(int(numCtx)); err != nil {
+			return err
+		}
+	}
+
+	c.numCtx = numCtx
+	return nil
+}
+
 // Locking: Operations on InputCacheSlot (including finding one
 // through LoadCacheSlot) require a lock to be be held that serializes
 // these operations with each other and processBatch
@@ -0,0 +1,75 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+)
+
+// ImatrixProgress is written to stdout, one per line, as the calibration
+// dataset is evaluated
+type ImatrixProgress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+// ExecuteImatrix computes the importance matrix of a model's weights over a
+// calibration dataset and writes it to a file.
+func ExecuteImatrix(args []string) error {
+	fs := flag.NewFlagSet("imatrix", flag.ExitOnError)
+	mpath := fs.String("model", "", "Path to model binary file")
+	dataset := fs.String("dataset", "", "Path to calibration text file")
+	output := fs.String("output", "", "Path to write the importance matrix to")
+	chunkSize := fs.Int("ctx-size", 512, "Number of tokens evaluated at a time")
+	threads := fs.Int("threads", runtime.NumCPU(), "Number of threads to use")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Imatrix usage\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	text, err := os.ReadFile(*dataset)
+	if err != nil {
+		return err
+	}
+
+	m, err := model.New(context.Background(), *mpath, ml.BackendParams{NumThreads: *threads})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	imatrix, err := model.Calibrate(m, string(text), model.CalibrateOptions{
+		ChunkSize: *chunkSize,
+		Progress: func(completed, total int) {
+			enc.Encode(ImatrixProgress{Completed: completed, Total: total})
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := imatrix.WriteTo(f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -24,12 +24,14 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
 	"github.com/ollama/ollama/model/input"
 	"github.com/ollama/ollama/runner/common"
 	"github.com/ollama/ollama/sample"
+	"github.com/ollama/ollama/tracing"
 
 	_ "github.com/ollama/ollama/model/models"
 )
@@ -89,6 +91,7 @@ type Sequence struct {
 	startGenerationTime time.Time
 	numPredicted        int
 	numPromptInputs     int
+	numCachedInputs     int
 }
 
 type NewSequenceParams struct {
@@ -155,6 +158,11 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		inputs = newInputs
 	}
 
+	// Embeddings are pooled over the whole input, which must all be in the same batch
+	if params.embedding {
+		inputs[0].SameBatch = len(inputs) - 1
+	}
+
 	// TODO(jessegross): Ingest cached history for grammar
 
 	return &Sequence{
@@ -502,8 +510,8 @@ func (s *Server) processBatch() error {
 
 		// if done processing the prompt, generate an embedding and return
 		if seq.embeddingOnly {
-			// TODO(jessegross): Embedding support
-			slog.Warn("generation of embedding outputs not yet supported")
+			size := modelOutput.Dim(0)
+			seq.embedding <- logits[seq.iBatch*size : (seq.iBatch+1)*size]
 			s.removeSequence(i, llm.DoneReasonStop)
 			continue
 		}
@@ -579,6 +587,9 @@ func (s *Server) processBatch() error {
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "runner.completion")
+	defer span.End()
+
 	var req llm.CompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -653,6 +664,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
 				return
 			}
+			seq.numCachedInputs = seq.numPromptInputs - len(seq.inputs)
 
 			s.seqs[i] = seq
 			s.cond.Signal()
@@ -685,10 +697,17 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 
 				flusher.Flush()
 			} else {
+				if seq.numPredicted > 0 {
+					tracing.Record(ctx, "runner.prefill", seq.startProcessingTime, seq.startGenerationTime, "prompt_tokens", seq.numPromptInputs, "cached_tokens", seq.numCachedInputs)
+					tracing.Record(ctx, "runner.decode", seq.startGenerationTime, time.Now(), "tokens", seq.numPredicted)
+				}
+				span.SetAttributes("done_reason", seq.doneReason.String())
+
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
 					Done:               true,
 					DoneReason:         seq.doneReason,
 					PromptEvalCount:    seq.numPromptInputs,
+					PromptCachedCount:  seq.numCachedInputs,
 					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
 					EvalCount:          seq.numPredicted,
 					EvalDuration:       time.Since(seq.startGenerationTime),
@@ -702,6 +721,76 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req llm.EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.ready.Wait()
+
+	// Models with a cache generate text so their outputs are logits rather than
+	// embeddings pooled over each sequence
+	if s.cache.enabled {
+		http.Error(w, "this model does not support embeddings", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	slog.Debug("embedding request", "content", req.Content)
+
+	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			slog.Info("aborting embeddings request due to client closing the connection")
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to acquire semaphore: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	found := false
+	for i, sq := range s.seqs {
+		if sq == nil {
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
+			if err != nil {
+				s.mu.Unlock()
+				s.seqsSem.Release(1)
+				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+				return
+			}
+			s.seqs[i] = seq
+			s.cond.Signal()
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		s.seqsSem.Release(1)
+		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
+		return
+	}
+
+	embedding := <-seq.embedding
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embedding: embedding,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(&llm.ServerStatusResponse{
@@ -712,6 +801,40 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// context grows the kv cache so that each parallel sequence has a larger
+// context window, without reloading the model
+func (s *Server) context(w http.ResponseWriter, r *http.Request) {
+	var req llm.ContextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if s.status != llm.ServerStatusReady {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	// wait for the current batch to finish so the cache isn't in use
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.cache.Grow(int32(req.Size / s.parallel)); err != nil {
+		http.Error(w, fmt.Sprintf("failed to grow cache: %v", err), http.StatusInsufficientStorage)
+		return
+	}
+
+	// the worst case graph attends to the whole cache, so it needs more
+	// memory now that the cache is bigger
+	if err := s.reserveWorstCaseGraph(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reserve graph: %v", err), http.StatusInsufficientStorage)
+		return
+	}
+
+	slog.Info("grew kv cache", "ctx-size", req.Size, "parallel", s.parallel)
+	w.WriteHeader(http.StatusOK)
+}
+
 type multiLPath []string
 
 func (m *multiLPath) Set(value string) error {
@@ -856,6 +979,9 @@ func Execute(args []string) error {
 		},
 	})
 	slog.SetDefault(slog.New(handler))
+
+	shutdownTracing := tracing.Configure(envconfig.TraceEndpoint(), "ollama-runner")
+	defer shutdownTracing(context.Background())
 	slog.Info("starting ollama engine")
 
 	server := &Server{
@@ -907,13 +1033,10 @@ func Execute(args []string) error {
 	defer listener.Close()
 
 	mux := http.NewServeMux()
-	// TODO: support embeddings
-	mux.HandleFunc("POST /embedding", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "this model does not support embeddings", http.StatusNotImplemented)
-	})
-
+	mux.HandleFunc("POST /embedding", server.embeddings)
 	mux.HandleFunc("POST /completion", server.completion)
 	mux.HandleFunc("GET /health", server.health)
+	mux.HandleFunc("POST /context", server.context)
 
 	httpServer := http.Server{
 		Handler: mux,
@@ -0,0 +1,115 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/testutil"
+)
+
+// bert writes a small embedding model with mean pooling
+func bert(t *testing.T) string {
+	t.Helper()
+
+	const hidden = 8
+	return testutil.Write(t, fsggml.KV{
+		"general.architecture":              "bert",
+		"bert.block_count":                  uint32(1),
+		"bert.context_length":               uint32(16),
+		"bert.embedding_length":             uint32(hidden),
+		"bert.feed_forward_length":          uint32(16),
+		"bert.attention.head_count":         uint32(2),
+		"bert.attention.layer_norm_epsilon": float32(1e-12),
+		"bert.attention.causal":             false,
+		"bert.pooling_type":                 uint32(1),
+		"tokenizer.ggml.model":              "bert",
+		"tokenizer.ggml.tokens":             []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "▁a", "▁b"},
+		"tokenizer.ggml.token_type":         []int32{3, 3, 3, 3, 1, 1},
+		"tokenizer.ggml.cls_token_id":       uint32(2),
+		"tokenizer.ggml.seperator_token_id": uint32(3),
+	}, []testutil.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{hidden, 6}},
+		{Name: "token_types.weight", Shape: []uint64{hidden, 2}},
+		{Name: "position_embd.weight", Shape: []uint64{hidden, 16}},
+		{Name: "token_embd_norm.weight", Shape: []uint64{hidden}},
+		{Name: "token_embd_norm.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{hidden, hidden}},
+		{Name: "blk.0.attn_q.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{hidden, hidden}},
+		{Name: "blk.0.attn_k.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{hidden, hidden}},
+		{Name: "blk.0.attn_v.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{hidden, hidden}},
+		{Name: "blk.0.attn_output_norm.weight", Shape: []uint64{hidden}},
+		{Name: "blk.0.attn_output_norm.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{hidden, 16}},
+		{Name: "blk.0.ffn_up.bias", Shape: []uint64{16}},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{16, hidden}},
+		{Name: "blk.0.ffn_down.bias", Shape: []uint64{hidden}},
+		{Name: "blk.0.layer_output_norm.weight", Shape: []uint64{hidden}},
+		{Name: "blk.0.layer_output_norm.bias", Shape: []uint64{hidden}},
+	})
+}
+
+func TestEmbeddings(t *testing.T) {
+	p := bert(t)
+
+	s := Server{batchSize: 4}
+	s.cond = sync.NewCond(&s.mu)
+	s.ready.Add(1)
+	s.loadModel(context.TODO(), p, ml.BackendParams{NumThreads: 1}, multiLPath{}, 1, "", 16, false)
+
+	// the input is longer than the batch but still pooled in one batch
+	prompt := "a b a b a"
+	tokens, _, err := s.inputs(prompt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int32, len(tokens))
+	for i, token := range tokens {
+		ids[i] = token.Token
+	}
+
+	want, dims := testutil.Forward(t, s.model, ids, testutil.Sequence(ids, int32(len(ids)-1)))
+	if dims[1] != 1 {
+		t.Fatalf("expected one pooled output, got %v", dims)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.embeddings(w, httptest.NewRequest(http.MethodPost, "/embedding", strings.NewReader(`{"content":"`+prompt+`"}`)))
+	}()
+
+	if err := s.processBatch(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp llm.EmbeddingResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !testutil.Close(resp.Embedding, want, 1e-4) {
+		t.Errorf("embedding = %v, want %v", resp.Embedding, want)
+	}
+
+	if s.seqs[0] != nil {
+		t.Error("expected the sequence to be removed")
+	}
+}
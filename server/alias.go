@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errAliasShadowsModel = errors.New("a model with that name already exists")
+	errAliasTarget       = errors.New("an alias can't point to another alias")
+	errAliasNotFound     = errors.New("alias not found")
+	errModelIsAlias      = errors.New("an alias with that name already exists, delete the alias first")
+)
+
+// aliasesMu serializes updates to the aliases file.
+var aliasesMu sync.Mutex
+
+type alias struct {
+	Target     string    `json:"target"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// aliases maps alias names, as returned by [model.Name.String], to the
+// models they point to. It is stored in the models directory separately from
+// the manifests, so an alias never duplicates or modifies a model.
+type aliases map[string]alias
+
+var _ model.Aliases = aliases(nil)
+
+// Lookup implements [model.Aliases].
+func (a aliases) Lookup(n model.Name) (model.Name, bool) {
+	for k, v := range a {
+		if model.ParseName(k).EqualFold(n) {
+			return model.ParseName(v.Target), true
+		}
+	}
+	return model.Name{}, false
+}
+
+// key returns the key of n in a, which may differ from n.String() in case.
+func (a aliases) key(n model.Name) (string, bool) {
+	for k := range a {
+		if model.ParseName(k).EqualFold(n) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func aliasesPath() string {
+	return filepath.Join(envconfig.Models(), "aliases.json")
+}
+
+// readAliases reads the aliases file. A missing file has no aliases.
+func readAliases() (aliases, error) {
+	f, err := os.Open(aliasesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return aliases{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := aliases{}
+	if err := json.NewDecoder(f).Decode(&a); err != nil {
+		return nil, fmt.Errorf("reading aliases: %w", err)
+	}
+	return a, nil
+}
+
+func writeAliases(a aliases) error {
+	p := aliasesPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "aliases-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(a); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// resolveAlias returns the name of the model that name points to if it is
+// an alias, or name unchanged otherwise.
+func resolveAlias(name string) string {
+	a, err := readAliases()
+	if err != nil {
+		slog.Warn("ignoring aliases", "error", err)
+		return name
+	}
+	if _, ok := a.Lookup(model.ParseName(name)); !ok {
+		return name
+	}
+	return model.ParseNameAliased(name, a).String()
+}
+
+// checkNotAlias returns [errModelIsAlias] if n is an alias. Models can't be
+// written under the name of an alias, since the alias would hide them.
+func checkNotAlias(n model.Name) error {
+	a, err := readAliases()
+	if err != nil {
+		return err
+	}
+	if _, ok := a.Lookup(n); ok {
+		return errModelIsAlias
+	}
+	return nil
+}
+
+// setAlias points the alias n at target, creating the alias if it doesn't
+// exist. It returns the previous target of the alias, if any. The alias
+// can't have the name of an existing model, and target must be an existing
+// model rather than another alias.
+func setAlias(n, target model.Name) (prev model.Name, err error) {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	if _, err := ParseNamedManifest(n); err == nil {
+		return prev, errAliasShadowsModel
+	}
+
+	a, err := readAliases()
+	if err != nil {
+		return prev, err
+	}
+	if _, ok := a.Lookup(target); ok {
+		return prev, errAliasTarget
+	}
+	if _, err := ParseNamedManifest(target); err != nil {
+		return prev, err
+	}
+
+	// keep the existing spelling of an alias when repointing it
+	k, ok := a.key(n)
+	if ok {
+		prev = model.ParseName(a[k].Target)
+	} else {
+		k = n.String()
+	}
+	a[k] = alias{Target: target.String(), ModifiedAt: time.Now()}
+	return prev, writeAliases(a)
+}
+
+// deleteAlias removes the alias n.
+func deleteAlias(n model.Name) error {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	a, err := readAliases()
+	if err != nil {
+		return err
+	}
+	k, ok := a.key(n)
+	if !ok {
+		return errAliasNotFound
+	}
+	delete(a, k)
+	return writeAliases(a)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestAlias(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"test", "test2"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:  name,
+			Files: map[string]string{"test.gguf": digest},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	w := createRequest(t, s.AliasHandler, api.AliasRequest{Alias: "prod", Target: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ShortName != "test:latest" {
+		t.Errorf("alias resolved to %q, want %q", m.ShortName, "test:latest")
+	}
+
+	// repoint the alias
+	w = createRequest(t, s.AliasHandler, api.AliasRequest{Alias: "PROD", Target: "test2"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err = GetModel("prod:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ShortName != "test2:latest" {
+		t.Errorf("alias resolved to %q, want %q", m.ShortName, "test2:latest")
+	}
+
+	cases := []struct {
+		name string
+		req  api.AliasRequest
+		code int
+	}{
+		{"shadows model", api.AliasRequest{Alias: "test", Target: "test2"}, http.StatusBadRequest},
+		{"alias target", api.AliasRequest{Alias: "other", Target: "prod"}, http.StatusBadRequest},
+		{"missing target", api.AliasRequest{Alias: "other", Target: "missing"}, http.StatusNotFound},
+		{"invalid alias", api.AliasRequest{Alias: "a:b:c", Target: "test"}, http.StatusBadRequest},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.AliasHandler, tt.req)
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	w = createRequest(t, s.ListHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ListResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, m := range resp.Models {
+		if m.Name == "prod:latest" {
+			found = true
+			if m.Target != "test2:latest" {
+				t.Errorf("target = %q, want %q", m.Target, "test2:latest")
+			}
+			if m.Digest == "" {
+				t.Error("expected alias to have the digest of its target")
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("alias not listed: %v", resp.Models)
+	}
+
+	w = createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "prod"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	if _, err := GetModel("prod"); err == nil {
+		t.Fatal("expected error resolving deleted alias")
+	}
+
+	w = createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "prod"})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code 404, actual %d", w.Code)
+	}
+}
+
+func TestAliasNotOverwritten(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.AliasHandler, api.AliasRequest{Alias: "prod", Target: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name    string
+		handler func(*gin.Context)
+		req     any
+	}{
+		{"create", s.CreateHandler, api.CreateRequest{Name: "PROD", Files: map[string]string{"test.gguf": digest}}},
+		{"pull", s.PullHandler, api.PullRequest{Name: "prod"}},
+		{"copy", s.CopyHandler, api.CopyRequest{Source: "test", Destination: "prod:latest"}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, tt.handler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			if _, err := ParseNamedManifest(model.ParseName("prod")); err == nil {
+				t.Fatal("expected no model to be written under the alias")
+			}
+		})
+	}
+
+	// once the alias is deleted the name can be used for a model
+	w = createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "prod"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{Name: "prod", Files: map[string]string{"test.gguf": digest}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/openai"
+)
+
+// Scopes of API keys. Keys with the admin scope have every scope.
+const (
+	scopeInference = "inference"
+	scopePull      = "pull"
+	scopeCreate    = "create"
+	scopeAdmin     = "admin"
+)
+
+// routeScopes are the scopes needed for routes other than inference, which
+// is needed for the rest of /api and /v1
+var routeScopes = map[string]string{
+	"/api/pull":          scopePull,
+	"/api/pull/pause":    scopePull,
+	"/api/push":          scopeCreate,
+	"/api/create":        scopeCreate,
+	"/api/blobs/:digest": scopeCreate,
+	"/api/copy":          scopeCreate,
+	"/api/delete":        scopeCreate,
+	"/api/alias":         scopeCreate,
+	"/api/version":       "",
+	"/metrics":           scopeAdmin,
+}
+
+// apiKeysReloadInterval is how often the API keys file is checked for
+// changes
+var apiKeysReloadInterval = 5 * time.Second
+
+// apiKeysConfig is the API keys file named by OLLAMA_API_KEYS
+type apiKeysConfig struct {
+	Keys []apiKeyConfig `json:"keys"`
+}
+
+type apiKeyConfig struct {
+	// Name identifies the key in logs
+	Name string `json:"name"`
+
+	// Hash is the SHA-256 of the key in hex, prefixed with "sha256:"
+	Hash string `json:"hash"`
+
+	Scopes []string `json:"scopes"`
+
+	// RequestsPerMinute and TokensPerMinute limit the use of the key.
+	// Zero is unlimited.
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int `json:"tokens_per_minute,omitempty"`
+}
+
+func readAPIKeysConfig(path string) (*apiKeysConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config apiKeysConfig
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for _, k := range config.Keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("%s: name %w", path, errRequired)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("%s: duplicate key %q", path, k.Name)
+		}
+		names[k.Name] = true
+
+		if _, err := parseKeyHash(k.Hash); err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, k.Name, err)
+		}
+
+		for _, scope := range k.Scopes {
+			switch scope {
+			case scopeInference, scopePull, scopeCreate, scopeAdmin:
+			default:
+				return nil, fmt.Errorf("%s: key %q: unknown scope %q", path, k.Name, scope)
+			}
+		}
+
+		if k.RequestsPerMinute < 0 || k.TokensPerMinute < 0 {
+			return nil, fmt.Errorf("%s: key %q: limits can't be negative", path, k.Name)
+		}
+	}
+
+	return &config, nil
+}
+
+func parseKeyHash(s string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	digest, ok := strings.CutPrefix(s, "sha256:")
+	if !ok {
+		return hash, fmt.Errorf("hash %q must start with sha256:", s)
+	}
+
+	if len(digest) != hex.EncodedLen(sha256.Size) {
+		return hash, fmt.Errorf("hash %q isn't a SHA-256 digest in hex", s)
+	}
+
+	if _, err := hex.Decode(hash[:], []byte(digest)); err != nil {
+		return hash, fmt.Errorf("hash %q isn't a SHA-256 digest in hex", s)
+	}
+
+	return hash, nil
+}
+
+// apiKeys are the keys allowed to use the server. The keys file is read
+// again when it changes, keeping the rate limits of keys with the same
+// name.
+type apiKeys struct {
+	path string
+
+	mu      sync.Mutex
+	keys    map[[sha256.Size]byte]*apiKey
+	modTime time.Time
+	checked time.Time
+}
+
+type apiKey struct {
+	name   string
+	scopes []string
+
+	mu       sync.Mutex
+	requests rateLimit
+	tokens   rateLimit
+}
+
+func loadAPIKeys(path string) (*apiKeys, error) {
+	k := &apiKeys{path: path}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// load reads the keys file. k.mu must be held if k is in use.
+func (k *apiKeys) load() error {
+	fi, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+
+	config, err := readAPIKeysConfig(k.path)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*apiKey)
+	for _, key := range k.keys {
+		byName[key.name] = key
+	}
+
+	keys := make(map[[sha256.Size]byte]*apiKey)
+	for _, c := range config.Keys {
+		hash, _ := parseKeyHash(c.Hash)
+
+		key, ok := byName[c.Name]
+		if !ok {
+			key = &apiKey{name: c.Name}
+		}
+
+		key.mu.Lock()
+		key.scopes = c.Scopes
+		key.requests.setLimit(c.RequestsPerMinute)
+		key.tokens.setLimit(c.TokensPerMinute)
+		key.mu.Unlock()
+
+		keys[hash] = key
+	}
+
+	k.keys = keys
+	k.modTime = fi.ModTime()
+	return nil
+}
+
+// lookup returns the key with the given secret, or nil if there isn't one
+func (k *apiKeys) lookup(secret string) *apiKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.checked) > apiKeysReloadInterval {
+		k.checked = time.Now()
+		if fi, err := os.Stat(k.path); err != nil {
+			slog.Warn("unable to check api keys file, using the last keys read", "path", k.path, "error", err)
+		} else if !fi.ModTime().Equal(k.modTime) {
+			if err := k.load(); err != nil {
+				slog.Warn("unable to read api keys file, using the last keys read", "path", k.path, "error", err)
+			} else {
+				slog.Info("reloaded api keys", "path", k.path, "keys", len(k.keys))
+			}
+		}
+	}
+
+	return k.keys[sha256.Sum256([]byte(secret))]
+}
+
+func (k *apiKey) hasScope(scope string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return scope == "" || slices.Contains(k.scopes, scope) || slices.Contains(k.scopes, scopeAdmin)
+}
+
+// allow takes a request from the key's rate limit if neither its requests
+// nor tokens are exhausted, otherwise it returns how long until they
+// aren't
+func (k *apiKey) allow(now time.Time) (bool, time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	wait := max(k.requests.wait(1, now), k.tokens.wait(1, now))
+	if wait > 0 {
+		return false, wait
+	}
+
+	k.requests.take(1, now)
+	return true, 0
+}
+
+// useTokens takes n tokens from the key's rate limit
+func (k *apiKey) useTokens(n int, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.tokens.take(float64(n), now)
+}
+
+// rateLimit is a token bucket that holds up to a minute of its limit and
+// refills continuously. Taking more than is available leaves it in debt,
+// which has to be repaid before anything more can be taken.
+type rateLimit struct {
+	perMinute float64
+	available float64
+	last      time.Time
+}
+
+// setLimit changes the limit per minute, with zero being unlimited
+func (l *rateLimit) setLimit(perMinute int) {
+	if l.perMinute == 0 {
+		l.available = float64(perMinute)
+	}
+	l.perMinute = float64(perMinute)
+	l.available = min(l.available, l.perMinute)
+}
+
+func (l *rateLimit) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.available = min(l.perMinute, l.available+now.Sub(l.last).Minutes()*l.perMinute)
+	}
+	l.last = now
+}
+
+// wait returns how long until n can be taken
+func (l *rateLimit) wait(n float64, now time.Time) time.Duration {
+	if l.perMinute == 0 {
+		return 0
+	}
+
+	l.refill(now)
+	if l.available >= n {
+		return 0
+	}
+
+	return time.Duration((n - l.available) / l.perMinute * float64(time.Minute))
+}
+
+func (l *rateLimit) take(n float64, now time.Time) {
+	if l.perMinute == 0 {
+		return
+	}
+
+	l.refill(now)
+	l.available -= n
+}
+
+// apiKeyKey is the gin context key of the request's API key
+const apiKeyKey = "apiKey"
+
+func apiKeyFrom(c *gin.Context) *apiKey {
+	k, _ := c.Value(apiKeyKey).(*apiKey)
+	return k
+}
+
+// useTokens counts tokens processed by the request against the rate limit
+// of its API key, if any
+func useTokens(c *gin.Context, n int) {
+	if k := apiKeyFrom(c); k != nil {
+		k.useTokens(n, time.Now())
+	}
+}
+
+// authorize checks the bearer token of r against keys and returns the key
+// and, if it isn't allowed, the status and reason
+func authorize(keys *apiKeys, r *http.Request, scope string) (*apiKey, int, string, time.Duration) {
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || secret == "" {
+		return nil, http.StatusUnauthorized, "missing api key", 0
+	}
+
+	key := keys.lookup(strings.TrimSpace(secret))
+	if key == nil {
+		return nil, http.StatusUnauthorized, "invalid api key", 0
+	}
+
+	if !key.hasScope(scope) {
+		return key, http.StatusForbidden, fmt.Sprintf("api key %q doesn't have the %s scope", key.name, scope), 0
+	}
+
+	if ok, wait := key.allow(time.Now()); !ok {
+		return key, http.StatusTooManyRequests, fmt.Sprintf("api key %q has exceeded its rate limit", key.name), wait
+	}
+
+	return key, http.StatusOK, "", 0
+}
+
+func writeAuthError(w http.ResponseWriter, path string, status int, reason string, wait time.Duration) {
+	switch status {
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+
+	var body any = map[string]string{"error": reason}
+	if strings.HasPrefix(path, "/v1/") {
+		body = openai.NewError(status, reason)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// scopeOf returns the scope needed for a route, and whether the route
+// needs an API key at all
+func scopeOf(route, path string) (string, bool) {
+	if scope, ok := routeScopes[route]; ok {
+		return scope, true
+	}
+
+	if strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/v1/") {
+		if route == "" {
+			// unknown routes are left to return not found
+			return "", true
+		}
+		return scopeInference, true
+	}
+
+	return "", false
+}
+
+// authMiddleware requires requests to /api and /v1 to have the API key of
+// one of keys, with the scope needed for the route, as a bearer token. It
+// does nothing if keys is nil.
+func authMiddleware(keys *apiKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keys == nil {
+			c.Next()
+			return
+		}
+
+		scope, ok := scopeOf(c.FullPath(), c.Request.URL.Path)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		key, status, reason, wait := authorize(keys, c.Request, scope)
+		if status != http.StatusOK {
+			slog.Info("request denied", "path", c.Request.URL.Path, "client", c.Request.RemoteAddr, "reason", reason)
+			writeAuthError(c.Writer, c.Request.URL.Path, status, reason, wait)
+			c.Abort()
+			return
+		}
+
+		c.Set(apiKeyKey, key)
+		c.Next()
+	}
+}
+
+// authHandler requires an API key for requests to paths, which next
+// handles outside of gin, and passes other requests through
+func authHandler(keys *apiKeys, next http.Handler, paths ...string) http.Handler {
+	if keys == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(paths, r.URL.Path) {
+			scope, _ := scopeOf(r.URL.Path, r.URL.Path)
+			key, status, reason, wait := authorize(keys, r, scope)
+			if status != http.StatusOK {
+				slog.Info("request denied", "path", r.URL.Path, "client", r.RemoteAddr, "reason", reason)
+				writeAuthError(w, r.URL.Path, status, reason, wait)
+				return
+			}
+			slog.Debug("authorized request", "path", r.URL.Path, "key", key.name)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func keyHash(secret string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(secret)))
+}
+
+func writeAPIKeys(t *testing.T, path string, keys ...apiKeyConfig) {
+	t.Helper()
+
+	b, err := json.Marshal(apiKeysConfig{Keys: keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+}
+
+func TestReadAPIKeysConfig(t *testing.T) {
+	hash := keyHash("secret")
+
+	cases := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "valid", config: `{"keys": [{"name": "team", "hash": "` + hash + `", "scopes": ["inference", "pull"], "requests_per_minute": 60, "tokens_per_minute": 10000}]}`},
+		{name: "missing name", config: `{"keys": [{"hash": "` + hash + `"}]}`, err: "name is required"},
+		{name: "duplicate name", config: `{"keys": [{"name": "team", "hash": "` + hash + `"}, {"name": "team", "hash": "` + hash + `"}]}`, err: `duplicate key "team"`},
+		{name: "plain key", config: `{"keys": [{"name": "team", "hash": "secret"}]}`, err: "must start with sha256:"},
+		{name: "short hash", config: `{"keys": [{"name": "team", "hash": "sha256:abcd"}]}`, err: "isn't a SHA-256 digest"},
+		{name: "unknown scope", config: `{"keys": [{"name": "team", "hash": "` + hash + `", "scopes": ["delete"]}]}`, err: `unknown scope "delete"`},
+		{name: "negative limit", config: `{"keys": [{"name": "team", "hash": "` + hash + `", "requests_per_minute": -1}]}`, err: "can't be negative"},
+		{name: "unknown field", config: `{"keys": [{"name": "team", "key": "secret"}]}`, err: `unknown field "key"`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o600))
+
+			_, err := readAPIKeysConfig(path)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeAPIKeys(t, path,
+		apiKeyConfig{Name: "inference", Hash: keyHash("inference-key"), Scopes: []string{scopeInference}},
+		apiKeyConfig{Name: "puller", Hash: keyHash("pull-key"), Scopes: []string{scopeInference, scopePull}},
+		apiKeyConfig{Name: "admin", Hash: keyHash("admin-key"), Scopes: []string{scopeAdmin}},
+	)
+
+	keys, err := loadAPIKeys(path)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(authMiddleware(keys))
+	for _, route := range []string{"/", "/api/version", "/api/chat", "/api/pull", "/api/delete", "/v1/chat/completions", "/metrics"} {
+		r.Any(route, func(c *gin.Context) {
+			var name string
+			if k := apiKeyFrom(c); k != nil {
+				name = k.name
+			}
+			c.JSON(http.StatusOK, gin.H{"key": name})
+		})
+	}
+
+	cases := []struct {
+		path   string
+		key    string
+		status int
+		body   string
+	}{
+		{path: "/", status: http.StatusOK, body: `{"key":""}`},
+		{path: "/api/chat", status: http.StatusUnauthorized, body: `{"error":"missing api key"}`},
+		{path: "/api/chat", key: "wrong", status: http.StatusUnauthorized, body: `{"error":"invalid api key"}`},
+		{path: "/api/chat", key: "inference-key", status: http.StatusOK, body: `{"key":"inference"}`},
+		{path: "/api/version", key: "pull-key", status: http.StatusOK, body: `{"key":"puller"}`},
+		{path: "/api/pull", key: "inference-key", status: http.StatusForbidden, body: `{"error":"api key \"inference\" doesn't have the pull scope"}`},
+		{path: "/api/pull", key: "pull-key", status: http.StatusOK, body: `{"key":"puller"}`},
+		{path: "/api/delete", key: "pull-key", status: http.StatusForbidden},
+		{path: "/api/delete", key: "admin-key", status: http.StatusOK, body: `{"key":"admin"}`},
+		{path: "/api/unknown", key: "inference-key", status: http.StatusNotFound},
+		{path: "/v1/chat/completions", status: http.StatusUnauthorized, body: `{"error":{"message":"missing api key","type":"authentication_error","param":null,"code":null}}`},
+		{path: "/v1/chat/completions", key: "inference-key", status: http.StatusOK, body: `{"key":"inference"}`},
+		{path: "/metrics", key: "inference-key", status: http.StatusForbidden},
+		{path: "/metrics", key: "admin-key", status: http.StatusOK, body: `{"key":"admin"}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.path+" "+tt.key, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			if tt.body != "" {
+				require.JSONEq(t, tt.body, w.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized {
+				require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAPIKeyRateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeAPIKeys(t, path,
+		apiKeyConfig{Name: "requests", Hash: keyHash("requests-key"), Scopes: []string{scopeInference}, RequestsPerMinute: 2},
+		apiKeyConfig{Name: "tokens", Hash: keyHash("tokens-key"), Scopes: []string{scopeInference}, TokensPerMinute: 600},
+	)
+
+	keys, err := loadAPIKeys(path)
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	requests := keys.lookup("requests-key")
+	for range 2 {
+		ok, _ := requests.allow(now)
+		require.True(t, ok)
+	}
+
+	ok, wait := requests.allow(now)
+	require.False(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+
+	// a request is available again after half a minute
+	ok, _ = requests.allow(now.Add(30 * time.Second))
+	require.True(t, ok)
+
+	tokens := keys.lookup("tokens-key")
+	ok, _ = tokens.allow(now)
+	require.True(t, ok)
+
+	// a request can go over the limit, but requests are refused until the
+	// tokens are repaid
+	tokens.useTokens(899, now)
+	ok, wait = tokens.allow(now)
+	require.False(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+
+	ok, _ = tokens.allow(now.Add(30 * time.Second))
+	require.True(t, ok)
+}
+
+func TestAPIKeysReload(t *testing.T) {
+	interval := apiKeysReloadInterval
+	apiKeysReloadInterval = 0
+	t.Cleanup(func() { apiKeysReloadInterval = interval })
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeAPIKeys(t, path, apiKeyConfig{Name: "team", Hash: keyHash("old-key"), Scopes: []string{scopeInference}, RequestsPerMinute: 1})
+
+	keys, err := loadAPIKeys(path)
+	require.NoError(t, err)
+
+	key := keys.lookup("old-key")
+	require.NotNil(t, key)
+	ok, _ := key.allow(time.Now())
+	require.True(t, ok)
+
+	// the key is rotated, keeping its rate limit
+	writeAPIKeys(t, path, apiKeyConfig{Name: "team", Hash: keyHash("new-key"), Scopes: []string{scopeInference, scopePull}, RequestsPerMinute: 1})
+	require.NoError(t, os.Chtimes(path, time.Time{}, time.Now().Add(time.Minute)))
+
+	require.Nil(t, keys.lookup("old-key"))
+	require.Same(t, key, keys.lookup("new-key"))
+	require.True(t, key.hasScope(scopePull))
+	ok, _ = key.allow(time.Now())
+	require.False(t, ok)
+
+	// invalid changes are ignored
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Time{}, time.Now().Add(2*time.Minute)))
+	require.Same(t, key, keys.lookup("new-key"))
+}
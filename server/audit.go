@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+)
+
+// redacted replaces matches of the audit log's redaction rules
+const redacted = "[REDACTED]"
+
+// auditKey is the gin context key of the request's audit entry
+const auditKey = "audit"
+
+// auditLog writes an entry for each inference request to a JSONL file,
+// which is rotated when it grows past maxSize
+type auditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	// bodies includes prompts and responses in entries
+	bodies bool
+
+	// redact are the patterns replaced in prompts and responses
+	redact []*regexp.Regexp
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openAuditLog opens the audit log at path, configured from the environment
+func openAuditLog(path string) (*auditLog, error) {
+	var redact []*regexp.Regexp
+	if rules := envconfig.AuditRedact(); rules != "" {
+		var err error
+		redact, err = readRedactRules(rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newAuditLog(path, envconfig.AuditMaxSize(), int(envconfig.AuditMaxFiles()), envconfig.AuditBodies(), redact)
+}
+
+func newAuditLog(path string, maxSize int64, maxFiles int, bodies bool, redact []*regexp.Regexp) (*auditLog, error) {
+	l := &auditLog{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		bodies:   bodies,
+		redact:   redact,
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// readRedactRules reads regular expressions from path, one per line.
+// Blank lines and lines starting with # are ignored.
+func readRedactRules(path string) ([]*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []*regexp.Regexp
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		rules = append(rules, re)
+	}
+
+	return rules, scanner.Err()
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate renames the log to path.1, path.1 to path.2 and so on, removing
+// the oldest past maxFiles, and opens a new log. If the log can't be renamed
+// it's opened again so entries are still appended to it, and if it can't be
+// opened l.f is nil until a later write opens it. l.mu must be held.
+func (l *auditLog) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+
+	if rerr := l.rename(); rerr != nil {
+		err = errors.Join(err, rerr)
+	}
+
+	return errors.Join(err, l.open())
+}
+
+func (l *auditLog) rename() error {
+	if l.maxFiles <= 0 {
+		return os.Remove(l.path)
+	}
+
+	for i := l.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(l.path, l.path+".1")
+}
+
+func (l *auditLog) write(e *auditEntry) error {
+	if l.bodies {
+		e.Prompt = l.redactString(e.Prompt)
+		e.Response = l.redactString(e.response.String())
+		for i := range e.Messages {
+			e.Messages[i].Content = l.redactString(e.Messages[i].Content)
+			for j := range e.Messages[i].ToolCalls {
+				args := e.Messages[i].ToolCalls[j].Function.Arguments
+				e.Messages[i].ToolCalls[j].Function.Arguments = l.redactValue(map[string]any(args)).(map[string]any)
+			}
+		}
+		for i := range e.Input {
+			e.Input[i] = l.redactString(e.Input[i])
+		}
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			slog.Warn("unable to rotate audit log", "path", l.path, "error", err)
+		}
+	}
+
+	// the log couldn't be opened again after rotating
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func (l *auditLog) redactString(s string) string {
+	for _, re := range l.redact {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+// redactValue returns a copy of the decoded JSON value v with its strings
+// redacted
+func (l *auditLog) redactValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return l.redactString(v)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = l.redactValue(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			s[i] = l.redactValue(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// auditEntry is a line of the audit log. Handlers fill in the details of
+// the request through its methods, which do nothing on a nil entry.
+type auditEntry struct {
+	Time       time.Time      `json:"time"`
+	Client     string         `json:"client"`
+	Identity   string         `json:"identity,omitempty"`
+	Key        string         `json:"key,omitempty"`
+	Endpoint   string         `json:"endpoint"`
+	Status     int            `json:"status"`
+	Model      string         `json:"model,omitempty"`
+	Options    map[string]any `json:"options,omitempty"`
+	DoneReason string         `json:"done_reason,omitempty"`
+
+	PromptTokens   int   `json:"prompt_tokens"`
+	ResponseTokens int   `json:"response_tokens"`
+	DurationMs     int64 `json:"duration_ms"`
+
+	// bodies are only logged if enabled
+	Prompt   string         `json:"prompt,omitempty"`
+	Messages []auditMessage `json:"messages,omitempty"`
+	Input    []string       `json:"input,omitempty"`
+	Response string         `json:"response,omitempty"`
+
+	bodies   bool
+	response strings.Builder
+}
+
+type auditMessage struct {
+	Role      string         `json:"role"`
+	Content   string         `json:"content"`
+	ToolCalls []api.ToolCall `json:"tool_calls,omitempty"`
+}
+
+// auditFrom returns the audit entry of the request, or nil if the audit log
+// is disabled
+func auditFrom(c *gin.Context) *auditEntry {
+	e, _ := c.Value(auditKey).(*auditEntry)
+	return e
+}
+
+func (e *auditEntry) setRequest(model string, options map[string]any) {
+	if e == nil {
+		return
+	}
+
+	e.Model = model
+	e.Options = options
+}
+
+func (e *auditEntry) setPrompt(prompt string) {
+	if e == nil || !e.bodies {
+		return
+	}
+
+	e.Prompt = prompt
+}
+
+func (e *auditEntry) setMessages(msgs []api.Message) {
+	if e == nil || !e.bodies {
+		return
+	}
+
+	e.Messages = make([]auditMessage, len(msgs))
+	for i, m := range msgs {
+		e.Messages[i] = auditMessage{Role: m.Role, Content: m.Content, ToolCalls: slices.Clone(m.ToolCalls)}
+	}
+}
+
+func (e *auditEntry) setInput(input []string) {
+	if e == nil || !e.bodies {
+		return
+	}
+
+	e.Input = append([]string(nil), input...)
+}
+
+// addResponse records a streamed chunk of the response
+func (e *auditEntry) addResponse(content string) {
+	if e == nil || !e.bodies {
+		return
+	}
+
+	e.response.WriteString(content)
+}
+
+// setDone records the final response of a completion
+func (e *auditEntry) setDone(cr llm.CompletionResponse) {
+	if e == nil {
+		return
+	}
+
+	e.DoneReason = cr.DoneReason.String()
+	e.PromptTokens = cr.PromptEvalCount
+	e.ResponseTokens = cr.EvalCount
+}
+
+func (e *auditEntry) setPromptTokens(n int) {
+	if e == nil {
+		return
+	}
+
+	e.PromptTokens = n
+}
+
+// auditMiddleware writes an entry to l for each request once it has been
+// handled. It does nothing if l is nil.
+func auditMiddleware(l *auditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+
+		e := &auditEntry{
+			Time:     time.Now().UTC(),
+			Client:   c.Request.RemoteAddr,
+			Identity: clientIdentity(c.Request),
+			Endpoint: c.FullPath(),
+			bodies:   l.bodies,
+		}
+		if k := apiKeyFrom(c); k != nil {
+			e.Key = k.name
+		}
+		c.Set(auditKey, e)
+
+		c.Next()
+
+		e.Status = c.Writer.Status()
+		e.DurationMs = time.Since(e.Time).Milliseconds()
+		if err := l.write(e); err != nil {
+			slog.Error("failed to write audit log", "path", l.path, "error", err)
+		}
+	}
+}
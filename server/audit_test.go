@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func readAuditEntries(t *testing.T, path string) []auditEntry {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		name   string
+		bodies bool
+		want   auditEntry
+	}{
+		{
+			name: "counts",
+			want: auditEntry{
+				Client:         "192.0.2.1:1234",
+				Endpoint:       "/api/chat",
+				Status:         http.StatusOK,
+				Model:          "llama3.2",
+				Options:        map[string]any{"temperature": 0.5},
+				DoneReason:     "stop",
+				PromptTokens:   12,
+				ResponseTokens: 3,
+			},
+		},
+		{
+			name:   "bodies",
+			bodies: true,
+			want: auditEntry{
+				Client:         "192.0.2.1:1234",
+				Endpoint:       "/api/chat",
+				Status:         http.StatusOK,
+				Model:          "llama3.2",
+				Options:        map[string]any{"temperature": 0.5},
+				DoneReason:     "stop",
+				PromptTokens:   12,
+				ResponseTokens: 3,
+				Messages: []auditMessage{
+					{Role: "user", Content: "my key is [REDACTED]"},
+					{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{
+						Name:      "login",
+						Arguments: api.ToolCallFunctionArguments{"key": "[REDACTED]", "phones": []any{"call [REDACTED]"}, "retries": float64(3)},
+					}}}},
+				},
+				Response: "call [REDACTED]",
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			l, err := newAuditLog(path, 1<<20, 1, tt.bodies, []*regexp.Regexp{
+				regexp.MustCompile(`sk-[a-z0-9]+`),
+				regexp.MustCompile(`\d{3}-\d{4}`),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			r := gin.New()
+			r.POST("/api/chat", auditMiddleware(l), func(c *gin.Context) {
+				audit := auditFrom(c)
+				audit.setRequest("llama3.2", map[string]any{"temperature": 0.5})
+				msgs := []api.Message{
+					{Role: "user", Content: "my key is sk-abc123"},
+					{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{
+						Name:      "login",
+						Arguments: api.ToolCallFunctionArguments{"key": "sk-abc123", "phones": []any{"call 555-1234"}, "retries": 3},
+					}}}},
+				}
+				audit.setMessages(msgs)
+				audit.addResponse("call ")
+				audit.addResponse("555-1234")
+				audit.setDone(llm.CompletionResponse{DoneReason: llm.DoneReasonStop, PromptEvalCount: 12, EvalCount: 3})
+				c.JSON(http.StatusOK, gin.H{})
+
+				// the request's messages aren't redacted
+				if key := msgs[1].ToolCalls[0].Function.Arguments["key"]; key != "sk-abc123" {
+					t.Errorf("expected the request to be unchanged, got key %v", key)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader("{}"))
+			req.RemoteAddr = "192.0.2.1:1234"
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			entries := readAuditEntries(t, path)
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 entry, got %d", len(entries))
+			}
+
+			if entries[0].Time.IsZero() {
+				t.Error("expected a time")
+			}
+
+			if diff := cmp.Diff(tt.want, entries[0], cmpopts.IgnoreUnexported(auditEntry{}), cmpopts.IgnoreFields(auditEntry{}, "Time", "DurationMs")); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuditMiddlewareDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var called bool
+	r := gin.New()
+	r.POST("/api/generate", auditMiddleware(nil), func(c *gin.Context) {
+		audit := auditFrom(c)
+		if audit != nil {
+			t.Error("expected no audit entry")
+		}
+
+		// methods of a nil entry do nothing
+		audit.setRequest("llama3.2", nil)
+		audit.setPrompt("hello")
+		audit.addResponse("hi")
+		audit.setDone(llm.CompletionResponse{})
+		called = true
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", nil))
+	if !called {
+		t.Error("handler wasn't called")
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := newAuditLog(path, 100, 2, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, model := range []string{"a", "b", "c", "d"} {
+		if err := l.write(&auditEntry{Model: model}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each entry is bigger than half of the max size so every write after
+	// the first rotates the log, and only two rotated logs are kept
+	for file, model := range map[string]string{"audit.jsonl": "d", "audit.jsonl.1": "c", "audit.jsonl.2": "b"} {
+		entries := readAuditEntries(t, filepath.Join(dir, file))
+		if len(entries) != 1 || entries[0].Model != model {
+			t.Errorf("%s: expected model %s, got %+v", file, model, entries)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "audit.jsonl.3")); !os.IsNotExist(err) {
+		t.Errorf("expected audit.jsonl.3 to be removed, got %v", err)
+	}
+}
+
+func TestAuditLogRotateFailed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := newAuditLog(path, 100, 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the log can't be renamed over a directory
+	if err := os.MkdirAll(filepath.Join(dir, "audit.jsonl.1", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []string{"a", "b"} {
+		if err := l.write(&auditEntry{Model: model}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := readAuditEntries(t, path)
+	if len(entries) != 2 || entries[0].Model != "a" || entries[1].Model != "b" {
+		t.Errorf("expected models a and b, got %+v", entries)
+	}
+}
+
+func TestAuditLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := newAuditLog(path, 100, 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.write(&auditEntry{Model: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// closing the log fails when rotating but it's still rotated
+	if err := l.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.write(&auditEntry{Model: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := readAuditEntries(t, path+".1"); len(entries) != 1 || entries[0].Model != "a" {
+		t.Errorf("expected model a in the rotated log, got %+v", entries)
+	}
+
+	// the log can be neither renamed nor opened while it's replaced with
+	// a directory, and rotated logs can't be renamed over a directory
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.write(&auditEntry{Model: "c"}); err == nil {
+		t.Fatal("expected an error writing while the log can't be opened")
+	}
+	if l.f != nil {
+		t.Fatal("expected the log to be closed")
+	}
+
+	// the log is opened again once it can be
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.write(&auditEntry{Model: "d"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := readAuditEntries(t, path); len(entries) != 1 || entries[0].Model != "d" {
+		t.Errorf("expected model d, got %+v", entries)
+	}
+}
+
+func TestReadRedactRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redact")
+	if err := os.WriteFile(path, []byte("# api keys\nsk-[a-z0-9]+\n\n  \\d{3}-\\d{4}  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := readRedactRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, re := range rules {
+		got = append(got, re.String())
+	}
+
+	if diff := cmp.Diff([]string{`sk-[a-z0-9]+`, `\d{3}-\d{4}`}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte("valid\n(unclosed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readRedactRules(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -36,6 +37,7 @@ var (
 	errUnknownType             = errors.New("unknown type")
 	errNeitherFromOrFiles      = errors.New("neither 'from' or 'files' was specified")
 	errFilePath                = errors.New("file path must be relative")
+	errImatrixNeedsQuantize    = errors.New("imatrix requires quantize")
 )
 
 func (s *Server) CreateHandler(c *gin.Context) {
@@ -55,6 +57,27 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		}
 	}
 
+	if r.Imatrix != "" {
+		if cmp.Or(r.Quantize, r.Quantization) == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errImatrixNeedsQuantize.Error()})
+			return
+		}
+
+		p, err := GetBlobsPath(r.Imatrix)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("imatrix dataset %s not found", r.Imatrix)})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	name := model.ParseName(cmp.Or(r.Model, r.Name))
 	if !name.IsValid() {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
@@ -67,6 +90,11 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkNotAlias(name); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -335,11 +363,31 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 					return err
 				}
 
-				ft := layer.GGML.KV().FileType()
-				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
-					return errors.New("quantization is only supported for F16 and F32 models")
-				} else if ft != want {
-					layer, err = quantizeLayer(layer, quantType, fn)
+				if layer.GGML.KV().FileType() != want {
+					if !slices.Contains(ggml.QuantizeFileTypes, want.String()) && (len(r.TensorTypes) > 0 || r.Imatrix != "") {
+						return fmt.Errorf("tensor types and imatrix aren't supported when quantizing to %s, use one of %s", want, strings.Join(ggml.QuantizeFileTypes, ", "))
+					}
+
+					opts := ggml.QuantizeOptions{TensorTypes: r.TensorTypes}
+					if r.Imatrix != "" {
+						opts.Imatrix, err = calibrate(layer, r.Imatrix, fn)
+						if err != nil {
+							return err
+						}
+
+						digest := sha256.New()
+						if _, err := opts.Imatrix.WriteTo(digest); err != nil {
+							return err
+						}
+
+						config.Imatrix = &api.ImatrixDetails{
+							Digest:  fmt.Sprintf("sha256:%x", digest.Sum(nil)),
+							Dataset: r.Imatrix,
+							Chunks:  opts.Imatrix.Chunks,
+						}
+					}
+
+					layer, err = quantizeLayer(layer, quantType, opts, fn)
 					if err != nil {
 						return err
 					}
@@ -404,6 +452,16 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		return err
 	}
 
+	layers, err = setTools(layers, r.Tools)
+	if err != nil {
+		return err
+	}
+
+	layers, err = setFormat(layers, r.Format)
+	if err != nil {
+		return err
+	}
+
 	configLayer, err := createConfigLayer(layers, config)
 	if err != nil {
 		return err
@@ -423,9 +481,10 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 	return nil
 }
 
-func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+func quantizeLayer(layer *layerGGML, quantizeType string, opts ggml.QuantizeOptions, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
 	ft := layer.GGML.KV().FileType()
-	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
+	status := fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)
+	fn(api.ProgressResponse{Status: status})
 
 	want, err := ggml.ParseFileType(quantizeType)
 	if err != nil {
@@ -437,14 +496,47 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 		return nil, err
 	}
 
-	temp, err := os.CreateTemp(filepath.Dir(blob), quantizeType)
+	src, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	blobs, err := getWritableBlobsPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(blobs, quantizeType)
 	if err != nil {
 		return nil, err
 	}
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
+	if !slices.Contains(ggml.QuantizeFileTypes, want.String()) {
+		// types the Go quantizer doesn't implement are quantized by llama.cpp
+		if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
+			return nil, fmt.Errorf("quantizing to %s is only supported for F16 and F32 models", want)
+		}
+
+		if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
+			return nil, err
+		}
+	} else {
+		opts.Progress = func(tensor string, completed, total uint64) {
+			slog.Debug("quantized tensor", "name", tensor)
+			fn(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+		}
+
+		if err := ggml.Quantize(temp, src, want, opts); errors.Is(err, ggml.ErrQuantizeUnsupported) {
+			return nil, fmt.Errorf("quantization is only supported for F32, F16, BF16 and Q8_0 models: %w", err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
 
@@ -666,6 +758,49 @@ func setMessages(layers []Layer, m []api.Message) ([]Layer, error) {
 	return layers, nil
 }
 
+func setTools(layers []Layer, t api.Tools) ([]Layer, error) {
+	// like messages, the tools of the base model are kept unless new ones
+	// are specified
+	if len(t) == 0 {
+		return layers, nil
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.tools")
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(t); err != nil {
+		return nil, err
+	}
+	layer, err := NewLayer(&b, "application/vnd.ollama.image.tools")
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, layer)
+	return layers, nil
+}
+
+func setFormat(layers []Layer, f json.RawMessage) ([]Layer, error) {
+	if len(f) == 0 {
+		return layers, nil
+	}
+
+	if !json.Valid(f) {
+		return nil, errors.New("format must be \"json\" or a JSON schema")
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, f); err != nil {
+		return nil, err
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.format")
+	layer, err := NewLayer(&b, "application/vnd.ollama.image.format")
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, layer)
+	return layers, nil
+}
+
 func createConfigLayer(layers []Layer, config ConfigV2) (*Layer, error) {
 	digests := make([]string, len(layers))
 	for i, layer := range layers {
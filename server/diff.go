@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+// maxDiffValueSize is the size, in bytes of JSON, above which array values
+// in a diff are summarized by their length, e.g. tokenizer vocabularies.
+const maxDiffValueSize = 256
+
+// diffModels compares the models named from and to layer by layer. For model
+// layers which differ, the GGUF metadata and tensors are compared as well.
+func diffModels(from, to model.Name) (*api.DiffResponse, error) {
+	mf, err := ParseNamedManifest(from)
+	if err != nil {
+		return nil, err
+	}
+	mt, err := ParseNamedManifest(to)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := GetModel(from.String())
+	if err != nil {
+		return nil, err
+	}
+	b, err := GetModel(to.String())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.DiffResponse{
+		From:   from.DisplayShortest(),
+		To:     to.DisplayShortest(),
+		Layers: diffLayers(mf.Layers, mt.Layers),
+	}
+
+	// models such as adapters or templates on their own don't have a model
+	// layer to compare
+	if a.ModelPath != b.ModelPath && a.ModelPath != "" && b.ModelPath != "" {
+		resp.ModelInfo, resp.Tensors, err = diffGGUF(a.ModelPath, b.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp.Template = diffValue("template", a.Template.String(), b.Template.String())
+	resp.System = diffValue("system", a.System, b.System)
+	resp.License = diffValue("license", strings.Join(a.License, "\n"), strings.Join(b.License, "\n"))
+	resp.Parameters = diffMaps(a.Options, b.Options)
+	return resp, nil
+}
+
+// diffLayers pairs the layers of two manifests by media type, in the order
+// they appear, and reports the status of each pair.
+func diffLayers(from, to []Layer) []api.LayerDiff {
+	byType := func(layers []Layer) map[string][]Layer {
+		m := make(map[string][]Layer)
+		for _, l := range layers {
+			m[l.MediaType] = append(m[l.MediaType], l)
+		}
+		return m
+	}
+
+	a, b := byType(from), byType(to)
+
+	var mediaTypes []string
+	for _, l := range slices.Concat(from, to) {
+		if !slices.Contains(mediaTypes, l.MediaType) {
+			mediaTypes = append(mediaTypes, l.MediaType)
+		}
+	}
+
+	var diffs []api.LayerDiff
+	for _, mt := range mediaTypes {
+		for i := range max(len(a[mt]), len(b[mt])) {
+			d := api.LayerDiff{MediaType: mt}
+			if i < len(a[mt]) {
+				d.From, d.FromSize = a[mt][i].Digest, a[mt][i].Size
+			}
+			if i < len(b[mt]) {
+				d.To, d.ToSize = b[mt][i].Digest, b[mt][i].Size
+			}
+
+			switch {
+			case d.From == "":
+				d.Status = "added"
+			case d.To == "":
+				d.Status = "removed"
+			case d.From != d.To:
+				d.Status = "changed"
+			default:
+				d.Status = "unchanged"
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+func decodeGGUF(path string) (*ggml.GGML, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// arrays are read in full so differences in e.g. the vocabulary aren't
+	// hidden by truncation
+	g, _, err := ggml.Decode(f, -1)
+	return g, err
+}
+
+// diffGGUF compares the metadata and tensors of two GGUF files.
+func diffGGUF(from, to string) ([]api.ValueDiff, []api.TensorDiff, error) {
+	a, err := decodeGGUF(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := decodeGGUF(to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kv := diffMaps(a.KV(), b.KV())
+
+	tensors := func(g *ggml.GGML) map[string]*ggml.Tensor {
+		m := make(map[string]*ggml.Tensor)
+		for _, t := range g.Tensors().Items() {
+			m[t.Name] = t
+		}
+		return m
+	}
+
+	ta, tb := tensors(a), tensors(b)
+
+	var diffs []api.TensorDiff
+	for _, name := range sortedKeys(ta, tb) {
+		d := api.TensorDiff{Name: name}
+		if t, ok := ta[name]; ok {
+			d.From = &api.Tensor{Name: t.Name, Type: t.Type(), Shape: t.Shape}
+		}
+		if t, ok := tb[name]; ok {
+			d.To = &api.Tensor{Name: t.Name, Type: t.Type(), Shape: t.Shape}
+		}
+		if d.From != nil && d.To != nil && d.From.Type == d.To.Type && slices.Equal(d.From.Shape, d.To.Shape) {
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+
+	return kv, diffs, nil
+}
+
+// diffMaps reports the keys whose values differ between a and b, in key
+// order.
+func diffMaps[M ~map[string]any](a, b M) []api.ValueDiff {
+	var diffs []api.ValueDiff
+	for _, k := range sortedKeys(a, b) {
+		va, oka := a[k]
+		vb, okb := b[k]
+		if oka && okb && reflect.DeepEqual(va, vb) {
+			continue
+		}
+
+		d := api.ValueDiff{Key: k}
+		if oka {
+			d.From = diffSummary(va)
+		}
+		if okb {
+			d.To = diffSummary(vb)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+func diffValue(key, a, b string) *api.ValueDiff {
+	if a == b {
+		return nil
+	}
+
+	d := api.ValueDiff{Key: key}
+	if a != "" {
+		d.From = a
+	}
+	if b != "" {
+		d.To = b
+	}
+	return &d
+}
+
+// diffSummary returns v, or a short description of v if it is a large array.
+func diffSummary(v any) any {
+	bts, err := json.Marshal(v)
+	if err != nil || len(bts) <= maxDiffValueSize || bts[0] != '[' {
+		return v
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(bts, &values); err != nil {
+		return v
+	}
+	return fmt.Sprintf("[%d values]", len(values))
+}
+
+// sortedKeys returns the union of the keys of ms in sorted order.
+func sortedKeys[V any, M ~map[string]V](ms ...M) []string {
+	set := make(map[string]struct{})
+	for _, m := range ms {
+		for k := range m {
+			set[k] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(set))
+}
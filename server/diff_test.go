@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestDiff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	_, a := createBinFile(t, ggml.KV{
+		"general.architecture": "test",
+		"test.context_length":  uint32(2048),
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 16))},
+		{Name: "output.weight", Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 16))},
+	})
+
+	_, b := createBinFile(t, ggml.KV{
+		"general.architecture": "test",
+		"test.context_length":  uint32(4096),
+		"test.rope.freq_base":  float32(10000),
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 16))},
+		{Name: "output.weight", Kind: 1, Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 8))},
+		{Name: "output_norm.weight", Shape: []uint64{2}, WriterTo: bytes.NewReader(make([]byte, 8))},
+	})
+
+	for _, req := range []api.CreateRequest{
+		{Name: "a", Files: map[string]string{"a.gguf": a}, Parameters: map[string]any{"temperature": 1}},
+		{Name: "b", Files: map[string]string{"b.gguf": b}, Parameters: map[string]any{"temperature": 0.5}, System: "Be brief."},
+		{Name: "c", Files: map[string]string{"a.gguf": a}, Parameters: map[string]any{"temperature": 1}},
+	} {
+		w := createRequest(t, s.CreateHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	diff := func(t *testing.T, from, to string) api.DiffResponse {
+		t.Helper()
+		w := createRequest(t, s.DiffHandler, api.DiffRequest{From: from, To: to})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.DiffResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	t.Run("changed", func(t *testing.T) {
+		resp := diff(t, "a", "b")
+
+		statuses := make(map[string]string)
+		for _, l := range resp.Layers {
+			statuses[l.MediaType] = l.Status
+		}
+		if diff := cmp.Diff(map[string]string{
+			"application/vnd.ollama.image.model":  "changed",
+			"application/vnd.ollama.image.params": "changed",
+			"application/vnd.ollama.image.system": "added",
+		}, statuses); diff != "" {
+			t.Errorf("layers mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff([]api.ValueDiff{
+			{Key: "general.parameter_count", From: float64(8), To: float64(10)},
+			{Key: "test.context_length", From: float64(2048), To: float64(4096)},
+			{Key: "test.rope.freq_base", To: float64(10000)},
+		}, resp.ModelInfo); diff != "" {
+			t.Errorf("model info mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff([]api.TensorDiff{
+			{Name: "output.weight", From: &api.Tensor{Name: "output.weight", Type: "F32", Shape: []uint64{2, 2}}, To: &api.Tensor{Name: "output.weight", Type: "F16", Shape: []uint64{2, 2}}},
+			{Name: "output_norm.weight", To: &api.Tensor{Name: "output_norm.weight", Type: "F32", Shape: []uint64{2}}},
+		}, resp.Tensors); diff != "" {
+			t.Errorf("tensors mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(&api.ValueDiff{Key: "system", To: "Be brief."}, resp.System); diff != "" {
+			t.Errorf("system mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff([]api.ValueDiff{
+			{Key: "temperature", From: float64(1), To: 0.5},
+		}, resp.Parameters); diff != "" {
+			t.Errorf("parameters mismatch (-want +got):\n%s", diff)
+		}
+
+		if resp.Template != nil || resp.License != nil {
+			t.Errorf("unexpected template or license diff: %v %v", resp.Template, resp.License)
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		resp := diff(t, "a", "c")
+		for _, l := range resp.Layers {
+			if l.Status != "unchanged" {
+				t.Errorf("layer %s: status = %q, want unchanged", l.MediaType, l.Status)
+			}
+		}
+		if resp.ModelInfo != nil || resp.Tensors != nil || resp.Parameters != nil {
+			t.Errorf("unexpected differences: %+v", resp)
+		}
+	})
+
+	t.Run("no model layer", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(&ConfigV2{}); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := NewLayer(&b, "application/vnd.docker.container.image.v1+json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tmpl, err := NewLayer(strings.NewReader("{{ .Prompt }}"), "application/vnd.ollama.image.template")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WriteManifest(model.ParseName("template"), config, []Layer{tmpl}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := diff(t, "a", "template")
+		if resp.ModelInfo != nil || resp.Tensors != nil {
+			t.Errorf("unexpected model differences: %v %v", resp.ModelInfo, resp.Tensors)
+		}
+
+		statuses := make(map[string]string)
+		for _, l := range resp.Layers {
+			statuses[l.MediaType] = l.Status
+		}
+		if diff := cmp.Diff(map[string]string{
+			"application/vnd.ollama.image.model":    "removed",
+			"application/vnd.ollama.image.params":   "removed",
+			"application/vnd.ollama.image.template": "added",
+		}, statuses); diff != "" {
+			t.Errorf("layers mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		w := createRequest(t, s.DiffHandler, api.DiffRequest{From: "a", To: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code 404, actual %d", w.Code)
+		}
+	})
+}
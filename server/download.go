@@ -1,7 +1,10 @@
 package server
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +16,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,7 +27,12 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/registry"
+	"github.com/ollama/ollama/types/model"
 )
 
 const maxRetries = 6
@@ -32,6 +41,7 @@ var (
 	errMaxRetriesExceeded   = errors.New("max retries exceeded")
 	errPartStalled          = errors.New("part stalled")
 	errMaxRedirectsExceeded = errors.New("maximum redirects exceeded (10) for directURL")
+	errPullPaused           = registry.ErrPullPaused
 )
 
 var blobDownloadManager sync.Map
@@ -43,8 +53,27 @@ type blobDownload struct {
 	Total     int64
 	Completed atomic.Int64
 
+	// Reused is the number of bytes of Completed that were copied from
+	// local blobs instead of being downloaded.
+	Reused atomic.Int64
+
 	Parts []*blobDownloadPart
 
+	// prev are the digests of local blobs that may share chunks with this
+	// blob, such as the layer it replaces in an updated model.
+	prev []string
+
+	// chunks are the chunks of the blob listed by the registry, if it
+	// lists them
+	chunks []chunksum
+
+	// limiter limits the download rate of this blob to the lowest limit
+	// of the pulls waiting on it, in addition to the server wide
+	// pullRateLimiter. limits are the limits of those pulls.
+	limiter  rateLimiter
+	limitsMu sync.Mutex
+	limits   []int64
+
 	context.CancelFunc
 
 	done       chan struct{}
@@ -58,6 +87,9 @@ type blobDownloadPart struct {
 	Size      int64
 	Completed atomic.Int64
 
+	// Reused is set if the part was copied from a local blob
+	Reused bool
+
 	lastUpdatedMu sync.Mutex
 	lastUpdated   time.Time
 
@@ -69,6 +101,7 @@ type jsonBlobDownloadPart struct {
 	Offset    int64
 	Size      int64
 	Completed int64
+	Reused    bool `json:",omitempty"`
 }
 
 func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
@@ -77,6 +110,7 @@ func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
 		Offset:    p.Offset,
 		Size:      p.Size,
 		Completed: p.Completed.Load(),
+		Reused:    p.Reused,
 	})
 }
 
@@ -89,6 +123,7 @@ func (p *blobDownloadPart) UnmarshalJSON(b []byte) error {
 		N:      j.N,
 		Offset: j.Offset,
 		Size:   j.Size,
+		Reused: j.Reused,
 	}
 	p.Completed.Store(j.Completed)
 	return nil
@@ -139,6 +174,9 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 
 		b.Total += part.Size
 		b.Completed.Add(part.Completed.Load())
+		if part.Reused {
+			b.Reused.Add(part.Size)
+		}
 		b.Parts = append(b.Parts, part)
 	}
 
@@ -159,17 +197,35 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 			size = maxDownloadPartSize
 		}
 
+		// requestURL is .../<repository>/blobs/<digest>
+		chunksumsURL := requestURL.JoinPath("..", "..", "chunksums", b.Digest)
+		reused, err := b.reuseChunks(ctx, chunksumsURL, opts)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("unable to reuse local data for %s: %v", b.Digest[7:19], err))
+			reused = nil
+		}
+
+		// download what couldn't be reused in parts of up to size
 		var offset int64
-		for offset < b.Total {
-			if offset+size > b.Total {
-				size = b.Total - offset
+		for _, r := range append(reused, blobRange{start: b.Total, end: b.Total}) {
+			for offset < r.start {
+				n := min(size, r.start-offset)
+				if err := b.newPart(offset, n, false); err != nil {
+					return err
+				}
+				offset += n
 			}
 
-			if err := b.newPart(offset, size); err != nil {
-				return err
+			if r.end > r.start {
+				if err := b.newPart(r.start, r.end-r.start, true); err != nil {
+					return err
+				}
+				offset = r.end
 			}
+		}
 
-			offset += size
+		if n := b.Reused.Load(); n > 0 {
+			slog.Info(fmt.Sprintf("reused %s of %s from local blobs", format.HumanBytes(n), b.Digest[7:19]))
 		}
 	}
 
@@ -180,6 +236,171 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 	return nil
 }
 
+// blobRange is the range of bytes [start, end) of a blob
+type blobRange struct {
+	start, end int64
+}
+
+// chunksum is a chunk of a blob listed by the registry's chunksums endpoint
+type chunksum struct {
+	digest string
+	blobRange
+}
+
+// reuseChunks copies the chunks of the blob listed by the registry's
+// chunksums endpoint that are already stored locally to the partial file,
+// and returns the ranges that were copied. As in chunked pulls by
+// Registry.Pull, a chunk is looked for in a blob with the chunk's digest,
+// where the chunk index says a previous pull of any blob stored it, and at
+// the same range in each of b.prev. Each chunk is checked against its
+// digest so only matching data is kept.
+func (b *blobDownload) reuseChunks(ctx context.Context, chunksumsURL *url.URL, opts *registryOptions) ([]blobRange, error) {
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, chunksumsURL, nil, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// each line is "<digest> <start>-<end>", where end is inclusive
+	var chunks []chunksum
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var c chunksum
+		if _, err := fmt.Sscanf(scanner.Text(), "%s %d-%d", &c.digest, &c.start, &c.end); err != nil {
+			return nil, fmt.Errorf("invalid chunksum %q: %w", scanner.Text(), err)
+		}
+		c.end++
+
+		if c.start < 0 || c.end > b.Total || c.start >= c.end {
+			return nil, fmt.Errorf("invalid chunk range %d-%d", c.start, c.end-1)
+		}
+
+		chunks = append(chunks, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	b.chunks = chunks
+
+	cache, err := blob.Open(envconfig.Models())
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(b.Name+"-partial", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	setSparse(file)
+
+	if err := file.Truncate(b.Total); err != nil {
+		return nil, err
+	}
+
+	// local blobs opened so far, nil if they can't be read
+	blobs := make(map[string]*os.File)
+	defer func() {
+		for _, f := range blobs {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+
+	open := func(digest string) *os.File {
+		if f, ok := blobs[digest]; ok || digest == b.Digest {
+			return f
+		}
+
+		var f *os.File
+		if fp, err := GetBlobsPath(digest); err == nil {
+			f, _ = os.Open(fp)
+		}
+		blobs[digest] = f
+		return f
+	}
+
+	var reused []blobRange
+	for _, c := range chunks {
+		size := c.end - c.start
+
+		type source struct {
+			digest string
+			offset int64
+		}
+
+		sources := []source{{c.digest, 0}}
+		if d, err := blob.ParseDigest(c.digest); err == nil {
+			if src, chunk, err := cache.ChunkSource(d); err == nil && chunk.Size() == size {
+				sources = append(sources, source{src.String(), chunk.Start})
+			}
+		}
+		for _, digest := range b.prev {
+			sources = append(sources, source{digest, c.start})
+		}
+
+		for _, src := range sources {
+			f := open(src.digest)
+			if f == nil {
+				continue
+			}
+
+			h := sha256.New()
+			w := io.NewOffsetWriter(file, c.start)
+			n, err := io.Copy(io.MultiWriter(w, h), io.NewSectionReader(f, src.offset, size))
+			if err != nil {
+				return nil, err
+			}
+
+			if n == size && fmt.Sprintf("sha256:%x", h.Sum(nil)) == c.digest {
+				if i := len(reused) - 1; i >= 0 && reused[i].end == c.start {
+					reused[i].end = c.end
+				} else {
+					reused = append(reused, c.blobRange)
+				}
+				break
+			}
+		}
+	}
+
+	return reused, nil
+}
+
+// indexChunks records where the chunks of the downloaded blob are in the
+// chunk index shared with Registry.Pull, so later pulls of any blob can
+// reuse them
+func (b *blobDownload) indexChunks() {
+	if len(b.chunks) == 0 {
+		return
+	}
+
+	cache, err := blob.Open(envconfig.Models())
+	if err != nil {
+		slog.Debug("unable to index chunks", "digest", b.Digest, "error", err)
+		return
+	}
+
+	src, err := blob.ParseDigest(b.Digest)
+	if err != nil {
+		return
+	}
+
+	for _, c := range b.chunks {
+		d, err := blob.ParseDigest(c.digest)
+		if err != nil {
+			continue
+		}
+
+		if err := cache.PutChunkSource(d, src, blob.Chunk{Start: c.start, End: c.end - 1}); err != nil {
+			slog.Debug("unable to index chunks", "digest", b.Digest, "error", err)
+			return
+		}
+	}
+}
+
 func (b *blobDownload) Run(ctx context.Context, requestURL *url.URL, opts *registryOptions) {
 	defer close(b.done)
 	b.err = b.run(ctx, requestURL, opts)
@@ -323,6 +544,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 		return err
 	}
 
+	b.indexChunks()
 	return nil
 }
 
@@ -340,7 +562,10 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 		}
 		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
+		var body io.Reader = resp.Body
+		body = &rateLimitedReader{ctx: ctx, r: body, limiters: b.limiters()}
+
+		n, err := io.CopyN(w, io.TeeReader(body, part), part.Size-part.Completed.Load())
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			// rollback progress
 			b.Completed.Add(-n)
@@ -387,8 +612,52 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 	return g.Wait()
 }
 
-func (b *blobDownload) newPart(offset, size int64) error {
-	part := blobDownloadPart{blobDownload: b, Offset: offset, Size: size, N: len(b.Parts)}
+// limiters returns the rate limiters that apply to this download.
+func (b *blobDownload) limiters() []*rateLimiter {
+	limiters := []*rateLimiter{&b.limiter}
+	if l := pullRateLimiter(); l != nil {
+		limiters = append(limiters, l)
+	}
+	return limiters
+}
+
+// attachLimit applies the download rate limit of a pull waiting on this
+// blob, with zero being no limit, until the returned function is called.
+// The lowest limit of the pulls waiting applies.
+func (b *blobDownload) attachLimit(limit int64) (detach func()) {
+	if limit <= 0 {
+		return func() {}
+	}
+
+	b.limitsMu.Lock()
+	b.limits = append(b.limits, limit)
+	b.limiter.setRate(slices.Min(b.limits))
+	b.limitsMu.Unlock()
+
+	return func() {
+		b.limitsMu.Lock()
+		defer b.limitsMu.Unlock()
+
+		i := slices.Index(b.limits, limit)
+		b.limits = slices.Delete(b.limits, i, i+1)
+		if len(b.limits) > 0 {
+			b.limiter.setRate(slices.Min(b.limits))
+		} else {
+			b.limiter.setRate(0)
+		}
+	}
+}
+
+// newPart adds a part of the blob to download, or a part that's already
+// been copied from a local blob if reused is set
+func (b *blobDownload) newPart(offset, size int64, reused bool) error {
+	part := blobDownloadPart{blobDownload: b, Offset: offset, Size: size, N: len(b.Parts), Reused: reused}
+	if reused {
+		part.Completed.Store(size)
+		b.Completed.Add(size)
+		b.Reused.Add(size)
+	}
+
 	if err := b.writePart(part.Name(), &part); err != nil {
 		return err
 	}
@@ -448,6 +717,7 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 				Digest:    b.Digest,
 				Total:     b.Total,
 				Completed: b.Completed.Load(),
+				Reused:    b.Reused.Load(),
 			})
 		case <-ctx.Done():
 			return ctx.Err()
@@ -460,6 +730,13 @@ type downloadOpts struct {
 	digest  string
 	regOpts *registryOptions
 	fn      func(api.ProgressResponse)
+
+	// limitRate, if positive, limits the download rate of the blob while
+	// this pull waits on it
+	limitRate int64
+
+	// prev are local blobs that may share chunks with the blob
+	prev []string
 }
 
 // downloadBlob downloads a blob from the registry and stores it in the blobs directory
@@ -485,8 +762,12 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 		return true, nil
 	}
 
-	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
+	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest, prev: opts.prev})
 	download := data.(*blobDownload)
+
+	detach := download.attachLimit(opts.limitRate)
+	defer detach()
+
 	if !ok {
 		requestURL := opts.mp.BaseURL()
 		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
@@ -501,3 +782,124 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 
 	return false, download.Wait(ctx, opts.fn)
 }
+
+// pullSlots limits the number of models pulled at once so concurrent pulls do
+// not compete for bandwidth. It is configured by OLLAMA_MAX_PULLS and is nil
+// when there is no limit.
+var pullSlots = sync.OnceValue(func() chan struct{} {
+	if n := envconfig.MaxPulls(); n > 0 {
+		return make(chan struct{}, n)
+	}
+	return nil
+})
+
+// acquirePullSlot blocks until the caller may start pulling a model, reporting
+// through fn if the pull has to wait. Waiting pulls are admitted in the order
+// they arrived. The returned function must be called to release the slot.
+func acquirePullSlot(ctx context.Context, fn func(api.ProgressResponse)) (release func(), _ error) {
+	slots := pullSlots()
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		fn(api.ProgressResponse{Status: "waiting for other pulls to finish"})
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() { <-slots }, nil
+}
+
+// activePulls tracks in-flight pulls by model name so they can be paused.
+var activePulls = struct {
+	mu   sync.Mutex
+	next int
+	m    map[string]map[int]context.CancelCauseFunc
+}{m: make(map[string]map[int]context.CancelCauseFunc)}
+
+// trackPull registers cancel as the cancel function of an in-flight pull of
+// name. The returned function must be called when the pull is finished.
+func trackPull(name model.Name, cancel context.CancelCauseFunc) (untrack func()) {
+	key := strings.ToLower(name.String())
+
+	activePulls.mu.Lock()
+	defer activePulls.mu.Unlock()
+
+	id := activePulls.next
+	activePulls.next++
+	if activePulls.m[key] == nil {
+		activePulls.m[key] = make(map[int]context.CancelCauseFunc)
+	}
+	activePulls.m[key][id] = cancel
+
+	return func() {
+		activePulls.mu.Lock()
+		defer activePulls.mu.Unlock()
+		delete(activePulls.m[key], id)
+		if len(activePulls.m[key]) == 0 {
+			delete(activePulls.m, key)
+		}
+	}
+}
+
+// pausePulls cancels all in-flight pulls of name with [errPullPaused] and
+// reports how many were paused. Downloaded parts are kept on disk, so pulling
+// the model again resumes where the paused pull stopped.
+func pausePulls(name model.Name) int {
+	activePulls.mu.Lock()
+	defer activePulls.mu.Unlock()
+
+	pulls := activePulls.m[strings.ToLower(name.String())]
+	for _, cancel := range pulls {
+		cancel(errPullPaused)
+	}
+	return len(pulls)
+}
+
+// startRegistryPull is [registry.Local.StartPull] for pulls by the registry
+// client. Like pulls by [PullModel], they wait for a slot from OLLAMA_MAX_PULLS,
+// can be paused with [pausePulls], and are limited by OLLAMA_PULL_RATE_LIMIT
+// and limitRate.
+func startRegistryPull(ctx context.Context, name string, limitRate int64, status func(string)) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	untrack := trackPull(model.ParseName(name), cancel)
+
+	release, err := acquirePullSlot(ctx, func(p api.ProgressResponse) { status(p.Status) })
+	if err != nil {
+		// report errPullPaused if the pull was paused while waiting
+		err = cmp.Or(context.Cause(ctx), err)
+		untrack()
+		cancel(nil)
+		return nil, nil, err
+	}
+
+	var limiters []*rateLimiter
+	for _, l := range []*rateLimiter{pullRateLimiter(), newRateLimiter(limitRate)} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+
+	if len(limiters) > 0 {
+		ctx = ollama.WithLimit(ctx, func(ctx context.Context, n int) error {
+			for _, l := range limiters {
+				if err := l.wait(ctx, n); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return ctx, func() {
+		release()
+		untrack()
+		cancel(nil)
+	}, nil
+}
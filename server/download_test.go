@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestPausePulls(t *testing.T) {
+	name := model.ParseName("llama3.2")
+
+	if n := pausePulls(name); n != 0 {
+		t.Fatalf("paused %d pulls, want 0", n)
+	}
+
+	ctx1, cancel1 := context.WithCancelCause(t.Context())
+	untrack1 := trackPull(name, cancel1)
+	defer untrack1()
+
+	ctx2, cancel2 := context.WithCancelCause(t.Context())
+	untrack2 := trackPull(model.ParseName("LLAMA3.2"), cancel2)
+	defer untrack2()
+
+	other, cancelOther := context.WithCancelCause(t.Context())
+	untrackOther := trackPull(model.ParseName("gemma3"), cancelOther)
+	defer untrackOther()
+
+	if n := pausePulls(name); n != 2 {
+		t.Fatalf("paused %d pulls, want 2", n)
+	}
+
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		if err := context.Cause(ctx); !errors.Is(err, errPullPaused) {
+			t.Errorf("cause = %v, want %v", err, errPullPaused)
+		}
+	}
+
+	if err := other.Err(); err != nil {
+		t.Errorf("unrelated pull was canceled: %v", err)
+	}
+
+	untrack1()
+	untrack2()
+	if n := pausePulls(name); n != 0 {
+		t.Fatalf("paused %d pulls after untrack, want 0", n)
+	}
+}
+
+func TestStartRegistryPull(t *testing.T) {
+	var statuses []string
+	ctx, done, err := startRegistryPull(t.Context(), "llama3.2", 1024, func(status string) {
+		statuses = append(statuses, status)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) > 0 {
+		t.Errorf("unexpected statuses %v without OLLAMA_MAX_PULLS", statuses)
+	}
+
+	// pulls by the registry client are paused like any other
+	if n := pausePulls(model.ParseName("llama3.2")); n != 1 {
+		t.Fatalf("paused %d pulls, want 1", n)
+	}
+
+	if err := context.Cause(ctx); !errors.Is(err, errPullPaused) {
+		t.Errorf("cause = %v, want %v", err, errPullPaused)
+	}
+
+	done()
+	if n := pausePulls(model.ParseName("llama3.2")); n != 0 {
+		t.Fatalf("paused %d pulls after done, want 0", n)
+	}
+}
+
+func testDigest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+// testChunksRegistry serves blobs and their chunksums, in chunks of 1000
+// bytes, and returns the ranges of blobs that were downloaded
+func testChunksRegistry(t *testing.T, blobs ...[]byte) func() []string {
+	t.Helper()
+
+	byDigest := make(map[string][]byte)
+	for _, b := range blobs {
+		byDigest[testDigest(b)] = b
+	}
+
+	var mu sync.Mutex
+	var ranges []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if digest, ok := strings.CutPrefix(r.URL.Path, "/v2/library/m/blobs/"); ok && byDigest[digest] != nil {
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Length", fmt.Sprint(len(byDigest[digest])))
+				return
+			}
+			http.Redirect(w, r, srv.URL+"/direct/"+digest, http.StatusTemporaryRedirect)
+		} else if digest, ok := strings.CutPrefix(r.URL.Path, "/v2/library/m/chunksums/"); ok && byDigest[digest] != nil {
+			b := byDigest[digest]
+			for i := 0; i < len(b); i += 1000 {
+				end := min(i+1000, len(b))
+				fmt.Fprintf(w, "%s %d-%d\n", testDigest(b[i:end]), i, end-1)
+			}
+		} else if digest, ok := strings.CutPrefix(r.URL.Path, "/direct/"); ok && byDigest[digest] != nil {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+			http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(byDigest[digest]))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	testMakeRequestDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+	t.Cleanup(func() { testMakeRequestDialContext = nil })
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := ranges
+		ranges = nil
+		return r
+	}
+}
+
+// testDownloadBlob pulls the blob with digest from the test registry and
+// returns the number of bytes reused from local blobs
+func testDownloadBlob(t *testing.T, digest string, prev ...string) int64 {
+	t.Helper()
+
+	var last api.ProgressResponse
+	if _, err := downloadBlob(t.Context(), downloadOpts{
+		mp:      ParseModelPath("example.com/library/m:latest"),
+		digest:  digest,
+		regOpts: &registryOptions{Insecure: true},
+		fn:      func(p api.ProgressResponse) { last = p },
+		prev:    prev,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyBlob(digest); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), "partial") {
+			t.Errorf("partial file %s left behind", e.Name())
+		}
+	}
+
+	return last.Reused
+}
+
+func TestDownloadBlobReusesChunks(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	chunk := func(b byte) []byte { return bytes.Repeat([]byte{b}, 1000) }
+
+	// the new blob changes the middle chunk of the old one
+	oldBlob := slices.Concat(chunk('a'), chunk('b'), chunk('c'))
+	newBlob := slices.Concat(chunk('a'), chunk('x'), chunk('c'))
+	oldDigest, newDigest := testDigest(oldBlob), testDigest(newBlob)
+
+	fp, err := GetBlobsPath(oldDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fp, oldBlob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := testChunksRegistry(t, newBlob)
+	reused := testDownloadBlob(t, newDigest, oldDigest)
+
+	// only the changed chunk is downloaded
+	if got, want := ranges(), []string{"bytes=1000-1999"}; !slices.Equal(got, want) {
+		t.Errorf("ranges = %v, want %v", got, want)
+	}
+
+	if reused != 2000 {
+		t.Errorf("reused = %d, want 2000", reused)
+	}
+}
+
+func TestDownloadBlobReusesIndexedChunks(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	chunk := func(b byte) []byte { return bytes.Repeat([]byte{b}, 1000) }
+
+	// the second blob, of another model, has chunks of the first at other
+	// offsets
+	first := slices.Concat(chunk('a'), chunk('b'), chunk('c'))
+	second := slices.Concat(chunk('x'), chunk('c'), chunk('a'))
+	ranges := testChunksRegistry(t, first, second)
+
+	if reused := testDownloadBlob(t, testDigest(first)); reused != 0 {
+		t.Errorf("reused = %d, want 0", reused)
+	}
+	ranges()
+
+	reused := testDownloadBlob(t, testDigest(second))
+	if got, want := ranges(), []string{"bytes=0-999"}; !slices.Equal(got, want) {
+		t.Errorf("ranges = %v, want %v", got, want)
+	}
+
+	if reused != 2000 {
+		t.Errorf("reused = %d, want 2000", reused)
+	}
+}
+
+func TestBlobDownloadLimits(t *testing.T) {
+	var b blobDownload
+
+	rate := func() float64 {
+		b.limiter.mu.Lock()
+		defer b.limiter.mu.Unlock()
+		return b.limiter.rate
+	}
+
+	detachA := b.attachLimit(2000)
+	if got := rate(); got != 2000 {
+		t.Fatalf("rate = %v, want 2000", got)
+	}
+
+	// a pull without a limit doesn't lift the limits of others
+	detachNone := b.attachLimit(0)
+	detachB := b.attachLimit(1000)
+	if got := rate(); got != 1000 {
+		t.Fatalf("rate = %v, want the lowest limit 1000", got)
+	}
+
+	detachB()
+	if got := rate(); got != 2000 {
+		t.Fatalf("rate = %v after the lowest limit detached, want 2000", got)
+	}
+
+	detachA()
+	detachNone()
+	if got := rate(); got != 0 {
+		t.Fatalf("rate = %v, want no limit", got)
+	}
+
+	if err := b.limiter.wait(t.Context(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
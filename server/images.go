@@ -46,6 +46,10 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// LimitRate, if positive, limits the download rate of a pull in bytes
+	// per second.
+	LimitRate int64
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -62,6 +66,8 @@ type Model struct {
 	Digest         string
 	Options        map[string]any
 	Messages       []api.Message
+	Tools          api.Tools
+	Format         json.RawMessage
 
 	Template *template.Template
 }
@@ -210,6 +216,30 @@ func (m *Model) String() string {
 		})
 	}
 
+	for _, tool := range m.Tools {
+		bts, err := json.Marshal(tool)
+		if err != nil {
+			continue
+		}
+
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "tool",
+			Args: string(bts),
+		})
+	}
+
+	if len(m.Format) > 0 {
+		format := string(m.Format)
+		if format == `"json"` {
+			format = "json"
+		}
+
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "format",
+			Args: format,
+		})
+	}
+
 	return modelfile.String()
 }
 
@@ -220,6 +250,8 @@ type ConfigV2 struct {
 	ModelType     string   `json:"model_type"`
 	FileType      string   `json:"file_type"`
 
+	Imatrix *api.ImatrixDetails `json:"imatrix,omitempty"`
+
 	// required by spec
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
@@ -231,12 +263,20 @@ type RootFS struct {
 	DiffIDs []string `json:"diff_ids"`
 }
 
+// GetManifest reads the manifest for mp from the first models directory that
+// has one, and returns it with its digest.
 func GetManifest(mp ModelPath) (*Manifest, string, error) {
-	fp, err := mp.GetManifestPath()
-	if err != nil {
-		return nil, "", err
+	name := model.Name{
+		Host:      mp.Registry,
+		Namespace: mp.Namespace,
+		Model:     mp.Repository,
+		Tag:       mp.Tag,
+	}
+	if !name.IsValid() {
+		return nil, "", os.ErrNotExist
 	}
 
+	fp, _ := findManifestPath(name)
 	f, err := os.Open(fp)
 	if err != nil {
 		return nil, "", err
@@ -254,7 +294,7 @@ func GetManifest(mp ModelPath) (*Manifest, string, error) {
 }
 
 func GetModel(name string) (*Model, error) {
-	mp := ParseModelPath(name)
+	mp := ParseModelPath(resolveAlias(name))
 	manifest, digest, err := GetManifest(mp)
 	if err != nil {
 		return nil, err
@@ -341,6 +381,21 @@ func GetModel(name string) (*Model, error) {
 			if err = json.NewDecoder(msgs).Decode(&model.Messages); err != nil {
 				return nil, err
 			}
+		case "application/vnd.ollama.image.tools":
+			tools, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer tools.Close()
+
+			if err = json.NewDecoder(tools).Decode(&model.Tools); err != nil {
+				return nil, err
+			}
+		case "application/vnd.ollama.image.format":
+			model.Format, err = os.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.license":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
@@ -375,7 +430,7 @@ func CopyModel(src, dst model.Name) error {
 		return err
 	}
 
-	srcpath := filepath.Join(manifests, src.Filepath())
+	srcpath, _ := findManifestPath(src)
 	srcfile, err := os.Open(srcpath)
 	if err != nil {
 		return err
@@ -407,9 +462,10 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 		delete(deleteMap, manifest.Config.Digest)
 	}
 
-	// only delete the files which are still in the deleteMap
+	// only delete the files which are still in the deleteMap; blobs in
+	// read-only models directories are never deleted
 	for k := range deleteMap {
-		fp, err := GetBlobsPath(k)
+		fp, err := getWritableBlobsPath(k)
 		if err != nil {
 			slog.Info(fmt.Sprintf("couldn't get file path for '%s': %v", k, err))
 			continue
@@ -555,11 +611,14 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	// build deleteMap to prune unused layers
 	deleteMap := make(map[string]struct{})
 	manifest, _, err := GetManifest(mp)
+	var prevLayers []Layer
 	if errors.Is(err, os.ErrNotExist) {
 		// noop
 	} else if err != nil {
 		slog.Warn("pulling model with bad existing manifest", "name", name, "error", err)
 	} else {
+		// the layers being replaced may share chunks with the new layers
+		prevLayers = manifest.Layers
 		for _, l := range manifest.Layers {
 			deleteMap[l.Digest] = struct{}{}
 		}
@@ -585,13 +644,28 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		layers = append(layers, manifest.Config)
 	}
 
+	release, err := acquirePullSlot(ctx, fn)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	skipVerify := make(map[string]bool)
 	for _, layer := range layers {
+		var prev []string
+		for _, l := range prevLayers {
+			if l.MediaType == layer.MediaType && l.Digest != layer.Digest {
+				prev = append(prev, l.Digest)
+			}
+		}
+
 		cacheHit, err := downloadBlob(ctx, downloadOpts{
-			mp:      mp,
-			digest:  layer.Digest,
-			regOpts: regOpts,
-			fn:      fn,
+			mp:        mp,
+			digest:    layer.Digest,
+			regOpts:   regOpts,
+			fn:        fn,
+			limitRate: regOpts.LimitRate,
+			prev:      prev,
 		})
 		if err != nil {
 			return err
@@ -609,7 +683,7 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		if err := verifyBlob(layer.Digest); err != nil {
 			if errors.Is(err, errDigestMismatch) {
 				// something went wrong, delete the blob
-				fp, err := GetBlobsPath(layer.Digest)
+				fp, err := getWritableBlobsPath(layer.Digest)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/runner/ollamarunner"
+)
+
+// calibrate computes the importance matrix of layer's weights over the
+// calibration text in the blob dataset. The model is evaluated on the CPU in
+// a runner subprocess so its memory is released when it's done.
+func calibrate(layer *layerGGML, dataset string, fn func(resp api.ProgressResponse)) (*ggml.Imatrix, error) {
+	status := "calibrating importance matrix"
+	fn(api.ProgressResponse{Status: status})
+
+	modelPath, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	datasetPath, err := GetBlobsPath(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(datasetPath); err != nil {
+		return nil, fmt.Errorf("imatrix dataset: %w", err)
+	}
+
+	temp, err := os.CreateTemp("", "imatrix")
+	if err != nil {
+		return nil, err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup executable path: %w", err)
+	}
+
+	if eval, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = eval
+	}
+
+	cmd := exec.Command(exe, "runner", "--imatrix",
+		"-model", modelPath,
+		"-dataset", datasetPath,
+		"-output", temp.Name(),
+	)
+
+	var pathEnv string
+	switch runtime.GOOS {
+	case "windows":
+		pathEnv = "PATH"
+	case "darwin":
+		pathEnv = "DYLD_LIBRARY_PATH"
+	default:
+		pathEnv = "LD_LIBRARY_PATH"
+	}
+
+	libraryPaths := []string{discover.LibOllamaPath}
+	if libraryPath, ok := os.LookupEnv(pathEnv); ok {
+		libraryPaths = append(filepath.SplitList(libraryPath), libraryPaths...)
+	}
+
+	cmd.Env = append(os.Environ(), pathEnv+"="+strings.Join(libraryPaths, string(filepath.ListSeparator)))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("starting imatrix calibration", "cmd", cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var progress ollamarunner.ImatrixProgress
+		if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+			continue
+		}
+
+		fn(api.ProgressResponse{Status: status, Total: int64(progress.Total), Completed: int64(progress.Completed)})
+	}
+
+	if err := cmd.Wait(); err != nil {
+		// the runner reports its error on the last line of stderr
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if msg := strings.TrimPrefix(lines[len(lines)-1], "Error: "); msg != "" {
+			return nil, errors.New(msg)
+		}
+
+		return nil, fmt.Errorf("imatrix calibration failed: %w", err)
+	}
+
+	f, err := os.Open(temp.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	imatrix, err := ggml.ReadImatrix(f)
+	if err != nil {
+		return nil, err
+	}
+
+	imatrix.Dataset = dataset
+	return imatrix, nil
+}
@@ -36,6 +36,8 @@ type Entry struct {
 //	<dir>/
 //	  blobs/
 //	    sha256-<digest> - <blob data>
+//	  chunks/
+//	    sha256-<digest> - <blob and range holding the chunk>
 //	  manifests/
 //	    <host>/
 //	      <namespace>/
@@ -56,6 +58,10 @@ type DiskCache struct {
 	dir string
 	now func() time.Time
 
+	// readOnly are additional cache directories searched, in order, for
+	// manifests and blobs not found in dir. They are never written to.
+	readOnly []string
+
 	testHookBeforeFinalWrite func(f *os.File)
 }
 
@@ -95,6 +101,29 @@ func Open(dir string) (*DiskCache, error) {
 	return c, nil
 }
 
+// ErrReadOnly is returned when attempting to modify a manifest which is only
+// present in a read-only directory of a cache opened with [OpenWithReadOnly].
+var ErrReadOnly = errors.New("blob: manifest is in a read-only cache directory")
+
+// OpenWithReadOnly is like [Open], but the returned cache also resolves
+// manifests and blobs from the readOnly directories, in order, when they are
+// not found in dir. All writes go to dir, and nothing in the readOnly
+// directories is ever modified or removed.
+func OpenWithReadOnly(dir string, readOnly ...string) (*DiskCache, error) {
+	c, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ro := range readOnly {
+		abs, err := filepath.Abs(ro)
+		if err != nil {
+			return nil, err
+		}
+		c.readOnly = append(c.readOnly, abs)
+	}
+	return c, nil
+}
+
 func readAndSum(filename string, limit int64) (data []byte, _ Digest, err error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -184,6 +213,18 @@ func (c *DiskCache) Resolve(name string) (Digest, error) {
 	if err != nil {
 		return Digest{}, err
 	}
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		for _, dir := range c.readOnly {
+			ro, err := manifestPathIn(dir, name)
+			if err != nil {
+				return Digest{}, err
+			}
+			if _, err := os.Stat(ro); err == nil {
+				file = ro
+				break
+			}
+		}
+	}
 
 	data, d, err := readAndSum(file, 1<<20)
 	if err != nil {
@@ -312,19 +353,41 @@ func (c *DiskCache) Unlink(name string) (ok bool, _ error) {
 	}
 	err = os.Remove(manifest)
 	if errors.Is(err, fs.ErrNotExist) {
+		for _, dir := range c.readOnly {
+			ro, err := manifestPathIn(dir, name)
+			if err != nil {
+				return false, err
+			}
+			if _, err := os.Stat(ro); err == nil {
+				return false, fmt.Errorf("%w: %s", ErrReadOnly, name)
+			}
+		}
 		return false, nil
 	}
 	return true, err
 }
 
 // GetFile returns the absolute path to the file, in the cache, for the given
-// digest. It does not check if the file exists.
+// digest. It does not check if the file exists, except that if the file is
+// missing from the cache directory but present in one of its read-only
+// directories, the read-only path is returned.
 //
 // The returned path should not be stored, used outside the lifetime of the
 // cache, or interpreted in any way.
 func (c *DiskCache) GetFile(d Digest) string {
 	filename := fmt.Sprintf("sha256-%x", d.sum)
-	return absJoin(c.dir, "blobs", filename)
+	name := absJoin(c.dir, "blobs", filename)
+	if len(c.readOnly) > 0 {
+		if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+			for _, dir := range c.readOnly {
+				ro := absJoin(dir, "blobs", filename)
+				if _, err := os.Stat(ro); err == nil {
+					return ro
+				}
+			}
+		}
+	}
+	return name
 }
 
 // Links returns a sequence of link names. The sequence is in lexical order.
@@ -366,28 +429,40 @@ func pathToName(s string) string {
 // case-insensitive comparison, the one that sorts first, lexically, is
 // returned.
 func (c *DiskCache) manifestPath(name string) (string, error) {
+	return manifestPathIn(c.dir, name)
+}
+
+// manifestPathIn is like [DiskCache.manifestPath], but for the cache
+// directory dir.
+func manifestPathIn(dir, name string) (string, error) {
 	np, err := nameToPath(name)
 	if err != nil {
 		return "", err
 	}
 
 	maybe := filepath.Join("manifests", np)
-	for l, err := range c.links() {
+	for l, err := range links(dir) {
 		if err != nil {
 			return "", err
 		}
 		if strings.EqualFold(maybe, l) {
-			return filepath.Join(c.dir, l), nil
+			return filepath.Join(dir, l), nil
 		}
 	}
-	return filepath.Join(c.dir, maybe), nil
+	return filepath.Join(dir, maybe), nil
 }
 
 // links returns a sequence of links in the cache in lexical order.
 func (c *DiskCache) links() iter.Seq2[string, error] {
+	return links(c.dir)
+}
+
+// links returns a sequence of links in the cache directory dir in lexical
+// order.
+func links(dir string) iter.Seq2[string, error] {
 	// TODO(bmizerany): reuse empty dirnames if exist
 	return func(yield func(string, error) bool) {
-		fsys := os.DirFS(c.dir)
+		fsys := os.DirFS(dir)
 		manifests, err := fs.Glob(fsys, "manifests/*/*/*/*")
 		if err != nil {
 			yield("", err)
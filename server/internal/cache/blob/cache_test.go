@@ -686,3 +686,67 @@ func dumpCacheContents(t *testing.T, c *DiskCache) {
 	t.Log()
 	t.Logf("cache contents:\n%s", b.String())
 }
+
+func TestChunkSource(t *testing.T) {
+	c, _ := openTester(t)
+	check := testutil.Checker(t)
+
+	d := mkdigest("ab")
+	_, _, err := c.ChunkSource(d)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("err = %v, want fs.ErrNotExist", err)
+	}
+
+	src := mkdigest("abc")
+	check(c.PutChunkSource(d, src, Chunk{Start: 0, End: 1}))
+
+	got, chunk, err := c.ChunkSource(d)
+	check(err)
+	if got != src {
+		t.Errorf("src = %v, want %v", got, src)
+	}
+	if want := (Chunk{Start: 0, End: 1}); chunk != want {
+		t.Errorf("chunk = %v, want %v", chunk, want)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	check := testutil.Checker(t)
+
+	shared, err := Open(t.TempDir())
+	check(err)
+	d := mkdigest("abc")
+	check(PutBytes(shared, d, "abc"))
+	check(shared.Link("h/n/m:t", d))
+
+	c, err := OpenWithReadOnly(t.TempDir(), shared.dir)
+	check(err)
+
+	got, err := c.Resolve("h/n/m:t")
+	check(err)
+	if got != d {
+		t.Fatalf("Resolve = %v, want %v", got, d)
+	}
+	if got, want := c.GetFile(d), shared.GetFile(d); got != want {
+		t.Errorf("GetFile = %q, want %q", got, want)
+	}
+
+	_, err = c.Unlink("h/n/m:t")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Unlink err = %v, want ErrReadOnly", err)
+	}
+	if _, err := shared.Resolve("h/n/m:t"); err != nil {
+		t.Fatalf("manifest removed from read-only dir: %v", err)
+	}
+
+	// A local link shadows the read-only one and can be removed.
+	check(c.Link("h/n/m:t", d))
+	ok, err := c.Unlink("h/n/m:t")
+	check(err)
+	if !ok {
+		t.Fatal("Unlink = false, want true")
+	}
+
+	_, err = c.Unlink("h/n/other:t")
+	check(err)
+}
@@ -3,6 +3,7 @@ package blob
 import (
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 )
@@ -71,3 +72,53 @@ func (c *Chunker) Put(chunk Chunk, d Digest, r io.Reader) error {
 func (c *Chunker) Close() error {
 	return c.f.Close()
 }
+
+// PutChunkSource records that the chunk of data with digest d is stored in
+// the blob src at the given range, so that it can later be found with
+// [DiskCache.ChunkSource] regardless of which blob it was downloaded for.
+//
+// The record is only a hint. The blob src may be removed or incomplete, so
+// callers must verify any data read using it.
+func (c *DiskCache) PutChunkSource(d Digest, src Digest, chunk Chunk) error {
+	dir := absJoin(c.dir, "chunks")
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%s %d %d\n", src, chunk.Start, chunk.End); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.chunkSourceFile(d))
+}
+
+// ChunkSource returns the blob and range recorded for the chunk digest d by
+// [DiskCache.PutChunkSource]. It returns an error satisfying
+// errors.Is(err, fs.ErrNotExist) if no record exists.
+func (c *DiskCache) ChunkSource(d Digest) (src Digest, _ Chunk, _ error) {
+	data, err := os.ReadFile(c.chunkSourceFile(d))
+	if err != nil {
+		return Digest{}, Chunk{}, err
+	}
+	var s string
+	var chunk Chunk
+	if _, err := fmt.Sscanf(string(data), "%s %d %d", &s, &chunk.Start, &chunk.End); err != nil {
+		return Digest{}, Chunk{}, fmt.Errorf("invalid chunk source for %s: %w", d.Short(), err)
+	}
+	src, err = ParseDigest(s)
+	if err != nil {
+		return Digest{}, Chunk{}, err
+	}
+	return src, chunk, nil
+}
+
+func (c *DiskCache) chunkSourceFile(d Digest) string {
+	return absJoin(c.dir, "chunks", fmt.Sprintf("sha256-%x", d.sum))
+}
@@ -60,6 +60,12 @@ var (
 	// exists. It is a non-fatal error and is never returned by [Registry.Push].
 	ErrCached = errors.New("cached")
 
+	// ErrReused is passed to [Trace.Update] when a chunk of a layer was
+	// copied from a blob already in the local cache instead of being
+	// downloaded. Like [ErrCached], it is a non-fatal error and is never
+	// returned by [Registry.Pull].
+	ErrReused = errors.New("reused")
+
 	// ErrIncomplete is returned by [Registry.Pull] when a model pull was
 	// incomplete due to one or more layer download failures. Users that
 	// want specific errors should use [WithTrace].
@@ -80,13 +86,20 @@ var defaultCache = sync.OnceValues(func() (*blob.DiskCache, error) {
 		home = cmp.Or(home, ".")
 		dir = filepath.Join(home, ".ollama", "models")
 	}
-	return blob.Open(dir)
+	var readOnly []string
+	for _, ro := range filepath.SplitList(os.Getenv("OLLAMA_MODELS_READONLY")) {
+		if ro != "" {
+			readOnly = append(readOnly, ro)
+		}
+	}
+	return blob.OpenWithReadOnly(dir, readOnly...)
 })
 
 // DefaultCache returns the default cache used by the registry. It is
 // configured from the OLLAMA_MODELS environment variable, or defaults to
 // $HOME/.ollama/models, or, if an error occurs obtaining the home directory,
-// it uses the current working directory.
+// it uses the current working directory. Directories listed in
+// OLLAMA_MODELS_READONLY are searched for models not found there.
 func DefaultCache() (*blob.DiskCache, error) {
 	return defaultCache()
 }
@@ -454,6 +467,48 @@ func (r *trackingReader) Read(p []byte) (n int, err error) {
 	return
 }
 
+// maxLimitedRead bounds the size of a single read through a limitedReader so
+// large reads do not cause long, bursty pauses.
+const maxLimitedRead = 32 << 10
+
+// limitedReader is an io.Reader which calls limit with the number of bytes
+// read after every read, failing the read if limit fails.
+type limitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit func(_ context.Context, n int) error
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+	n, err = r.r.Read(p)
+	if err := r.limit(r.ctx, n); err != nil {
+		// Drop what was read so a reader stopping at the end of a
+		// chunk doesn't miss the error
+		return 0, err
+	}
+	return n, err
+}
+
+type limitKey struct{}
+
+// WithLimit adds a limit to the context for [Registry.Pull]. Pull calls limit
+// after each read of a download with the number of bytes read, and limit
+// blocks for as long as the download should wait, such as to limit its rate.
+// If limit returns an error, the download fails with it.
+func WithLimit(ctx context.Context, limit func(_ context.Context, n int) error) context.Context {
+	return context.WithValue(ctx, limitKey{}, limit)
+}
+
+// limitFromContext returns the limit associated with ctx, or nil if none is
+// found.
+func limitFromContext(ctx context.Context) func(context.Context, int) error {
+	limit, _ := ctx.Value(limitKey{}).(func(context.Context, int) error)
+	return limit
+}
+
 // Pull pulls the model with the given name from the remote registry into the
 // cache.
 //
@@ -461,12 +516,27 @@ func (r *trackingReader) Read(p []byte) (n int, err error) {
 // chunks of the specified size, and then reassembled and verified. This is
 // typically slower than splitting the model up across layers, and is mostly
 // utilized for layers of type equal to "application/vnd.ollama.image".
+//
+// Before a chunk is downloaded, Pull attempts to copy it from blobs already
+// in the cache: a blob with the same digest as the chunk, a chunk with the
+// same digest seen in a previous pull, or the same byte range in the
+// previously pulled layer of the same media type under name. Chunks found
+// locally are reported to the trace with [ErrReused].
+//
+// Downloads wait on the limit added to ctx with [WithLimit], if any.
 func (r *Registry) Pull(ctx context.Context, name string) error {
 	m, err := r.Resolve(ctx, name)
 	if err != nil {
 		return err
 	}
 
+	// The manifest currently linked to name, if any, is the most likely
+	// source of reusable chunks when a tag moves to a new manifest.
+	var prev []*Layer
+	if pm, err := r.ResolveLocal(name); err == nil {
+		prev = pm.Layers
+	}
+
 	// TODO(bmizerany): decide if this should be considered valid. Maybe
 	// server-side we special case '{}' to have some special meaning? Maybe
 	// "archiving" a tag (which is how we reason about it in the registry
@@ -581,6 +651,12 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 						}
 					}()
 
+					if reuseChunk(c, chunked, l, cs, prev) {
+						t.reused(l, cs.Chunk.Size())
+						update(cs.Chunk.Size(), ErrReused)
+						return blob.PutBytes(c, cacheKeyDigest, cacheKey)
+					}
+
 					ctx, cancel := context.WithCancelCause(ctx)
 					defer cancel(nil)
 
@@ -606,14 +682,32 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 					}
 					defer res.Body.Close()
 
-					tr := &trackingReader{
+					var body io.Reader = &trackingReader{
 						r: res.Body,
 						update: func(n int64, err error) {
 							timer.Reset(r.readTimeout())
 							update(n, err)
 						},
 					}
-					if err := chunked.Put(cs.Chunk, cs.Digest, tr); err != nil {
+					if limit := limitFromContext(ctx); limit != nil {
+						body = &limitedReader{
+							ctx: ctx,
+							r:   body,
+							limit: func(ctx context.Context, n int) error {
+								// Waiting on the limit is not a stalled read
+								timer.Stop()
+								defer timer.Reset(r.readTimeout())
+								return limit(ctx, n)
+							},
+						}
+					}
+					if err := chunked.Put(cs.Chunk, cs.Digest, body); err != nil {
+						return err
+					}
+
+					// Remember where this chunk lives so later
+					// pulls of other layers can reuse it.
+					if err := c.PutChunkSource(cs.Digest, l.Digest, cs.Chunk); err != nil {
 						return err
 					}
 
@@ -639,6 +733,51 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 	return c.Link(m.Name, md)
 }
 
+// reuseChunk attempts to fill the chunk cs of layer l from data already in
+// the cache c, and reports if it succeeded. Candidate sources are tried in
+// order:
+//
+//   - a blob whose digest is the chunk digest,
+//   - the location recorded for the chunk digest by a previous pull
+//     (content-defined matching), and
+//   - the same range in each layer in prev with the same media type as l
+//     (fixed matching).
+//
+// Data copied from a candidate is verified against the chunk digest by
+// [blob.Chunker.Put], so a stale or mismatched candidate only costs a local
+// read before falling back to the next candidate.
+func reuseChunk(c *blob.DiskCache, chunked *blob.Chunker, l *Layer, cs chunksum, prev []*Layer) bool {
+	try := func(d blob.Digest, chunk blob.Chunk) bool {
+		if d == l.Digest || chunk.Size() != cs.Chunk.Size() {
+			return false
+		}
+		info, err := c.Get(d)
+		if err != nil || info.Size <= chunk.End {
+			return false
+		}
+		f, err := os.Open(c.GetFile(d))
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		sr := io.NewSectionReader(f, chunk.Start, chunk.Size())
+		return chunked.Put(cs.Chunk, cs.Digest, sr) == nil
+	}
+
+	if try(cs.Digest, blob.Chunk{Start: 0, End: cs.Chunk.Size() - 1}) {
+		return true
+	}
+	if d, chunk, err := c.ChunkSource(cs.Digest); err == nil && try(d, chunk) {
+		return true
+	}
+	for _, p := range prev {
+		if p != nil && p.MediaType == l.MediaType && try(p.Digest, cs.Chunk) {
+			return true
+		}
+	}
+	return false
+}
+
 // Unlink is like [blob.DiskCache.Unlink], but makes name fully qualified
 // before attempting to unlink the model.
 func (r *Registry) Unlink(name string) (ok bool, _ error) {
@@ -672,6 +672,64 @@ func TestPullChunked(t *testing.T) {
 	}
 }
 
+func TestPullLimit(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/latest"):
+			io.WriteString(w, `{"layers":[{"size":3,"digest":"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}]}`)
+		case strings.Contains(r.URL.Path, "/chunksums/"):
+			w.Header().Set("Content-Location", "http://blob.store/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			fmt.Fprintf(w, "%s 0-1\n", blob.DigestFromBytes("ab"))
+			fmt.Fprintf(w, "%s 2-2\n", blob.DigestFromBytes("c"))
+		case r.Header.Get("Range") == "bytes=0-1":
+			io.WriteString(w, "ab")
+		case r.Header.Get("Range") == "bytes=2-2":
+			io.WriteString(w, "c")
+		default:
+			t.Errorf("unexpected request: %v", r)
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+		}
+	}
+
+	t.Run("wait", func(t *testing.T) {
+		c, ctx := newRegistryClient(t, upstream)
+		c.ChunkingThreshold = 1 // force chunking
+
+		var limited atomic.Int64
+		ctx = WithLimit(ctx, func(_ context.Context, n int) error {
+			limited.Add(int64(n))
+			return nil
+		})
+
+		err := c.Pull(ctx, "http://o.com/library/abc")
+		testutil.Check(t, err)
+
+		if g := limited.Load(); g != 3 {
+			t.Errorf("limited %d bytes, want 3", g)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		c, ctx := newRegistryClient(t, upstream)
+		c.ChunkingThreshold = 1 // force chunking
+
+		errLimit := errors.New("limit")
+		ctx = WithLimit(ctx, func(context.Context, int) error {
+			return errLimit
+		})
+
+		err := c.Pull(ctx, "http://o.com/library/abc")
+		if !errors.Is(err, errLimit) {
+			t.Fatalf("err = %v, want %v", err, errLimit)
+		}
+
+		_, err = c.Cache.Resolve("o.com/library/abc:latest")
+		if err == nil {
+			t.Error("expected the model to not be pulled")
+		}
+	})
+}
+
 func TestPullCached(t *testing.T) {
 	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
 		checkRequest(t, r, "GET", "/v2/library/abc/manifests/latest")
@@ -951,3 +1009,78 @@ func TestPullChunksumsCached(t *testing.T) {
 		t.Fatalf("cached %d bytes, want 5", g)
 	}
 }
+
+func TestPullReusesLocalChunks(t *testing.T) {
+	var step atomic.Int64
+	c, _ := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch step.Add(1) {
+		case 1:
+			checkRequest(t, r, "GET", "/v2/library/abc/manifests/latest")
+			io.WriteString(w, `{"layers":[{"size":3,"digest":"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}]}`)
+		case 2:
+			w.Header().Set("Content-Location", "http://blob.store/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			fmt.Fprintf(w, "%s 0-1\n", blob.DigestFromBytes("ab"))
+			fmt.Fprintf(w, "%s 2-2\n", blob.DigestFromBytes("c"))
+		case 3:
+			// Only the chunk that differs from the local layer
+			// should be downloaded.
+			if rng := r.Header.Get("Range"); rng != "bytes=2-2" {
+				t.Errorf("unexpected range %q", rng)
+			}
+			io.WriteString(w, "c")
+		default:
+			t.Errorf("unexpected steps %d: %v", step.Load(), r)
+			http.Error(w, "unexpected steps", http.StatusInternalServerError)
+		}
+	})
+
+	c.ChunkingThreshold = 1 // force chunking
+
+	check := testutil.Checker(t)
+
+	// Link a previous version of the model whose only layer shares the
+	// first chunk with the new layer.
+	old := importBytes(t, c.Cache, "abd")
+	data, err := json.Marshal(&Manifest{Layers: []*Layer{{Digest: old, Size: 3}}})
+	check(err)
+	md := importBytes(t, c.Cache, string(data))
+	check(c.Cache.Link("o.com/library/abc:latest", md))
+
+	var reused, written atomic.Int64
+	ctx := WithTrace(t.Context(), &Trace{
+		Update: func(l *Layer, n int64, err error) {
+			t.Log("trace:", l.Digest.Short(), n, err)
+			if err != nil && !errors.Is(err, ErrReused) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			written.Store(n)
+		},
+		Reused: func(l *Layer, n int64) {
+			reused.Add(n)
+		},
+	})
+
+	err = c.Pull(ctx, "http://o.com/library/abc")
+	check(err)
+
+	if g := step.Load(); g != 3 {
+		t.Fatalf("got %d steps, want 3", g)
+	}
+	if g := reused.Load(); g != 2 {
+		t.Fatalf("reused %d bytes, want 2", g)
+	}
+	if g := written.Load(); g != 3 {
+		t.Fatalf("wrote %d bytes, want 3", g)
+	}
+
+	d, err := c.Cache.Resolve("o.com/library/abc:latest")
+	check(err)
+	if d == md {
+		t.Fatal("manifest was not updated")
+	}
+	got, err := os.ReadFile(c.Cache.GetFile(blob.DigestFromBytes("abc")))
+	check(err)
+	if string(got) != "abc" {
+		t.Fatalf("layer = %q, want %q", got, "abc")
+	}
+}
@@ -24,6 +24,15 @@ type Trace struct {
 	// A function assigned must be safe for concurrent use. The function is
 	// called synchronously and so should not block or take long to run.
 	Update func(_ *Layer, n int64, _ error)
+
+	// Reused is called during [Registry.Pull] each time a chunk of n bytes
+	// of a layer is copied from a blob already in the local cache instead
+	// of being downloaded. The same bytes are also reported to Update
+	// with [ErrReused].
+	//
+	// Like Update, a function assigned must be safe for concurrent use and
+	// should not block.
+	Reused func(_ *Layer, n int64)
 }
 
 func (t *Trace) update(l *Layer, n int64, err error) {
@@ -32,6 +41,12 @@ func (t *Trace) update(l *Layer, n int64, err error) {
 	}
 }
 
+func (t *Trace) reused(l *Layer, n int64) {
+	if t.Reused != nil {
+		t.Reused(l, n)
+	}
+}
+
 type traceKey struct{}
 
 // WithTrace adds a trace to the context for transfer progress reporting.
@@ -53,6 +68,12 @@ func WithTrace(ctx context.Context, t *Trace) context.Context {
 			}
 			t.update(l, n, err)
 		},
+		Reused: func(l *Layer, n int64) {
+			if old != nil {
+				old.reused(l, n)
+			}
+			t.reused(l, n)
+		},
 	}
 	return context.WithValue(ctx, traceKey{}, composed)
 }
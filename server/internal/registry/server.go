@@ -37,8 +37,19 @@ type Local struct {
 	// Prune, if set, is called to prune the local disk cache after a model
 	// is deleted.
 	Prune func() error // optional
+
+	// StartPull, if set, is called before a model is pulled with its name
+	// and the download rate limit requested, in bytes per second. It may
+	// block until the pull can start, reporting why with status, and returns
+	// the context to pull with and a function to call when the pull is done.
+	// Canceling the context with [ErrPullPaused] pauses the pull.
+	StartPull func(_ context.Context, name string, limitRate int64, status func(string)) (context.Context, func(), error) // optional
 }
 
+// ErrPullPaused is the cause of a pull's context being canceled to pause it.
+// Downloaded chunks are kept, so pulling the model again resumes it.
+var ErrPullPaused = errors.New("pull paused")
+
 // serverError is like ollama.Error, but with a Status field for the HTTP
 // response code. We want to avoid adding that field to ollama.Error because it
 // would always be 0 to clients (we don't want to leak the status code in
@@ -201,6 +212,10 @@ type params struct {
 	// confusing flags such as this.
 	AllowNonTLS bool `json:"insecure"`
 
+	// LimitRate, if positive, limits the download rate of a pull in bytes
+	// per second.
+	LimitRate int64 `json:"limit_rate"`
+
 	// Stream, if true, will make the server send progress updates in a
 	// streaming of JSON objects. If false, the server will send a single
 	// JSON object with the final status as "success", or an error object
@@ -236,6 +251,9 @@ func (s *Local) handleDelete(_ http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	ok, err := s.Client.Unlink(p.model())
+	if errors.Is(err, blob.ErrReadOnly) {
+		return &serverError{403, "forbidden", err.Error()}
+	}
 	if err != nil {
 		return err
 	}
@@ -254,6 +272,7 @@ type progressUpdateJSON struct {
 	Digest    blob.Digest `json:"digest,omitempty,omitzero"`
 	Total     int64       `json:"total,omitempty,omitzero"`
 	Completed int64       `json:"completed,omitempty,omitzero"`
+	Reused    int64       `json:"reused,omitempty,omitzero"`
 }
 
 func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
@@ -267,8 +286,29 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	enc := json.NewEncoder(w)
+	ctx, done, err := s.startPull(r.Context(), p, func(status string) {
+		if p.stream() {
+			enc.Encode(progressUpdateJSON{Status: status})
+			if fl, ok := w.(http.Flusher); ok {
+				fl.Flush()
+			}
+		}
+	})
+	if errors.Is(err, ErrPullPaused) {
+		enc.Encode(progressUpdateJSON{Status: "paused"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	if !p.stream() {
-		if err := s.Client.Pull(r.Context(), p.model()); err != nil {
+		if err := s.Client.Pull(ctx, p.model()); err != nil {
+			if errors.Is(context.Cause(ctx), ErrPullPaused) {
+				enc.Encode(progressUpdateJSON{Status: "paused"})
+				return nil
+			}
 			if errors.Is(err, ollama.ErrModelNotFound) {
 				return errModelNotFound
 			}
@@ -298,9 +338,9 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 		flushProgress() // flush initial state
 		t.Reset(100 * time.Millisecond)
 	})
-	ctx := ollama.WithTrace(r.Context(), &ollama.Trace{
+	ctx = ollama.WithTrace(ctx, &ollama.Trace{
 		Update: func(l *ollama.Layer, n int64, err error) {
-			if err != nil && !errors.Is(err, ollama.ErrCached) {
+			if err != nil && !errors.Is(err, ollama.ErrCached) && !errors.Is(err, ollama.ErrReused) {
 				s.Logger.Error("pulling", "model", p.model(), "error", err)
 				return
 			}
@@ -328,11 +368,21 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 			// as new layers are registered.
 			start()
 		},
+		Reused: func(l *ollama.Layer, n int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			for i, p := range progress {
+				if p.Digest == l.Digest {
+					progress[i].Reused += n
+					return
+				}
+			}
+		},
 	})
 
-	done := make(chan error, 1)
+	pulled := make(chan error, 1)
 	go func() (err error) {
-		defer func() { done <- err }()
+		defer func() { pulled <- err }()
 		for _, err := range backoff.Loop(ctx, 3*time.Second) {
 			if err != nil {
 				return err
@@ -351,9 +401,13 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 		select {
 		case <-t.C:
 			flushProgress()
-		case err := <-done:
+		case err := <-pulled:
 			flushProgress()
 			if err != nil {
+				if errors.Is(context.Cause(ctx), ErrPullPaused) {
+					enc.Encode(progressUpdateJSON{Status: "paused"})
+					return nil
+				}
 				if errors.Is(err, ollama.ErrModelNotFound) {
 					return &serverError{
 						Status:  404,
@@ -374,6 +428,14 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 	}
 }
 
+// startPull calls [Local.StartPull], if set, for the pull requested by p.
+func (s *Local) startPull(ctx context.Context, p *params, status func(string)) (context.Context, func(), error) {
+	if s.StartPull == nil {
+		return ctx, func() {}, nil
+	}
+	return s.StartPull(ctx, p.model(), p.LimitRate, status)
+}
+
 func decodeUserJSON[T any](r io.Reader) (T, error) {
 	var v T
 	err := json.NewDecoder(r).Decode(&v)
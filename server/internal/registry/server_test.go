@@ -252,6 +252,58 @@ func TestServerPull(t *testing.T) {
 	checkErrorResponse(t, got, 404, "not_found", "model not found")
 }
 
+func TestServerPullStart(t *testing.T) {
+	modelsHandler := http.FileServerFS(registryFS())
+	s := newTestServer(t, modelsHandler.ServeHTTP)
+
+	var started struct {
+		name      string
+		limitRate int64
+		done      bool
+	}
+	s.StartPull = func(ctx context.Context, name string, limitRate int64, status func(string)) (context.Context, func(), error) {
+		started.name, started.limitRate = name, limitRate
+		status("waiting for other pulls to finish")
+		return ctx, func() { started.done = true }, nil
+	}
+
+	got := s.send(t, "POST", "/api/pull", `{"model": "smol", "limit_rate": 1024}`)
+	if got.Code != 200 {
+		t.Fatalf("Code = %d; want 200", got.Code)
+	}
+	if want := "{\"status\":\"waiting for other pulls to finish\"}\n{\"status\":\"pulling manifest\"}\n"; !strings.HasPrefix(got.Body.String(), want) {
+		t.Errorf("body = %q; want prefix %q", got.Body.String(), want)
+	}
+	if !strings.Contains(got.Body.String(), `{"status":"success"}`) {
+		t.Errorf("body = %q; want success", got.Body.String())
+	}
+	if started.name != "smol" || started.limitRate != 1024 || !started.done {
+		t.Errorf("started = %+v; want smol limited to 1024 and done", started)
+	}
+
+	// pulls are paused by canceling their context with ErrPullPaused
+	s.StartPull = func(ctx context.Context, name string, limitRate int64, status func(string)) (context.Context, func(), error) {
+		ctx, cancel := context.WithCancelCause(ctx)
+		cancel(ErrPullPaused)
+		return ctx, func() {}, nil
+	}
+	for _, body := range []string{`{"model": "smol"}`, `{"model": "smol", "stream": false}`} {
+		got = s.send(t, "POST", "/api/pull", body)
+		if got.Code != 200 || !strings.HasSuffix(got.Body.String(), "{\"status\":\"paused\"}\n") {
+			t.Errorf("%s: Code = %d, body = %q; want paused", body, got.Code, got.Body.String())
+		}
+	}
+
+	// or while waiting to start
+	s.StartPull = func(ctx context.Context, name string, limitRate int64, status func(string)) (context.Context, func(), error) {
+		return nil, nil, ErrPullPaused
+	}
+	got = s.send(t, "POST", "/api/pull", `{"model": "smol"}`)
+	if got.Code != 200 || got.Body.String() != "{\"status\":\"paused\"}\n" {
+		t.Errorf("Code = %d, body = %q; want paused", got.Code, got.Body.String())
+	}
+}
+
 func TestServerUnknownPath(t *testing.T) {
 	s := newTestServer(t, nil)
 	got := s.send(t, "DELETE", "/api/unknown", `{}`)
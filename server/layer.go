@@ -121,7 +121,8 @@ func (l *Layer) Remove() error {
 		}
 	}
 
-	blob, err := GetBlobsPath(l.Digest)
+	// only remove blobs from the writable models directory
+	blob, err := getWritableBlobsPath(l.Digest)
 	if err != nil {
 		return err
 	}
@@ -11,9 +11,12 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/types/model"
 )
 
+var errReadOnlyModel = errors.New("model is in a read-only models directory")
+
 type Manifest struct {
 	SchemaVersion int     `json:"schemaVersion"`
 	MediaType     string  `json:"mediaType"`
@@ -21,10 +24,22 @@ type Manifest struct {
 	Layers        []Layer `json:"layers"`
 
 	filepath string
+	root     string
 	fi       os.FileInfo
 	digest   string
 }
 
+// Root returns the models directory the manifest was read from.
+func (m *Manifest) Root() string {
+	return m.root
+}
+
+// ReadOnly reports whether the manifest was read from a read-only models
+// directory, in which case it cannot be removed.
+func (m *Manifest) ReadOnly() bool {
+	return m.root != "" && m.root != envconfig.Models()
+}
+
 func (m *Manifest) Size() (size int64) {
 	for _, layer := range append(m.Layers, m.Config) {
 		size += layer.Size
@@ -34,6 +49,10 @@ func (m *Manifest) Size() (size int64) {
 }
 
 func (m *Manifest) Remove() error {
+	if m.ReadOnly() {
+		return fmt.Errorf("%w: %s", errReadOnlyModel, m.root)
+	}
+
 	if err := os.Remove(m.filepath); err != nil {
 		return err
 	}
@@ -60,18 +79,22 @@ func (m *Manifest) RemoveLayers() error {
 	return nil
 }
 
+// ParseNamedManifest reads the manifest for n from the first models directory
+// that has one.
 func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	if !n.IsFullyQualified() {
 		return nil, model.Unqualified(n)
 	}
 
-	manifests, err := GetManifestPath()
-	if err != nil {
+	if _, err := GetManifestPath(); err != nil {
 		return nil, err
 	}
 
-	p := filepath.Join(manifests, n.Filepath())
+	p, root := findManifestPath(n)
+	return parseManifestFile(p, root)
+}
 
+func parseManifestFile(p, root string) (*Manifest, error) {
 	var m Manifest
 	f, err := os.Open(p)
 	if err != nil {
@@ -90,6 +113,7 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	}
 
 	m.filepath = p
+	m.root = root
 	m.fi = fi
 	m.digest = hex.EncodeToString(sha256sum.Sum(nil))
 
@@ -123,54 +147,64 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 	return json.NewEncoder(f).Encode(m)
 }
 
+// Manifests returns the manifests in all models directories. If a model is in
+// more than one directory, the manifest from the first directory is used.
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
-	manifests, err := GetManifestPath()
-	if err != nil {
-		return nil, err
-	}
-
-	// TODO(mxyng): use something less brittle
-	matches, err := filepath.Glob(filepath.Join(manifests, "*", "*", "*", "*"))
-	if err != nil {
+	if _, err := GetManifestPath(); err != nil {
 		return nil, err
 	}
 
 	ms := make(map[model.Name]*Manifest)
-	for _, match := range matches {
-		fi, err := os.Stat(match)
+	for _, root := range modelRoots() {
+		manifests := filepath.Join(root, "manifests")
+
+		// TODO(mxyng): use something less brittle
+		matches, err := filepath.Glob(filepath.Join(manifests, "*", "*", "*", "*"))
 		if err != nil {
 			return nil, err
 		}
 
-		if !fi.IsDir() {
-			rel, err := filepath.Rel(manifests, match)
+		for _, match := range matches {
+			fi, err := os.Stat(match)
 			if err != nil {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", match, err)
-				}
-				slog.Warn("bad filepath", "path", match, "error", err)
-				continue
+				return nil, err
 			}
 
-			n := model.ParseNameFromFilepath(rel)
-			if !n.IsValid() {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", rel, err)
+			if !fi.IsDir() {
+				rel, err := filepath.Rel(manifests, match)
+				if err != nil {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", match, err)
+					}
+					slog.Warn("bad filepath", "path", match, "error", err)
+					continue
 				}
-				slog.Warn("bad manifest name", "path", rel)
-				continue
-			}
 
-			m, err := ParseNamedManifest(n)
-			if err != nil {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", n, err)
+				n := model.ParseNameFromFilepath(rel)
+				if !n.IsValid() {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", rel, err)
+					}
+					slog.Warn("bad manifest name", "path", rel)
+					continue
+				}
+
+				if _, ok := ms[n]; ok {
+					// shadowed by an earlier models directory
+					continue
 				}
-				slog.Warn("bad manifest", "name", n, "error", err)
-				continue
-			}
 
-			ms[n] = m
+				m, err := parseManifestFile(match, root)
+				if err != nil {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", n, err)
+					}
+					slog.Warn("bad manifest", "name", n, "error", err)
+					continue
+				}
+
+				ms[n] = m
+			}
 		}
 	}
 
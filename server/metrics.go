@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/metrics"
+)
+
+// serverMetrics holds the metrics that accumulate while the server runs.
+// Metrics of the server's current state, such as loaded models, are read
+// when they're scraped by MetricsHandler.
+var serverMetrics metrics.Registry
+
+var (
+	requestsTotal = serverMetrics.Counter("ollama_requests_total", "Requests served by model and endpoint.", "model", "endpoint")
+
+	promptTokensTotal       = serverMetrics.Counter("ollama_prompt_tokens_total", "Prompt tokens processed, including those loaded from the cache.", "model")
+	promptCachedTokensTotal = serverMetrics.Counter("ollama_prompt_cached_tokens_total", "Prompt tokens loaded from the cache instead of being evaluated.", "model")
+	promptCacheHitRatio     = serverMetrics.Gauge("ollama_prompt_cache_hit_ratio", "Fraction of prompt tokens loaded from the cache.", "model")
+	evalTokensTotal         = serverMetrics.Counter("ollama_eval_tokens_total", "Tokens generated.", "model")
+
+	tokensPerSecond  = serverMetrics.Histogram("ollama_eval_tokens_per_second", "Generation speed of each request.", metrics.ExponentialBuckets(1, 2, 10), "model")
+	timeToFirstToken = serverMetrics.Histogram("ollama_time_to_first_token_seconds", "Time from receiving a request to generating its first token, including loading the model.", metrics.ExponentialBuckets(0.05, 2, 12), "model")
+
+	modelLoadsTotal   = serverMetrics.Counter("ollama_model_loads_total", "Models loaded.", "model")
+	modelUnloadsTotal = serverMetrics.Counter("ollama_model_unloads_total", "Models unloaded.", "model")
+	modelLoadSeconds  = serverMetrics.Histogram("ollama_model_load_seconds", "Time to load a model.", metrics.ExponentialBuckets(0.5, 2, 10), "model")
+)
+
+// recordCompletion records the metrics of a finished completion request
+func recordCompletion(model string, cr llm.CompletionResponse, loadDuration time.Duration) {
+	promptTokensTotal.Add(float64(cr.PromptEvalCount), model)
+	promptCachedTokensTotal.Add(float64(cr.PromptCachedCount), model)
+	if prompt := promptTokensTotal.Value(model); prompt > 0 {
+		promptCacheHitRatio.Set(promptCachedTokensTotal.Value(model)/prompt, model)
+	}
+
+	evalTokensTotal.Add(float64(cr.EvalCount), model)
+	if cr.EvalDuration > 0 {
+		tokensPerSecond.Observe(float64(cr.EvalCount)/cr.EvalDuration.Seconds(), model)
+	}
+
+	timeToFirstToken.Observe((loadDuration + cr.PromptEvalDuration).Seconds(), model)
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	// state is scraped into its own registry so models that have been
+	// unloaded and GPUs that have gone away aren't reported
+	var state metrics.Registry
+	queueDepth := state.Gauge("ollama_queue_depth", "Requests waiting for a model to be scheduled.")
+	runnerVRAM := state.Gauge("ollama_runner_vram_bytes", "Estimated VRAM used by a loaded model on each GPU.", "model", "gpu")
+	runnerMemory := state.Gauge("ollama_runner_memory_bytes", "Estimated memory used by a loaded model, including VRAM.", "model")
+	runnerSlots := state.Gauge("ollama_runner_slots", "Requests a loaded model can process in parallel.", "model")
+	runnerSlotsUsed := state.Gauge("ollama_runner_slots_used", "Requests a loaded model is processing.", "model")
+	gpuFree := state.Gauge("ollama_gpu_free_bytes", "Free memory reported by each GPU.", "gpu", "library")
+	gpuTotal := state.Gauge("ollama_gpu_total_bytes", "Total memory of each GPU.", "gpu", "library")
+
+	queueDepth.Set(float64(len(s.sched.pendingReqCh)))
+
+	s.sched.loadedMu.Lock()
+	for _, runner := range s.sched.loaded {
+		runner.refMu.Lock()
+		if runner.llama != nil {
+			name := runner.model.ShortName
+			for _, gpu := range runner.gpus {
+				if gpu.Library != "cpu" {
+					runnerVRAM.Set(float64(runner.llama.EstimatedVRAMByGPU(gpu.ID)), name, gpu.ID)
+				}
+			}
+			runnerMemory.Set(float64(runner.estimatedTotal), name)
+			runnerSlots.Set(float64(runner.numParallel), name)
+			runnerSlotsUsed.Set(float64(min(int(runner.refCount), runner.numParallel)), name)
+		}
+		runner.refMu.Unlock()
+	}
+	s.sched.loadedMu.Unlock()
+
+	for _, gpu := range s.sched.getGpuFn() {
+		if gpu.Library != "cpu" {
+			gpuFree.Set(float64(gpu.FreeMemory), gpu.ID, gpu.Library)
+			gpuTotal.Set(float64(gpu.TotalMemory), gpu.ID, gpu.Library)
+		}
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := serverMetrics.WriteTo(c.Writer); err != nil {
+		return
+	}
+	_, _ = state.WriteTo(c.Writer)
+}
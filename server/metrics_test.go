@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gpus := discover.GpuInfoList{{Library: "cuda", ID: "GPU-0"}}
+	gpus[0].TotalMemory = 24 * format.GigaByte
+	gpus[0].FreeMemory = 12 * format.GigaByte
+
+	var s Server
+	s.sched = InitScheduler(ctx)
+	s.sched.getGpuFn = func() discover.GpuInfoList { return gpus }
+	s.sched.loaded["/models/metrics-test"] = &runnerRef{
+		model:          &Model{ShortName: "metrics-test:latest"},
+		modelPath:      "/models/metrics-test",
+		llama:          &mockLlm{estimatedVRAMByGPU: map[string]uint64{"GPU-0": 3 * format.GigaByte}},
+		gpus:           gpus,
+		estimatedTotal: 4 * format.GigaByte,
+		numParallel:    2,
+		refCount:       1,
+	}
+	s.sched.pendingReqCh <- &LlmRequest{}
+
+	requestsTotal.Inc("metrics-test:latest", "chat")
+	recordCompletion("metrics-test:latest", llm.CompletionResponse{
+		PromptEvalCount:    30,
+		PromptEvalDuration: 100 * time.Millisecond,
+		PromptCachedCount:  10,
+		EvalCount:          20,
+		EvalDuration:       time.Second,
+	}, 400*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	s.MetricsHandler(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	for _, want := range []string{
+		`ollama_requests_total{model="metrics-test:latest",endpoint="chat"} 1`,
+		`ollama_prompt_tokens_total{model="metrics-test:latest"} 30`,
+		`ollama_prompt_cached_tokens_total{model="metrics-test:latest"} 10`,
+		`ollama_prompt_cache_hit_ratio{model="metrics-test:latest"} 0.3333333333333333`,
+		`ollama_eval_tokens_per_second_bucket{model="metrics-test:latest",le="32"} 1`,
+		`ollama_time_to_first_token_seconds_sum{model="metrics-test:latest"} 0.5`,
+		`ollama_queue_depth 1`,
+		`ollama_runner_vram_bytes{model="metrics-test:latest",gpu="GPU-0"} 3e+09`,
+		`ollama_runner_memory_bytes{model="metrics-test:latest"} 4e+09`,
+		`ollama_runner_slots{model="metrics-test:latest"} 2`,
+		`ollama_runner_slots_used{model="metrics-test:latest"} 1`,
+		`ollama_gpu_free_bytes{gpu="GPU-0",library="cuda"} 1.2e+10`,
+		`ollama_gpu_total_bytes{gpu="GPU-0",library="cuda"} 2.4e+10`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, w.Body.String())
+		}
+	}
+}
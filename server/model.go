@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"text/template/parse"
 
@@ -83,6 +84,39 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
 			if t, err := template.Named(s); err != nil {
 				slog.Debug("template detection", "error", err, "template", s)
+
+				eos, err := eosToken(layer)
+				if err != nil {
+					return nil, err
+				}
+
+				// translate the model's template if no template matches, or use
+				// it as is if it can't be translated
+				if t, err := template.Translate(withEOSToken(s, eos)); err == nil {
+					layer, err := NewLayer(strings.NewReader(t.String()), "application/vnd.ollama.image.template")
+					if err != nil {
+						return nil, err
+					}
+
+					layer.status = "using a template translated from the model's chat template"
+					layers = append(layers, &layerGGML{layer, nil})
+				} else if t, ok := jinjaTemplate(s, eos); ok {
+					slog.Debug("template translation", "error", err)
+
+					status := fmt.Sprintf("using the model's Jinja template, it can't be translated: %v", err)
+					var divergence *template.DivergenceError
+					if errors.As(err, &divergence) {
+						status = fmt.Sprintf("using the model's Jinja template, its translation renders these conversations differently: %s", strings.Join(divergence.Conversations, ", "))
+					}
+
+					layer, err := NewLayer(strings.NewReader(t), "application/vnd.ollama.image.template")
+					if err != nil {
+						return nil, err
+					}
+
+					layer.status = status
+					layers = append(layers, &layerGGML{layer, nil})
+				}
 			} else {
 				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
 				if err != nil {
@@ -112,6 +146,61 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 	return layers, nil
 }
 
+// eosToken returns the text of a model's EOS token. The vocabulary is
+// decoded again since it's too large to be decoded with the rest of the
+// model's metadata.
+func eosToken(layer *layerGGML) (string, error) {
+	if _, ok := layer.GGML.KV()["tokenizer.ggml.eos_token_id"]; !ok {
+		return "", nil
+	}
+
+	blob, err := layer.Open()
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+
+	f, _, err := ggml.Decode(blob, -1)
+	if err != nil {
+		return "", err
+	}
+
+	tokens := f.KV().Strings("tokenizer.ggml.tokens")
+	if eos := int(f.KV().Uint("tokenizer.ggml.eos_token_id")); eos < len(tokens) {
+		return tokens[eos], nil
+	}
+
+	return "", nil
+}
+
+// withEOSToken sets the EOS token of a chat template
+func withEOSToken(s, eos string) string {
+	if eos == "" {
+		return s
+	}
+
+	return fmt.Sprintf("{%%- set eos_token = %s %%}\n", strconv.Quote(eos)) + s
+}
+
+// jinjaTemplate returns a chat template as a Jinja template with its EOS
+// token set, or false if the template can't render a chat
+func jinjaTemplate(s, eos string) (string, bool) {
+	s = template.JinjaPrefix + withEOSToken(s, eos)
+
+	t, err := template.Parse(s)
+	if err != nil {
+		slog.Debug("template detection", "error", err)
+		return "", false
+	}
+
+	if err := t.Execute(io.Discard, template.Values{Messages: []api.Message{{Role: "user", Content: "Hello!"}}}); err != nil {
+		slog.Debug("template detection", "error", err)
+		return "", false
+	}
+
+	return s, true
+}
+
 func detectContentType(r io.Reader) (string, error) {
 	var b bytes.Buffer
 	if _, err := io.Copy(&b, r); err != nil {
@@ -153,52 +242,53 @@ func parseObjects(s string) []map[string]any {
 	return objs
 }
 
+// rangesOverToolCalls reports whether n is the node that ranges over .ToolCalls
+func rangesOverToolCalls(n parse.Node) bool {
+	if t, ok := n.(*parse.RangeNode); ok {
+		return slices.Contains(template.Identifiers(t.Pipe), "ToolCalls")
+	}
+
+	return false
+}
+
 // parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
 // mxyng: this only really works if the input contains tool calls in some JSON format
 func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
-	// create a subtree from the node that ranges over .ToolCalls
-	tmpl := m.Template.Subtree(func(n parse.Node) bool {
-		if t, ok := n.(*parse.RangeNode); ok {
-			return slices.Contains(template.Identifiers(t.Pipe), "ToolCalls")
-		}
-
-		return false
-	})
-
-	if tmpl == nil {
-		return nil, false
-	}
-
-	var b bytes.Buffer
-	if err := tmpl.Execute(&b, map[string][]api.ToolCall{
-		"ToolCalls": {
-			{
-				Function: api.ToolCallFunction{
-					Name: "@@name@@",
-					Arguments: api.ToolCallFunctionArguments{
-						"@@argument@@": 1,
-					},
+	b, ok := m.renderToolCalls([]api.ToolCall{
+		{
+			Function: api.ToolCallFunction{
+				Name: "@@name@@",
+				Arguments: api.ToolCallFunctionArguments{
+					"@@argument@@": 1,
 				},
 			},
 		},
-	}); err != nil {
-		return nil, false
-	}
-
-	templateObjects := parseObjects(b.String())
-	if len(templateObjects) == 0 {
+	})
+	if !ok {
 		return nil, false
 	}
 
 	// find the keys that correspond to the name and arguments fields
 	var name, arguments string
-	for k, v := range templateObjects[0] {
-		switch v.(type) {
-		case string:
-			name = k
-		case map[string]any:
-			arguments = k
+	for _, obj := range collectObjects(parseObjects(b)) {
+		for k, v := range obj {
+			switch v := v.(type) {
+			case string:
+				if v == "@@name@@" {
+					name = k
+				}
+			case map[string]any:
+				if _, ok := v["@@argument@@"]; ok {
+					arguments = k
+				}
+			}
+		}
+
+		if name != "" && arguments != "" {
+			break
 		}
+
+		name, arguments = "", ""
 	}
 
 	if name == "" || arguments == "" {
@@ -210,7 +300,57 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		return nil, false
 	}
 
-	// collect all nested objects
+	objs := collectObjects(responseObjects)
+
+	var toolCalls []api.ToolCall
+	for _, kv := range objs {
+		n, nok := kv[name].(string)
+		a, aok := kv[arguments].(map[string]any)
+		if nok && aok {
+			toolCalls = append(toolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{
+					Name:      n,
+					Arguments: a,
+				},
+			})
+		}
+	}
+
+	return toolCalls, len(toolCalls) > 0
+}
+
+// renderToolCalls renders calls in the format the model's template gives an
+// assistant's tool calls, which is the format the model responds with
+func (m *Model) renderToolCalls(calls []api.ToolCall) (string, bool) {
+	var b bytes.Buffer
+	if strings.HasPrefix(m.Template.String(), template.JinjaPrefix) {
+		// Jinja templates are rendered whole but without tools the tool calls
+		// are the only objects in the conversation
+		if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{
+			{Role: "user", Content: "@@user@@"},
+			{Role: "assistant", ToolCalls: calls},
+		}}); err != nil {
+			return "", false
+		}
+
+		return b.String(), true
+	}
+
+	// create a subtree from the node that ranges over .ToolCalls
+	tmpl := m.Template.Subtree(rangesOverToolCalls)
+	if tmpl == nil {
+		return "", false
+	}
+
+	if err := tmpl.Execute(&b, map[string][]api.ToolCall{"ToolCalls": calls}); err != nil {
+		return "", false
+	}
+
+	return b.String(), true
+}
+
+// collectObjects returns objs and all the objects nested in them
+func collectObjects(objs []map[string]any) []map[string]any {
 	var collect func(any) []map[string]any
 	collect = func(obj any) (all []map[string]any) {
 		switch o := obj.(type) {
@@ -228,24 +368,45 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		return all
 	}
 
-	var objs []map[string]any
-	for _, p := range responseObjects {
-		objs = append(objs, collect(p)...)
+	var all []map[string]any
+	for _, obj := range objs {
+		all = append(all, collect(obj)...)
 	}
 
-	var toolCalls []api.ToolCall
-	for _, kv := range objs {
-		n, nok := kv[name].(string)
-		a, aok := kv[arguments].(map[string]any)
-		if nok && aok {
-			toolCalls = append(toolCalls, api.ToolCall{
-				Function: api.ToolCallFunction{
-					Name:      n,
-					Arguments: a,
-				},
-			})
-		}
+	return all
+}
+
+// lintTemplate returns the problems with the model's template, including tool
+// calls which parseToolCalls can't parse back from the format the template
+// renders them in
+func (m *Model) lintTemplate() []string {
+	warnings := m.Template.Lint()
+	if !slices.Contains(m.Template.Vars(), "toolcalls") {
+		return warnings
 	}
 
-	return toolCalls, len(toolCalls) > 0
+	tmpl := m.Template.Subtree(rangesOverToolCalls)
+	if tmpl == nil {
+		return append(warnings, "template renders .ToolCalls outside of a range so tool calls can't be parsed")
+	}
+
+	call := api.ToolCall{
+		Function: api.ToolCallFunction{
+			Name:      "get_current_weather",
+			Arguments: api.ToolCallFunctionArguments{"location": "Paris"},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string][]api.ToolCall{"ToolCalls": {call}}); err != nil {
+		return append(warnings, fmt.Sprintf("tool calls can't be rendered: %v", err))
+	}
+
+	// a model responds with tool calls in the format the template renders them
+	calls, ok := m.parseToolCalls(b.String())
+	if !ok || len(calls) != 1 || calls[0].Function.Name != call.Function.Name || calls[0].Function.Arguments["location"] != "Paris" {
+		return append(warnings, fmt.Sprintf("tool calls rendered as %q can't be parsed", b.String()))
+	}
+
+	return warnings
 }
@@ -92,7 +92,7 @@ func (mp ModelPath) GetShortTagname() string {
 	return fmt.Sprintf("%s/%s/%s:%s", mp.Registry, mp.Namespace, mp.Repository, mp.Tag)
 }
 
-// GetManifestPath returns the path to the manifest file for the given model path, it is up to the caller to create the directory if it does not exist.
+// GetManifestPath returns the path to the manifest file for the given model path in the writable models directory, it is up to the caller to create the directory if it does not exist.
 func (mp ModelPath) GetManifestPath() (string, error) {
 	name := model.Name{
 		Host:      mp.Registry,
@@ -122,7 +122,53 @@ func GetManifestPath() (string, error) {
 	return path, nil
 }
 
+// modelRoots returns the models directories searched for manifests and blobs,
+// in order. The first is the writable directory set by OLLAMA_MODELS and the
+// rest are the read-only directories set by OLLAMA_MODELS_READONLY.
+func modelRoots() []string {
+	return append([]string{envconfig.Models()}, envconfig.ReadOnlyModels()...)
+}
+
+// findManifestPath returns the path to the manifest for n in the first models
+// directory that has one, along with that directory. If no directory has a
+// manifest for n, the path in the writable models directory is returned.
+func findManifestPath(n model.Name) (path, root string) {
+	roots := modelRoots()
+	for _, root := range roots {
+		p := filepath.Join(root, "manifests", n.Filepath())
+		if _, err := os.Stat(p); err == nil {
+			return p, root
+		}
+	}
+
+	return filepath.Join(roots[0], "manifests", n.Filepath()), roots[0]
+}
+
+// GetBlobsPath returns the path to the blob with the given digest, or to the
+// blobs directory if digest is empty. If the blob is not in the writable models
+// directory but is in a read-only one, the read-only path is returned so the
+// blob can be read in place.
 func GetBlobsPath(digest string) (string, error) {
+	path, err := getWritableBlobsPath(digest)
+	if err != nil || digest == "" {
+		return path, err
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		for _, root := range envconfig.ReadOnlyModels() {
+			p := filepath.Join(root, "blobs", filepath.Base(path))
+			if _, err := os.Stat(p); err == nil {
+				return p, nil
+			}
+		}
+	}
+
+	return path, nil
+}
+
+// getWritableBlobsPath is like GetBlobsPath but only considers the writable
+// models directory. Use it for any path that will be written to or removed.
+func getWritableBlobsPath(digest string) (string, error) {
 	// only accept actual sha256 digests
 	pattern := "^sha256[:-][0-9a-fA-F]{64}$"
 	re := regexp.MustCompile(pattern)
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// pinnedDuration is the session duration of pinned runners
+const pinnedDuration = time.Duration(math.MaxInt64)
+
+// preloadInterval is how often the preload config is checked for changes and
+// pinned models that aren't loaded are loaded again
+var preloadInterval = 5 * time.Second
+
+// preloadConfig lists the models to load when the server starts. It's read
+// from the file named by OLLAMA_PRELOAD.
+type preloadConfig struct {
+	Models []preloadModel `json:"models"`
+}
+
+// preloadModel is a model to load when the server starts and the settings
+// the scheduler uses whenever it loads the model
+type preloadModel struct {
+	Model string `json:"model"`
+
+	// KeepAlive is how long the model stays loaded after it's preloaded
+	KeepAlive *api.Duration `json:"keep_alive,omitempty"`
+
+	modelConfig
+}
+
+// modelConfig is how the scheduler loads a model
+type modelConfig struct {
+	// Pinned models are never unloaded to make room for other models and
+	// are loaded again if they're unloaded
+	Pinned bool `json:"pinned,omitempty"`
+
+	NumCtx      int `json:"num_ctx,omitempty"`
+	NumParallel int `json:"num_parallel,omitempty"`
+
+	// GPUs are the IDs of the GPUs the model can be loaded on
+	GPUs []string `json:"gpus,omitempty"`
+}
+
+func readPreloadConfig(path string) (*preloadConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config preloadConfig
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, m := range config.Models {
+		if m.Model == "" {
+			return nil, fmt.Errorf("%s: model %w", path, errRequired)
+		}
+	}
+
+	return &config, nil
+}
+
+// modelConfig returns the settings of a model from the preload config
+func (s *Scheduler) modelConfig(modelPath string) modelConfig {
+	s.configsMu.Lock()
+	defer s.configsMu.Unlock()
+	return s.configs[modelPath]
+}
+
+// setModelConfigs replaces the settings of models from the preload config
+// and pins or unpins loaded runners to match
+func (s *Scheduler) setModelConfigs(configs map[string]modelConfig) {
+	s.configsMu.Lock()
+	s.configs = configs
+	s.configsMu.Unlock()
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, runner := range s.loaded {
+		runner.refMu.Lock()
+		pinned := configs[runner.modelPath].Pinned
+		if pinned && !runner.pinned {
+			slog.Info("pinning model", "model", runner.modelPath)
+			if runner.expireTimer != nil {
+				runner.expireTimer.Stop()
+				runner.expireTimer = nil
+			}
+			runner.sessionDuration = pinnedDuration
+			runner.expiresAt = time.Time{}
+		} else if !pinned && runner.pinned {
+			slog.Info("unpinning model", "model", runner.modelPath)
+			runner.sessionDuration = envconfig.KeepAlive()
+			if runner.refCount == 0 {
+				s.expireAfterDuration(runner)
+			}
+		}
+		runner.pinned = pinned
+		runner.refMu.Unlock()
+	}
+}
+
+// preload loads the models in the preload config at path and keeps pinned
+// models loaded until ctx is done. The config is read again when it changes.
+func (s *Server) preload(ctx context.Context, path string) {
+	var config *preloadConfig
+	var modTime time.Time
+
+	ticker := time.NewTicker(preloadInterval)
+	defer ticker.Stop()
+	for {
+		changed := false
+		if fi, err := os.Stat(path); err != nil {
+			if !errors.Is(err, os.ErrNotExist) || config != nil {
+				slog.Warn("unable to read preload config", "path", path, "error", err)
+			}
+		} else if !fi.ModTime().Equal(modTime) {
+			modTime = fi.ModTime()
+			if c, err := readPreloadConfig(path); err != nil {
+				slog.Warn("invalid preload config, keeping the previous config", "error", err)
+			} else {
+				slog.Info("read preload config", "path", path, "models", len(c.Models))
+				config, changed = c, true
+			}
+		}
+
+		if config != nil {
+			s.applyPreloadConfig(ctx, config, changed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyPreloadConfig sets the scheduler's model settings from config and
+// loads its models: all of them if the config changed, otherwise only pinned
+// models that aren't loaded
+func (s *Server) applyPreloadConfig(ctx context.Context, config *preloadConfig, changed bool) {
+	models := make([]*Model, len(config.Models))
+	configs := make(map[string]modelConfig)
+	for i, m := range config.Models {
+		model, err := GetModel(m.Model)
+		if err != nil {
+			if changed {
+				slog.Warn("unable to preload model", "model", m.Model, "error", err)
+			}
+			continue
+		}
+
+		models[i] = model
+		configs[model.ModelPath] = m.modelConfig
+	}
+
+	s.sched.setModelConfigs(configs)
+
+	for i, m := range config.Models {
+		model := models[i]
+		if model == nil || (!changed && !m.Pinned) {
+			continue
+		}
+
+		s.sched.loadedMu.Lock()
+		_, loaded := s.sched.loaded[model.ModelPath]
+		s.sched.loadedMu.Unlock()
+		if loaded && !changed {
+			continue
+		}
+
+		opts, err := modelOptions(model, nil)
+		if err != nil {
+			slog.Warn("unable to preload model", "model", m.Model, "error", err)
+			continue
+		}
+
+		if err := s.loadModel(ctx, model, opts, m.KeepAlive); err != nil {
+			slog.Warn("unable to preload model", "model", m.Model, "error", err)
+		}
+	}
+}
+
+// loadModel loads a model without using it
+func (s *Server) loadModel(ctx context.Context, model *Model, opts api.Options, keepAlive *api.Duration) error {
+	// canceling the context releases the runner once it's loaded
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
+	select {
+	case <-runnerCh:
+		slog.Info("preloaded model", "model", model.ShortName)
+		return nil
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
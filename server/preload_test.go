@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestReadPreloadConfig(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		want   *preloadConfig
+		err    string
+	}{
+		{
+			name:   "models",
+			config: `{"models": [{"model": "llama3.2", "pinned": true, "num_ctx": 8192, "num_parallel": 2, "gpus": ["0"]}, {"model": "qwen2.5", "keep_alive": "1h"}]}`,
+			want: &preloadConfig{Models: []preloadModel{
+				{Model: "llama3.2", modelConfig: modelConfig{Pinned: true, NumCtx: 8192, NumParallel: 2, GPUs: []string{"0"}}},
+				{Model: "qwen2.5", KeepAlive: &api.Duration{Duration: time.Hour}},
+			}},
+		},
+		{name: "unknown field", config: `{"models": [{"model": "llama3.2", "pinned": true, "num_gpu": 1}]}`, err: `unknown field "num_gpu"`},
+		{name: "missing model", config: `{"models": [{"pinned": true}]}`, err: "model is required"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "preload.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o644))
+
+			config, err := readPreloadConfig(path)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, config)
+		})
+	}
+}
+
+func TestFindRunnerToUnloadPinned(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	r1 := &runnerRef{modelPath: "a", sessionDuration: 1, numParallel: 1}
+	r2 := &runnerRef{modelPath: "b", sessionDuration: 2, numParallel: 1}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loaded["b"] = r2
+	s.loadedMu.Unlock()
+
+	s.setModelConfigs(map[string]modelConfig{"a": {Pinned: true}})
+	require.Equal(t, r2, s.findRunnerToUnload())
+	require.True(t, r1.pinned)
+	require.Equal(t, pinnedDuration, r1.sessionDuration)
+
+	s.setModelConfigs(map[string]modelConfig{"a": {Pinned: true}, "b": {Pinned: true}})
+	require.Nil(t, s.findRunnerToUnload())
+
+	// unpinned runners expire after the default keep alive
+	s.setModelConfigs(map[string]modelConfig{"b": {Pinned: true}})
+	require.Equal(t, r1, s.findRunnerToUnload())
+	r1.refMu.Lock()
+	require.False(t, r1.pinned)
+	require.Equal(t, 5*time.Minute, r1.sessionDuration)
+	require.NotNil(t, r1.expireTimer)
+	r1.expireTimer.Stop()
+	r1.refMu.Unlock()
+}
+
+func TestPreload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var s Server
+	s.sched = InitScheduler(ctx)
+	s.sched.getGpuFn = getGpuFn
+	s.sched.getCpuFn = getCpuFn
+
+	s.sched.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		return &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}, nil
+	}
+	s.sched.Run(ctx)
+
+	for _, name := range []string{"pinned", "preloaded", "elsewhere"} {
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture":          "llama",
+			"general.name":                  name,
+			"llama.context_length":          uint32(32),
+			"llama.embedding_length":        uint32(4096),
+			"llama.block_count":             uint32(1),
+			"llama.attention.head_count":    uint32(32),
+			"llama.attention.head_count_kv": uint32(32),
+			"tokenizer.ggml.tokens":         []string{" "},
+			"tokenizer.ggml.scores":         []float32{0},
+			"tokenizer.ggml.token_type":     []int32{0},
+		}, []ggml.Tensor{
+			{Name: "blk.0.attn.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "output.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	pinned, err := GetModel("pinned")
+	require.NoError(t, err)
+	preloaded, err := GetModel("preloaded")
+	require.NoError(t, err)
+	elsewhere, err := GetModel("elsewhere")
+	require.NoError(t, err)
+
+	config := &preloadConfig{Models: []preloadModel{
+		{Model: "pinned", modelConfig: modelConfig{Pinned: true, NumCtx: 8192, NumParallel: 1}},
+		{Model: "preloaded", KeepAlive: &api.Duration{Duration: time.Hour}},
+		{Model: "elsewhere", modelConfig: modelConfig{GPUs: []string{"GPU-1"}}},
+		{Model: "missing"},
+	}}
+	s.applyPreloadConfig(ctx, config, true)
+
+	loaded := func(m *Model) *runnerRef {
+		s.sched.loadedMu.Lock()
+		defer s.sched.loadedMu.Unlock()
+		return s.sched.loaded[m.ModelPath]
+	}
+
+	runner := loaded(pinned)
+	require.NotNil(t, runner)
+	require.True(t, runner.pinned)
+	require.Equal(t, 8192, runner.Options.NumCtx)
+	require.Equal(t, 1, runner.numParallel)
+
+	runner = loaded(preloaded)
+	require.NotNil(t, runner)
+	require.False(t, runner.pinned)
+	require.Equal(t, time.Hour, runner.sessionDuration)
+
+	// the model's only GPU isn't available
+	require.Nil(t, loaded(elsewhere))
+
+	// requests for a pinned model use its settings and don't change its keep alive
+	reqCtx, reqDone := context.WithCancel(ctx)
+	runnerCh, errCh := s.sched.GetRunner(reqCtx, pinned, api.DefaultOptions(), &api.Duration{Duration: 0})
+	select {
+	case r := <-runnerCh:
+		require.Same(t, loaded(pinned), r)
+	case err := <-errCh:
+		t.Fatal(err)
+	}
+	reqDone()
+	require.Equal(t, pinnedDuration, loaded(pinned).sessionDuration)
+
+	// pinned models are loaded again when they're unloaded
+	s.sched.expireRunner(pinned)
+	for loaded(pinned) != nil {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for unload")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	s.applyPreloadConfig(ctx, config, false)
+	runner = loaded(pinned)
+	require.NotNil(t, runner)
+	require.True(t, runner.pinned)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/model/models/mllama"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/tracing"
 )
 
 type tokenizeFunc func(context.Context, string) ([]int, error)
@@ -23,6 +24,9 @@ var errTooManyImages = errors.New("vision model only supports a single image per
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
 func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
+	ctx, span := tracing.Start(ctx, "chat.prompt", "messages", len(msgs))
+	defer span.End()
+
 	var system []api.Message
 
 	isMllama := checkMllamaModelFamily(m)
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// pullRateLimiter limits the combined download rate of all pulls. It is
+// configured by OLLAMA_PULL_RATE_LIMIT and is nil when no limit is set.
+var pullRateLimiter = sync.OnceValue(func() *rateLimiter {
+	return newRateLimiter(envconfig.PullRateLimit())
+})
+
+// rateLimiter is a token bucket which limits the number of bytes consumed per
+// second. A nil *rateLimiter, or one with a rate of zero, imposes no limit.
+type rateLimiter struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing rate bytes per second, with a
+// burst of up to one second worth of bytes. It returns nil if rate is not
+// positive.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// wait consumes n bytes from the bucket, blocking until the bucket is no
+// longer in debt or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if d == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// setRate changes the rate of l, with zero being no limit.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		// start with a full bucket, as newRateLimiter does
+		l.tokens = float64(rate)
+		l.last = time.Now()
+	}
+
+	l.rate = float64(max(rate, 0))
+	l.tokens = min(l.tokens, l.rate)
+}
+
+// maxRateLimitedRead bounds the size of a single read through a
+// rateLimitedReader so large reads do not cause long, bursty pauses.
+const maxRateLimitedRead = 32 << 10
+
+// rateLimitedReader is an io.Reader which waits on each of its limiters after
+// every read.
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxRateLimitedRead {
+		p = p[:maxRateLimitedRead]
+	}
+
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if err := l.wait(r.ctx, n); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterNil(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Fatalf("expected nil limiter, got %v", l)
+	}
+
+	var l *rateLimiter
+	if err := l.wait(t.Context(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	// 1000 bytes per second with a 1000 byte burst: reading 1500 bytes
+	// should take at least half a second.
+	r := &rateLimitedReader{
+		ctx:      t.Context(),
+		r:        bytes.NewReader(make([]byte, 1500)),
+		limiters: []*rateLimiter{newRateLimiter(1000)},
+	}
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1500 {
+		t.Fatalf("read %d bytes, want 1500", n)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read took %s, want at least 400ms", elapsed)
+	}
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	l := newRateLimiter(1)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if err := l.wait(ctx, 100); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/ollama/ollama/server/internal/client/ollama"
 	"github.com/ollama/ollama/server/internal/registry"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/tracing"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -53,6 +54,8 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+	audit *auditLog
+	keys  *apiKeys
 }
 
 func init() {
@@ -106,11 +109,15 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 		return nil, nil, nil, err
 	}
 
+	ctx, span := tracing.Start(ctx, "scheduler.get_runner", "model", model.ShortName)
+	defer span.End()
+
 	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
 	var runner *runnerRef
 	select {
 	case runner = <-runnerCh:
 	case err = <-errCh:
+		span.SetError(err)
 		return nil, nil, nil, err
 	}
 
@@ -119,6 +126,10 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
+	ctx, span := tracing.Start(tracing.Extract(c.Request.Context(), c.Request.Header), "ollama.generate")
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
 	var req api.GenerateRequest
 	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
@@ -127,6 +138,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	span.SetAttributes("model", req.Model)
+
+	audit := auditFrom(c)
+	audit.setRequest(req.Model, req.Options)
+	audit.setPrompt(req.Prompt)
 
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
@@ -191,6 +207,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	}
 
 	checkpointLoaded := time.Now()
+	requestsTotal.Inc(m.ShortName, "generate")
 
 	// load the model
 	if req.Prompt == "" {
@@ -323,11 +340,16 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			if _, err := sb.WriteString(cr.Content); err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
+			audit.addResponse(cr.Content)
 
 			if cr.Done {
 				res.DoneReason = cr.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				recordCompletion(m.ShortName, cr, res.LoadDuration)
+				audit.setDone(cr)
+				useTokens(c, cr.PromptEvalCount+cr.EvalCount)
+				span.SetAttributes("done_reason", res.DoneReason, "prompt_eval_count", cr.PromptEvalCount, "eval_count", cr.EvalCount)
 
 				if !req.Raw {
 					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
@@ -388,6 +410,9 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	audit := auditFrom(c)
+	audit.setRequest(req.Model, req.Options)
+
 	truncate := true
 
 	if req.Truncate != nil && !*req.Truncate {
@@ -416,6 +441,8 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		}
 	}
 
+	audit.setInput(input)
+
 	name, err := getExistingName(model.ParseName(req.Model))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
@@ -429,6 +456,7 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 	}
 
 	checkpointLoaded := time.Now()
+	requestsTotal.Inc(m.ShortName, "embed")
 
 	if len(input) == 0 {
 		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
@@ -494,6 +522,8 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
 	}
+	audit.setPromptTokens(count)
+	useTokens(c, count)
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -524,30 +554,45 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	audit := auditFrom(c)
+	audit.setRequest(req.Model, req.Options)
+	audit.setPrompt(req.Prompt)
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{}, req.Options, req.KeepAlive)
+	r, m, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
 	}
 
+	requestsTotal.Inc(m.ShortName, "embeddings")
+
 	// an empty request loads the model
 	if req.Prompt == "" {
 		c.JSON(http.StatusOK, api.EmbeddingResponse{Embedding: []float64{}})
 		return
 	}
 
+	tokens, err := r.Tokenize(c.Request.Context(), req.Prompt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
 		return
 	}
 
+	audit.setPromptTokens(len(tokens))
+	useTokens(c, len(tokens))
+
 	var e []float64
 	for _, v := range embedding {
 		e = append(e, float64(v))
@@ -583,6 +628,11 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkNotAlias(name); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -591,13 +641,21 @@ func (s *Server) PullHandler(c *gin.Context) {
 		}
 
 		regOpts := &registryOptions{
-			Insecure: req.Insecure,
+			Insecure:  req.Insecure,
+			LimitRate: req.LimitRate,
 		}
 
-		ctx, cancel := context.WithCancel(c.Request.Context())
-		defer cancel()
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+
+		untrack := trackPull(name, cancel)
+		defer untrack()
 
 		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
+			if errors.Is(context.Cause(ctx), errPullPaused) {
+				ch <- api.ProgressResponse{Status: "paused"}
+				return
+			}
 			ch <- gin.H{"error": err.Error()}
 		}
 	}()
@@ -610,6 +668,38 @@ func (s *Server) PullHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+func (s *Server) PullPauseHandler(c *gin.Context) {
+	var req api.PullRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(cmp.Or(req.Model, req.Name))
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if pausePulls(name) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no pull in progress for model '%s'", cmp.Or(req.Model, req.Name))})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ProgressResponse{Status: "paused"})
+}
+
 func (s *Server) PushHandler(c *gin.Context) {
 	var req api.PushRequest
 	err := c.ShouldBindJSON(&req)
@@ -726,7 +816,10 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		return
 	}
 
-	if err := m.Remove(); err != nil {
+	if err := m.Remove(); errors.Is(err, errReadOnlyModel) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("model '%s' is in the read-only models directory %q and can't be deleted", cmp.Or(r.Model, r.Name), m.Root())})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -796,6 +889,7 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		Families:          m.Config.ModelFamilies,
 		ParameterSize:     m.Config.ModelType,
 		QuantizationLevel: m.Config.FileType,
+		Imatrix:           m.Config.Imatrix,
 	}
 
 	if req.System != "" {
@@ -906,6 +1000,7 @@ func (s *Server) ListHandler(c *gin.Context) {
 	}
 
 	models := []api.ListModelResponse{}
+	byName := make(map[model.Name]api.ListModelResponse, len(ms))
 	for n, m := range ms {
 		var cf ConfigV2
 
@@ -924,7 +1019,7 @@ func (s *Server) ListHandler(c *gin.Context) {
 		}
 
 		// tag should never be masked
-		models = append(models, api.ListModelResponse{
+		resp := api.ListModelResponse{
 			Model:      n.DisplayShortest(),
 			Name:       n.DisplayShortest(),
 			Size:       m.Size(),
@@ -937,7 +1032,32 @@ func (s *Server) ListHandler(c *gin.Context) {
 				ParameterSize:     cf.ModelType,
 				QuantizationLevel: cf.FileType,
 			},
-		})
+			Root:     m.Root(),
+			ReadOnly: m.ReadOnly(),
+		}
+		models = append(models, resp)
+		byName[n] = resp
+	}
+
+	as, err := readAliases()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for k, a := range as {
+		n, target := model.ParseName(k), model.ParseName(a.Target)
+		resp, ok := byName[target]
+		if !ok {
+			slog.Warn("alias target not found", "alias", n, "target", target)
+			continue
+		}
+
+		resp.Model = n.DisplayShortest()
+		resp.Name = n.DisplayShortest()
+		resp.ModifiedAt = a.ModifiedAt
+		resp.Target = target.DisplayShortest()
+		models = append(models, resp)
 	}
 
 	slices.SortStableFunc(models, func(i, j api.ListModelResponse) int {
@@ -980,6 +1100,11 @@ func (s *Server) CopyHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkNotAlias(dst); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := CopyModel(src, dst); errors.Is(err, os.ErrNotExist) {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Source)})
 	} else if err != nil {
@@ -987,6 +1112,226 @@ func (s *Server) CopyHandler(c *gin.Context) {
 	}
 }
 
+func (s *Server) DiffHandler(c *gin.Context) {
+	var r api.DiffRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var names [2]model.Name
+	for i, s := range []string{r.From, r.To} {
+		n := model.ParseName(resolveAlias(s))
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", s)})
+			return
+		}
+		n, err := getExistingName(n)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := ParseNamedManifest(n); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", s)})
+			return
+		}
+		names[i] = n
+	}
+
+	resp, err := diffModels(names[0], names[1])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) TemplateRenderHandler(c *gin.Context) {
+	var req api.TemplateRenderRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" && req.Template == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model or template is required"})
+		return
+	}
+
+	// the model is only loaded to count the tokens of the prompt and
+	// truncate messages the same way a chat request would
+	var r llm.LlamaServer
+	var opts *api.Options
+	m := &Model{Template: template.DefaultTemplate}
+	if req.Model != "" {
+		name := model.ParseName(req.Model)
+		if !name.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", req.Model)})
+			return
+		}
+
+		name, err := getExistingName(name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", req.Model)})
+			return
+		}
+
+		r, m, opts, err = s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{model.CapabilityCompletion}, req.Options, req.KeepAlive)
+		if err != nil {
+			handleScheduleError(c, req.Model, err)
+			return
+		}
+	}
+
+	if req.Template != "" {
+		tmpl, err := template.Parse(req.Template)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		m.Template = tmpl
+	}
+
+	if len(req.Tools) == 0 {
+		req.Tools = m.Tools
+	}
+
+	msgs := append(slices.Clone(m.Messages), req.Messages...)
+	if req.Prompt != "" {
+		msgs = append(msgs, api.Message{Role: "user", Content: req.Prompt})
+	}
+
+	if system := cmp.Or(req.System, m.System); system != "" && (len(req.Messages) == 0 || req.Messages[0].Role != "system") {
+		msgs = append([]api.Message{{Role: "system", Content: system}}, msgs...)
+	}
+
+	var prompt string
+	switch {
+	case req.Prompt != "" && req.Suffix != "":
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Prompt: req.Prompt, Suffix: req.Suffix}); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		prompt = b.String()
+	case r != nil:
+		var err error
+		prompt, _, err = chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs, Tools: req.Tools}); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		prompt = b.String()
+	}
+
+	resp := api.TemplateRenderResponse{
+		Prompt:   prompt,
+		Warnings: m.lintTemplate(),
+	}
+
+	if r != nil {
+		tokens, err := r.Tokenize(c.Request.Context(), prompt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.TokenCount = len(tokens)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) AliasHandler(c *gin.Context) {
+	var r api.AliasRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n := model.ParseName(r.Alias)
+	if !n.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", r.Alias)})
+		return
+	}
+
+	target := model.ParseName(r.Target)
+	if !target.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("target %q is invalid", r.Target)})
+		return
+	}
+	target, err := getExistingName(target)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prev, err := setAlias(n, target)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Target)})
+		return
+	case errors.Is(err, errAliasShadowsModel), errors.Is(err, errAliasTarget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// if the alias was repointed, replace the runner for the old target so
+	// requests for the alias don't have to wait for the new model to load
+	if s.sched != nil && prev.IsValid() && !prev.EqualFold(target) {
+		from, err := GetModel(prev.String())
+		if err != nil {
+			return
+		}
+		to, err := GetModel(target.String())
+		if err != nil {
+			return
+		}
+		go s.sched.swapRunner(context.Background(), from, to)
+	}
+}
+
+func (s *Server) DeleteAliasHandler(c *gin.Context) {
+	var r api.AliasRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n := model.ParseName(r.Alias)
+	if !n.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", r.Alias)})
+		return
+	}
+
+	if err := deleteAlias(n); errors.Is(err, errAliasNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("alias %q not found", r.Alias)})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func (s *Server) HeadBlobHandler(c *gin.Context) {
 	path, err := GetBlobsPath(c.Param("digest"))
 	if err != nil {
@@ -1168,10 +1513,13 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	}
 	corsConfig.AllowOrigins = envconfig.AllowedOrigins()
 
-	r := gin.Default()
+	r := gin.New()
 	r.Use(
+		gin.LoggerWithFormatter(logRequestFormatter),
+		gin.Recovery(),
 		cors.New(corsConfig),
 		allowedHostsMiddleware(s.addr),
+		authMiddleware(s.keys),
 	)
 
 	// General
@@ -1179,9 +1527,11 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.HEAD("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
 	r.GET("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
+	r.GET("/metrics", s.MetricsHandler)
 
 	// Local model cache management (new implementation is at end of function)
 	r.POST("/api/pull", s.PullHandler)
+	r.POST("/api/pull/pause", s.PullPauseHandler)
 	r.POST("/api/push", s.PushHandler)
 	r.HEAD("/api/tags", s.ListHandler)
 	r.GET("/api/tags", s.ListHandler)
@@ -1193,18 +1543,22 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
 	r.POST("/api/copy", s.CopyHandler)
+	r.POST("/api/diff", s.DiffHandler)
+	r.POST("/api/template/render", s.TemplateRenderHandler)
+	r.POST("/api/alias", s.AliasHandler)
+	r.DELETE("/api/alias", s.DeleteAliasHandler)
 
 	// Inference
 	r.GET("/api/ps", s.PsHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
-	r.POST("/api/embed", s.EmbedHandler)
-	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/generate", auditMiddleware(s.audit), s.GenerateHandler)
+	r.POST("/api/chat", auditMiddleware(s.audit), s.ChatHandler)
+	r.POST("/api/embed", auditMiddleware(s.audit), s.EmbedHandler)
+	r.POST("/api/embeddings", auditMiddleware(s.audit), s.EmbeddingsHandler)
 
 	// Inference (OpenAI compatibility)
-	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
-	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	r.POST("/v1/chat/completions", auditMiddleware(s.audit), openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", auditMiddleware(s.audit), openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", auditMiddleware(s.audit), openai.EmbeddingsMiddleware(), s.EmbedHandler)
 	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
 	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
 
@@ -1215,9 +1569,11 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 			Logger:   slog.Default(), // TODO(bmizerany): Take a logger, do not use slog.Default()
 			Fallback: r,
 
-			Prune: PruneLayers,
+			Prune:     PruneLayers,
+			StartPull: startRegistryPull,
 		}
-		return rs, nil
+		// pulls and deletes are handled by the registry without gin
+		return authHandler(s.keys, rs, "/api/pull", "/api/delete"), nil
 	}
 
 	return r, nil
@@ -1275,6 +1631,32 @@ func Serve(ln net.Listener) error {
 
 	s := &Server{addr: ln.Addr()}
 
+	if cert, key := envconfig.TLSCert(), envconfig.TLSKey(); cert != "" || key != "" {
+		t, err := loadTLSCertificates(cert, key, envconfig.TLSClientCA())
+		if err != nil {
+			return err
+		}
+		ln = t.listener(ln)
+		slog.Info("serving TLS", "cert", cert, "client_ca", envconfig.TLSClientCA())
+	}
+
+	if path := envconfig.APIKeys(); path != "" {
+		s.keys, err = loadAPIKeys(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if path := envconfig.AuditLog(); path != "" {
+		s.audit, err = openAuditLog(path)
+		if err != nil {
+			return err
+		}
+		defer s.audit.Close()
+	}
+
+	shutdownTracing := tracing.Configure(envconfig.TraceEndpoint(), "ollama")
+
 	var rc *ollama.Registry
 	if useClient2 {
 		var err error
@@ -1317,11 +1699,20 @@ func Serve(ln net.Listener) error {
 		srvr.Close()
 		schedDone()
 		sched.unloadAllRunners()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Warn("failed to export traces", "error", err)
+		}
+		cancel()
 		done()
 	}()
 
 	s.sched.Run(schedCtx)
 
+	if path := envconfig.Preload(); path != "" {
+		go s.preload(schedCtx, path)
+	}
+
 	// At startup we retrieve GPU information so we can get log messages before loading a model
 	// This will log warnings to the log in case we have problems with detected GPUs
 	gpus := discover.GetGPUInfo()
@@ -1412,7 +1803,15 @@ func (s *Server) PsHandler(c *gin.Context) {
 			Digest:    model.Digest,
 			Details:   modelDetails,
 			ExpiresAt: v.expiresAt,
+			Pinned:    v.pinned,
+			Slots:     v.numParallel,
 		}
+
+		v.refMu.Lock()
+		// requests beyond the runner's slots wait for one to free up
+		mr.SlotsUsed = min(int(v.refCount), v.numParallel)
+		v.refMu.Unlock()
+
 		// The scheduler waits to set expiresAt, so if a model is loading it's
 		// possible that it will be set to the unix epoch. For those cases, just
 		// calculate the time w/ the sessionDuration instead.
@@ -1434,6 +1833,9 @@ func (s *Server) PsHandler(c *gin.Context) {
 
 func (s *Server) ChatHandler(c *gin.Context) {
 	checkpointStart := time.Now()
+	ctx, span := tracing.Start(tracing.Extract(c.Request.Context(), c.Request.Header), "ollama.chat")
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
 
 	var req api.ChatRequest
 	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
@@ -1443,6 +1845,11 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	span.SetAttributes("model", req.Model)
+
+	audit := auditFrom(c)
+	audit.setRequest(req.Model, req.Options)
+	audit.setMessages(req.Messages)
 
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
@@ -1496,6 +1903,20 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}
 
 	checkpointLoaded := time.Now()
+	requestsTotal.Inc(m.ShortName, "chat")
+
+	// tools and format set in the Modelfile are defaults for requests
+	// which don't specify their own
+	if len(req.Tools) == 0 && len(m.Tools) > 0 {
+		if err := m.CheckCapabilities(model.CapabilityTools); err != nil {
+			handleScheduleError(c, req.Model, err)
+			return
+		}
+		req.Tools = m.Tools
+	}
+	if len(req.Format) == 0 {
+		req.Format = m.Format
+	}
 
 	if len(req.Messages) == 0 {
 		c.JSON(http.StatusOK, api.ChatResponse{
@@ -1533,6 +1954,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			Format:  req.Format,
 			Options: opts,
 		}, func(r llm.CompletionResponse) {
+			audit.addResponse(r.Content)
 			res := api.ChatResponse{
 				Model:     req.Model,
 				CreatedAt: time.Now().UTC(),
@@ -1550,6 +1972,10 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				res.DoneReason = r.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				recordCompletion(m.ShortName, r, res.LoadDuration)
+				audit.setDone(r)
+				useTokens(c, r.PromptEvalCount+r.EvalCount)
+				span.SetAttributes("done_reason", res.DoneReason, "prompt_eval_count", r.PromptEvalCount, "eval_count", r.EvalCount)
 			}
 
 			// TODO: tool call checking and filtering should be moved outside of this callback once streaming
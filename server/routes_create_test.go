@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -20,6 +21,7 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/template"
 )
 
 var stream bool = false
@@ -664,6 +666,24 @@ func TestCreateLicenses(t *testing.T) {
 	}
 }
 
+// statuses returns the statuses of a streamed create response
+func statuses(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var ss []string
+	dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+	for dec.More() {
+		var resp api.ProgressResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		ss = append(ss, resp.Status)
+	}
+
+	return ss
+}
+
 func TestCreateDetectTemplate(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -671,6 +691,8 @@ func TestCreateDetectTemplate(t *testing.T) {
 	t.Setenv("OLLAMA_MODELS", p)
 	var s Server
 
+	streaming := true
+
 	t.Run("matched", func(t *testing.T) {
 		_, digest := createBinFile(t, ggml.KV{
 			"tokenizer.chat_template": "{{ bos_token }}{% for message in messages %}{{'<|' + message['role'] + '|>' + '\n' + message['content'] + '<|end|>\n' }}{% endfor %}{% if add_generation_prompt %}{{ '<|assistant|>\n' }}{% else %}{{ eos_token }}{% endif %}",
@@ -710,6 +732,298 @@ func TestCreateDetectTemplate(t *testing.T) {
 			filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 		})
 	})
+
+	t.Run("jinja", func(t *testing.T) {
+		tokens := make([]string, 2000)
+		tokens[1500] = "<|turn_end|>"
+
+		_, digest := createBinFile(t, ggml.KV{
+			"tokenizer.chat_template":      "{% for message in messages %}<|turn|>{{ message.role | upper }}\n{{ message.content }}{{ eos_token }}{% endfor %}",
+			"tokenizer.ggml.tokens":        tokens,
+			"tokenizer.ggml.eos_token_id":  uint32(1500),
+			"tokenizer.ggml.bos_token_id":  uint32(1),
+			"tokenizer.ggml.add_bos_token": true,
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "jinja",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &streaming,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("jinja")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{{Role: "user", Content: "Hello!"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "<|turn|>USER\nHello!<|turn_end|>"; b.String() != want {
+			t.Errorf("expected %q, got %q", want, b.String())
+		}
+
+		// upper has no Go template translation
+		if !strings.HasPrefix(m.Template.String(), template.JinjaPrefix) {
+			t.Errorf("expected a Jinja template, got %q", m.Template.String())
+		}
+
+		if want := "using the model's Jinja template, it can't be translated: can't translate filter upper"; !slices.Contains(statuses(t, w), want) {
+			t.Errorf("expected status %q, got %v", want, statuses(t, w))
+		}
+	})
+
+	t.Run("divergent jinja", func(t *testing.T) {
+		tokens := make([]string, 2000)
+		tokens[1500] = "<|turn_end|>"
+
+		_, digest := createBinFile(t, ggml.KV{
+			"tokenizer.chat_template":     "{% for message in messages %}<|turn|>{{ message.role }}\n{{ message.content }}{% if message.tool_calls %}{{ message.tool_calls | tojson }}{% endif %}{{ eos_token }}{% endfor %}",
+			"tokenizer.ggml.tokens":       tokens,
+			"tokenizer.ggml.eos_token_id": uint32(1500),
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "divergent",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &streaming,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		if want := "using the model's Jinja template, its translation renders these conversations differently: a tool call, a tool result"; !slices.Contains(statuses(t, w), want) {
+			t.Errorf("expected status %q, got %v", want, statuses(t, w))
+		}
+	})
+
+	t.Run("translated jinja", func(t *testing.T) {
+		tokens := make([]string, 2000)
+		tokens[1500] = "<|turn_end|>"
+
+		_, digest := createBinFile(t, ggml.KV{
+			"tokenizer.chat_template":     "<|conversation|>A conversation between a user and an assistant which answers briefly.\n{% for message in messages %}<|turn|>{{ message.role }}\n{{ message.content }}{{ eos_token }}{% endfor %}{% if add_generation_prompt %}<|turn|>assistant\n{% endif %}",
+			"tokenizer.ggml.tokens":       tokens,
+			"tokenizer.ggml.eos_token_id": uint32(1500),
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "translated",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("translated")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{{ $add_generation_prompt := true }}{{ range .Messages }}{{ $add_generation_prompt = ne .Role "assistant" }}{{ end }}<|conversation|>A conversation between a user and an assistant which answers briefly.
+{{ range $i, $message := $.Messages }}<|turn|>{{ $message.Role }}
+{{ $message.Content }}<|turn_end|>{{ end }}{{ if $add_generation_prompt }}<|turn|>assistant
+{{ end }}`
+		if m.Template.String() != want {
+			t.Errorf("expected %q, got %q", want, m.Template.String())
+		}
+	})
+
+	t.Run("invalid jinja", func(t *testing.T) {
+		_, digest := createBinFile(t, ggml.KV{
+			"tokenizer.chat_template": "{{ raise_exception('unsupported') }}",
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "invalid",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("invalid")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Template.String() != template.DefaultTemplate.String() {
+			t.Errorf("expected the default template, got %q", m.Template.String())
+		}
+	})
+}
+
+func TestCreateQuantize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+		"llama.block_count":    uint32(1),
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Kind: 1, Shape: []uint64{256, 2}, WriterTo: bytes.NewReader(make([]byte, 1024))},
+		{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{256}, WriterTo: bytes.NewReader(make([]byte, 1024))},
+		{Name: "blk.0.attn_v.weight", Kind: 1, Shape: []uint64{256, 2}, WriterTo: bytes.NewReader(make([]byte, 1024))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:        "test",
+		Files:       map[string]string{"test.gguf": digest},
+		Quantize:    "q8_0",
+		TensorTypes: map[string]string{"token_embd.weight": "f16"},
+		Stream:      &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ft := g.KV().FileType().String(); ft != "Q8_0" {
+		t.Errorf("file type = %s, want Q8_0", ft)
+	}
+
+	kinds := make(map[string]uint32)
+	for _, tt := range g.Tensors().Items() {
+		kinds[tt.Name] = tt.Kind
+	}
+
+	if want := map[string]uint32{
+		"token_embd.weight":      1,
+		"blk.0.attn_norm.weight": 0,
+		"blk.0.attn_v.weight":    8,
+	}; !maps.Equal(kinds, want) {
+		t.Errorf("tensor types = %v, want %v", kinds, want)
+	}
+
+	// the source model, the quantized model and the config; no temporary
+	// files are left behind
+	blobs, err := filepath.Glob(filepath.Join(p, "blobs", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 3 {
+		t.Errorf("expected 3 blobs, got %v", blobs)
+	}
+
+	t.Run("llama.cpp quantization", func(t *testing.T) {
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture":                   "llama",
+			"general.file_type":                      uint32(1),
+			"llama.block_count":                      uint32(1),
+			"llama.context_length":                   uint32(32),
+			"llama.embedding_length":                 uint32(256),
+			"llama.feed_forward_length":              uint32(256),
+			"llama.attention.head_count":             uint32(2),
+			"llama.attention.head_count_kv":          uint32(2),
+			"llama.attention.layer_norm_rms_epsilon": float32(1e-5),
+			"tokenizer.ggml.model":                   "gpt2",
+			"tokenizer.ggml.tokens":                  []string{"a", "b"},
+			"tokenizer.ggml.token_type":              []int32{1, 1},
+		}, []ggml.Tensor{
+			{Name: "token_embd.weight", Kind: 1, Shape: []uint64{256, 2}, WriterTo: bytes.NewReader(make([]byte, 1024))},
+			{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{256}, WriterTo: bytes.NewReader(make([]byte, 1024))},
+			{Name: "blk.0.attn_v.weight", Kind: 1, Shape: []uint64{256, 256}, WriterTo: bytes.NewReader(make([]byte, 256*256*2))},
+		})
+
+		// types the Go quantizer doesn't implement are quantized by llama.cpp
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:     "test-q4_1",
+			Files:    map[string]string{"test.gguf": digest},
+			Quantize: "q4_1",
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test-q4_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(m.ModelPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		g, _, err := ggml.Decode(f, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ft := g.KV().FileType().String(); ft != "Q4_1" {
+			t.Errorf("file type = %s, want Q4_1", ft)
+		}
+
+		// which don't support tensor types
+		w = createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:        "test-q4_1",
+			Files:       map[string]string{"test.gguf": digest},
+			Quantize:    "q4_1",
+			TensorTypes: map[string]string{"token_embd.weight": "f16"},
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "tensor types and imatrix aren't supported") {
+			t.Errorf("expected an error for tensor types, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("imatrix without quantize", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:    "test",
+			Files:   map[string]string{"test.gguf": digest},
+			Imatrix: digest,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	t.Run("imatrix dataset missing", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:     "test",
+			Files:    map[string]string{"test.gguf": digest},
+			Quantize: "q3_k_m",
+			Imatrix:  "sha256:" + strings.Repeat("0", 64),
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d", w.Code)
+		}
+	})
 }
 
 func TestDetectModelTypeFromFiles(t *testing.T) {
@@ -110,3 +110,53 @@ func TestDeleteDuplicateLayers(t *testing.T) {
 
 	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
 }
+
+func TestDeleteReadOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	shared := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", shared)
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	// serve the models created above from a read-only models directory
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MODELS_READONLY", shared)
+
+	w = createRequest(t, s.ListHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ListResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Models) != 1 || resp.Models[0].Root != shared || !resp.Models[0].ReadOnly {
+		t.Fatalf("expected one read-only model from %s, actual %+v", shared, resp.Models)
+	}
+
+	if _, err := GetModel("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.DeleteHandler, api.DeleteRequest{Name: "test"})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code 403, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(shared, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(shared, "manifests", "registry.ollama.ai", "library", "test", "latest"),
+	})
+}
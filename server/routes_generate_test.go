@@ -18,6 +18,7 @@ import (
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
 )
 
 type mockRunner struct {
@@ -594,6 +595,138 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("messages with tools and a jinja template", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model: "test-jinja",
+			From:  "test",
+			Template: template.JinjaPrefix + `
+{%- if tools %}{{ tools | tojson }}{% endif %}
+{%- for message in messages %}
+{{- message.role }}: {{ message.content }}
+{%- for tool_call in message.tool_calls %}<call>{{ tool_call | tojson }}</call>{% endfor %}
+{% endfor %}`,
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		mock.CompletionFn = nil
+		mock.CompletionResponse = llm.CompletionResponse{
+			Content:    `<call>{"type":"function","function":{"name":"get_weather","arguments":{"location":"Seattle, WA"}}}</call>`,
+			Done:       true,
+			DoneReason: llm.DoneReasonStop,
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-jinja",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+			Tools:    api.Tools{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(mock.CompletionRequest.Prompt, "get_weather") {
+			t.Errorf("expected the prompt to contain the tools, got %q", mock.CompletionRequest.Prompt)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.ToolCall{{
+			Function: api.ToolCallFunction{
+				Name:      "get_weather",
+				Arguments: api.ToolCallFunctionArguments{"location": "Seattle, WA"},
+			},
+		}}
+		if diff := cmp.Diff(want, resp.Message.ToolCalls); diff != "" {
+			t.Errorf("tool calls mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("messages with model tools and format", func(t *testing.T) {
+		var tools api.Tools
+		if err := json.Unmarshal([]byte(`[{"type":"function","function":{"name":"get_time","description":"Get the time"}}]`), &tools); err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test-tools",
+			From:   "test",
+			Tools:  tools,
+			Format: json.RawMessage(`{"type": "object"}`),
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		mock.CompletionFn = nil
+		mock.CompletionResponse = llm.CompletionResponse{
+			Content:    "Hi!",
+			Done:       true,
+			DoneReason: llm.DoneReasonStop,
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-tools",
+			Messages: []api.Message{{Role: "user", Content: "What time is it?"}},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(mock.CompletionRequest.Prompt, "get_time") {
+			t.Errorf("expected the prompt to contain the model's tools, got %q", mock.CompletionRequest.Prompt)
+		}
+		if diff := cmp.Diff(`{"type":"object"}`, string(mock.CompletionRequest.Format)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+
+		// tools and format in the request replace the model's
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-tools",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather?"}},
+			Tools:    api.Tools{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}},
+			Format:   json.RawMessage(`"json"`),
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if strings.Contains(mock.CompletionRequest.Prompt, "get_time") || !strings.Contains(mock.CompletionRequest.Prompt, "get_weather") {
+			t.Errorf("expected the prompt to contain only the request's tools, got %q", mock.CompletionRequest.Prompt)
+		}
+		if diff := cmp.Diff(`"json"`, string(mock.CompletionRequest.Format)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+
+		w = createRequest(t, s.ShowHandler, api.ShowRequest{Model: "test-tools"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ShowResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{
+			`TOOL {"type":"function","function":{"name":"get_time","description":"Get the time",`,
+			`FORMAT {"type":"object"}`,
+		} {
+			if !strings.Contains(resp.Modelfile, want) {
+				t.Errorf("expected modelfile to contain %q, got:\n%s", want, resp.Modelfile)
+			}
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
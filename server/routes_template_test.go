@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestTemplateRender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: "{{ range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}",
+		System:   "You are a helpful assistant.",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	render := func(t *testing.T, req api.TemplateRenderRequest) api.TemplateRenderResponse {
+		t.Helper()
+
+		w := createRequest(t, s.TemplateRenderHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.TemplateRenderResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	t.Run("missing model and template", func(t *testing.T) {
+		w := createRequest(t, s.TemplateRenderHandler, api.TemplateRenderRequest{})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("model not found", func(t *testing.T) {
+		w := createRequest(t, s.TemplateRenderHandler, api.TemplateRenderRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("stored template", func(t *testing.T) {
+		resp := render(t, api.TemplateRenderRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+
+		want := api.TemplateRenderResponse{
+			Prompt:     "system: You are a helpful assistant. user: Hello! ",
+			TokenCount: 8,
+		}
+
+		if diff := cmp.Diff(resp, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		resp := render(t, api.TemplateRenderRequest{
+			Model:  "test",
+			System: "Be brief.",
+			Prompt: "Hello!",
+		})
+
+		if want := "system: Be brief. user: Hello! "; resp.Prompt != want {
+			t.Errorf("expected prompt %q, got %q", want, resp.Prompt)
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		resp := render(t, api.TemplateRenderRequest{
+			Template: "{{ .System }} {{ .Prompt }}",
+			Prompt:   "Hello!",
+		})
+
+		want := api.TemplateRenderResponse{
+			Prompt:   " Hello!",
+			Warnings: []string{"template has no .Response or .Messages so .Response will be appended to it"},
+		}
+
+		if diff := cmp.Diff(resp, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		w := createRequest(t, s.TemplateRenderHandler, api.TemplateRenderRequest{Template: "{{ .Prompt"})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("tool calls", func(t *testing.T) {
+		tools := `{{ if .Tools }}{{ json .Tools }}{{ end }}{{ range .Messages }}{{ .Role }}: {{ .Content }}`
+
+		resp := render(t, api.TemplateRenderRequest{
+			Template: tools + `{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}{{ end }}`,
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+
+		if len(resp.Warnings) > 0 {
+			t.Errorf("unexpected warnings %v", resp.Warnings)
+		}
+
+		resp = render(t, api.TemplateRenderRequest{
+			Template: tools + `{{ range .ToolCalls }}<call>{{ .Function.Name }}</call>{{ end }}{{ end }}`,
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+
+		want := []string{`tool calls rendered as "<call>get_current_weather</call>" can't be parsed`}
+		if diff := cmp.Diff(resp.Warnings, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,6 +22,7 @@ import (
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/tracing"
 	"github.com/ollama/ollama/types/model"
 )
 
@@ -29,6 +32,7 @@ type LlmRequest struct {
 	opts            api.Options
 	origNumCtx      int // Track the initial ctx request
 	sessionDuration *api.Duration
+	config          modelConfig // settings from the preload config
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
@@ -43,6 +47,10 @@ type Scheduler struct {
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
+	// configs are the settings of models in the preload config by model path
+	configs   map[string]modelConfig
+	configsMu sync.Mutex
+
 	loadFn       func(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel int)
 	newServerFn  func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
 	getGpuFn     func() discover.GpuInfoList
@@ -62,6 +70,8 @@ var defaultParallel = 4
 
 var ErrMaxQueue = errors.New("server busy, please try again.  maximum pending requests exceeded")
 
+var errPinned = errors.New("unable to make room for the model, loaded models are pinned")
+
 func InitScheduler(ctx context.Context) *Scheduler {
 	maxQueue := envconfig.MaxQueue()
 	sched := &Scheduler{
@@ -70,6 +80,7 @@ func InitScheduler(ctx context.Context) *Scheduler {
 		expiredCh:     make(chan *runnerRef, maxQueue),
 		unloadedCh:    make(chan any, maxQueue),
 		loaded:        make(map[string]*runnerRef),
+		configs:       make(map[string]modelConfig),
 		newServerFn:   llm.NewLlamaServer,
 		getGpuFn:      discover.GetGPUInfo,
 		getCpuFn:      discover.GetCPUInfo,
@@ -81,6 +92,14 @@ func InitScheduler(ctx context.Context) *Scheduler {
 
 // context must be canceled to decrement ref count and release the runner
 func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options, sessionDuration *api.Duration) (chan *runnerRef, chan error) {
+	config := s.modelConfig(model.ModelPath)
+	if config.NumCtx > 0 {
+		opts.NumCtx = config.NumCtx
+	}
+	if config.NumParallel > 0 {
+		opts.NumParallel = config.NumParallel
+	}
+
 	if opts.NumCtx < 4 {
 		opts.NumCtx = 4
 	}
@@ -90,6 +109,7 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		model:           model,
 		opts:            opts,
 		sessionDuration: sessionDuration,
+		config:          config,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
 	}
@@ -131,11 +151,18 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				slog.Debug("pending request cancelled or timed out, skipping scheduling")
 				continue
 			}
-			numParallel := int(envconfig.NumParallel())
+			// The model's own setting takes precedence over the server's
+			numParallel := pending.opts.NumParallel
+			if numParallel <= 0 {
+				numParallel = int(envconfig.NumParallel())
+			}
 			// TODO (jmorganca): mllama doesn't support parallel yet
 			// see https://github.com/ollama/ollama/issues/4165
 			if checkMllamaModelFamily(pending.model) && numParallel != 1 {
 				numParallel = 1
+				if pending.opts.NumParallel > 0 {
+					pending.opts.NumParallel = 1
+				}
 				slog.Warn("mllama doesn't support parallel requests yet")
 			}
 
@@ -148,6 +175,9 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				if runner != nil {
 					if runner.needsReload(ctx, pending) {
 						runnerToExpire = runner
+					} else if numCtx := runner.needsGrow(pending); numCtx > 0 {
+						s.growRunner(ctx, runner, pending, numCtx)
+						break
 					} else {
 						// Runner is usable, return it
 						pending.useLoadedRunner(runner, s.finishedReqCh)
@@ -188,6 +218,17 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						}
 					}
 
+					// Restrict the model to the GPUs it's configured to use
+					if len(pending.config.GPUs) > 0 && pending.opts.NumGPU != 0 {
+						gpus = slices.DeleteFunc(gpus, func(gpu discover.GpuInfo) bool {
+							return !slices.Contains(pending.config.GPUs, gpu.ID)
+						})
+						if len(gpus) == 0 {
+							pending.errCh <- fmt.Errorf("none of the GPUs configured for %s are available: %s", pending.model.ShortName, strings.Join(pending.config.GPUs, ", "))
+							break
+						}
+					}
+
 					// Load model for fitting
 					ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
 					if err != nil {
@@ -195,8 +236,9 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						break
 					}
 
-					// Embedding models should always be loaded with parallel=1
-					if pending.model.CheckCapabilities(model.CapabilityCompletion) != nil {
+					// Embedding models are loaded with parallel=1 unless the
+					// model sets its own num_parallel
+					if pending.opts.NumParallel <= 0 && pending.model.CheckCapabilities(model.CapabilityCompletion) != nil {
 						numParallel = 1
 					}
 
@@ -276,9 +318,9 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				}
 
 				if runnerToExpire == nil {
-					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
-					continue
+					// every loaded runner is pinned
+					pending.errCh <- errPinned
+					break
 				}
 				// Trigger an expiration to unload once it's done
 				runnerToExpire.refMu.Lock()
@@ -339,17 +381,7 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 					s.expiredCh <- runner
 				} else if runner.expireTimer == nil {
 					slog.Debug("runner with non-zero duration has gone idle, adding timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
-					runner.expireTimer = time.AfterFunc(runner.sessionDuration, func() {
-						slog.Debug("timer expired, expiring to unload", "modelPath", runner.modelPath)
-						runner.refMu.Lock()
-						defer runner.refMu.Unlock()
-						if runner.expireTimer != nil {
-							runner.expireTimer.Stop()
-							runner.expireTimer = nil
-						}
-						s.expiredCh <- runner
-					})
-					runner.expiresAt = time.Now().Add(runner.sessionDuration)
+					s.expireAfterDuration(runner)
 				} else {
 					slog.Debug("runner with non-zero duration has gone idle, resetting timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
 					runner.expireTimer.Reset(runner.sessionDuration)
@@ -376,6 +408,9 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			s.loadedMu.Lock()
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
 			finished := runner.waitForVRAMRecovery()
+			if !runner.loading && runner.model != nil {
+				modelUnloadsTotal.Inc(runner.model.ShortName)
+			}
 			runner.unload()
 			delete(s.loaded, runner.modelPath)
 			s.loadedMu.Unlock()
@@ -389,18 +424,40 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 	}
 }
 
+// expireAfterDuration starts a timer to expire an idle runner after its
+// session duration. The refMu must already be held.
+func (s *Scheduler) expireAfterDuration(runner *runnerRef) {
+	runner.expireTimer = time.AfterFunc(runner.sessionDuration, func() {
+		slog.Debug("timer expired, expiring to unload", "modelPath", runner.modelPath)
+		runner.refMu.Lock()
+		defer runner.refMu.Unlock()
+		if runner.expireTimer != nil {
+			runner.expireTimer.Stop()
+			runner.expireTimer = nil
+		}
+		s.expiredCh <- runner
+	})
+	runner.expiresAt = time.Now().Add(runner.sessionDuration)
+}
+
 // Complete the pending request and send the runner back to the requester
 // Wires up a finished event after the request context is completed
 // Updates session duration, and resets expiration timer
 func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *LlmRequest) {
 	runner.refMu.Lock()
 	defer runner.refMu.Unlock()
+	pending.useRunner(runner, finished)
+}
+
+// useRunner is useLoadedRunner with the runner's refMu already held
+func (pending *LlmRequest) useRunner(runner *runnerRef, finished chan *LlmRequest) {
 	runner.refCount++
 	if runner.expireTimer != nil {
 		runner.expireTimer.Stop()
 		runner.expireTimer = nil
 	}
-	if pending.sessionDuration != nil {
+	// pinned runners ignore keep alive
+	if pending.sessionDuration != nil && !pending.config.Pinned {
 		runner.sessionDuration = pending.sessionDuration.Duration
 	}
 	pending.successCh <- runner
@@ -415,12 +472,19 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	if numParallel < 1 {
 		numParallel = 1
 	}
+	start := time.Now()
 	sessionDuration := envconfig.KeepAlive()
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
+	if req.config.Pinned {
+		sessionDuration = pinnedDuration
+	}
+	ctx, span := tracing.Start(req.ctx, "scheduler.load", "model", req.model.ShortName, "num_ctx", req.opts.NumCtx, "num_parallel", numParallel, "gpus", len(gpus))
 	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
 	if err != nil {
+		span.SetError(err)
+		span.End()
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
 		// check for model compatibility
@@ -441,6 +505,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		estimatedVRAM:   llama.EstimatedVRAM(),
 		estimatedTotal:  llama.EstimatedTotal(),
 		loading:         true,
+		pinned:          req.config.Pinned,
 		refCount:        1,
 	}
 	runner.numParallel = numParallel
@@ -453,7 +518,9 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 
 	go func() {
 		defer runner.refMu.Unlock()
-		if err = llama.WaitUntilRunning(req.ctx); err != nil {
+		defer span.End()
+		if err = llama.WaitUntilRunning(ctx); err != nil {
+			span.SetError(err)
 			slog.Error("error loading llama server", "error", err)
 			runner.refCount--
 			req.errCh <- err
@@ -463,6 +530,8 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
 		runner.loading = false
+		modelLoadsTotal.Inc(req.model.ShortName)
+		modelLoadSeconds.Observe(time.Since(start).Seconds(), req.model.ShortName)
 		go func() {
 			<-req.ctx.Done()
 			slog.Debug("context for request finished")
@@ -555,6 +624,9 @@ type runnerRef struct {
 	model       *Model
 	modelPath   string
 	numParallel int
+	pinned      bool
+	grown       bool // the context was grown after the runner loaded
+	growFailed  bool // the context couldn't be grown, so bigger contexts reload the runner
 	*api.Options
 }
 
@@ -598,6 +670,18 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 	// Normalize the NumCtx for parallelism
 	optsExisting.NumCtx = optsExisting.NumCtx / runner.numParallel
 
+	// A bigger context is grown in place by growRunner unless that's failed,
+	// and a runner that's been grown keeps its context for smaller ones
+	if (optsNew.NumCtx > optsExisting.NumCtx && !runner.growFailed) || (runner.grown && optsNew.NumCtx < optsExisting.NumCtx) {
+		optsNew.NumCtx = optsExisting.NumCtx
+	}
+
+	// Only reload for parallelism if the request sets it
+	optsExisting.NumParallel = runner.numParallel
+	if optsNew.NumParallel <= 0 {
+		optsExisting.NumParallel = optsNew.NumParallel
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
@@ -696,14 +780,6 @@ func (a ByDurationAndName) Less(i, j int) bool {
 func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel *int) discover.GpuInfoList {
 	var estimatedVRAM uint64
 
-	var numParallelToTry []int
-	if *numParallel <= 0 {
-		// If no specific parallel setting was provided, try larger then smaller, always end with 1
-		numParallelToTry = append(numParallelToTry, defaultParallel, 1)
-	} else {
-		numParallelToTry = []int{*numParallel}
-	}
-
 	for _, gl := range gpus.ByLibrary() {
 		var ok bool
 		sgl := append(make(discover.GpuInfoList, 0, len(gl)), gl...)
@@ -713,8 +789,17 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 		// Note: at present, this will favor more VRAM over faster GPU speed in mixed setups
 		sort.Sort(sort.Reverse(discover.ByFreeMemory(sgl)))
 
+		// If no specific parallel setting was provided, start with as many
+		// as the free VRAM of the GPUs tried fits
+		numParallelToTry := func(gpus discover.GpuInfoList) []int {
+			if *numParallel > 0 {
+				return []int{*numParallel}
+			}
+			return parallelToTry(req, f, gpus)
+		}
+
 		// First attempt to fit the model into a single GPU
-		for _, p := range numParallelToTry {
+		for _, p := range numParallelToTry(sgl[:1]) {
 			req.opts.NumCtx = req.origNumCtx * p
 			if !envconfig.SchedSpread() {
 				for _, g := range sgl {
@@ -732,7 +817,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 		// - try subsets of GPUs instead of just falling back to 1 or all in a family
 
 		// Now try all the GPUs
-		for _, p := range numParallelToTry {
+		for _, p := range numParallelToTry(sgl) {
 			req.opts.NumCtx = req.origNumCtx * p
 			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, p); ok {
 				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
@@ -744,6 +829,44 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 	return nil
 }
 
+// parallelToTry returns the numbers of parallel requests to try loading a
+// model with on gpus. It starts with as many as there is free VRAM for the
+// context of, up to defaultParallel so VRAM is left for other models, and
+// halves down to 1.
+func parallelToTry(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) []int {
+	var free uint64
+	for _, g := range gpus {
+		free += g.FreeMemory
+	}
+
+	// estimate the model fully loaded on a GPU with room for it, as the
+	// estimates depend on how much of it fits. The memory of each request
+	// beyond the first is the difference between the estimates for one and
+	// two.
+	g := gpus[0]
+	g.FreeMemory = math.MaxUint64 / 2
+	opts := req.opts
+	opts.NumCtx = req.origNumCtx
+	one := llm.EstimateGPULayers([]discover.GpuInfo{g}, f, req.model.ProjectorPaths, opts, 1).TotalSize
+	opts.NumCtx = req.origNumCtx * 2
+	two := llm.EstimateGPULayers([]discover.GpuInfo{g}, f, req.model.ProjectorPaths, opts, 2).TotalSize
+
+	p := 1
+	if free > one {
+		p = defaultParallel
+		if two > one {
+			p = int(min(1+(free-one)/(two-one), uint64(defaultParallel)))
+		}
+	}
+
+	var ps []int
+	for ; p > 1; p /= 2 {
+		ps = append(ps, p)
+	}
+
+	return append(ps, 1)
+}
+
 // If multiple Libraries are detected, pick the Library which loads the most layers for the model
 func pickBestPartialFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel *int) discover.GpuInfoList {
 	if *numParallel <= 0 {
@@ -771,11 +894,15 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	s.loadedMu.Lock()
 	runnerList := make([]*runnerRef, 0, len(s.loaded))
 	for _, r := range s.loaded {
+		// pinned runners are never unloaded to make room
+		if s.modelConfig(r.modelPath).Pinned {
+			continue
+		}
 		runnerList = append(runnerList, r)
 	}
 	s.loadedMu.Unlock()
 	if len(runnerList) == 0 {
-		slog.Debug("no loaded runner to unload")
+		slog.Debug("no unpinned runner to unload")
 		return nil
 	}
 
@@ -828,6 +955,109 @@ func (s *Scheduler) expireRunner(model *Model) {
 	}
 }
 
+// swapRunner replaces the runner for model from, if one is loaded, with a
+// runner for model to. The new runner is loaded with the options and keep
+// alive of the old one, and the old runner is expired only once the new one
+// is ready, so requests that followed a repointed alias don't wait on a cold
+// load.
+func (s *Scheduler) swapRunner(ctx context.Context, from, to *Model) {
+	if from.ModelPath == to.ModelPath {
+		return
+	}
+
+	s.loadedMu.Lock()
+	runner, ok := s.loaded[from.ModelPath]
+	s.loadedMu.Unlock()
+	if !ok {
+		return
+	}
+
+	runner.refMu.Lock()
+	opts := *runner.Options
+	opts.NumCtx = opts.NumCtx / runner.numParallel
+	sessionDuration := &api.Duration{Duration: runner.sessionDuration}
+	runner.refMu.Unlock()
+
+	// canceling the context releases the new runner once it's loaded
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	slog.Info("swapping model", "from", from.ShortName, "to", to.ShortName)
+	runnerCh, errCh := s.GetRunner(ctx, to, opts, sessionDuration)
+	select {
+	case <-runnerCh:
+	case err := <-errCh:
+		slog.Warn("failed to load model for swap", "model", to.ShortName, "error", err)
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	s.expireRunner(from)
+}
+
+// needsGrow returns the context the runner must grow to for req, or 0 if its
+// context is big enough
+func (runner *runnerRef) needsGrow(req *LlmRequest) int {
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+
+	numCtx := req.origNumCtx * runner.numParallel
+	if runner.Options == nil || numCtx <= runner.Options.NumCtx {
+		return 0
+	}
+
+	return numCtx
+}
+
+// growRunner grows the context of a loaded runner to numCtx for req without
+// reloading it. Like a load, the runner is locked while it grows on its own
+// goroutine and req is sent the runner once it has. If the runner can't grow
+// in place, for example because the larger kv cache doesn't fit in the
+// memory available to it, req is scheduled again to reload the runner.
+func (s *Scheduler) growRunner(ctx context.Context, runner *runnerRef, req *LlmRequest, numCtx int) {
+	runner.refMu.Lock()
+	library := runner.gpus[0].Library
+	runner.refMu.Unlock()
+
+	var gpus discover.GpuInfoList
+	if library == "cpu" {
+		gpus = s.getCpuFn()
+	} else {
+		gpus = s.getGpuFn()
+		s.updateFreeSpace(gpus)
+		// the memory the runner already uses is available to it
+		for i := range gpus {
+			gpus[i].FreeMemory += runner.llama.EstimatedVRAMByGPU(gpus[i].ID)
+		}
+	}
+
+	runner.refMu.Lock()
+	go func() {
+		defer runner.refMu.Unlock()
+
+		f, err := llm.LoadModel(runner.modelPath, 0)
+		if err == nil {
+			err = runner.llama.GrowContext(ctx, numCtx, gpus, f)
+		}
+
+		if err != nil {
+			slog.Info("unable to grow context, reloading model", "model", runner.modelPath, "num_ctx", req.origNumCtx, "error", err)
+			runner.growFailed = true
+			go func() { s.pendingReqCh <- req }()
+			return
+		}
+
+		opts := *runner.Options
+		opts.NumCtx = numCtx
+		runner.Options = &opts
+		runner.estimatedVRAM = runner.llama.EstimatedVRAM()
+		runner.estimatedTotal = runner.llama.EstimatedTotal()
+		runner.grown = true
+		req.useRunner(runner, s.finishedReqCh)
+	}()
+}
+
 // If other runners are loaded, make sure the pending request will fit in system memory
 // If not, pick a runner to unload, else return nil and the request can be loaded
 func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) *runnerRef {
@@ -257,6 +257,170 @@ func TestRequestsSimpleReloadSameModel(t *testing.T) {
 	}
 }
 
+func TestRequestsNumParallel(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.opts.NumParallel = 16
+	b := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+	s.Run(ctx)
+
+	// a sets its own parallelism and b's is sized to the available memory
+	for _, tt := range []struct {
+		bundle      *reqBundle
+		numParallel int
+	}{
+		{a, 16},
+		{b, defaultParallel},
+	} {
+		s.newServerFn = tt.bundle.newServer
+		numCtx := tt.bundle.req.opts.NumCtx
+		s.pendingReqCh <- tt.bundle.req
+		select {
+		case resp := <-tt.bundle.req.successCh:
+			require.Equal(t, tt.numParallel, resp.numParallel)
+			require.Equal(t, numCtx*tt.numParallel, resp.Options.NumCtx)
+		case err := <-tt.bundle.req.errCh:
+			t.Fatal(err.Error())
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestParallelToTry(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.origNumCtx = a.req.opts.NumCtx
+
+	gpus := getGpuFn()
+
+	opts := a.req.opts
+	one := llm.EstimateGPULayers(gpus, a.f, nil, opts, 1).TotalSize
+	opts.NumCtx *= 2
+	perSlot := llm.EstimateGPULayers(gpus, a.f, nil, opts, 2).TotalSize - one
+	require.Positive(t, perSlot)
+
+	cases := []struct {
+		name string
+		free uint64
+		want []int
+	}{
+		{"too little memory", one / 2, []int{1}},
+		{"one", one + perSlot/2, []int{1}},
+		{"some", one + 5*perSlot/2, []int{3, 1}},
+		{"capped", 24 * format.GigaByte, []int{4, 2, 1}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			gpus[0].FreeMemory = tt.free
+			require.Equal(t, tt.want, parallelToTry(a.req, a.f, gpus))
+		})
+	}
+
+	// the free memory of all the GPUs tried is used
+	gpus = append(gpus, gpus[0])
+	gpus[0].FreeMemory, gpus[1].FreeMemory = one, 5*perSlot/2
+	require.Equal(t, []int{3, 1}, parallelToTry(a.req, a.f, gpus))
+}
+
+func TestRequestsGrowContext(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.opts.NumParallel = 1
+	b := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	b.req.model = a.req.model
+	b.req.opts.NumParallel = 1
+	b.req.opts.NumCtx = a.req.opts.NumCtx * 4
+	c := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	c.req.model = a.req.model
+	c.req.opts.NumParallel = 1
+	c.req.opts.NumCtx = a.req.opts.NumCtx * 8
+	c.f = a.f
+	d := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+	d.req.opts.NumParallel = 1
+	e := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+	e.req.model = d.req.model
+	e.req.opts.NumParallel = 1
+	s.Run(ctx)
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, a.srv, resp.llama)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.newServerFn = d.newServer
+	s.pendingReqCh <- d.req
+	select {
+	case resp := <-d.req.successCh:
+		require.Equal(t, d.srv, resp.llama)
+	case err := <-d.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the loaded runner grows its context instead of reloading, without
+	// holding up requests for other models
+	a.srv.growWait = make(chan struct{})
+	s.pendingReqCh <- b.req
+	s.pendingReqCh <- e.req
+	select {
+	case resp := <-e.req.successCh:
+		require.Equal(t, d.srv, resp.llama)
+	case err := <-e.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	require.Empty(t, b.req.successCh)
+	close(a.srv.growWait)
+	select {
+	case resp := <-b.req.successCh:
+		require.Equal(t, a.srv, resp.llama)
+		require.Equal(t, b.req.opts.NumCtx, a.srv.grownCtx)
+		require.Equal(t, b.req.opts.NumCtx, resp.Options.NumCtx)
+		require.True(t, resp.grown)
+	case err := <-b.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// and reloads the model if it can't
+	a.srv.growResp = errors.New("not enough memory")
+	s.newServerFn = c.newServer
+	a.ctxDone()
+	b.ctxDone()
+	s.pendingReqCh <- c.req
+	select {
+	case resp := <-c.req.successCh:
+		require.Equal(t, c.srv, resp.llama)
+		require.Equal(t, c.req.opts.NumCtx, resp.Options.NumCtx)
+		require.False(t, resp.grown)
+	case err := <-c.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
+
 func TestRequestsMultipleLoadedModels(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer done()
@@ -454,6 +618,52 @@ func TestExpireRunner(t *testing.T) {
 }
 
 // TODO - add one scenario that triggers the bogus finished event with positive ref count
+func TestSwapRunner(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: time.Minute})
+	b := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+	a.ctxDone()
+
+	// nothing to swap if the old model isn't loaded
+	s.swapRunner(ctx, b.req.model, a.req.model)
+
+	s.newServerFn = b.newServer
+	s.swapRunner(ctx, a.req.model, b.req.model)
+
+	for {
+		s.loadedMu.Lock()
+		_, loadedA := s.loaded[a.req.model.ModelPath]
+		_, loadedB := s.loaded[b.req.model.ModelPath]
+		s.loadedMu.Unlock()
+		if !loadedA && loadedB {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timeout: loaded a = %v, b = %v", loadedA, loadedB)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	require.True(t, a.srv.closeCalled)
+	require.False(t, b.srv.closeCalled)
+}
+
 func TestPrematureExpired(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer done()
@@ -660,6 +870,23 @@ func TestNeedsReload(t *testing.T) {
 	req.opts.NumGPU = -1
 	resp = runner.needsReload(ctx, req)
 	require.False(t, resp)
+	req.opts.NumParallel = 4
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
+	req.opts.NumParallel = runner.numParallel
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
+
+	// bigger contexts are grown instead of reloading
+	req.opts.NumCtx = runner.Options.NumCtx * 2
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
+	req.opts.NumCtx = runner.Options.NumCtx / 2
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
+	runner.grown = true
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
 }
 
 func TestUnloadAllRunners(t *testing.T) {
@@ -760,6 +987,9 @@ type mockLlm struct {
 	tokenizeRespErr    error
 	detokenizeResp     string
 	detonekizeRespErr  error
+	growResp           error
+	growWait           chan struct{}
+	grownCtx           int
 	closeResp          error
 	closeCalled        bool
 	estimatedVRAM      uint64
@@ -773,6 +1003,16 @@ func (s *mockLlm) Completion(ctx context.Context, req llm.CompletionRequest, fn
 	return s.completionResp
 }
 
+func (s *mockLlm) GrowContext(ctx context.Context, numCtx int, gpus discover.GpuInfoList, f *ggml.GGML) error {
+	if s.growWait != nil {
+		<-s.growWait
+	}
+	if s.growResp == nil {
+		s.grownCtx = numCtx
+	}
+	return s.growResp
+}
+
 func (s *mockLlm) Embedding(ctx context.Context, input string) ([]float32, error) {
 	return s.embeddingResp, s.embeddingRespErr
 }
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tlsReloadInterval is how often the certificate files are checked for
+// changes
+var tlsReloadInterval = 5 * time.Second
+
+// tlsCertificates serves TLS with a certificate and key, and optionally
+// requires client certificates signed by a CA. The files are read again
+// when they change so certificates can be renewed without a restart.
+type tlsCertificates struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+func loadTLSCertificates(certFile, keyFile, clientCAFile string) (*tlsCertificates, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both a TLS certificate and key are required")
+	}
+
+	t := &tlsCertificates{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *tlsCertificates) files() []string {
+	files := []string{t.certFile, t.keyFile}
+	if t.clientCAFile != "" {
+		files = append(files, t.clientCAFile)
+	}
+	return files
+}
+
+// load reads the certificate files. t.mu must be held if t is in use.
+func (t *tlsCertificates) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range t.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = fi.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if t.clientCAFile != "" {
+		b, err := os.ReadFile(t.clientCAFile)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("%s: no PEM certificates found", t.clientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.config = config
+	t.modTimes = modTimes
+	return nil
+}
+
+func (t *tlsCertificates) changed() bool {
+	for file, modTime := range t.modTimes {
+		fi, err := os.Stat(file)
+		if err != nil {
+			// the file may be part way through being replaced
+			slog.Warn("unable to check TLS file, using the last certificates read", "path", file, "error", err)
+			return false
+		}
+
+		if !fi.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configForClient returns the TLS config of a new connection, reading the
+// certificate files again if they've changed
+func (t *tlsCertificates) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mu.Lock()
+	if time.Since(t.checked) > tlsReloadInterval {
+		t.checked = time.Now()
+		if t.changed() {
+			if err := t.load(); err != nil {
+				slog.Warn("unable to read TLS files, using the last certificates read", "cert", t.certFile, "error", err)
+			} else {
+				slog.Info("reloaded TLS certificates", "cert", t.certFile)
+			}
+		}
+	}
+	config := t.config.Clone()
+	t.mu.Unlock()
+
+	if config.ClientAuth == tls.RequireAndVerifyClientCert {
+		remote := hello.Conn.RemoteAddr()
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			slog.Debug("client certificate verified", "remote", remote, "identity", certIdentity(cs.PeerCertificates[0]))
+			return nil
+		}
+	}
+
+	return config, nil
+}
+
+// listener wraps ln to serve TLS
+func (t *tlsCertificates) listener(ln net.Listener) net.Listener {
+	return tls.NewListener(ln, &tls.Config{GetConfigForClient: t.configForClient})
+}
+
+// certIdentity is the name a client certificate is known by in logs: its
+// common name, or failing that its first email, DNS or URI name
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return cert.SerialNumber.String()
+	}
+}
+
+// clientIdentity returns the identity of the request's client certificate,
+// or "" if there isn't one
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return certIdentity(r.TLS.PeerCertificates[0])
+}
+
+// logRequestFormatter formats the request log like gin's default logger,
+// adding the identity of the client certificate after the client's address
+func logRequestFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+
+	client := param.ClientIP
+	if identity := clientIdentity(param.Request); identity != "" {
+		client = fmt.Sprintf("%s (%s)", client, identity)
+	}
+
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		client,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate from template signed by parent, or
+// self-signed if parent is nil
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	if keyFile != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(c.key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func newTestServerCert(t *testing.T, ca *testCert, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func TestTLSListener(t *testing.T) {
+	interval := tlsReloadInterval
+	tlsReloadInterval = 0
+	t.Cleanup(func() { tlsReloadInterval = interval })
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	ca.write(t, caFile, "")
+	newTestServerCert(t, ca, "server-1").write(t, certFile, keyFile)
+
+	alice := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	certs, err := loadTLSCertificates(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, clientIdentity(r))
+	})}
+	go srv.Serve(certs.listener(ln))
+	t.Cleanup(func() { srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	get := func(clientCerts ...tls.Certificate) (server, identity string, err error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: clientCerts},
+			DisableKeepAlives: true,
+			ForceAttemptHTTP2: true,
+		}}
+
+		resp, err := client.Get((&url.URL{Scheme: "https", Host: ln.Addr().String()}).String())
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", "", err
+		}
+
+		if resp.ProtoMajor != 2 {
+			return "", "", fmt.Errorf("expected HTTP/2, got %s", resp.Proto)
+		}
+
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, string(b), nil
+	}
+
+	server, identity, err := get(alice.tlsCertificate())
+	require.NoError(t, err)
+	require.Equal(t, "server-1", server)
+	require.Equal(t, "alice", identity)
+
+	// a client certificate is required
+	_, _, err = get()
+	require.Error(t, err)
+
+	// a client certificate signed by another CA isn't accepted
+	mallory := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mallory"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil)
+	_, _, err = get(mallory.tlsCertificate())
+	require.Error(t, err)
+
+	// a renewed certificate is served to new connections
+	newTestServerCert(t, ca, "server-2").write(t, certFile, keyFile)
+	for _, file := range []string{certFile, keyFile} {
+		require.NoError(t, os.Chtimes(file, time.Time{}, time.Now().Add(time.Minute)))
+	}
+
+	server, _, err = get(alice.tlsCertificate())
+	require.NoError(t, err)
+	require.Equal(t, "server-2", server)
+
+	// invalid changes are ignored
+	require.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, time.Time{}, time.Now().Add(2*time.Minute)))
+
+	server, _, err = get(alice.tlsCertificate())
+	require.NoError(t, err)
+	require.Equal(t, "server-2", server)
+}
+
+func TestLoadTLSCertificatesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	newTestServerCert(t, nil, "server").write(t, certFile, keyFile)
+
+	_, err := loadTLSCertificates(certFile, "", "")
+	require.ErrorContains(t, err, "both a TLS certificate and key are required")
+
+	_, err = loadTLSCertificates(certFile, filepath.Join(dir, "missing.key"), "")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = loadTLSCertificates(certFile, keyFile, keyFile)
+	require.ErrorContains(t, err, "no PEM certificates found")
+}
+
+func TestCertIdentity(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.com/worker")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name string
+		cert x509.Certificate
+		want string
+	}{
+		{name: "common name", cert: x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, EmailAddresses: []string{"bob@example.com"}}, want: "alice"},
+		{name: "email", cert: x509.Certificate{EmailAddresses: []string{"bob@example.com"}, DNSNames: []string{"worker.example.com"}}, want: "bob@example.com"},
+		{name: "dns", cert: x509.Certificate{DNSNames: []string{"worker.example.com"}}, want: "worker.example.com"},
+		{name: "uri", cert: x509.Certificate{URIs: []*url.URL{uri}}, want: "spiffe://example.com/worker"},
+		{name: "serial", cert: x509.Certificate{SerialNumber: big.NewInt(42)}, want: "42"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, certIdentity(&tt.cert))
+		})
+	}
+}
+
+func TestLogRequestFormatter(t *testing.T) {
+	param := gin.LogFormatterParams{
+		Request:    httptest.NewRequest(http.MethodPost, "/api/chat", nil),
+		TimeStamp:  time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		StatusCode: http.StatusOK,
+		Latency:    time.Second,
+		ClientIP:   "192.0.2.1",
+		Method:     http.MethodPost,
+		Path:       "/api/chat",
+	}
+
+	require.Equal(t, "[GIN] 2025/01/02 - 03:04:05 | 200 |            1s |       192.0.2.1 | POST     \"/api/chat\"\n", logRequestFormatter(param))
+
+	param.Request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}}}
+	require.Equal(t, "[GIN] 2025/01/02 - 03:04:05 | 200 |            1s | 192.0.2.1 (alice) | POST     \"/api/chat\"\n", logRequestFormatter(param))
+}
@@ -0,0 +1,942 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// now returns the current time for strftime_now
+var now = time.Now
+
+// globals are the functions available to every template, including those
+// Hugging Face adds for chat templates
+var globals = map[string]any{
+	"range": function(func(_ *state, args []any, _ *Dict) (any, error) {
+		var start, stop, step int = 0, 0, 1
+		ints := make([]int, len(args))
+		for i, arg := range args {
+			n, ok := arg.(int)
+			if !ok {
+				return nil, fmt.Errorf("'%s' object cannot be interpreted as an integer", typeName(arg))
+			}
+			ints[i] = n
+		}
+
+		switch len(ints) {
+		case 1:
+			stop = ints[0]
+		case 2:
+			start, stop = ints[0], ints[1]
+		case 3:
+			start, stop, step = ints[0], ints[1], ints[2]
+		default:
+			return nil, fmt.Errorf("range expected 1 to 3 arguments, got %d", len(args))
+		}
+
+		if step == 0 {
+			return nil, errors.New("range() arg 3 must not be zero")
+		}
+
+		var items []any
+		for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+			if len(items) >= maxOutput {
+				return nil, errors.New("range is too large")
+			}
+			items = append(items, i)
+		}
+		return items, nil
+	}),
+	"namespace": function(func(_ *state, args []any, kwargs *Dict) (any, error) {
+		ns := namespace{NewDict()}
+		for _, arg := range args {
+			d, ok := arg.(*Dict)
+			if !ok {
+				return nil, fmt.Errorf("namespace expected a dict, got %s", typeName(arg))
+			}
+			for _, k := range d.Keys() {
+				v, _ := d.Get(k)
+				ns.Set(k, v)
+			}
+		}
+
+		for _, k := range kwargs.Keys() {
+			v, _ := kwargs.Get(k)
+			ns.Set(k, v)
+		}
+		return &ns, nil
+	}),
+	"dict": function(func(_ *state, _ []any, kwargs *Dict) (any, error) {
+		d := NewDict()
+		for _, k := range kwargs.Keys() {
+			v, _ := kwargs.Get(k)
+			d.Set(k, v)
+		}
+		return d, nil
+	}),
+	"raise_exception": function(func(_ *state, args []any, _ *Dict) (any, error) {
+		if len(args) == 0 {
+			return nil, errors.New("template raised an exception")
+		}
+		return nil, errors.New(str(args[0]))
+	}),
+	"strftime_now": function(func(_ *state, args []any, _ *Dict) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("strftime_now expected 1 argument, got %d", len(args))
+		}
+		return strftime(now(), str(args[0])), nil
+	}),
+}
+
+// strftime formats t like Python's time.strftime
+func strftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'e':
+			b.WriteString(t.Format("_2"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'I':
+			b.WriteString(t.Format("03"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+
+	return b.String()
+}
+
+// argument returns the argument at position i or with the given name,
+// or def if there is neither
+func argument(args []any, kwargs *Dict, i int, name string, def any) any {
+	if i < len(args) {
+		return args[i]
+	}
+
+	if kwargs != nil {
+		if v, ok := kwargs.Get(name); ok {
+			return v
+		}
+	}
+
+	return def
+}
+
+// bound returns fn as a function, for methods which don't need the state
+func bound(fn func(args []any, kwargs *Dict) (any, error)) function {
+	return func(_ *state, args []any, kwargs *Dict) (any, error) {
+		return fn(args, kwargs)
+	}
+}
+
+// method returns the method of v called name, or nil if v has no such
+// method. Methods which modify their receiver aren't available, as in
+// Hugging Face's immutable sandbox.
+func method(v any, name string) any {
+	switch v := v.(type) {
+	case string:
+		return stringMethod(v, name)
+	case *Dict:
+		switch name {
+		case "items":
+			return bound(func([]any, *Dict) (any, error) {
+				return items(v), nil
+			})
+		case "keys":
+			return bound(func([]any, *Dict) (any, error) {
+				return iterate(v)
+			})
+		case "values":
+			return bound(func([]any, *Dict) (any, error) {
+				values := make([]any, 0, v.Len())
+				for _, k := range v.Keys() {
+					value, _ := v.Get(k)
+					values = append(values, value)
+				}
+				return values, nil
+			})
+		case "get":
+			return bound(func(args []any, kwargs *Dict) (any, error) {
+				if len(args) == 0 {
+					return nil, errors.New("get expected at least 1 argument")
+				}
+
+				if value, ok := v.Get(str(args[0])); ok {
+					return value, nil
+				}
+				return argument(args, kwargs, 1, "default", nil), nil
+			})
+		}
+	}
+
+	return nil
+}
+
+func stringMethod(s, name string) any {
+	strip := func(trim func(string, string) string, space func(string) string) function {
+		return bound(func(args []any, _ *Dict) (any, error) {
+			if len(args) > 0 && args[0] != nil {
+				return trim(s, str(args[0])), nil
+			}
+			return space(s), nil
+		})
+	}
+
+	affix := func(has func(string, string) bool) function {
+		return bound(func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s expected at least 1 argument", name)
+			}
+
+			switch prefix := args[0].(type) {
+			case string:
+				return has(s, prefix), nil
+			case []any:
+				return slices.ContainsFunc(prefix, func(p any) bool { return has(s, str(p)) }), nil
+			}
+			return nil, fmt.Errorf("%s first arg must be str or a tuple of str, not %s", name, typeName(args[0]))
+		})
+	}
+
+	is := func(f func(rune) bool) function {
+		return bound(func([]any, *Dict) (any, error) {
+			return s != "" && strings.IndexFunc(s, func(r rune) bool { return !f(r) }) < 0, nil
+		})
+	}
+
+	switch name {
+	case "strip":
+		return strip(strings.Trim, strings.TrimSpace)
+	case "lstrip":
+		return strip(strings.TrimLeft, func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) })
+	case "rstrip":
+		return strip(strings.TrimRight, func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) })
+	case "startswith":
+		return affix(strings.HasPrefix)
+	case "endswith":
+		return affix(strings.HasSuffix)
+	case "upper":
+		return bound(func([]any, *Dict) (any, error) { return strings.ToUpper(s), nil })
+	case "lower":
+		return bound(func([]any, *Dict) (any, error) { return strings.ToLower(s), nil })
+	case "title":
+		return bound(func([]any, *Dict) (any, error) { return title(s), nil })
+	case "capitalize":
+		return bound(func([]any, *Dict) (any, error) { return capitalize(s), nil })
+	case "isdigit":
+		return is(unicode.IsDigit)
+	case "isalpha":
+		return is(unicode.IsLetter)
+	case "isspace":
+		return is(unicode.IsSpace)
+	case "split", "rsplit":
+		return bound(func(args []any, kwargs *Dict) (any, error) {
+			n := -1
+			if v, ok := argument(args, kwargs, 1, "maxsplit", -1).(int); ok && v >= 0 {
+				n = v + 1
+			}
+
+			var parts []string
+			switch sep := argument(args, kwargs, 0, "sep", nil); {
+			case sep == nil:
+				parts = strings.Fields(s)
+				if n > 0 && len(parts) > n {
+					parts = append(parts[:n-1], strings.Join(parts[n-1:], " "))
+				}
+			case str(sep) == "":
+				return nil, errors.New("empty separator")
+			case name == "rsplit" && n > 0:
+				parts = strings.Split(s, str(sep))
+				if len(parts) > n {
+					parts = append([]string{strings.Join(parts[:len(parts)-n+1], str(sep))}, parts[len(parts)-n+1:]...)
+				}
+			default:
+				parts = strings.SplitN(s, str(sep), n)
+			}
+			return valueOf(parts)
+		})
+	case "splitlines":
+		return bound(func([]any, *Dict) (any, error) {
+			lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+			if len(lines) > 0 && lines[len(lines)-1] == "" {
+				lines = lines[:len(lines)-1]
+			}
+			return valueOf(lines)
+		})
+	case "replace":
+		return bound(func(args []any, _ *Dict) (any, error) {
+			if len(args) < 2 {
+				return nil, errors.New("replace expected at least 2 arguments")
+			}
+
+			n := -1
+			if len(args) > 2 {
+				if v, ok := args[2].(int); ok {
+					n = v
+				}
+			}
+			return strings.Replace(s, str(args[0]), str(args[1]), n), nil
+		})
+	case "join":
+		return bound(func(args []any, _ *Dict) (any, error) {
+			if len(args) != 1 {
+				return nil, errors.New("join expected 1 argument")
+			}
+
+			items, err := iterate(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return join(items, s), nil
+		})
+	case "find", "rfind", "count":
+		return bound(func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s expected at least 1 argument", name)
+			}
+
+			sub := str(args[0])
+			switch name {
+			case "find":
+				return runeIndex(s, strings.Index(s, sub)), nil
+			case "rfind":
+				return runeIndex(s, strings.LastIndex(s, sub)), nil
+			}
+			return strings.Count(s, sub), nil
+		})
+	}
+
+	return nil
+}
+
+// runeIndex converts the byte index i of s to an index of its characters
+func runeIndex(s string, i int) int {
+	if i < 0 {
+		return i
+	}
+	return len([]rune(s[:i]))
+}
+
+func title(s string) string {
+	var b strings.Builder
+	prev := ' '
+	for _, r := range s {
+		if unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '\'' {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+func capitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + strings.ToLower(s[i+len(string(r)):])
+	}
+	return s
+}
+
+func join(items []any, sep string) string {
+	var b strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(str(item))
+	}
+	return b.String()
+}
+
+// items returns the key and value pairs of a dict
+func items(d *Dict) []any {
+	pairs := make([]any, 0, d.Len())
+	for _, k := range d.Keys() {
+		v, _ := d.Get(k)
+		pairs = append(pairs, []any{k, v})
+	}
+	return pairs
+}
+
+// length returns the length of v like Python's len
+func length(v any) (int, error) {
+	switch v := v.(type) {
+	case string:
+		return len([]rune(v)), nil
+	case []any:
+		return len(v), nil
+	case *Dict:
+		return v.Len(), nil
+	case undefined:
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("object of type '%s' has no len()", typeName(v))
+}
+
+// path returns the attribute of v at a dotted path such as "function.name",
+// like the attribute argument of Jinja's filters
+func path(v any, p string) (any, error) {
+	for _, part := range strings.Split(p, ".") {
+		var err error
+		if i, convErr := strconv.Atoi(part); convErr == nil {
+			v, err = item(v, i)
+		} else {
+			v, err = item(v, part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// sortKey returns a function which returns the key to sort or compare an
+// item by for filters such as sort, max and unique
+func sortKey(args []any, kwargs *Dict, attrIndex, caseIndex int) func(any) (any, error) {
+	attr := argument(args, kwargs, attrIndex, "attribute", nil)
+	caseSensitive := truthy(argument(args, kwargs, caseIndex, "case_sensitive", false))
+	return func(v any) (any, error) {
+		if attr != nil {
+			var err error
+			if v, err = path(v, str(attr)); err != nil {
+				return nil, err
+			}
+		}
+
+		if s, ok := v.(string); ok && !caseSensitive {
+			return strings.ToLower(s), nil
+		}
+		return v, nil
+	}
+}
+
+func sortItems(items []any, key func(any) (any, error), reverse bool) ([]any, error) {
+	keys := make([]any, len(items))
+	for i, item := range items {
+		var err error
+		if keys[i], err = key(item); err != nil {
+			return nil, err
+		}
+	}
+
+	indices := make([]int, len(items))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	var err error
+	slices.SortStableFunc(indices, func(a, b int) int {
+		c, cerr := compare(keys[a], keys[b])
+		if cerr != nil {
+			err = cerr
+		}
+		if reverse {
+			return -c
+		}
+		return c
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]any, len(items))
+	for i, j := range indices {
+		sorted[i] = items[j]
+	}
+	return sorted, nil
+}
+
+// selectItems implements the select, reject, selectattr and rejectattr
+// filters
+func selectItems(s *state, v any, args []any, keep bool, attr bool) ([]any, error) {
+	items, err := iterate(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var attribute string
+	if attr {
+		if len(args) == 0 {
+			return nil, errors.New("missing parameter for attribute name")
+		}
+		attribute, args = str(args[0]), args[1:]
+	}
+
+	var selected []any
+	for _, item := range items {
+		value := item
+		if attr {
+			if value, err = path(item, attribute); err != nil {
+				return nil, err
+			}
+		}
+
+		ok := truthy(value)
+		if len(args) > 0 {
+			if ok, err = applyTest(str(args[0]), value, args[1:]); err != nil {
+				return nil, err
+			}
+		}
+
+		if ok == keep {
+			selected = append(selected, item)
+		}
+	}
+	return selected, nil
+}
+
+func toInt(v any, def int) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return int(f)
+		}
+	}
+	return def
+}
+
+func toFloat(v any, def float64) float64 {
+	switch v := v.(type) {
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f
+		}
+	default:
+		if f, ok := number(v); ok {
+			return f
+		}
+	}
+	return def
+}
+
+func applyFilter(s *state, name string, v any, args []any, kwargs *Dict) (any, error) {
+	switch name {
+	case "abs":
+		switch v := v.(type) {
+		case int:
+			return max(v, -v), nil
+		case float64:
+			return math.Abs(v), nil
+		}
+		return nil, fmt.Errorf("bad operand type for abs(): '%s'", typeName(v))
+	case "attr":
+		return attribute(v, str(argument(args, kwargs, 0, "name", ""))), nil
+	case "capitalize":
+		return capitalize(str(v)), nil
+	case "count", "length":
+		return length(v)
+	case "default", "d":
+		def := argument(args, kwargs, 0, "default_value", "")
+		if _, ok := v.(undefined); ok {
+			return def, nil
+		}
+		if truthy(argument(args, kwargs, 1, "boolean", false)) && !truthy(v) {
+			return def, nil
+		}
+		return v, nil
+	case "dictsort":
+		d, ok := v.(*Dict)
+		if !ok {
+			return nil, errors.New("dictsort filter requires a dict")
+		}
+
+		by := str(argument(args, kwargs, 1, "by", "key"))
+		caseSensitive := truthy(argument(args, kwargs, 0, "case_sensitive", false))
+		key := func(pair any) (any, error) {
+			v := pair.([]any)[0]
+			if by == "value" {
+				v = pair.([]any)[1]
+			}
+			if s, ok := v.(string); ok && !caseSensitive {
+				return strings.ToLower(s), nil
+			}
+			return v, nil
+		}
+		return sortItems(items(d), key, truthy(argument(args, kwargs, 2, "reverse", false)))
+	case "escape", "e":
+		return html.EscapeString(str(v)), nil
+	case "first", "last":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return undefined{name}, nil
+		}
+		if name == "first" {
+			return items[0], nil
+		}
+		return items[len(items)-1], nil
+	case "float":
+		return toFloat(v, toFloat(argument(args, kwargs, 0, "default", 0.0), 0)), nil
+	case "int":
+		return toInt(v, toInt(argument(args, kwargs, 0, "default", 0), 0)), nil
+	case "indent":
+		width := argument(args, kwargs, 0, "width", 4)
+		indent, ok := width.(string)
+		if !ok {
+			indent = strings.Repeat(" ", toInt(width, 4))
+		}
+		first := truthy(argument(args, kwargs, 1, "first", false))
+		blank := truthy(argument(args, kwargs, 2, "blank", false))
+
+		lines := strings.Split(str(v), "\n")
+		for i, line := range lines {
+			if (i > 0 || first) && (blank || strings.TrimSpace(line) != "") {
+				lines[i] = indent + line
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	case "items":
+		switch v := v.(type) {
+		case *Dict:
+			return items(v), nil
+		case undefined:
+			return []any{}, nil
+		}
+		return nil, fmt.Errorf("can't get items of %s", typeName(v))
+	case "join":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if attr := argument(args, kwargs, 1, "attribute", nil); attr != nil {
+			for i := range items {
+				if items[i], err = path(items[i], str(attr)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return join(items, str(argument(args, kwargs, 0, "d", ""))), nil
+	case "list":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]any{}, items...), nil
+	case "lower":
+		return strings.ToLower(str(v)), nil
+	case "upper":
+		return strings.ToUpper(str(v)), nil
+	case "map":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		mapped := make([]any, len(items))
+		for i, item := range items {
+			if attr, ok := kwargs.Get("attribute"); ok {
+				if mapped[i], err = path(item, str(attr)); err != nil {
+					return nil, err
+				}
+
+				if _, ok := mapped[i].(undefined); ok {
+					if def, ok := kwargs.Get("default"); ok {
+						mapped[i] = def
+					}
+				}
+			} else if len(args) > 0 {
+				if mapped[i], err = applyFilter(s, str(args[0]), item, args[1:], kwargs); err != nil {
+					return nil, err
+				}
+			} else {
+				mapped[i] = item
+			}
+		}
+		return mapped, nil
+	case "max", "min":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return undefined{name}, nil
+		}
+
+		sorted, err := sortItems(items, sortKey(args, kwargs, 1, 0), name == "max")
+		if err != nil {
+			return nil, err
+		}
+		return sorted[0], nil
+	case "select", "reject":
+		return selectItems(s, v, args, name == "select", false)
+	case "selectattr", "rejectattr":
+		return selectItems(s, v, args, name == "selectattr", true)
+	case "replace":
+		if len(args) < 2 {
+			return nil, errors.New("replace filter expected at least 2 arguments")
+		}
+		return strings.Replace(str(v), str(args[0]), str(args[1]), toInt(argument(args, kwargs, 2, "count", -1), -1)), nil
+	case "reverse":
+		if s, ok := v.(string); ok {
+			r := []rune(s)
+			slices.Reverse(r)
+			return string(r), nil
+		}
+
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append([]any{}, items...)
+		slices.Reverse(items)
+		return items, nil
+	case "round":
+		f, ok := number(v)
+		if !ok {
+			return nil, fmt.Errorf("can't round %s", typeName(v))
+		}
+
+		scale := math.Pow(10, float64(toInt(argument(args, kwargs, 0, "precision", 0), 0)))
+		switch argument(args, kwargs, 1, "method", "common") {
+		case "ceil":
+			return math.Ceil(f*scale) / scale, nil
+		case "floor":
+			return math.Floor(f*scale) / scale, nil
+		}
+		return math.Round(f*scale) / scale, nil
+	case "safe":
+		return v, nil
+	case "sort":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		return sortItems(items, sortKey(args, kwargs, 2, 1), truthy(argument(args, kwargs, 0, "reverse", false)))
+	case "string":
+		return str(v), nil
+	case "sum":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		total := argument(args, kwargs, 1, "start", 0)
+		attr := argument(args, kwargs, 0, "attribute", nil)
+		for _, item := range items {
+			if attr != nil {
+				if item, err = path(item, str(attr)); err != nil {
+					return nil, err
+				}
+			}
+
+			if total, err = arithmetic("+", total, item); err != nil {
+				return nil, err
+			}
+		}
+		return total, nil
+	case "title":
+		return title(str(v)), nil
+	case "tojson":
+		opts := jsonOptions{indent: -1, sortKeys: truthy(argument(nil, kwargs, 0, "sort_keys", false))}
+		if indent := argument(args, kwargs, 0, "indent", nil); indent != nil {
+			opts.indent = toInt(indent, 0)
+		}
+
+		if separators, ok := argument(nil, kwargs, 0, "separators", nil).([]any); ok && len(separators) == 2 {
+			opts.itemSep, opts.keySep = str(separators[0]), str(separators[1])
+		}
+		return toJSON(v, opts)
+	case "trim":
+		if chars := argument(args, kwargs, 0, "chars", nil); chars != nil {
+			return strings.Trim(str(v), str(chars)), nil
+		}
+		return strings.TrimSpace(str(v)), nil
+	case "unique":
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		key := sortKey(args, kwargs, 1, 0)
+		var unique, seen []any
+		for _, item := range items {
+			k, err := key(item)
+			if err != nil {
+				return nil, err
+			}
+
+			if !slices.ContainsFunc(seen, func(s any) bool { return equal(s, k) }) {
+				seen = append(seen, k)
+				unique = append(unique, item)
+			}
+		}
+		return unique, nil
+	case "wordcount":
+		return len(strings.Fields(str(v))), nil
+	}
+
+	return nil, fmt.Errorf("no filter named '%s'", name)
+}
+
+func applyTest(name string, v any, args []any) (bool, error) {
+	arg := func() (any, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("test %s expected an argument", name)
+		}
+		return args[0], nil
+	}
+
+	switch name {
+	case "defined":
+		_, ok := v.(undefined)
+		return !ok, nil
+	case "undefined":
+		_, ok := v.(undefined)
+		return ok, nil
+	case "none":
+		return v == nil, nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok, nil
+	case "true", "false":
+		b, ok := v.(bool)
+		return ok && b == (name == "true"), nil
+	case "integer":
+		_, ok := v.(int)
+		return ok, nil
+	case "float":
+		_, ok := v.(float64)
+		return ok, nil
+	case "number":
+		_, ok := number(v)
+		return ok, nil
+	case "string":
+		_, ok := v.(string)
+		return ok, nil
+	case "mapping":
+		_, ok := v.(*Dict)
+		return ok, nil
+	case "iterable", "sequence":
+		switch v.(type) {
+		case string, []any, *Dict:
+			return true, nil
+		}
+		return false, nil
+	case "callable":
+		_, ok := v.(function)
+		return ok, nil
+	case "odd", "even":
+		i, ok := v.(int)
+		if !ok {
+			return false, fmt.Errorf("test %s requires an integer", name)
+		}
+		return (i%2 == 0) == (name == "even"), nil
+	case "divisibleby":
+		n, err := arg()
+		if err != nil {
+			return false, err
+		}
+
+		i, ok := v.(int)
+		d, dok := n.(int)
+		if !ok || !dok || d == 0 {
+			return false, errors.New("test divisibleby requires nonzero integers")
+		}
+		return i%d == 0, nil
+	case "lower":
+		s, ok := v.(string)
+		return ok && strings.ToLower(s) == s, nil
+	case "upper":
+		s, ok := v.(string)
+		return ok && strings.ToUpper(s) == s, nil
+	case "sameas":
+		other, err := arg()
+		if err != nil {
+			return false, err
+		}
+
+		switch v.(type) {
+		case nil, bool:
+			return v == other, nil
+		}
+		return equal(v, other), nil
+	case "in":
+		container, err := arg()
+		if err != nil {
+			return false, err
+		}
+		return contains(container, v)
+	case "eq", "equalto", "==", "ne", "!=", "lt", "lessthan", "<", "le", "<=", "gt", "greaterthan", ">", "ge", ">=":
+		other, err := arg()
+		if err != nil {
+			return false, err
+		}
+
+		op := map[string]string{
+			"eq": "==", "equalto": "==", "ne": "!=",
+			"lt": "<", "lessthan": "<", "le": "<=",
+			"gt": ">", "greaterthan": ">", "ge": ">=",
+		}[name]
+		if op == "" {
+			op = name
+		}
+		return compareOp(op, v, other)
+	}
+
+	return false, fmt.Errorf("no test named '%s'", name)
+}
@@ -0,0 +1,799 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// maxOutput limits the size of a rendered template
+	maxOutput = 64 << 20
+
+	// maxDepth limits the depth of nested macro calls
+	maxDepth = 64
+)
+
+var (
+	errBreak    = errors.New("break outside of a loop")
+	errContinue = errors.New("continue outside of a loop")
+)
+
+// state is the state of a template being executed
+type state struct {
+	b      strings.Builder
+	scopes []map[string]any
+	depth  int
+}
+
+func (s *state) lookup(key string) any {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if v, ok := s.scopes[i][key]; ok {
+			return v
+		}
+	}
+
+	if v, ok := globals[key]; ok {
+		return v
+	}
+
+	return undefined{key}
+}
+
+func (s *state) set(key string, value any) {
+	s.scopes[len(s.scopes)-1][key] = value
+}
+
+func (s *state) write(v string) error {
+	if s.b.Len()+len(v) > maxOutput {
+		return errors.New("template output is too large")
+	}
+
+	s.b.WriteString(v)
+	return nil
+}
+
+func (s *state) render(nodes []node) error {
+	for _, n := range nodes {
+		if err := s.node(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *state) node(n node) error {
+	switch n := n.(type) {
+	case *textNode:
+		return s.write(n.text)
+	case *outputNode:
+		v, err := s.eval(n.expr)
+		if err != nil {
+			return err
+		}
+		return s.write(str(v))
+	case *ifNode:
+		cond, err := s.eval(n.cond)
+		if err != nil {
+			return err
+		}
+
+		if truthy(cond) {
+			return s.render(n.body)
+		}
+		return s.render(n.else_)
+	case *forNode:
+		return s.loop(n)
+	case *setNode:
+		var value any
+		if n.body != nil {
+			var err error
+			if value, err = s.capture(n.body); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if value, err = s.eval(n.value); err != nil {
+				return err
+			}
+		}
+
+		if n.attr != "" {
+			ns, ok := s.lookup(n.names[0]).(*namespace)
+			if !ok {
+				return fmt.Errorf("can't assign attribute %s of %s, which isn't a namespace", n.attr, n.names[0])
+			}
+			ns.Set(n.attr, value)
+			return nil
+		}
+
+		return s.assign(n.names, value)
+	case *macroNode:
+		s.set(n.name, s.macro(n))
+		return nil
+	case *breakNode:
+		return errBreak
+	case *continueNode:
+		return errContinue
+	}
+
+	return fmt.Errorf("unknown node %T", n)
+}
+
+// capture renders nodes, returning their output instead of writing it
+func (s *state) capture(nodes []node) (string, error) {
+	b := s.b
+	s.b = strings.Builder{}
+	defer func() { s.b = b }()
+
+	if err := s.render(nodes); err != nil {
+		return "", err
+	}
+
+	return s.b.String(), nil
+}
+
+// assign sets names to value, unpacking value if there is more than one name
+func (s *state) assign(names []string, value any) error {
+	if len(names) == 1 {
+		s.set(names[0], value)
+		return nil
+	}
+
+	values, err := iterate(value)
+	if err != nil {
+		return err
+	}
+
+	if len(values) != len(names) {
+		return fmt.Errorf("expected %d values to unpack, got %d", len(names), len(values))
+	}
+
+	for i, name := range names {
+		s.set(name, values[i])
+	}
+
+	return nil
+}
+
+func (s *state) loop(n *forNode) error {
+	iter, err := s.eval(n.iter)
+	if err != nil {
+		return err
+	}
+
+	items, err := iterate(iter)
+	if err != nil {
+		return err
+	}
+
+	// variables set in a loop aren't visible after it
+	s.scopes = append(s.scopes, make(map[string]any))
+	defer func() { s.scopes = s.scopes[:len(s.scopes)-1] }()
+
+	if n.cond != nil {
+		var filtered []any
+		for _, item := range items {
+			if err := s.assign(n.targets, item); err != nil {
+				return err
+			}
+
+			cond, err := s.eval(n.cond)
+			if err != nil {
+				return err
+			}
+
+			if truthy(cond) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		return s.render(n.else_)
+	}
+
+	for i, item := range items {
+		loop := NewDict()
+		loop.Set("index", i+1)
+		loop.Set("index0", i)
+		loop.Set("revindex", len(items)-i)
+		loop.Set("revindex0", len(items)-i-1)
+		loop.Set("first", i == 0)
+		loop.Set("last", i == len(items)-1)
+		loop.Set("length", len(items))
+		loop.Set("depth", 1)
+		loop.Set("depth0", 0)
+
+		if i > 0 {
+			loop.Set("previtem", items[i-1])
+		} else {
+			loop.Set("previtem", undefined{"previtem"})
+		}
+
+		if i < len(items)-1 {
+			loop.Set("nextitem", items[i+1])
+		} else {
+			loop.Set("nextitem", undefined{"nextitem"})
+		}
+
+		loop.Set("cycle", function(func(_ *state, args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("no items for cycling given")
+			}
+			return args[i%len(args)], nil
+		}))
+
+		s.set("loop", loop)
+		if err := s.assign(n.targets, item); err != nil {
+			return err
+		}
+
+		if err := s.render(n.body); errors.Is(err, errBreak) {
+			break
+		} else if errors.Is(err, errContinue) {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// macro returns a function which renders n with its arguments
+func (s *state) macro(n *macroNode) function {
+	return func(s *state, args []any, kwargs *Dict) (any, error) {
+		if len(args) > len(n.params) {
+			return nil, fmt.Errorf("macro %s takes at most %d arguments, got %d", n.name, len(n.params), len(args))
+		}
+
+		if s.depth++; s.depth > maxDepth {
+			return nil, errors.New("maximum macro depth exceeded")
+		}
+		defer func() { s.depth-- }()
+
+		// macros see the template's variables but not those of their caller
+		scopes := s.scopes
+		s.scopes = []map[string]any{scopes[0], make(map[string]any)}
+		defer func() { s.scopes = scopes }()
+
+		for i, param := range n.params {
+			switch {
+			case i < len(args):
+				s.set(param, args[i])
+			case kwargs != nil && has(kwargs, param):
+				v, _ := kwargs.Get(param)
+				s.set(param, v)
+			case n.defaults[i] != nil:
+				v, err := s.eval(n.defaults[i])
+				if err != nil {
+					return nil, err
+				}
+				s.set(param, v)
+			default:
+				s.set(param, undefined{param})
+			}
+		}
+
+		return s.capture(n.body)
+	}
+}
+
+func has(d *Dict, key string) bool {
+	_, ok := d.Get(key)
+	return ok
+}
+
+func (s *state) eval(e expr) (any, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.value, nil
+	case *name:
+		return s.lookup(e.name), nil
+	case *listExpr:
+		items := make([]any, len(e.items))
+		for i, item := range e.items {
+			v, err := s.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	case *dictExpr:
+		d := NewDict()
+		for i := range e.keys {
+			k, err := s.eval(e.keys[i])
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := s.eval(e.values[i])
+			if err != nil {
+				return nil, err
+			}
+
+			d.Set(str(k), v)
+		}
+		return d, nil
+	case *getattr:
+		v, err := s.eval(e.value)
+		if err != nil {
+			return nil, err
+		}
+		return attribute(v, e.name), nil
+	case *getitem:
+		v, err := s.eval(e.value)
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := s.eval(e.key)
+		if err != nil {
+			return nil, err
+		}
+		return item(v, k)
+	case *slice:
+		return s.slice(e)
+	case *call:
+		fn, err := s.eval(e.fn)
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := fn.(function)
+		if !ok {
+			if u, ok := fn.(undefined); ok {
+				return nil, fmt.Errorf("%s is undefined", u.name)
+			}
+			return nil, fmt.Errorf("'%s' object is not callable", typeName(fn))
+		}
+
+		args, kwargs, err := s.arguments(e.args, e.kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return f(s, args, kwargs)
+	case *filter:
+		v, err := s.eval(e.value)
+		if err != nil {
+			return nil, err
+		}
+
+		args, kwargs, err := s.arguments(e.args, e.kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return applyFilter(s, e.name, v, args, kwargs)
+	case *test:
+		v, err := s.eval(e.value)
+		if err != nil {
+			return nil, err
+		}
+
+		args, _, err := s.arguments(e.args, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := applyTest(e.name, v, args)
+		if err != nil {
+			return nil, err
+		}
+		return ok != e.negate, nil
+	case *unary:
+		v, err := s.eval(e.value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.op {
+		case "not":
+			return !truthy(v), nil
+		case "-":
+			switch v := v.(type) {
+			case int:
+				return -v, nil
+			case float64:
+				return -v, nil
+			}
+			return nil, fmt.Errorf("bad operand type for unary -: '%s'", typeName(v))
+		}
+	case *binary:
+		left, err := s.eval(e.left)
+		if err != nil {
+			return nil, err
+		}
+
+		// and and or short circuit, returning the deciding operand
+		switch e.op {
+		case "and":
+			if !truthy(left) {
+				return left, nil
+			}
+			return s.eval(e.right)
+		case "or":
+			if truthy(left) {
+				return left, nil
+			}
+			return s.eval(e.right)
+		}
+
+		right, err := s.eval(e.right)
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(e.op, left, right)
+	case *comparison:
+		left, err := s.eval(e.left)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, op := range e.ops {
+			right, err := s.eval(e.rights[i])
+			if err != nil {
+				return nil, err
+			}
+
+			ok, err := compareOp(op, left, right)
+			if err != nil || !ok {
+				return false, err
+			}
+			left = right
+		}
+		return true, nil
+	case *conditional:
+		cond, err := s.eval(e.cond)
+		if err != nil {
+			return nil, err
+		}
+
+		if truthy(cond) {
+			return s.eval(e.then)
+		} else if e.else_ != nil {
+			return s.eval(e.else_)
+		}
+		return undefined{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (s *state) arguments(exprs []expr, kwexprs []kwarg) ([]any, *Dict, error) {
+	args := make([]any, len(exprs))
+	for i, e := range exprs {
+		v, err := s.eval(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = v
+	}
+
+	kwargs := NewDict()
+	for _, kw := range kwexprs {
+		v, err := s.eval(kw.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		kwargs.Set(kw.name, v)
+	}
+
+	return args, kwargs, nil
+}
+
+func (s *state) slice(e *slice) (any, error) {
+	v, err := s.eval(e.value)
+	if err != nil {
+		return nil, err
+	}
+
+	var bounds [3]*int
+	for i, b := range []expr{e.start, e.stop, e.step} {
+		if b == nil {
+			continue
+		}
+
+		n, err := s.eval(b)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n := n.(type) {
+		case nil:
+		case int:
+			bounds[i] = &n
+		default:
+			return nil, errors.New("slice indices must be integers or None")
+		}
+	}
+
+	var items []any
+	switch v := v.(type) {
+	case []any:
+		items = v
+	case string:
+		items, _ = iterate(v)
+	default:
+		return nil, fmt.Errorf("'%s' object is not subscriptable", typeName(v))
+	}
+
+	indices, err := sliceIndices(len(items), bounds[0], bounds[1], bounds[2])
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]any, len(indices))
+	for i, j := range indices {
+		result[i] = items[j]
+	}
+
+	if _, ok := v.(string); ok {
+		var b strings.Builder
+		for _, r := range result {
+			b.WriteString(r.(string))
+		}
+		return b.String(), nil
+	}
+
+	return result, nil
+}
+
+// sliceIndices returns the indices selected by a Python slice of a sequence
+// of length n
+func sliceIndices(n int, start, stop, step *int) ([]int, error) {
+	st := 1
+	if step != nil {
+		st = *step
+	}
+
+	if st == 0 {
+		return nil, errors.New("slice step cannot be zero")
+	}
+
+	clamp := func(i *int, def, lo, hi int) int {
+		if i == nil {
+			return def
+		}
+
+		v := *i
+		if v < 0 {
+			v += n
+		}
+		return max(lo, min(v, hi))
+	}
+
+	var indices []int
+	if st > 0 {
+		for i := clamp(start, 0, 0, n); i < clamp(stop, n, 0, n); i += st {
+			indices = append(indices, i)
+		}
+	} else {
+		for i := clamp(start, n-1, -1, n-1); i > clamp(stop, -1, -1, n-1); i += st {
+			indices = append(indices, i)
+		}
+	}
+
+	return indices, nil
+}
+
+// attribute returns the attribute of v like Jinja's getattr, which prefers
+// methods to the items of a dict
+func attribute(v any, name string) any {
+	if m := method(v, name); m != nil {
+		return m
+	}
+
+	switch v := v.(type) {
+	case *Dict:
+		if value, ok := v.Get(name); ok {
+			return value
+		}
+	case *namespace:
+		if value, ok := v.Get(name); ok {
+			return value
+		}
+	}
+
+	return undefined{name}
+}
+
+// item returns the item of v with key k like Jinja's getitem, which falls
+// back to attributes
+func item(v, k any) (any, error) {
+	switch v := v.(type) {
+	case *Dict:
+		if value, ok := v.Get(str(k)); ok {
+			return value, nil
+		}
+	case *namespace:
+		if value, ok := v.Get(str(k)); ok {
+			return value, nil
+		}
+	case []any, string:
+		i, ok := k.(int)
+		if !ok {
+			break
+		}
+
+		items, _ := iterate(v)
+		if i < 0 {
+			i += len(items)
+		}
+
+		if i < 0 || i >= len(items) {
+			return undefined{str(k)}, nil
+		}
+		return items[i], nil
+	case undefined:
+		return undefined{str(k)}, nil
+	}
+
+	if name, ok := k.(string); ok {
+		return attribute(v, name), nil
+	}
+
+	return undefined{str(k)}, nil
+}
+
+func arithmetic(op string, left, right any) (any, error) {
+	if op == "~" {
+		return str(left) + str(right), nil
+	}
+
+	unsupported := fmt.Errorf("unsupported operand type(s) for %s: '%s' and '%s'", op, typeName(left), typeName(right))
+	switch op {
+	case "+":
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+			return nil, unsupported
+		case []any:
+			if r, ok := right.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+			return nil, unsupported
+		}
+	case "*":
+		// repeat a string or list
+		if n, ok := right.(int); ok {
+			switch l := left.(type) {
+			case string:
+				return strings.Repeat(l, max(n, 0)), nil
+			case []any:
+				var items []any
+				for range max(n, 0) {
+					items = append(items, l...)
+				}
+				return items, nil
+			}
+		}
+	}
+
+	l, lok := number(left)
+	r, rok := number(right)
+	if !lok || !rok {
+		return nil, unsupported
+	}
+
+	_, lf := left.(float64)
+	_, rf := right.(float64)
+	isInt := !lf && !rf
+
+	switch op {
+	case "+", "-", "*":
+		if isInt {
+			l, r := int(l), int(r)
+			switch op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			}
+			return l * r, nil
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		}
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "//", "%":
+		if r == 0 {
+			return nil, errors.New("integer division or modulo by zero")
+		}
+
+		q := math.Floor(l / r)
+		v := q
+		if op == "%" {
+			v = l - q*r
+		}
+
+		if isInt {
+			return int(v), nil
+		}
+		return v, nil
+	case "**":
+		v := math.Pow(l, r)
+		if isInt && r >= 0 {
+			return int(v), nil
+		}
+		return v, nil
+	}
+
+	return nil, unsupported
+}
+
+func compareOp(op string, left, right any) (bool, error) {
+	switch op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in", "not in":
+		ok, err := contains(right, left)
+		return ok == (op == "in"), err
+	}
+
+	c, err := compare(left, right)
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+// contains reports whether container contains v like Python's in operator
+func contains(container, v any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", typeName(v))
+		}
+		return strings.Contains(c, s), nil
+	case *Dict:
+		return has(c, str(v)), nil
+	case *namespace:
+		return has(c.Dict, str(v)), nil
+	case undefined:
+		return false, nil
+	}
+
+	items, err := iterate(container)
+	if err != nil {
+		return false, fmt.Errorf("argument of type '%s' is not iterable", typeName(container))
+	}
+
+	for _, item := range items {
+		if equal(item, v) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
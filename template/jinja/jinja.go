@@ -0,0 +1,143 @@
+// Package jinja implements the subset of Jinja2 used by the chat templates of
+// Hugging Face models, so that a model's own template can render its
+// prompts.
+//
+// Templates are rendered as Hugging Face's transformers renders them: in a
+// sandbox without access to Go values, with trim_blocks and lstrip_blocks
+// enabled, with loop controls, and with the raise_exception and strftime_now
+// functions. Statements include if, for, set, macro, break and continue, and
+// common filters, tests and string and dict methods are available.
+package jinja
+
+import (
+	"io"
+	"slices"
+)
+
+// Template is a parsed Jinja template
+type Template struct {
+	nodes []node
+}
+
+// Parse parses a Jinja template
+func Parse(s string) (*Template, error) {
+	nodes, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{nodes: nodes}, nil
+}
+
+// Execute renders the template to w. vars are converted to template values
+// through their JSON encoding, so structs are accessed by their JSON field
+// names.
+func (t *Template) Execute(w io.Writer, vars map[string]any) error {
+	root := make(map[string]any, len(vars))
+	for k, v := range vars {
+		value, err := valueOf(v)
+		if err != nil {
+			return err
+		}
+		root[k] = value
+	}
+
+	s := state{scopes: []map[string]any{root}}
+	if err := s.render(t.nodes); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s.b.String())
+	return err
+}
+
+// Vars returns the names of the variables and attributes the template
+// references, sorted and without duplicates
+func (t *Template) Vars() []string {
+	var vars []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case []node:
+			for _, n := range v {
+				walk(n)
+			}
+		case []expr:
+			for _, e := range v {
+				walk(e)
+			}
+		case *outputNode:
+			walk(v.expr)
+		case *ifNode:
+			walk(v.cond)
+			walk(v.body)
+			walk(v.else_)
+		case *forNode:
+			walk(v.iter)
+			walk(v.cond)
+			walk(v.body)
+			walk(v.else_)
+		case *setNode:
+			walk(v.value)
+			walk(v.body)
+		case *macroNode:
+			walk(v.defaults)
+			walk(v.body)
+		case *name:
+			vars = append(vars, v.name)
+		case *listExpr:
+			walk(v.items)
+		case *dictExpr:
+			walk(v.keys)
+			walk(v.values)
+		case *getattr:
+			vars = append(vars, v.name)
+			walk(v.value)
+		case *getitem:
+			if l, ok := v.key.(*literal); ok {
+				if s, ok := l.value.(string); ok {
+					vars = append(vars, s)
+				}
+			}
+			walk(v.value)
+			walk(v.key)
+		case *slice:
+			walk(v.value)
+			walk(v.start)
+			walk(v.stop)
+			walk(v.step)
+		case *call:
+			walk(v.fn)
+			walk(v.args)
+			for _, kw := range v.kwargs {
+				walk(kw.value)
+			}
+		case *filter:
+			walk(v.value)
+			walk(v.args)
+			for _, kw := range v.kwargs {
+				walk(kw.value)
+			}
+		case *test:
+			walk(v.value)
+			walk(v.args)
+		case *unary:
+			walk(v.value)
+		case *binary:
+			walk(v.left)
+			walk(v.right)
+		case *comparison:
+			walk(v.left)
+			walk(v.rights)
+		case *conditional:
+			walk(v.cond)
+			walk(v.then)
+			walk(v.else_)
+		}
+	}
+
+	walk(t.nodes)
+
+	slices.Sort(vars)
+	return slices.Compact(vars)
+}
@@ -0,0 +1,210 @@
+package jinja
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func render(t *testing.T, tmpl string, vars map[string]any) (string, error) {
+	t.Helper()
+
+	tt, err := Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = tt.Execute(&b, vars)
+	return b.String(), err
+}
+
+func TestExecute(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, time.July, 26, 9, 5, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now })
+
+	messages := []map[string]any{
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": "Hello!"},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		want     string
+	}{
+		{"text", "Hello, world!", nil, "Hello, world!"},
+		{"variable", "{{ name }}", map[string]any{"name": "Ollama"}, "Ollama"},
+		{"undefined", "[{{ missing }}]", nil, "[]"},
+		{"none", "{{ none }} {{ true }} {{ 1.0 }} {{ [1, 'a'] }} {{ {'a': 1} }}", nil, "None True 1.0 [1, 'a'] {'a': 1}"},
+		{"arithmetic", "{{ 1 + 2 * 3 }} {{ 7 // 2 }} {{ -7 % 3 }} {{ 7 / 2 }} {{ 2 ** 10 }} {{ 'a' ~ 1 }}", nil, "7 3 2 3.5 1024 a1"},
+		{"comparison", "{{ 1 < 2 < 3 }} {{ 'a' in 'abc' }} {{ 3 not in [1, 2] }} {{ not 1 == 1 }}", nil, "True True True False"},
+		{"and or", "{{ 0 or 'default' }} {{ 1 and 2 }} {{ none or '' }}", nil, "default 2 "},
+		{"conditional", "{{ 'yes' if x else 'no' }} {{ 'yes' if not x }}", map[string]any{"x": false}, "no yes"},
+		{"filter precedence", "{{ -1 | abs }} {{ 'a' ~ 'b' | upper }}", nil, "1 aB"},
+		{"attributes", "{{ messages[1].content }} {{ messages[-1]['role'] }} {{ messages | length }}", map[string]any{"messages": messages}, "Hello! user 2"},
+		{"slice", "{{ messages[1:] | map(attribute='role') | join(',') }} {{ 'hello'[::-1] }} {{ 'hello'[1:3] }}", map[string]any{"messages": messages}, "user olleh el"},
+		{"if", "{% if x > 1 %}big{% elif x > 0 %}small{% else %}none{% endif %}", map[string]any{"x": 1}, "small"},
+		{"for", "{% for m in messages %}{{ loop.index }}:{{ m.role }}{% if not loop.last %}, {% endif %}{% endfor %}", map[string]any{"messages": messages}, "1:system, 2:user"},
+		{"for else", "{% for x in [] %}{{ x }}{% else %}empty{% endfor %}", nil, "empty"},
+		{"for filter", "{% for x in range(10) if x is odd %}{{ x }}{% endfor %}", nil, "13579"},
+		{"for unpack", "{% for k, v in {'a': 1, 'b': 2}.items() %}{{ k }}={{ v }};{% endfor %}", nil, "a=1;b=2;"},
+		{"for dict", "{% for k in {'b': 1, 'a': 2} %}{{ k }}{% endfor %}", nil, "ba"},
+		{"loop controls", "{% for x in range(10) %}{% if x is even %}{% continue %}{% endif %}{% if x > 5 %}{% break %}{% endif %}{{ x }}{% endfor %}", nil, "135"},
+		{"loop cycle", "{% for x in range(3) %}{{ loop.cycle('a', 'b') }}{% endfor %}", nil, "aba"},
+		{"loop scope", "{% set x = 1 %}{% for i in range(3) %}{% set x = i %}{% endfor %}{{ x }}", nil, "1"},
+		{"namespace", "{% set ns = namespace(found=false) %}{% for i in range(3) %}{% if i == 1 %}{% set ns.found = true %}{% endif %}{% endfor %}{{ ns.found }}", nil, "True"},
+		{"set block", "{% set x %}Hello {{ name }}{% endset %}{{ x | upper }}", map[string]any{"name": "world"}, "HELLO WORLD"},
+		{"set tuple", "{% set a, b = 1, 2 %}{{ a + b }}", nil, "3"},
+		{"macro", "{% macro greet(name, greeting='Hello') %}{{ greeting }}, {{ name }}!{% endmacro %}{{ greet('world') }} {{ greet('you', greeting='Hi') }}", nil, "Hello, world! Hi, you!"},
+		{"methods", "{{ ' x '.strip() }}|{{ 'a,b'.split(',') }}|{{ 'Hello'.startswith(('He', 'x')) }}|{{ 'abc'.upper() }}|{{ 'a<think>b'.split('<think>')[-1] }}", nil, "x|['a', 'b']|True|ABC|b"},
+		{"dict methods", "{{ d.get('a') }} {{ d.get('z', 0) }} {{ d.keys() | list }} {{ d['items'] }}", map[string]any{"d": map[string]any{"a": 1, "items": 2}}, "1 0 ['a', 'items'] 2"},
+		{"filters", "{{ '  x  ' | trim }}|{{ [3, 1, 2] | sort | join(',') }}|{{ x | default('y') }}|{{ '' | default('z', true) }}|{{ [1, 2, 2] | unique | list }}|{{ 'ab' | reverse }}|{{ '3' | int + 1 }}|{{ 2.567 | round(1) }}", nil, "x|1,2,3|y|z|[1, 2]|ba|4|2.6"},
+		{"select", "{{ messages | selectattr('role', 'equalto', 'user') | map(attribute='content') | first }} {{ messages | rejectattr('role', 'eq', 'user') | list | length }} {{ [1, 2, 3, 4] | select('even') | list }}", map[string]any{"messages": messages}, "Hello! 1 [2, 4]"},
+		{"tests", "{{ x is defined }} {{ y is none }} {{ 'a' is string }} {{ {} is mapping }} {{ 4 is divisibleby 2 }} {{ 1 is number }} {{ 'a' is not in 'b' }}", map[string]any{"y": nil}, "False True True True True True True"},
+		{"tojson", `{{ {"a": [1, 2.5, "é\n"], "b": none} | tojson }}|{{ {"b": 1, "a": true} | tojson(indent=2) }}|{{ {"b": 1, "a": 2} | tojson(sort_keys=true) }}`, nil, "{\"a\": [1, 2.5, \"é\\n\"], \"b\": null}|{\n  \"b\": 1,\n  \"a\": true\n}|{\"a\": 2, \"b\": 1}"},
+		{"indent", "{{ 'a\nb\n\nc' | indent(2) }}", nil, "a\n  b\n\n  c"},
+		{"strftime_now", "{{ strftime_now('%d %b %Y %H:%M') }}", nil, "26 Jul 2024 09:05"},
+		{"trim_blocks", "{% if true %}\nyes\n{% endif %}\n", nil, "yes\n"},
+		{"lstrip_blocks", "  {% if true %}\n  yes\n  {% endif %}\nno", nil, "  yes\nno"},
+		{"whitespace control", "a  {{- 'b' -}}  c {%- if true %} d{% endif -%}  e", nil, "abc de"},
+		{"disable lstrip", "  {%+ if true %}x{% endif %}", nil, "  x"},
+		{"comments", "a {# comment #}b\n  {# own line #}\nc", nil, "a b\nc"},
+		{"generation", "{% generation %}x{% endgeneration %}", nil, "x"},
+		{"strings", `{{ "a\tb" }}{{ 'it\'s' }}{{ "é" }}`, nil, "a\tbit'sé"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(t, tt.template, tt.vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExecuteError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"raise_exception", "{{ raise_exception('Conversation roles must alternate') }}", "Conversation roles must alternate"},
+		{"unclosed tag", "{{ x", "line 1: unclosed tag"},
+		{"unclosed block", "{% if x %}", `line 1: expected "endif", got ""`},
+		{"unknown filter", "{{ x | nope }}", "no filter named 'nope'"},
+		{"unknown test", "{{ x is nope }}", "no test named 'nope'"},
+		{"not callable", "{{ x() }}", "x is undefined"},
+		{"not iterable", "{% for x in 1 %}{% endfor %}", "'int' object is not iterable"},
+		{"bad operands", "{{ 'a' + 1 }}", "unsupported operand type(s) for +: 'str' and 'int'"},
+		{"division by zero", "{{ 1 / 0 }}", "division by zero"},
+		{"mutation", "{{ [].append(1) }}", "append is undefined"},
+		{"not a namespace", "{% set x = 1 %}{% set x.y = 2 %}", "can't assign attribute y of x, which isn't a namespace"},
+		{"recursion", "{% macro f() %}{{ f() }}{% endmacro %}{{ f() }}", "maximum macro depth exceeded"},
+		{"output", "{% for i in range(100000) %}{{ 'x' * 1000 }}{% endfor %}", "template output is too large"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := render(t, tt.template, nil)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestQwen(t *testing.T) {
+	bts, err := os.ReadFile(filepath.Join("testdata", "qwen2.5.jinja"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tools := []any{
+		map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        "get_current_weather",
+				"description": "Get the current weather",
+				"parameters": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"location": map[string]any{"type": "string"}},
+					"required":   []string{"location"},
+				},
+			},
+		},
+	}
+
+	messages := []any{
+		map[string]any{"role": "user", "content": "What's the weather in Paris?"},
+		map[string]any{"role": "assistant", "content": "", "tool_calls": []any{
+			map[string]any{"type": "function", "function": map[string]any{"name": "get_current_weather", "arguments": map[string]any{"location": "Paris"}}},
+		}},
+		map[string]any{"role": "tool", "content": "22°C"},
+		map[string]any{"role": "tool", "content": "Sunny"},
+	}
+
+	got, err := render(t, string(bts), map[string]any{"messages": messages, "tools": tools, "add_generation_prompt": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<|im_start|>system
+You are Qwen, created by Alibaba Cloud. You are a helpful assistant.
+
+# Tools
+
+You may call one or more functions to assist with the user query.
+
+You are provided with function signatures within <tools></tools> XML tags:
+<tools>
+{"function": {"description": "Get the current weather", "name": "get_current_weather", "parameters": {"properties": {"location": {"type": "string"}}, "required": ["location"], "type": "object"}}, "type": "function"}
+</tools>
+
+For each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:
+<tool_call>
+{"name": <function-name>, "arguments": <args-json-object>}
+</tool_call><|im_end|>
+<|im_start|>user
+What's the weather in Paris?<|im_end|>
+<|im_start|>assistant
+<tool_call>
+{"name": "get_current_weather", "arguments": {"location": "Paris"}}
+</tool_call><|im_end|>
+<|im_start|>user
+<tool_response>
+22°C
+</tool_response>
+<tool_response>
+Sunny
+</tool_response><|im_end|>
+<|im_start|>assistant
+`
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestVars(t *testing.T) {
+	tmpl, err := Parse("{% for m in messages %}{{ m['content'] }}{% for t in m.tool_calls %}{{ t.function.name }}{% endfor %}{% endfor %}{% if tools %}{{ tools | tojson }}{% endif %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"content", "function", "m", "messages", "name", "t", "tool_calls", "tools"}
+	if got := tmpl.Vars(); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,325 @@
+package jinja
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenText
+	tokenVariableBegin
+	tokenVariableEnd
+	tokenBlockBegin
+	tokenBlockEnd
+	tokenName
+	tokenString
+	tokenInteger
+	tokenFloat
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	line  int
+}
+
+// operators, longest first so they're matched greedily
+var operators = []string{
+	"//", "**", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "~", "<", ">", "=", "(", ")", "[", "]", "{", "}", ".", ",", ":", "|",
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	line   int
+	tokens []token
+}
+
+// lex splits src into tokens. Whitespace is controlled as Hugging Face
+// renders chat templates, with trim_blocks and lstrip_blocks enabled: the
+// first newline after a block or comment is removed, and spaces and tabs
+// before a block or comment on its own line are stripped. A - inside a tag's
+// delimiters strips all whitespace on that side and a + disables trimming.
+func lex(src string) ([]token, error) {
+	l := lexer{src: src, line: 1}
+
+	// whether the next text starts a line, for lstrip_blocks
+	lineStarting := true
+	var trimLeft, trimNewline bool
+	for l.pos < len(l.src) {
+		i := indexTag(l.src[l.pos:])
+		end := len(l.src)
+		if i >= 0 {
+			end = l.pos + i
+		}
+
+		text := l.src[l.pos:end]
+		l.line += strings.Count(text, "\n")
+		l.pos = end
+
+		if trimLeft {
+			text = strings.TrimLeft(text, " \t\r\n")
+		} else if trimNewline {
+			if t, ok := strings.CutPrefix(text, "\n"); ok {
+				text = t
+				lineStarting = true
+			} else if t, ok := strings.CutPrefix(text, "\r\n"); ok {
+				text = t
+				lineStarting = true
+			}
+		}
+
+		trimLeft, trimNewline = false, false
+
+		if i < 0 {
+			l.emit(tokenText, text)
+			break
+		}
+
+		begin := l.src[l.pos : l.pos+2]
+		l.pos += 2
+
+		var modifier byte
+		if l.pos < len(l.src) && (l.src[l.pos] == '-' || l.src[l.pos] == '+') {
+			modifier = l.src[l.pos]
+			l.pos++
+		}
+
+		switch {
+		case modifier == '-':
+			text = strings.TrimRight(text, " \t\r\n")
+		case modifier != '+' && begin != "{{":
+			// strip indentation before a block on its own line
+			start := strings.LastIndexByte(text, '\n') + 1
+			if (start > 0 || lineStarting) && strings.TrimLeft(text[start:], " \t") == "" {
+				text = text[:start]
+			}
+		}
+
+		if text != "" {
+			l.emit(tokenText, text)
+		}
+
+		var err error
+		switch begin {
+		case "{#":
+			trimLeft, trimNewline, err = l.comment()
+		case "{{":
+			l.emit(tokenVariableBegin, begin)
+			trimLeft, err = l.tag("}}", tokenVariableEnd)
+		case "{%":
+			l.emit(tokenBlockBegin, begin)
+			if trimLeft, err = l.tag("%}", tokenBlockEnd); err == nil {
+				// + before the end of a block disables trim_blocks
+				trimNewline = !trimLeft && l.src[l.pos-3] != '+'
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lineStarting = false
+	}
+
+	l.emit(tokenEOF, "")
+	return l.tokens, nil
+}
+
+func indexTag(s string) int {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '{' && (s[i+1] == '{' || s[i+1] == '%' || s[i+1] == '#') {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (l *lexer) emit(kind tokenKind, value string) {
+	l.tokens = append(l.tokens, token{kind: kind, value: value, line: l.line})
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// comment skips a comment, returning how whitespace after it is trimmed
+func (l *lexer) comment() (trimLeft, trimNewline bool, err error) {
+	i := strings.Index(l.src[l.pos:], "#}")
+	if i < 0 {
+		return false, false, l.errorf("unclosed comment")
+	}
+
+	comment := l.src[l.pos : l.pos+i]
+	l.line += strings.Count(comment, "\n")
+	l.pos += i + 2
+
+	switch {
+	case strings.HasSuffix(comment, "-"):
+		return true, false, nil
+	case strings.HasSuffix(comment, "+"):
+		return false, false, nil
+	}
+
+	return false, true, nil
+}
+
+// tag lexes the contents of a tag up to end, returning whether whitespace
+// after it is trimmed
+func (l *lexer) tag(end string, kind tokenKind) (trimLeft bool, _ error) {
+	var depth int
+	for {
+		for l.pos < len(l.src) && strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])) {
+			if l.src[l.pos] == '\n' {
+				l.line++
+			}
+			l.pos++
+		}
+
+		if l.pos >= len(l.src) {
+			return false, l.errorf("unclosed tag")
+		}
+
+		rest := l.src[l.pos:]
+		if depth == 0 {
+			for _, modifier := range []string{"-", "+", ""} {
+				if strings.HasPrefix(rest, modifier+end) {
+					l.pos += len(modifier) + len(end)
+					l.emit(kind, end)
+					return modifier == "-", nil
+				}
+			}
+		}
+
+		c := rest[0]
+		switch {
+		case c == '_' || isLetter(c):
+			n := 1
+			for n < len(rest) && (rest[n] == '_' || isLetter(rest[n]) || isDigit(rest[n])) {
+				n++
+			}
+			l.emit(tokenName, rest[:n])
+			l.pos += n
+		case isDigit(c):
+			n, kind := 0, tokenInteger
+			for n < len(rest) && (isDigit(rest[n]) || rest[n] == '_') {
+				n++
+			}
+			if n+1 < len(rest) && rest[n] == '.' && isDigit(rest[n+1]) {
+				kind = tokenFloat
+				n++
+				for n < len(rest) && isDigit(rest[n]) {
+					n++
+				}
+			}
+			if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+				m := n + 1
+				if m < len(rest) && (rest[m] == '+' || rest[m] == '-') {
+					m++
+				}
+				if m < len(rest) && isDigit(rest[m]) {
+					kind = tokenFloat
+					for n = m; n < len(rest) && isDigit(rest[n]); n++ {
+					}
+				}
+			}
+			l.emit(kind, strings.ReplaceAll(rest[:n], "_", ""))
+			l.pos += n
+		case c == '"' || c == '\'':
+			s, n, err := unquote(rest)
+			if err != nil {
+				return false, l.errorf("%v", err)
+			}
+			l.emit(tokenString, s)
+			l.line += strings.Count(rest[:n], "\n")
+			l.pos += n
+		default:
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(rest, o) {
+					op = o
+					break
+				}
+			}
+
+			switch op {
+			case "":
+				return false, l.errorf("unexpected character %q", c)
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+
+			l.emit(tokenOperator, op)
+			l.pos += len(op)
+		}
+	}
+}
+
+// unquote decodes the string literal at the start of s, returning its value
+// and length
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case '0':
+				b.WriteByte(0)
+			case '\\', '\'', '"':
+				b.WriteByte(e)
+			case '\n':
+				// line continuation
+			case 'x', 'u', 'U':
+				n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+				if i+n >= len(s) {
+					return "", 0, fmt.Errorf("invalid escape in string")
+				}
+
+				var r rune
+				if _, err := fmt.Sscanf(s[i+1:i+1+n], "%x", &r); err != nil {
+					return "", 0, fmt.Errorf("invalid escape in string")
+				}
+				b.WriteRune(r)
+				i += n
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unclosed string")
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
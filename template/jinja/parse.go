@@ -0,0 +1,895 @@
+package jinja
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+type node interface{}
+
+type (
+	textNode struct {
+		text string
+	}
+
+	outputNode struct {
+		expr expr
+	}
+
+	ifNode struct {
+		cond        expr
+		body, else_ []node
+	}
+
+	forNode struct {
+		targets     []string
+		iter        expr
+		cond        expr
+		body, else_ []node
+	}
+
+	// setNode assigns value, or the output of body, to names or to an
+	// attribute of a namespace
+	setNode struct {
+		names []string
+		attr  string
+		value expr
+		body  []node
+	}
+
+	macroNode struct {
+		name     string
+		params   []string
+		defaults []expr
+		body     []node
+	}
+
+	breakNode    struct{}
+	continueNode struct{}
+)
+
+type expr interface{}
+
+type (
+	literal struct {
+		value any
+	}
+
+	name struct {
+		name string
+	}
+
+	listExpr struct {
+		items []expr
+	}
+
+	dictExpr struct {
+		keys, values []expr
+	}
+
+	getattr struct {
+		value expr
+		name  string
+	}
+
+	getitem struct {
+		value, key expr
+	}
+
+	slice struct {
+		value, start, stop, step expr
+	}
+
+	kwarg struct {
+		name  string
+		value expr
+	}
+
+	call struct {
+		fn     expr
+		args   []expr
+		kwargs []kwarg
+	}
+
+	filter struct {
+		value  expr
+		name   string
+		args   []expr
+		kwargs []kwarg
+	}
+
+	test struct {
+		value  expr
+		name   string
+		args   []expr
+		negate bool
+	}
+
+	unary struct {
+		op    string
+		value expr
+	}
+
+	binary struct {
+		op          string
+		left, right expr
+	}
+
+	comparison struct {
+		left   expr
+		ops    []string
+		rights []expr
+	}
+
+	conditional struct {
+		cond, then, else_ expr
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) ([]node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	nodes, end, err := p.body()
+	if err != nil {
+		return nil, err
+	}
+
+	if end != "" {
+		return nil, p.errorf("unexpected tag %q", end)
+	}
+
+	return nodes, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// accept consumes the next token if it's an operator or name with value
+func (p *parser) accept(value string) bool {
+	if t := p.peek(); (t.kind == tokenOperator || t.kind == tokenName) && t.value == value {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(value string) error {
+	if !p.accept(value) {
+		return p.errorf("expected %q, got %q", value, p.peek().value)
+	}
+
+	return nil
+}
+
+func (p *parser) expectKind(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("line %d: unexpected %q", t.line, t.value)
+	}
+
+	return t, nil
+}
+
+// body parses nodes until a block whose tag isn't a statement, such as
+// endif, or the end of the template. It returns the tag, leaving the rest of
+// the block to be parsed.
+func (p *parser) body() ([]node, string, error) {
+	var nodes []node
+	for {
+		switch t := p.next(); t.kind {
+		case tokenEOF:
+			return nodes, "", nil
+		case tokenText:
+			nodes = append(nodes, &textNode{t.value})
+		case tokenVariableBegin:
+			e, err := p.expr()
+			if err != nil {
+				return nil, "", err
+			}
+
+			if _, err := p.expectKind(tokenVariableEnd); err != nil {
+				return nil, "", err
+			}
+
+			nodes = append(nodes, &outputNode{e})
+		case tokenBlockBegin:
+			tag, err := p.expectKind(tokenName)
+			if err != nil {
+				return nil, "", err
+			}
+
+			var n node
+			switch tag.value {
+			case "if":
+				n, err = p.ifBlock()
+			case "for":
+				n, err = p.forBlock()
+			case "set":
+				n, err = p.setBlock()
+			case "macro":
+				n, err = p.macroBlock()
+			case "break":
+				n = &breakNode{}
+			case "continue":
+				n = &continueNode{}
+			case "generation":
+				// Hugging Face marks assistant output with generation blocks,
+				// which render their body
+				var body []node
+				if body, err = p.block("endgeneration"); err == nil {
+					n = &ifNode{cond: &literal{true}, body: body}
+				}
+			default:
+				return nodes, tag.value, nil
+			}
+			if err != nil {
+				return nil, "", err
+			}
+
+			if _, err := p.expectKind(tokenBlockEnd); err != nil {
+				return nil, "", err
+			}
+
+			nodes = append(nodes, n)
+		default:
+			return nil, "", fmt.Errorf("line %d: unexpected %q", t.line, t.value)
+		}
+	}
+}
+
+// block parses the end of the current tag, then the body up to the end tag
+func (p *parser) block(end string) ([]node, error) {
+	if _, err := p.expectKind(tokenBlockEnd); err != nil {
+		return nil, err
+	}
+
+	body, tag, err := p.body()
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != end {
+		return nil, p.errorf("expected %q, got %q", end, tag)
+	}
+
+	return body, nil
+}
+
+// ifBlock parses an if block up to the end of its endif tag
+func (p *parser) ifBlock() (node, error) {
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expectKind(tokenBlockEnd); err != nil {
+		return nil, err
+	}
+
+	n := ifNode{cond: cond}
+	var tag string
+	if n.body, tag, err = p.body(); err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case "elif":
+		elif, err := p.ifBlock()
+		if err != nil {
+			return nil, err
+		}
+		n.else_ = []node{elif}
+		return &n, nil
+	case "else":
+		if n.else_, err = p.block("endif"); err != nil {
+			return nil, err
+		}
+	case "endif":
+	default:
+		return nil, p.errorf("expected \"endif\", got %q", tag)
+	}
+
+	return &n, nil
+}
+
+func (p *parser) targets() ([]string, error) {
+	paren := p.accept("(")
+
+	var names []string
+	for {
+		t, err := p.expectKind(tokenName)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, t.value)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if paren {
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+func (p *parser) forBlock() (node, error) {
+	targets, err := p.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+
+	n := forNode{targets: targets}
+	if n.iter, err = p.tuple(false); err != nil {
+		return nil, err
+	}
+
+	if p.accept("if") {
+		if n.cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("recursive") {
+		return nil, p.errorf("recursive loops are not supported")
+	}
+
+	if _, err := p.expectKind(tokenBlockEnd); err != nil {
+		return nil, err
+	}
+
+	var tag string
+	if n.body, tag, err = p.body(); err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case "else":
+		if n.else_, err = p.block("endfor"); err != nil {
+			return nil, err
+		}
+	case "endfor":
+	default:
+		return nil, p.errorf("expected \"endfor\", got %q", tag)
+	}
+
+	return &n, nil
+}
+
+func (p *parser) setBlock() (node, error) {
+	var n setNode
+
+	names, err := p.targets()
+	if err != nil {
+		return nil, err
+	}
+	n.names = names
+
+	if len(names) == 1 && p.accept(".") {
+		t, err := p.expectKind(tokenName)
+		if err != nil {
+			return nil, err
+		}
+		n.attr = t.value
+	}
+
+	if p.accept("=") {
+		n.value, err = p.tuple(true)
+		return &n, err
+	}
+
+	if len(names) > 1 || n.attr != "" {
+		return nil, p.errorf("expected \"=\"")
+	}
+
+	if n.body, err = p.block("endset"); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (p *parser) macroBlock() (node, error) {
+	t, err := p.expectKind(tokenName)
+	if err != nil {
+		return nil, err
+	}
+
+	n := macroNode{name: t.value}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	for !p.accept(")") {
+		if len(n.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		t, err := p.expectKind(tokenName)
+		if err != nil {
+			return nil, err
+		}
+
+		var value expr
+		if p.accept("=") {
+			if value, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+
+		n.params = append(n.params, t.value)
+		n.defaults = append(n.defaults, value)
+	}
+
+	if n.body, err = p.block("endmacro"); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+// tuple parses an expression, or a tuple of expressions separated by commas
+func (p *parser) tuple(conditional bool) (expr, error) {
+	parse := p.expr
+	if !conditional {
+		parse = p.or
+	}
+
+	e, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenOperator || t.value != "," {
+		return e, nil
+	}
+
+	items := []expr{e}
+	for p.accept(",") {
+		e, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+
+	return &listExpr{items}, nil
+}
+
+func (p *parser) expr() (expr, error) {
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("if") {
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		var else_ expr
+		if p.accept("else") {
+			if else_, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+
+		e = &conditional{cond: cond, then: e, else_: else_}
+	}
+
+	return e, nil
+}
+
+func (p *parser) or() (expr, error) {
+	return p.binary([]string{"or"}, p.and)
+}
+
+func (p *parser) and() (expr, error) {
+	return p.binary([]string{"and"}, p.not)
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("not") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "not", value: e}, nil
+	}
+
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.math1()
+	if err != nil {
+		return nil, err
+	}
+
+	n := comparison{left: left}
+	for {
+		var op string
+		switch t := p.peek(); {
+		case t.kind == tokenOperator && slices.Contains([]string{"==", "!=", "<", ">", "<=", ">="}, t.value):
+			op = t.value
+			p.pos++
+		case t.kind == tokenName && t.value == "in":
+			op = "in"
+			p.pos++
+		case t.kind == tokenName && t.value == "not" && p.tokens[p.pos+1].kind == tokenName && p.tokens[p.pos+1].value == "in":
+			op = "not in"
+			p.pos += 2
+		}
+
+		if op == "" {
+			break
+		}
+
+		right, err := p.math1()
+		if err != nil {
+			return nil, err
+		}
+
+		n.ops = append(n.ops, op)
+		n.rights = append(n.rights, right)
+	}
+
+	if len(n.ops) == 0 {
+		return left, nil
+	}
+
+	return &n, nil
+}
+
+func (p *parser) math1() (expr, error) {
+	return p.binary([]string{"+", "-"}, p.concat)
+}
+
+func (p *parser) concat() (expr, error) {
+	return p.binary([]string{"~"}, p.math2)
+}
+
+func (p *parser) math2() (expr, error) {
+	return p.binary([]string{"*", "/", "//", "%"}, p.pow)
+}
+
+func (p *parser) pow() (expr, error) {
+	return p.binary([]string{"**"}, func() (expr, error) { return p.unary(true) })
+}
+
+// binary parses left associative operators
+func (p *parser) binary(ops []string, operand func() (expr, error)) (expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if (t.kind != tokenOperator && t.kind != tokenName) || !slices.Contains(ops, t.value) {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binary{op: t.value, left: left, right: right}
+	}
+}
+
+// unary parses a unary expression. Filters apply to the result of the
+// outermost operator, so -x|abs is (-x)|abs.
+func (p *parser) unary(withFilters bool) (expr, error) {
+	var e expr
+	var err error
+	switch {
+	case p.accept("-"):
+		if e, err = p.unary(false); err != nil {
+			return nil, err
+		}
+		e = &unary{op: "-", value: e}
+	case p.accept("+"):
+		if e, err = p.unary(false); err != nil {
+			return nil, err
+		}
+	default:
+		if e, err = p.primary(); err != nil {
+			return nil, err
+		}
+
+		if e, err = p.postfix(e); err != nil {
+			return nil, err
+		}
+	}
+
+	if !withFilters {
+		return e, nil
+	}
+
+	return p.filters(e)
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenName:
+		switch t.value {
+		case "true", "True":
+			return &literal{true}, nil
+		case "false", "False":
+			return &literal{false}, nil
+		case "none", "None":
+			return &literal{nil}, nil
+		}
+		return &name{t.value}, nil
+	case tokenString:
+		s := t.value
+		// adjacent strings are concatenated
+		for p.peek().kind == tokenString {
+			s += p.next().value
+		}
+		return &literal{s}, nil
+	case tokenInteger:
+		i, err := strconv.Atoi(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", t.line, err)
+		}
+		return &literal{i}, nil
+	case tokenFloat:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", t.line, err)
+		}
+		return &literal{f}, nil
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			if p.accept(")") {
+				return &listExpr{}, nil
+			}
+
+			e, err := p.tuple(true)
+			if err != nil {
+				return nil, err
+			}
+
+			return e, p.expect(")")
+		case "[":
+			var items []expr
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+
+					// trailing comma
+					if p.accept("]") {
+						break
+					}
+				}
+
+				e, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, e)
+			}
+			return &listExpr{items}, nil
+		case "{":
+			var d dictExpr
+			for !p.accept("}") {
+				if len(d.keys) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+
+					if p.accept("}") {
+						break
+					}
+				}
+
+				k, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+
+				v, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+
+				d.keys = append(d.keys, k)
+				d.values = append(d.values, v)
+			}
+			return &d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.value)
+}
+
+func (p *parser) postfix(e expr) (expr, error) {
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			switch t.kind {
+			case tokenName:
+				e = &getattr{value: e, name: t.value}
+			case tokenInteger:
+				i, _ := strconv.Atoi(t.value)
+				e = &getitem{value: e, key: &literal{i}}
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.value)
+			}
+		case p.accept("["):
+			var parts [3]expr
+			var colons int
+			for i := 0; !p.accept("]"); {
+				if p.accept(":") {
+					if colons++; colons > 2 {
+						return nil, p.errorf("invalid slice")
+					}
+					i++
+					continue
+				}
+
+				if parts[i] != nil {
+					return nil, p.errorf("expected \"]\", got %q", p.peek().value)
+				}
+
+				part, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				parts[i] = part
+			}
+
+			if colons == 0 {
+				if parts[0] == nil {
+					return nil, p.errorf("expected subscript")
+				}
+				e = &getitem{value: e, key: parts[0]}
+			} else {
+				e = &slice{value: e, start: parts[0], stop: parts[1], step: parts[2]}
+			}
+		case p.accept("("):
+			args, kwargs, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			e = &call{fn: e, args: args, kwargs: kwargs}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// arguments parses the arguments of a call after its opening parenthesis
+func (p *parser) arguments() ([]expr, []kwarg, error) {
+	var args []expr
+	var kwargs []kwarg
+	for !p.accept(")") {
+		if len(args)+len(kwargs) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, nil, err
+			}
+
+			if p.accept(")") {
+				break
+			}
+		}
+
+		if t := p.peek(); t.kind == tokenName && p.tokens[p.pos+1].kind == tokenOperator && p.tokens[p.pos+1].value == "=" {
+			p.pos += 2
+			value, err := p.expr()
+			if err != nil {
+				return nil, nil, err
+			}
+			kwargs = append(kwargs, kwarg{name: t.value, value: value})
+			continue
+		}
+
+		e, err := p.expr()
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, e)
+	}
+
+	return args, kwargs, nil
+}
+
+// filters parses filters and tests applied to e
+func (p *parser) filters(e expr) (expr, error) {
+	for {
+		switch {
+		case p.accept("|"):
+			t, err := p.expectKind(tokenName)
+			if err != nil {
+				return nil, err
+			}
+
+			f := filter{value: e, name: t.value}
+			if p.accept("(") {
+				if f.args, f.kwargs, err = p.arguments(); err != nil {
+					return nil, err
+				}
+			}
+			e = &f
+		case p.accept("is"):
+			negate := p.accept("not")
+
+			// tests such as none, true and false are literals
+			t := p.next()
+			if t.kind != tokenName {
+				return nil, fmt.Errorf("line %d: expected a test, got %q", t.line, t.value)
+			}
+
+			te := test{value: e, name: t.value, negate: negate}
+			if p.accept("(") {
+				args, _, err := p.arguments()
+				if err != nil {
+					return nil, err
+				}
+				te.args = args
+			} else if next := p.peek(); next.kind == tokenString || next.kind == tokenInteger || next.kind == tokenFloat ||
+				(next.kind == tokenName && !slices.Contains([]string{"and", "or", "else", "if", "in", "not", "is"}, next.value)) {
+				// a single argument without parentheses, such as "is divisibleby 3"
+				arg, err := p.primary()
+				if err != nil {
+					return nil, err
+				}
+				if arg, err = p.postfix(arg); err != nil {
+					return nil, err
+				}
+				te.args = []expr{arg}
+			}
+			e = &te
+		default:
+			return e, nil
+		}
+	}
+}
@@ -0,0 +1,1184 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// kind is the type of a value in a translated template
+type kind int
+
+const (
+	kindAny kind = iota
+	kindString
+	kindBool
+	kindInt
+	kindObject
+	kindMessages
+	kindMessage
+	kindToolCalls
+	kindToolCall
+	kindToolCallFunction
+	kindTools
+	kindTool
+	kindToolFunction
+)
+
+// elements are the kinds of the items of lists
+var elements = map[kind]kind{
+	kindMessages:  kindMessage,
+	kindToolCalls: kindToolCall,
+	kindTools:     kindTool,
+}
+
+type field struct {
+	name string
+	kind kind
+}
+
+// fields map the attributes of the values a chat template is rendered with
+// to the fields of Ollama's template values
+var fields = map[kind]map[string]field{
+	kindMessage: {
+		"role":       {"Role", kindString},
+		"content":    {"Content", kindString},
+		"tool_calls": {"ToolCalls", kindToolCalls},
+	},
+	kindToolCall: {
+		"function": {"Function", kindToolCallFunction},
+	},
+	kindToolCallFunction: {
+		"name":      {"Name", kindString},
+		"arguments": {"Arguments", kindObject},
+	},
+	kindTool: {
+		"type":     {"Type", kindString},
+		"function": {"Function", kindToolFunction},
+	},
+	kindToolFunction: {
+		"name":        {"Name", kindString},
+		"description": {"Description", kindString},
+		"parameters":  {"Parameters", kindObject},
+	},
+}
+
+// operand is a translated expression
+type operand struct {
+	// expr is a Go template operand such as $.Messages or (eq $x "user")
+	expr string
+	kind kind
+
+	// constant is set if the value is known, in which case it's value
+	constant bool
+	value    any
+
+	// optional is set if the value is undefined when it's empty, such as
+	// a message's tool_calls, and nullable if it's none, such as tools
+	optional bool
+	nullable bool
+}
+
+func constant(v any) operand {
+	return operand{constant: true, value: v}
+}
+
+// literal returns the value as a Go template literal
+func (o operand) literal() (string, error) {
+	switch v := o.value.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case undefined:
+		return `""`, nil
+	}
+
+	return "", fmt.Errorf("can't translate %s", repr(o.value))
+}
+
+// arg returns the operand as an argument of a Go template function
+func (o operand) arg() (string, error) {
+	if o.constant {
+		return o.literal()
+	}
+
+	return o.expr, nil
+}
+
+// variable is a variable assigned by a set statement
+type variable struct {
+	// name is the variable's name in the Go template
+	name string
+
+	assignments int
+
+	// value is the variable's value if it's assigned a constant once, at
+	// the top of the template, before it's used
+	value *operand
+
+	used bool
+}
+
+type loop struct {
+	target string
+	index  string
+	iter   operand
+}
+
+type translator struct {
+	vars  map[string]*variable
+	order []*variable
+
+	// names are the names the template uses, which index variables
+	// mustn't shadow
+	names map[string]bool
+
+	loops []loop
+	kinds map[string]kind
+}
+
+// GoTemplate translates the template into a Go template which renders
+// Ollama's template values the same way: messages are .Messages, tools are
+// .Tools and the tojson and trim filters are the functions of the same
+// names. Calls to raise_exception are dropped since a Go template can't reject
+// a conversation. An error is returned if the template uses something which
+// can't be translated, such as arithmetic or macros.
+func (t *Template) GoTemplate() (string, error) {
+	tr := translator{
+		vars:  make(map[string]*variable),
+		names: make(map[string]bool),
+		kinds: make(map[string]kind),
+	}
+
+	if err := tr.prepare(t.nodes, 0, nil); err != nil {
+		return "", err
+	}
+
+	for _, v := range tr.order {
+		if o, ok := tr.builtin(v.name); ok && v.value == nil {
+			tr.kinds[v.name] = o.kind
+		}
+	}
+
+	body, err := tr.nodes(t.nodes)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, v := range tr.order {
+		if v.value != nil {
+			continue
+		}
+
+		// variables assigned a builtin start with its value
+		value := `""`
+		if o, ok := tr.builtin(v.name); ok {
+			if v.name == "add_generation_prompt" {
+				continue
+			}
+			value, _ = o.arg()
+		}
+		fmt.Fprintf(&b, `{{ $%s := %s }}`, v.name, value)
+	}
+
+	if strings.Contains(body, "$add_generation_prompt") {
+		// prompt for a response unless the last message is the assistant's
+		b.WriteString(`{{ $add_generation_prompt := true }}{{ range .Messages }}{{ $add_generation_prompt = ne .Role "assistant" }}{{ end }}`)
+	}
+
+	b.WriteString(body)
+	return b.String(), nil
+}
+
+// prepare finds the variables the template assigns and which of them are
+// constants
+func (tr *translator) prepare(nodes []node, depth int, targets []string) error {
+	var walk func(any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case *name:
+			tr.names[v.name] = true
+			if variable, ok := tr.vars[v.name]; ok {
+				variable.used = true
+			}
+		case expr:
+			for _, e := range children(v) {
+				walk(e)
+			}
+		}
+	}
+
+	assign := func(name string, value expr) {
+		v, ok := tr.vars[name]
+		if !ok {
+			v = &variable{name: name}
+			tr.vars[name] = v
+			tr.order = append(tr.order, v)
+		}
+
+		v.assignments++
+		if l, ok := value.(*literal); ok && v.assignments == 1 && depth == 0 && !v.used && !tr.names[name] {
+			o := constant(l.value)
+			v.value = &o
+		} else {
+			v.value = nil
+		}
+	}
+
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *outputNode:
+			walk(n.expr)
+		case *ifNode:
+			walk(n.cond)
+			if err := tr.prepare(n.body, depth+1, targets); err != nil {
+				return err
+			}
+			if err := tr.prepare(n.else_, depth+1, targets); err != nil {
+				return err
+			}
+		case *forNode:
+			walk(n.iter)
+			walk(n.cond)
+			for _, target := range n.targets {
+				tr.names[target] = true
+			}
+			if err := tr.prepare(n.body, depth+1, append(targets, n.targets...)); err != nil {
+				return err
+			}
+			if err := tr.prepare(n.else_, depth+1, targets); err != nil {
+				return err
+			}
+		case *setNode:
+			walk(n.value)
+			if n.body != nil || len(n.names) != 1 {
+				return errors.New("can't translate set statements with blocks or several names")
+			}
+
+			name := n.names[0]
+			switch {
+			case n.attr != "":
+				assign(name+"_"+n.attr, n.value)
+			case isCall(n.value, "namespace"):
+				c := n.value.(*call)
+				if len(c.args) > 0 {
+					return errors.New("can't translate namespaces created from dicts")
+				}
+				for _, kw := range c.kwargs {
+					assign(name+"_"+kw.name, kw.value)
+				}
+			case slices.Contains(targets, name):
+				// an assignment to a loop variable
+			default:
+				assign(name, n.value)
+			}
+			tr.names[name] = true
+		case *macroNode:
+			return errors.New("can't translate macros")
+		}
+	}
+
+	return nil
+}
+
+// children returns the expressions an expression is made of
+func children(e expr) []expr {
+	switch e := e.(type) {
+	case *listExpr:
+		return e.items
+	case *dictExpr:
+		return append(slices.Clone(e.keys), e.values...)
+	case *getattr:
+		return []expr{e.value}
+	case *getitem:
+		return []expr{e.value, e.key}
+	case *slice:
+		return []expr{e.value, e.start, e.stop, e.step}
+	case *call:
+		exprs := append([]expr{e.fn}, e.args...)
+		for _, kw := range e.kwargs {
+			exprs = append(exprs, kw.value)
+		}
+		return exprs
+	case *filter:
+		exprs := append([]expr{e.value}, e.args...)
+		for _, kw := range e.kwargs {
+			exprs = append(exprs, kw.value)
+		}
+		return exprs
+	case *test:
+		return append([]expr{e.value}, e.args...)
+	case *unary:
+		return []expr{e.value}
+	case *binary:
+		return []expr{e.left, e.right}
+	case *comparison:
+		return append([]expr{e.left}, e.rights...)
+	case *conditional:
+		return []expr{e.cond, e.then, e.else_}
+	}
+
+	return nil
+}
+
+func isCall(e expr, fn string) bool {
+	c, ok := e.(*call)
+	if !ok {
+		return false
+	}
+
+	n, ok := c.fn.(*name)
+	return ok && n.name == fn
+}
+
+// raises reports whether nodes only raise an exception, in which case they
+// aren't reached when rendering a valid conversation
+func raises(nodes []node) bool {
+	for _, n := range nodes {
+		if o, ok := n.(*outputNode); !ok || !isCall(o.expr, "raise_exception") {
+			return false
+		}
+	}
+
+	return len(nodes) > 0
+}
+
+func (tr *translator) nodes(nodes []node) (string, error) {
+	var b strings.Builder
+	for _, n := range nodes {
+		s, err := tr.node(n)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+
+	return b.String(), nil
+}
+
+func (tr *translator) node(n node) (string, error) {
+	switch n := n.(type) {
+	case *textNode:
+		return text(n.text), nil
+	case *outputNode:
+		return tr.output(n.expr)
+	case *ifNode:
+		return tr.ifNode(n)
+	case *forNode:
+		return tr.forNode(n)
+	case *setNode:
+		return tr.setNode(n)
+	case *breakNode:
+		return "{{ break }}", nil
+	case *continueNode:
+		return "{{ continue }}", nil
+	}
+
+	return "", fmt.Errorf("can't translate %T", n)
+}
+
+// unparen removes the parentheses around an operand which is a function call
+func unparen(s string) string {
+	if !strings.HasPrefix(s, "(") {
+		return s
+	}
+
+	var depth int
+	var quoted bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 && i < len(s)-1 {
+				// the parentheses are followed by a field
+				return s
+			}
+		}
+	}
+
+	return s[1 : len(s)-1]
+}
+
+// text escapes s for a Go template
+func text(s string) string {
+	s = strings.ReplaceAll(s, "{{", `{{ "{{" }}`)
+	if strings.HasSuffix(s, "{") {
+		// a brace before an action would start a delimiter
+		s = s[:len(s)-1] + `{{ "{" }}`
+	}
+	return s
+}
+
+func (tr *translator) output(e expr) (string, error) {
+	switch e := e.(type) {
+	case *call:
+		if isCall(e, "raise_exception") {
+			return "", nil
+		}
+	case *binary:
+		if e.op == "+" || e.op == "~" {
+			// output the operands of a concatenation in turn
+			if e.op == "~" || tr.isString(e.left) && tr.isString(e.right) {
+				l, err := tr.output(e.left)
+				if err != nil {
+					return "", err
+				}
+
+				r, err := tr.output(e.right)
+				if err != nil {
+					return "", err
+				}
+				return l + r, nil
+			}
+		}
+	case *conditional:
+		return tr.conditional(e, tr.output)
+	}
+
+	v, err := tr.value(e)
+	if err != nil {
+		return "", err
+	}
+
+	if v.constant {
+		return text(str(v.value)), nil
+	}
+
+	switch v.kind {
+	case kindAny, kindString, kindInt:
+		return "{{ " + unparen(v.expr) + " }}", nil
+	}
+
+	return "", fmt.Errorf("can't translate output of %s", v.expr)
+}
+
+// conditional translates a conditional expression into an if statement,
+// with its branches translated by fn
+func (tr *translator) conditional(e *conditional, fn func(expr) (string, error)) (string, error) {
+	cond, err := tr.value(e.cond)
+	if err != nil {
+		return "", err
+	}
+
+	if cond.constant {
+		if truthy(cond.value) {
+			return fn(e.then)
+		} else if e.else_ == nil {
+			return "", nil
+		}
+		return fn(e.else_)
+	}
+
+	then, err := fn(e.then)
+	if err != nil {
+		return "", err
+	}
+
+	var else_ string
+	if e.else_ != nil {
+		if else_, err = fn(e.else_); err != nil {
+			return "", err
+		}
+	}
+
+	s := "{{ if " + unparen(cond.expr) + " }}" + then
+	if else_ != "" {
+		s += "{{ else }}" + else_
+	}
+	return s + "{{ end }}", nil
+}
+
+func (tr *translator) ifNode(n *ifNode) (string, error) {
+	// a branch which raises an exception is never taken
+	if raises(n.body) {
+		return tr.nodes(n.else_)
+	}
+
+	cond, err := tr.value(n.cond)
+	if err != nil {
+		return "", err
+	}
+
+	if cond.constant {
+		if truthy(cond.value) {
+			return tr.nodes(n.body)
+		}
+		return tr.nodes(n.else_)
+	}
+
+	kinds := tr.kinds
+	tr.kinds = clone(kinds)
+	body, err := tr.nodes(n.body)
+	if err != nil {
+		return "", err
+	}
+
+	bodyKinds := tr.kinds
+	tr.kinds = clone(kinds)
+
+	var else_ string
+	if !raises(n.else_) {
+		if else_, err = tr.nodes(n.else_); err != nil {
+			return "", err
+		}
+	}
+
+	// a variable's kind is only known after the if if both branches agree
+	for k, v := range bodyKinds {
+		if tr.kinds[k] != v {
+			tr.kinds[k] = kindAny
+		}
+	}
+
+	s := "{{ if " + unparen(cond.expr) + " }}" + body
+	if else_ != "" {
+		// an elif is an else if
+		if t, ok := strings.CutPrefix(else_, "{{ if "); ok && len(n.else_) == 1 && strings.HasSuffix(t, "{{ end }}") {
+			return s + "{{ else if " + t, nil
+		}
+		s += "{{ else }}" + else_
+	}
+
+	return s + "{{ end }}", nil
+}
+
+func clone(m map[string]kind) map[string]kind {
+	c := make(map[string]kind, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (tr *translator) forNode(n *forNode) (string, error) {
+	if len(n.targets) != 1 || n.cond != nil {
+		return "", errors.New("can't translate loops with several variables or conditions")
+	}
+
+	iter, err := tr.value(n.iter)
+	if err != nil {
+		return "", err
+	}
+
+	if iter.constant {
+		if _, ok := iter.value.(undefined); ok {
+			return tr.nodes(n.else_)
+		}
+	}
+
+	element, ok := elements[iter.kind]
+	if !ok {
+		return "", fmt.Errorf("can't translate a loop over %s", iter.expr)
+	}
+
+	index := tr.index()
+	target := n.targets[0]
+
+	kinds := tr.kinds
+	tr.kinds = clone(kinds)
+	tr.kinds[target] = element
+	tr.loops = append(tr.loops, loop{target: target, index: index, iter: iter})
+	body, err := tr.nodes(n.body)
+	tr.loops = tr.loops[:len(tr.loops)-1]
+	tr.kinds = kinds
+	if err != nil {
+		return "", err
+	}
+
+	s := fmt.Sprintf("{{ range $%s, $%s := %s }}%s", index, target, iter.expr, body)
+	if len(n.else_) > 0 {
+		else_, err := tr.nodes(n.else_)
+		if err != nil {
+			return "", err
+		}
+		s += "{{ else }}" + else_
+	}
+
+	return s + "{{ end }}", nil
+}
+
+// index returns the name of the index variable of a new loop
+func (tr *translator) index() string {
+	for i := 0; ; i++ {
+		name := string(rune('i' + i%4))
+		if i >= 4 {
+			name += strconv.Itoa(i / 4)
+		}
+
+		if !tr.names[name] && !slices.ContainsFunc(tr.loops, func(l loop) bool { return l.index == name }) {
+			return name
+		}
+	}
+}
+
+func (tr *translator) setNode(n *setNode) (string, error) {
+	name := n.names[0]
+	if n.attr != "" {
+		return tr.assign(name+"_"+n.attr, n.value)
+	}
+
+	if isCall(n.value, "namespace") {
+		var b strings.Builder
+		for _, kw := range n.value.(*call).kwargs {
+			s, err := tr.assign(name+"_"+kw.name, kw.value)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	}
+
+	return tr.assign(name, n.value)
+}
+
+func (tr *translator) assign(name string, e expr) (string, error) {
+	if v, ok := tr.vars[name]; ok && v.value != nil && !tr.isTarget(name) {
+		// constants are replaced by their values
+		return "", nil
+	}
+
+	if c, ok := e.(*conditional); ok {
+		return tr.conditional(c, func(e expr) (string, error) {
+			return tr.assign(name, e)
+		})
+	}
+
+	v, err := tr.value(e)
+	if err != nil {
+		return "", err
+	}
+
+	arg, err := v.arg()
+	if err != nil {
+		return "", err
+	}
+
+	tr.kinds[name] = v.kind
+	if v.constant {
+		tr.kinds[name] = kindOf(v.value)
+	}
+
+	return "{{ $" + name + " = " + unparen(arg) + " }}", nil
+}
+
+func (tr *translator) isTarget(name string) bool {
+	return slices.ContainsFunc(tr.loops, func(l loop) bool { return l.target == name })
+}
+
+func kindOf(v any) kind {
+	switch v.(type) {
+	case string:
+		return kindString
+	case int:
+		return kindInt
+	case bool:
+		return kindBool
+	}
+
+	return kindAny
+}
+
+// not negates an operand, removing double negations
+func not(o operand) operand {
+	if s, ok := strings.CutPrefix(o.expr, "(not "); ok && unparen(o.expr) != o.expr {
+		return operand{expr: strings.TrimSuffix(s, ")"), kind: kindBool}
+	}
+
+	return operand{expr: "(not " + o.expr + ")", kind: kindBool}
+}
+
+// isString reports whether an expression is a string, for concatenations
+// which are output in turn
+func (tr *translator) isString(e expr) bool {
+	switch e := e.(type) {
+	case *conditional:
+		return tr.isString(e.then) && (e.else_ == nil || tr.isString(e.else_))
+	case *binary:
+		if e.op == "~" {
+			return true
+		} else if e.op == "+" {
+			return tr.isString(e.left) && tr.isString(e.right)
+		}
+	}
+
+	v, err := tr.value(e)
+	return err == nil && isString(v)
+}
+
+func isString(o operand) bool {
+	if o.constant {
+		_, ok := o.value.(string)
+		return ok
+	}
+
+	return o.kind == kindString
+}
+
+// value translates an expression into an operand
+func (tr *translator) value(e expr) (operand, error) {
+	switch e := e.(type) {
+	case *literal:
+		return constant(e.value), nil
+	case *listExpr:
+		items := make([]any, len(e.items))
+		for i, item := range e.items {
+			v, err := tr.value(item)
+			if err != nil {
+				return operand{}, err
+			}
+
+			if !v.constant {
+				return operand{}, errors.New("can't translate lists of variables")
+			}
+			items[i] = v.value
+		}
+		return constant(items), nil
+	case *name:
+		return tr.name(e.name)
+	case *getattr:
+		return tr.attribute(e.value, e.name)
+	case *getitem:
+		if key, ok := e.key.(*literal); ok {
+			if s, ok := key.value.(string); ok {
+				return tr.attribute(e.value, s)
+			}
+		}
+
+		v, err := tr.value(e.value)
+		if err != nil {
+			return operand{}, err
+		}
+
+		i, err := tr.value(e.key)
+		if err != nil {
+			return operand{}, err
+		}
+
+		element, ok := elements[v.kind]
+		if !ok || i.kind != kindInt && !(i.constant && kindOf(i.value) == kindInt && i.value.(int) >= 0) {
+			return operand{}, errors.New("can't translate subscripts other than indices of lists")
+		}
+
+		arg, _ := i.arg()
+		return operand{expr: "(index " + v.expr + " " + arg + ")", kind: element}, nil
+	case *slice:
+		v, err := tr.value(e.value)
+		if err != nil {
+			return operand{}, err
+		}
+
+		if _, ok := elements[v.kind]; !ok || e.step != nil {
+			return operand{}, errors.New("can't translate slices other than slices of lists")
+		}
+
+		s := "(slice " + v.expr
+		for _, bound := range []expr{e.start, e.stop} {
+			if bound == nil {
+				if e.stop != nil {
+					s += " 0"
+				}
+				continue
+			}
+
+			b, err := tr.value(bound)
+			if err != nil {
+				return operand{}, err
+			}
+
+			if i, ok := b.value.(int); !b.constant || !ok || i < 0 {
+				return operand{}, errors.New("can't translate slices with variable or negative bounds")
+			}
+
+			arg, _ := b.arg()
+			s += " " + arg
+		}
+		return operand{expr: s + ")", kind: v.kind}, nil
+	case *call:
+		if g, ok := e.fn.(*getattr); ok && len(e.args) == 0 && len(e.kwargs) == 0 && g.name == "strip" {
+			return tr.filter(g.value, "trim")
+		}
+		return operand{}, errors.New("can't translate function calls")
+	case *filter:
+		if len(e.args) > 0 || len(e.kwargs) > 0 {
+			return operand{}, fmt.Errorf("can't translate the arguments of filter %s", e.name)
+		}
+		return tr.filter(e.value, e.name)
+	case *test:
+		return tr.test(e)
+	case *unary:
+		v, err := tr.value(e.value)
+		if err != nil {
+			return operand{}, err
+		}
+
+		if e.op != "not" {
+			if i, ok := v.value.(int); ok && v.constant && e.op == "-" {
+				return constant(-i), nil
+			}
+			return operand{}, errors.New("can't translate arithmetic")
+		}
+
+		if v.constant {
+			return constant(!truthy(v.value)), nil
+		}
+		return not(v), nil
+	case *binary:
+		return tr.binary(e)
+	case *comparison:
+		return tr.comparison(e)
+	}
+
+	return operand{}, fmt.Errorf("can't translate %T", e)
+}
+
+func (tr *translator) name(name string) (operand, error) {
+	if tr.isTarget(name) {
+		return operand{expr: "$" + name, kind: tr.kinds[name]}, nil
+	}
+
+	if v, ok := tr.vars[name]; ok {
+		if v.value != nil {
+			return *v.value, nil
+		}
+
+		// variables are declared as empty strings unless they're assigned
+		// a builtin
+		o := operand{expr: "$" + name, kind: kindString, optional: true}
+		if b, ok := tr.builtin(name); ok {
+			o.optional, o.nullable = false, b.nullable
+		}
+
+		if kind, ok := tr.kinds[name]; ok {
+			o.kind = kind
+		}
+		return o, nil
+	}
+
+	if b, ok := tr.builtin(name); ok {
+		return b, nil
+	}
+
+	return constant(undefined{name}), nil
+}
+
+// builtin returns the variables chat templates are rendered with
+func (tr *translator) builtin(name string) (operand, bool) {
+	switch name {
+	case "messages":
+		return operand{expr: "$.Messages", kind: kindMessages}, true
+	case "tools":
+		return operand{expr: "$.Tools", kind: kindTools, nullable: true}, true
+	case "add_generation_prompt":
+		return operand{expr: "$add_generation_prompt", kind: kindBool}, true
+	case "bos_token", "eos_token":
+		// the runner adds the BOS token
+		return constant(""), true
+	}
+
+	return operand{}, false
+}
+
+func (tr *translator) attribute(e expr, attr string) (operand, error) {
+	if n, ok := e.(*name); ok {
+		if n.name == "loop" && len(tr.loops) > 0 {
+			l := tr.loops[len(tr.loops)-1]
+			switch attr {
+			case "index0":
+				return operand{expr: "$" + l.index, kind: kindInt}, nil
+			case "first":
+				return operand{expr: "(eq $" + l.index + " 0)", kind: kindBool}, nil
+			case "last":
+				return operand{expr: "(eq (len (slice " + l.iter.expr + " $" + l.index + ")) 1)", kind: kindBool}, nil
+			case "length":
+				return operand{expr: "(len " + l.iter.expr + ")", kind: kindInt}, nil
+			}
+			return operand{}, fmt.Errorf("can't translate loop.%s", attr)
+		}
+
+		if _, ok := tr.vars[n.name+"_"+attr]; ok {
+			return tr.name(n.name + "_" + attr)
+		}
+	}
+
+	v, err := tr.value(e)
+	if err != nil {
+		return operand{}, err
+	}
+
+	if v.constant {
+		if _, ok := v.value.(undefined); ok {
+			return v, nil
+		}
+	}
+
+	fields, ok := fields[v.kind]
+	if !ok {
+		return operand{}, fmt.Errorf("can't translate attribute %s of %s", attr, v.expr)
+	}
+
+	f, ok := fields[attr]
+	if !ok {
+		// the values a template is rendered with have no other attributes
+		if v.kind == kindToolCall && attr == "type" {
+			return constant("function"), nil
+		}
+		return constant(undefined{attr}), nil
+	}
+
+	return operand{expr: v.expr + "." + f.name, kind: f.kind, optional: f.kind == kindToolCalls}, nil
+}
+
+func (tr *translator) filter(e expr, name string) (operand, error) {
+	v, err := tr.value(e)
+	if err != nil {
+		return operand{}, err
+	}
+
+	switch name {
+	case "trim":
+		if v.constant {
+			return constant(strings.TrimSpace(str(v.value))), nil
+		}
+
+		if v.kind != kindString {
+			return operand{}, fmt.Errorf("can't translate trim of %s", v.expr)
+		}
+		return operand{expr: "(trim " + v.expr + ")", kind: kindString}, nil
+	case "tojson":
+		arg, err := v.arg()
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{expr: "(tojson " + arg + ")", kind: kindString}, nil
+	case "length", "count":
+		if _, ok := elements[v.kind]; !ok || v.constant {
+			return operand{}, fmt.Errorf("can't translate length of %s", v.expr)
+		}
+		return operand{expr: "(len " + v.expr + ")", kind: kindInt}, nil
+	}
+
+	return operand{}, fmt.Errorf("can't translate filter %s", name)
+}
+
+func (tr *translator) test(e *test) (operand, error) {
+	v, err := tr.value(e.value)
+	if err != nil {
+		return operand{}, err
+	}
+
+	result := func(o operand) (operand, error) {
+		if !e.negate {
+			return o, nil
+		} else if o.constant {
+			return constant(!o.value.(bool)), nil
+		}
+		return not(o), nil
+	}
+
+	switch e.name {
+	case "defined", "undefined", "none":
+		if v.constant {
+			_, isUndefined := v.value.(undefined)
+			switch e.name {
+			case "defined":
+				return result(constant(!isUndefined))
+			case "undefined":
+				return result(constant(isUndefined))
+			}
+			return result(constant(v.value == nil))
+		}
+
+		// optional values are undefined when they're empty and nullable
+		// values are none
+		empty := v.optional
+		if e.name == "none" {
+			empty = v.nullable
+		}
+
+		switch {
+		case !empty:
+			return result(constant(e.name == "defined"))
+		case e.name == "defined":
+			return result(operand{expr: v.expr, kind: kindBool})
+		}
+		return result(not(v))
+	case "string":
+		if v.constant {
+			return result(constant(kindOf(v.value) == kindString))
+		}
+		return result(constant(v.kind == kindString))
+	}
+
+	return operand{}, fmt.Errorf("can't translate test %s", e.name)
+}
+
+func (tr *translator) binary(e *binary) (operand, error) {
+	left, err := tr.value(e.left)
+	if err != nil {
+		return operand{}, err
+	}
+
+	right, err := tr.value(e.right)
+	if err != nil {
+		return operand{}, err
+	}
+
+	switch e.op {
+	case "and", "or":
+		if left.constant {
+			if truthy(left.value) == (e.op == "and") {
+				return right, nil
+			}
+			return left, nil
+		}
+
+		r, err := right.arg()
+		if err != nil {
+			return operand{}, err
+		}
+
+		kind := left.kind
+		if right.constant || right.kind != kind {
+			kind = kindAny
+		}
+		return operand{expr: "(" + e.op + " " + left.expr + " " + r + ")", kind: kind}, nil
+	case "+", "~":
+		if left.constant && right.constant {
+			v, err := arithmetic(e.op, left.value, right.value)
+			if err != nil {
+				return operand{}, err
+			}
+			return constant(v), nil
+		}
+
+		if e.op == "+" && (!isString(left) || !isString(right)) {
+			return operand{}, errors.New("can't translate arithmetic")
+		}
+
+		l, err := left.arg()
+		if err != nil {
+			return operand{}, err
+		}
+
+		r, err := right.arg()
+		if err != nil {
+			return operand{}, err
+		}
+
+		// print concatenates strings, and a concatenation of concatenations
+		// is a single print
+		if s, ok := strings.CutPrefix(l, "(print "); ok && !left.constant {
+			l = strings.TrimSuffix(s, ")")
+		}
+		return operand{expr: "(print " + l + " " + r + ")", kind: kindString}, nil
+	}
+
+	return operand{}, errors.New("can't translate arithmetic")
+}
+
+func (tr *translator) comparison(e *comparison) (operand, error) {
+	if len(e.ops) != 1 {
+		return operand{}, errors.New("can't translate chained comparisons")
+	}
+
+	left, err := tr.value(e.left)
+	if err != nil {
+		return operand{}, err
+	}
+
+	right, err := tr.value(e.rights[0])
+	if err != nil {
+		return operand{}, err
+	}
+
+	op := e.ops[0]
+	if left.constant && right.constant {
+		ok, err := compareOp(op, left.value, right.value)
+		if err != nil {
+			return operand{}, err
+		}
+		return constant(ok), nil
+	}
+
+	l, err := left.arg()
+	if err != nil {
+		return operand{}, err
+	}
+
+	switch op {
+	case "==", "!=":
+		if right.constant {
+			if _, ok := right.value.(undefined); ok {
+				return constant(op == "!="), nil
+			}
+		}
+
+		r, err := right.arg()
+		if err != nil {
+			return operand{}, err
+		}
+
+		fn := map[string]string{"==": "eq", "!=": "ne"}[op]
+		return operand{expr: "(" + fn + " " + l + " " + r + ")", kind: kindBool}, nil
+	case "in", "not in":
+		items, ok := right.value.([]any)
+		if !right.constant || !ok || len(items) == 0 {
+			return operand{}, errors.New("can't translate in other than of a list")
+		}
+
+		// x in [a, b] is eq x a b
+		s := "(eq " + l
+		for _, item := range items {
+			arg, err := constant(item).literal()
+			if err != nil {
+				return operand{}, err
+			}
+			s += " " + arg
+		}
+		s += ")"
+
+		if op == "not in" {
+			s = "(not " + s + ")"
+		}
+		return operand{expr: s, kind: kindBool}, nil
+	case "<", "<=", ">", ">=":
+		if left.kind != kindInt && !right.constant || right.kind != kindInt && !right.constant {
+			return operand{}, errors.New("can't translate comparisons other than of integers")
+		}
+
+		r, err := right.arg()
+		if err != nil {
+			return operand{}, err
+		}
+
+		fn := map[string]string{"<": "lt", "<=": "le", ">": "gt", ">=": "ge"}[op]
+		return operand{expr: "(" + fn + " " + l + " " + r + ")", kind: kindBool}, nil
+	}
+
+	return operand{}, fmt.Errorf("can't translate %s", op)
+}
+
+// ToJSON encodes v like the tojson filter, for the tojson function of Go
+// templates translated by [Template.GoTemplate]
+func ToJSON(v any) (string, error) {
+	value, err := valueOf(v)
+	if err != nil {
+		return "", err
+	}
+
+	return toJSON(value, jsonOptions{indent: -1})
+}
@@ -0,0 +1,76 @@
+package jinja
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGoTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"text", "Hello, {{ '{{' }}world}}! {", `Hello, {{ "{{" }}world}}! {{ "{" }}`},
+		{"messages", "{% for message in messages %}<|{{ message['role'] }}|>{{ message.content | trim }}{% endfor %}", "{{ range $i, $message := $.Messages }}<|{{ $message.Role }}|>{{ trim $message.Content }}{{ end }}"},
+		{"concatenation", "{% for message in messages %}{{ '[' + message.role + ']\n' + message.content }}{% endfor %}", "{{ range $i, $message := $.Messages }}[{{ $message.Role }}]\n{{ $message.Content }}{{ end }}"},
+		{"generation prompt", "{% if add_generation_prompt %}<|assistant|>{% endif %}", `{{ $add_generation_prompt := true }}{{ range .Messages }}{{ $add_generation_prompt = ne .Role "assistant" }}{{ end }}{{ if $add_generation_prompt }}<|assistant|>{{ end }}`},
+		{"elif", "{% for m in messages %}{% if m.role == 'user' %}U{% elif m.role in ['system', 'tool'] %}S{% else %}A{% endif %}{% endfor %}", `{{ range $i, $m := $.Messages }}{{ if eq $m.Role "user" }}U{{ else if eq $m.Role "system" "tool" }}S{{ else }}A{{ end }}{{ end }}`},
+		{"loop", "{% for m in messages %}{% if loop.first %}^{% endif %}{% if not loop.last %},{% endif %}{% endfor %}", `{{ range $i, $m := $.Messages }}{{ if eq $i 0 }}^{{ end }}{{ if not (eq (len (slice $.Messages $i)) 1) }},{{ end }}{{ end }}`},
+		{"system message", "{% if messages[0]['role'] == 'system' %}{% set system = messages[0]['content'] %}{% set messages = messages[1:] %}{% endif %}{{ system }}{% for m in messages %}{{ m.content }}{% endfor %}", `{{ $system := "" }}{{ $messages := $.Messages }}{{ if eq (index $messages 0).Role "system" }}{{ $system = (index $messages 0).Content }}{{ $messages = slice $messages 1 }}{{ end }}{{ $system }}{{ range $i, $m := $messages }}{{ $m.Content }}{{ end }}`},
+		{"constants", "{% set eos_token = '</s>' %}{% if not add_generation_prompt is defined %}{% set add_generation_prompt = false %}{% endif %}{{ bos_token }}{% if custom is defined %}custom{% endif %}{{ eos_token }}", "</s>"},
+		{"namespace", "{% set ns = namespace(found=false) %}{% for m in messages %}{% if m.role == 'system' %}{% set ns.found = true %}{% endif %}{% endfor %}{% if not ns.found %}default{% endif %}", `{{ $ns_found := "" }}{{ $ns_found = false }}{{ range $i, $m := $.Messages }}{{ if eq $m.Role "system" }}{{ $ns_found = true }}{{ end }}{{ end }}{{ if not $ns_found }}default{{ end }}`},
+		{"conditional", "{% for m in messages %}{{ m.content if m.role == 'user' else '' }}{% endfor %}", `{{ range $i, $m := $.Messages }}{{ if eq $m.Role "user" }}{{ $m.Content }}{{ end }}{{ end }}`},
+		{"raise", "{% for m in messages %}{% if m.role == 'tool' %}{{ raise_exception('no tools') }}{% elif m.role == 'user' %}{{ m.content }}{% endif %}{% endfor %}", `{{ range $i, $m := $.Messages }}{{ if eq $m.Role "user" }}{{ $m.Content }}{{ end }}{{ end }}`},
+		{"tools", "{% if tools is not none %}{% for tool in tools %}{{ tool | tojson }}{% endfor %}{% endif %}", "{{ if $.Tools }}{{ range $i, $tool := $.Tools }}{{ tojson $tool }}{{ end }}{{ end }}"},
+		{"tool calls", "{% for m in messages %}{% if m.tool_calls is defined %}{% for tool_call in m.tool_calls %}{% set tool_call = tool_call.function %}{{ tool_call.name }}{{ tool_call.arguments | tojson }}{% endfor %}{% endif %}{% endfor %}", "{{ range $i, $m := $.Messages }}{{ if $m.ToolCalls }}{{ range $j, $tool_call := $m.ToolCalls }}{{ $tool_call = $tool_call.Function }}{{ $tool_call.Name }}{{ tojson $tool_call.Arguments }}{{ end }}{{ end }}{{ end }}"},
+		{"break", "{% for m in messages %}{% if m.role == 'assistant' %}{% break %}{% endif %}{{ m.content }}{% endfor %}", `{{ range $i, $m := $.Messages }}{{ if eq $m.Role "assistant" }}{{ break }}{{ end }}{{ $m.Content }}{{ end }}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tmpl.GoTemplate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoTemplateError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"arithmetic", "{% for m in messages %}{{ loop.index0 + 1 }}{% endfor %}", "arithmetic"},
+		{"macro", "{% macro f() %}{% endmacro %}", "macros"},
+		{"filter", "{% for m in messages %}{{ m.role | upper }}{% endfor %}", "filter upper"},
+		{"negative index", "{{ messages[-1].content }}", "subscripts"},
+		{"method", "{% for m in messages %}{{ m.role.title() }}{% endfor %}", "function calls"},
+		{"loop", "{% for m in messages %}{{ loop.index }}{% endfor %}", "loop.index"},
+		{"arguments", "{% for m in messages %}{% for k in m.tool_calls[0].function.arguments %}{% endfor %}{% endfor %}", "loop over"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := tmpl.GoTemplate(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package jinja
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Values in a template are nil (None), bool, int, float64, string, []any,
+// *Dict, *namespace, undefined or function. Go values are converted to these
+// by [valueOf].
+
+// Dict is a dictionary which remembers the order its keys were inserted in,
+// like a Python dict
+type Dict struct {
+	keys   []string
+	values map[string]any
+}
+
+func NewDict() *Dict {
+	return &Dict{values: make(map[string]any)}
+}
+
+func (d *Dict) Get(key string) (any, bool) {
+	v, ok := d.values[key]
+	return v, ok
+}
+
+func (d *Dict) Set(key string, value any) {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+
+	d.values[key] = value
+}
+
+func (d *Dict) Keys() []string {
+	return d.keys
+}
+
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+// namespace is a mutable object created by namespace() which can be assigned
+// to from inner scopes such as loops
+type namespace struct {
+	*Dict
+}
+
+// undefined is the value of a variable or attribute which doesn't exist
+type undefined struct {
+	name string
+}
+
+// function is a callable such as a global, a macro or a bound method
+type function func(s *state, args []any, kwargs *Dict) (any, error)
+
+// valueOf converts a Go value into a template value. Structs and other
+// types are converted through their JSON encoding, which keeps their field
+// order.
+func valueOf(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, int, float64, string, *Dict, *namespace, undefined, function:
+		return v, nil
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), nil
+	case float32:
+		return float64(v), nil
+	case []any:
+		s := make([]any, len(v))
+		for i := range v {
+			var err error
+			if s[i], err = valueOf(v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case []string:
+		s := make([]any, len(v))
+		for i := range v {
+			s[i] = v[i]
+		}
+		return s, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		d := NewDict()
+		for _, k := range keys {
+			value, err := valueOf(v[k])
+			if err != nil {
+				return nil, err
+			}
+			d.Set(k, value)
+		}
+		return d, nil
+	}
+
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromJSON(bts)
+}
+
+// fromJSON decodes a JSON value, keeping the order of object keys
+func fromJSON(bts []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(bts))
+	d.UseNumber()
+
+	var decode func() (any, error)
+	decode = func() (any, error) {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case json.Delim:
+			switch t {
+			case '[':
+				s := []any{}
+				for d.More() {
+					v, err := decode()
+					if err != nil {
+						return nil, err
+					}
+					s = append(s, v)
+				}
+				_, err := d.Token()
+				return s, err
+			case '{':
+				m := NewDict()
+				for d.More() {
+					k, err := d.Token()
+					if err != nil {
+						return nil, err
+					}
+
+					v, err := decode()
+					if err != nil {
+						return nil, err
+					}
+
+					m.Set(k.(string), v)
+				}
+				_, err := d.Token()
+				return m, err
+			}
+		case json.Number:
+			if i, err := t.Int64(); err == nil {
+				return int(i), nil
+			}
+			return t.Float64()
+		case nil, bool, string:
+			return t, nil
+		}
+
+		return nil, fmt.Errorf("unexpected JSON token %v", t)
+	}
+
+	return decode()
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil, undefined:
+		return false
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case *Dict:
+		return v.Len() > 0
+	}
+
+	return true
+}
+
+// pyFloat formats f like Python's repr
+func pyFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(s, "e")
+	e, _ := strconv.Atoi(exp)
+	if e < -4 || e >= 16 {
+		sign := "+"
+		if e < 0 {
+			sign, e = "-", -e
+		}
+		return fmt.Sprintf("%se%s%02d", mantissa, sign, e)
+	}
+
+	s = strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// str converts v to a string like Python's str
+func str(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case undefined:
+		return ""
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return pyFloat(v)
+	case string:
+		return v
+	case []any:
+		var b strings.Builder
+		b.WriteString("[")
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(repr(e))
+		}
+		b.WriteString("]")
+		return b.String()
+	case *Dict:
+		var b strings.Builder
+		b.WriteString("{")
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(repr(k))
+			b.WriteString(": ")
+			b.WriteString(repr(v.values[k]))
+		}
+		b.WriteString("}")
+		return b.String()
+	case *namespace:
+		return "<Namespace " + str(v.Dict) + ">"
+	case function:
+		return "<function>"
+	}
+
+	return fmt.Sprint(v)
+}
+
+// repr converts v to a string like Python's repr
+func repr(v any) string {
+	if s, ok := v.(string); ok {
+		quote := "'"
+		if strings.Contains(s, "'") && !strings.Contains(s, `"`) {
+			quote = `"`
+		}
+
+		var b strings.Builder
+		b.WriteString(quote)
+		for _, r := range s {
+			switch {
+			case r == '\\':
+				b.WriteString(`\\`)
+			case string(r) == quote:
+				b.WriteString(`\` + quote)
+			case r == '\n':
+				b.WriteString(`\n`)
+			case r == '\r':
+				b.WriteString(`\r`)
+			case r == '\t':
+				b.WriteString(`\t`)
+			case r < 0x20 || r == 0x7f:
+				fmt.Fprintf(&b, `\x%02x`, r)
+			default:
+				b.WriteRune(r)
+			}
+		}
+		b.WriteString(quote)
+		return b.String()
+	}
+
+	return str(v)
+}
+
+// jsonOptions are the options of Python's json.dumps. Indent is ignored if
+// negative and empty separators are the defaults for the indent.
+type jsonOptions struct {
+	indent          int
+	sortKeys        bool
+	itemSep, keySep string
+}
+
+// toJSON encodes v like Python's json.dumps with ensure_ascii=False
+func toJSON(v any, opts jsonOptions) (string, error) {
+	indent, sortKeys := opts.indent, opts.sortKeys
+	itemSep, keySep := opts.itemSep, opts.keySep
+	if itemSep == "" {
+		itemSep = ", "
+		if indent >= 0 {
+			itemSep = ","
+		}
+	}
+	if keySep == "" {
+		keySep = ": "
+	}
+
+	var b strings.Builder
+	var encode func(v any, level int) error
+	newline := func(level int) {
+		if indent >= 0 {
+			b.WriteString("\n")
+			b.WriteString(strings.Repeat(" ", indent*level))
+		}
+	}
+
+	encode = func(v any, level int) error {
+		switch v := v.(type) {
+		case nil:
+			b.WriteString("null")
+		case bool:
+			b.WriteString(strconv.FormatBool(v))
+		case int:
+			b.WriteString(strconv.Itoa(v))
+		case float64:
+			switch {
+			case math.IsInf(v, 1):
+				b.WriteString("Infinity")
+			case math.IsInf(v, -1):
+				b.WriteString("-Infinity")
+			case math.IsNaN(v):
+				b.WriteString("NaN")
+			default:
+				b.WriteString(pyFloat(v))
+			}
+		case string:
+			b.WriteString(jsonString(v))
+		case []any:
+			if len(v) == 0 {
+				b.WriteString("[]")
+				return nil
+			}
+
+			b.WriteString("[")
+			for i, e := range v {
+				if i > 0 {
+					b.WriteString(itemSep)
+				}
+				newline(level + 1)
+				if err := encode(e, level+1); err != nil {
+					return err
+				}
+			}
+			newline(level)
+			b.WriteString("]")
+		case *Dict:
+			if v.Len() == 0 {
+				b.WriteString("{}")
+				return nil
+			}
+
+			keys := v.keys
+			if sortKeys {
+				keys = slices.Sorted(slices.Values(keys))
+			}
+
+			b.WriteString("{")
+			for i, k := range keys {
+				if i > 0 {
+					b.WriteString(itemSep)
+				}
+				newline(level + 1)
+				b.WriteString(jsonString(k))
+				b.WriteString(keySep)
+				if err := encode(v.values[k], level+1); err != nil {
+					return err
+				}
+			}
+			newline(level)
+			b.WriteString("}")
+		case *namespace:
+			return encode(v.Dict, level)
+		default:
+			return fmt.Errorf("object of type %s is not JSON serializable", typeName(v))
+		}
+
+		return nil
+	}
+
+	if err := encode(v, 0); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func jsonString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r < 0x20:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case undefined:
+		return "Undefined"
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "str"
+	case []any:
+		return "list"
+	case *Dict:
+		return "dict"
+	case *namespace:
+		return "Namespace"
+	case function:
+		return "function"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+func equal(a, b any) bool {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+		return false
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case undefined:
+		_, ok := b.(undefined)
+		return ok
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case *Dict:
+		b, ok := b.(*Dict)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+
+		for _, k := range a.keys {
+			if v, ok := b.values[k]; !ok || !equal(a.values[k], v) {
+				return false
+			}
+		}
+		return true
+	case *namespace:
+		return a == b
+	}
+
+	return false
+}
+
+// compare orders numbers, strings and lists of them like Python
+func compare(a, b any) (int, error) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			for i := range min(len(a), len(b)) {
+				if c, err := compare(a[i], b[i]); err != nil || c != 0 {
+					return c, err
+				}
+			}
+			return len(a) - len(b), nil
+		}
+	}
+
+	return 0, fmt.Errorf("'<' not supported between instances of '%s' and '%s'", typeName(a), typeName(b))
+}
+
+// iterate returns the items of a list, the keys of a dict or the characters
+// of a string
+func iterate(v any) ([]any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, fmt.Errorf("'NoneType' object is not iterable")
+	case undefined:
+		return nil, nil
+	case []any:
+		return v, nil
+	case *Dict:
+		s := make([]any, len(v.keys))
+		for i, k := range v.keys {
+			s[i] = k
+		}
+		return s, nil
+	case string:
+		var s []any
+		for _, r := range v {
+			s = append(s, string(r))
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("'%s' object is not iterable", typeName(v))
+}
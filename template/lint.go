@@ -0,0 +1,131 @@
+package template
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/ollama/ollama/api"
+)
+
+// known is the set of fields and methods a template can reference, lower cased
+var known = func() map[string]struct{} {
+	names := map[string]struct{}{
+		"system":   {},
+		"prompt":   {},
+		"response": {},
+		"suffix":   {},
+		"messages": {},
+		"tools":    {},
+	}
+
+	seen := make(map[reflect.Type]bool)
+	var walk func(reflect.Type)
+	walk = func(t reflect.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+
+		for _, t := range []reflect.Type{t, reflect.PointerTo(t)} {
+			for i := range t.NumMethod() {
+				names[strings.ToLower(t.Method(i).Name)] = struct{}{}
+			}
+		}
+
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			walk(t.Elem())
+		case reflect.Struct:
+			for i := range t.NumField() {
+				if f := t.Field(i); f.IsExported() {
+					names[strings.ToLower(f.Name)] = struct{}{}
+					walk(f.Type)
+				}
+			}
+		}
+	}
+
+	for _, v := range []any{api.Message{}, api.Tools{}, api.ToolCall{}} {
+		walk(reflect.TypeOf(v))
+	}
+
+	return names
+}()
+
+// Lint returns problems with the template which don't prevent it from
+// executing but are likely to make a model misbehave, such as references to
+// unknown variables or values which are never rendered. Jinja templates
+// aren't linted.
+func (t *Template) Lint() []string {
+	if t.jinja != nil {
+		return nil
+	}
+
+	// parse the template again since Parse may have appended a response node
+	tmpl, err := template.New("").Funcs(funcs).Parse(t.raw)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var identifiers []string
+	for _, tt := range tmpl.Templates() {
+		if tt.Tree != nil {
+			identifiers = append(identifiers, Identifiers(tt.Root)...)
+		}
+	}
+
+	var issues []string
+	vars := make(map[string]bool)
+	for _, ident := range identifiers {
+		// variables such as $ or $i are declared by the template itself
+		if strings.HasPrefix(ident, "$") {
+			continue
+		}
+
+		name := strings.ToLower(ident)
+		if _, ok := known[name]; !ok && !vars[name] {
+			issues = append(issues, fmt.Sprintf("unknown variable .%s", ident))
+		}
+
+		vars[name] = true
+	}
+
+	if vars["messages"] {
+		if t.Subtree(func(n parse.Node) bool {
+			if r, ok := n.(*parse.RangeNode); ok {
+				return slices.Contains(Identifiers(r.Pipe), "Messages")
+			}
+
+			return false
+		}) == nil {
+			issues = append(issues, "template references .Messages but never ranges over them")
+		}
+
+		for _, name := range []string{"Role", "Content"} {
+			if !vars[strings.ToLower(name)] {
+				issues = append(issues, fmt.Sprintf("message .%s is never rendered", name))
+			}
+		}
+	} else {
+		if !vars["response"] {
+			issues = append(issues, "template has no .Response or .Messages so .Response will be appended to it")
+		}
+
+		if !vars["prompt"] {
+			issues = append(issues, ".Prompt is never rendered")
+		}
+	}
+
+	switch {
+	case vars["tools"] && !vars["toolcalls"]:
+		issues = append(issues, "template renders .Tools but never renders .ToolCalls so tool calls can't be parsed")
+	case vars["toolcalls"] && !vars["tools"]:
+		issues = append(issues, "template renders .ToolCalls but never renders .Tools so the model won't see tool definitions")
+	}
+
+	return issues
+}
@@ -0,0 +1,100 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		issues   []string
+	}{
+		{
+			name:     "legacy",
+			template: "{{ if .System }}{{ .System }} {{ end }}{{ .Prompt }} {{ .Response }}",
+		},
+		{
+			name:     "legacy without response",
+			template: "{{ .System }} {{ .Prompt }}",
+			issues:   []string{"template has no .Response or .Messages so .Response will be appended to it"},
+		},
+		{
+			name:     "messages",
+			template: "{{ range .Messages }}{{ .Role }}: {{ .Content }}\n{{ end }}",
+		},
+		{
+			name:     "messages not ranged over",
+			template: "{{ with index .Messages 0 }}{{ .Role }}: {{ .Content }}{{ end }}",
+			issues:   []string{"template references .Messages but never ranges over them"},
+		},
+		{
+			name:     "content never rendered",
+			template: "{{ range .Messages }}{{ .Role }}{{ end }}",
+			issues:   []string{"message .Content is never rendered"},
+		},
+		{
+			name:     "unknown variables",
+			template: "{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ .Text }}{{ end }}{{ .Text }}{{ .Tols }}",
+			issues:   []string{"unknown variable .Text", "unknown variable .Tols"},
+		},
+		{
+			name:     "variables",
+			template: "{{ range $i, $m := .Messages }}{{ if eq $i 0 }}{{ $.System }}{{ end }}{{ $m.Role }}: {{ $m.Content }}{{ end }}",
+		},
+		{
+			name:     "tools",
+			template: `{{ if .Tools }}{{ json .Tools }}{{ end }}{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ json .Function.Arguments }}}{{ end }}{{ end }}`,
+		},
+		{
+			name:     "tools without tool calls",
+			template: "{{ .Tools }}{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ end }}",
+			issues:   []string{"template renders .Tools but never renders .ToolCalls so tool calls can't be parsed"},
+		},
+		{
+			name:     "tool calls without tools",
+			template: `{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ range .ToolCalls }}{{ .Function.Name }}{{ end }}{{ end }}`,
+			issues:   []string{"template renders .ToolCalls but never renders .Tools so the model won't see tool definitions"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tmpl.Lint(), tt.issues); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("builtin", func(t *testing.T) {
+		matches, err := filepath.Glob("*.gotmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, match := range matches {
+			bts, err := os.ReadFile(match)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl, err := Parse(string(bts))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if issues := tmpl.Lint(); len(issues) > 0 {
+				t.Errorf("%s: unexpected issues %v", match, issues)
+			}
+		}
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"golang.org/x/exp/maps"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template/jinja"
 )
 
 //go:embed index.json
@@ -92,9 +93,16 @@ func Named(s string) (*named, error) {
 
 var DefaultTemplate, _ = Parse("{{ .Prompt }}")
 
+// JinjaPrefix marks a template as a Jinja chat template, such as a model's
+// tokenizer.chat_template, rather than a Go template
+const JinjaPrefix = "jinja:"
+
 type Template struct {
 	*template.Template
 	raw string
+
+	// jinja is the parsed template if it's a Jinja template
+	jinja *jinja.Template
 }
 
 // response is a template node that can be added to templates that don't already have one
@@ -121,9 +129,20 @@ var funcs = template.FuncMap{
 		b, _ := json.Marshal(v)
 		return string(b)
 	},
+	"tojson": jinja.ToJSON,
+	"trim":   strings.TrimSpace,
 }
 
 func Parse(s string) (*Template, error) {
+	if src, ok := strings.CutPrefix(s, JinjaPrefix); ok {
+		j, err := jinja.Parse(src)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Template{Template: template.New(""), raw: s, jinja: j}, nil
+	}
+
 	tmpl := template.New("").Option("missingkey=zero").Funcs(funcs)
 
 	tmpl, err := tmpl.Parse(s)
@@ -145,6 +164,10 @@ func (t *Template) String() string {
 }
 
 func (t *Template) Vars() []string {
+	if t.jinja != nil {
+		return t.jinja.Vars()
+	}
+
 	var vars []string
 	for _, tt := range t.Templates() {
 		for _, n := range tt.Root.Nodes {
@@ -172,7 +195,13 @@ type Values struct {
 	forceLegacy bool
 }
 
+// Subtree returns a template of the first node for which fn returns true,
+// or nil if there's no such node or t is a Jinja template
 func (t *Template) Subtree(fn func(parse.Node) bool) *template.Template {
+	if t.jinja != nil {
+		return nil
+	}
+
 	var walk func(parse.Node) parse.Node
 	walk = func(n parse.Node) parse.Node {
 		if fn(n) {
@@ -219,6 +248,10 @@ func (t *Template) Subtree(fn func(parse.Node) bool) *template.Template {
 }
 
 func (t *Template) Execute(w io.Writer, v Values) error {
+	if t.jinja != nil {
+		return t.executeJinja(w, v)
+	}
+
 	system, messages := collate(v.Messages)
 	if v.Prompt != "" && v.Suffix != "" {
 		return t.Template.Execute(w, map[string]any{
@@ -297,6 +330,48 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 	return err
 }
 
+// executeJinja renders a Jinja template with the variables Hugging Face
+// passes to chat templates. Each message's tool calls have the OpenAI format
+// templates expect.
+func (t *Template) executeJinja(w io.Writer, v Values) error {
+	_, collated := collate(v.Messages)
+
+	type toolCall struct {
+		Type     string               `json:"type"`
+		Function api.ToolCallFunction `json:"function"`
+	}
+
+	type message struct {
+		Role      string     `json:"role"`
+		Content   string     `json:"content"`
+		ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	}
+
+	messages := make([]message, len(collated))
+	for i, m := range collated {
+		messages[i] = message{Role: m.Role, Content: m.Content}
+		for _, call := range m.ToolCalls {
+			messages[i].ToolCalls = append(messages[i].ToolCalls, toolCall{Type: "function", Function: call.Function})
+		}
+	}
+
+	// templates test for tools with "tools is not none"
+	var tools any
+	if len(v.Tools) > 0 {
+		tools = v.Tools
+	}
+
+	return t.jinja.Execute(w, map[string]any{
+		"messages": messages,
+		"tools":    tools,
+		// a prompt ending with an assistant message continues it
+		"add_generation_prompt": len(messages) == 0 || messages[len(messages)-1].Role != "assistant",
+		// the runner adds the BOS token
+		"bos_token": "",
+		"eos_token": "",
+	})
+}
+
 // collate messages based on role. consecutive messages of the same role are merged
 // into a single message. collate also collects and returns all system messages.
 // collate mutates message content adding image tags ([img-%d]) as needed
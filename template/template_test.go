@@ -10,6 +10,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"text/template/parse"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -376,3 +377,123 @@ func TestExecuteWithSuffix(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteJinja(t *testing.T) {
+	tmpl, err := Parse(JinjaPrefix + `{%- set eos_token = "</s>" %}
+{%- if tools %}[TOOLS]{{ tools | map(attribute='function.name') | join(',') }}[/TOOLS]{% endif %}
+{%- for message in messages %}
+    {%- if message.role == 'assistant' %}
+        {{- message.content }}
+        {%- for call in message.tool_calls %}{{ call.function | tojson }}{% endfor %}
+        {{- eos_token }}
+    {%- else %}
+        {{- '<' + message.role + '>' + message.content }}
+    {%- endif %}
+{%- endfor %}
+{%- if add_generation_prompt %}<assistant>{% endif %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vars := tmpl.Vars(); !slices.Contains(vars, "tools") || !slices.Contains(vars, "messages") {
+		t.Errorf("expected tools and messages in %v", vars)
+	}
+
+	if tmpl.Subtree(func(parse.Node) bool { return true }) != nil {
+		t.Error("expected no subtree")
+	}
+
+	if issues := tmpl.Lint(); len(issues) > 0 {
+		t.Errorf("unexpected issues %v", issues)
+	}
+
+	var weather api.Tool
+	weather.Type = "function"
+	weather.Function.Name = "get_current_weather"
+
+	cases := []struct {
+		name   string
+		values Values
+		expect string
+	}{
+		{
+			"messages",
+			Values{Messages: []api.Message{
+				{Role: "system", Content: "You are a helpful assistant."},
+				{Role: "user", Content: "Hello!"},
+				{Role: "user", Content: "How are you?"},
+			}},
+			"<system>You are a helpful assistant.<user>Hello!\n\nHow are you?<assistant>",
+		},
+		{
+			"tool calls",
+			Values{
+				Messages: []api.Message{
+					{Role: "user", Content: "What's the weather?"},
+					{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{
+						Name:      "get_current_weather",
+						Arguments: api.ToolCallFunctionArguments{"location": "Paris"},
+					}}}},
+					{Role: "tool", Content: "22°C"},
+				},
+				Tools: api.Tools{weather},
+			},
+			`[TOOLS]get_current_weather[/TOOLS]<user>What's the weather?{"name": "get_current_weather", "arguments": {"location": "Paris"}}</s><tool>22°C<assistant>`,
+		},
+		{
+			"continue",
+			Values{Messages: []api.Message{
+				{Role: "user", Content: "Hello!"},
+				{Role: "assistant", Content: "Hi"},
+			}},
+			"<user>Hello!Hi</s>",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, tt.values); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(b.String(), tt.expect); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecuteJinjaChatTemplates(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "templates.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ss map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		for k, v := range ss {
+			t.Run(k, func(t *testing.T) {
+				tmpl, err := Parse(JinjaPrefix + v)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var b bytes.Buffer
+				if err := tmpl.Execute(&b, Values{Messages: []api.Message{{Role: "user", Content: "Hello, how are you?"}}}); err != nil {
+					t.Fatal(err)
+				}
+
+				if !strings.Contains(b.String(), "Hello, how are you?") {
+					t.Errorf("expected the message in %q", b.String())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template/jinja"
+)
+
+var weatherTool = func() api.Tool {
+	var tool api.Tool
+	if err := json.Unmarshal([]byte(`{
+		"type": "function",
+		"function": {
+			"name": "get_current_weather",
+			"description": "Get the current weather",
+			"parameters": {
+				"type": "object",
+				"required": ["location"],
+				"properties": {
+					"location": {"type": "string", "description": "The city, e.g. Paris"}
+				}
+			}
+		}
+	}`), &tool); err != nil {
+		panic(err)
+	}
+	return tool
+}()
+
+var weatherCall = api.ToolCall{
+	Function: api.ToolCallFunction{
+		Name:      "get_current_weather",
+		Arguments: api.ToolCallFunctionArguments{"location": "Paris"},
+	},
+}
+
+// corpus is the conversations a translated template must render like the
+// template it's translated from
+var corpus = []struct {
+	name string
+	Values
+}{
+	{"a user message", Values{Messages: []api.Message{
+		{Role: "user", Content: "Hello!"},
+	}}},
+	{"a system message", Values{Messages: []api.Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "Hello!"},
+	}}},
+	{"several turns", Values{Messages: []api.Message{
+		{Role: "user", Content: "Hello!"},
+		{Role: "assistant", Content: "Hi! How can I help?"},
+		{Role: "user", Content: "What's the weather like?"},
+	}}},
+	{"several turns with a system message", Values{Messages: []api.Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "Hello!"},
+		{Role: "assistant", Content: "Hi! How can I help?"},
+		{Role: "user", Content: "What's the weather like?"},
+	}}},
+	{"tools", Values{Tools: []api.Tool{weatherTool}, Messages: []api.Message{
+		{Role: "user", Content: "What's the weather like in Paris?"},
+	}}},
+	{"a tool call", Values{Tools: []api.Tool{weatherTool}, Messages: []api.Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "What's the weather like in Paris?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{weatherCall}},
+		{Role: "tool", Content: "22 degrees and sunny"},
+	}}},
+	{"a tool result", Values{Tools: []api.Tool{weatherTool}, Messages: []api.Message{
+		{Role: "user", Content: "What's the weather like in Paris?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{weatherCall}},
+		{Role: "tool", Content: "22 degrees and sunny"},
+		{Role: "assistant", Content: "It's 22 degrees and sunny in Paris."},
+		{Role: "user", Content: "Thanks!"},
+	}}},
+}
+
+// DivergenceError is returned by Translate when the translated template
+// renders conversations differently from the template it's translated from
+type DivergenceError struct {
+	// Conversations names the conversations rendered differently
+	Conversations []string
+
+	err error
+}
+
+func (e *DivergenceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *DivergenceError) Unwrap() error {
+	return e.err
+}
+
+// Translate translates a Jinja chat template into a Go template. The
+// translation is verified by rendering a corpus of conversations with
+// both templates, and a [*DivergenceError] is returned describing any
+// conversations they render differently.
+func Translate(s string) (*Template, error) {
+	j, err := jinja.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := j.GoTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	want := Template{Template: t.Template, raw: JinjaPrefix + s, jinja: j}
+
+	var errs []error
+	var names []string
+	var rendered int
+	for _, c := range corpus {
+		var b strings.Builder
+		if err := want.Execute(&b, c.Values); err != nil {
+			// the template doesn't support this conversation
+			continue
+		}
+		rendered++
+
+		var sb strings.Builder
+		if err := t.Execute(&sb, c.Values); err != nil {
+			errs = append(errs, fmt.Errorf("translated template can't render %s: %w", c.name, err))
+			names = append(names, c.name)
+		} else if sb.String() != b.String() {
+			errs = append(errs, fmt.Errorf("translated template renders %s as %q instead of %q", c.name, sb.String(), b.String()))
+			names = append(names, c.name)
+		}
+	}
+
+	if rendered == 0 {
+		return nil, errors.New("template can't render any conversation")
+	}
+
+	if len(errs) > 0 {
+		return nil, &DivergenceError{Conversations: names, err: errors.Join(errs...)}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,94 @@
+package template
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tmpl, err := Translate("{% for message in messages %}<|{{ message['role'] }}|>\n{{ message['content'] | trim }}<|end|>\n{% endfor %}{% if add_generation_prompt %}<|assistant|>\n{% endif %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasPrefix(tmpl.String(), JinjaPrefix) {
+		t.Errorf("expected a Go template, got %q", tmpl.String())
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, corpus[2].Values); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<|user|>\nHello!<|end|>\n<|assistant|>\nHi! How can I help?<|end|>\n<|user|>\nWhat's the weather like?<|end|>\n<|assistant|>\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"untranslatable", "{% for message in messages %}{{ message['role'] | upper }}{% endfor %}", "can't translate filter upper"},
+		// a message's tool calls are OpenAI's, with a type
+		{"divergent", "{% for message in messages %}{% if message.tool_calls %}{{ message.tool_calls | tojson }}{% endif %}{% endfor %}", "translated template renders a tool call as"},
+		{"rejected", "{{ raise_exception('no') }}", "can't render any conversation"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Translate(tt.template); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	_, err := Translate(cases[1].template)
+
+	var divergence *DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("expected a divergence error, got %v", err)
+	}
+
+	if want := []string{"a tool call", "a tool result"}; !slices.Equal(divergence.Conversations, want) {
+		t.Errorf("got conversations %v, want %v", divergence.Conversations, want)
+	}
+}
+
+func TestTranslateChatTemplates(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "templates.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var translated int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ss map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range ss {
+			// templates either translate to an equivalent Go template or
+			// fail cleanly
+			if _, err := Translate(v); err == nil {
+				translated++
+			}
+		}
+	}
+
+	if translated == 0 {
+		t.Error("expected chat templates to translate")
+	}
+}
@@ -0,0 +1,434 @@
+// Package tracing records spans of work and exports them to an
+// OpenTelemetry collector with OTLP over HTTP, encoded as JSON.
+//
+// Tracing is disabled until [Configure] is called with an endpoint. While
+// it's disabled, [Start] returns a nil *Span, and the methods of a nil
+// *Span do nothing:
+//
+//	ctx, span := tracing.Start(ctx, "scheduler.load", "model", name)
+//	defer span.End()
+//
+// The trace is propagated between processes in the W3C traceparent header
+// with [Inject] and [Extract].
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize is the number of ended spans that triggers an export
+	batchSize = 512
+
+	// maxQueued is the number of ended spans kept while exports fail;
+	// spans past it are dropped
+	maxQueued = 4 * batchSize
+
+	// exportInterval is how often ended spans are exported
+	exportInterval = 2 * time.Second
+)
+
+var current atomic.Pointer[exporter]
+
+// Configure starts exporting spans to endpoint, the full URL of an OTLP
+// traces receiver such as http://localhost:4318/v1/traces, as service. An
+// empty endpoint disables tracing. The returned function exports any
+// remaining spans and stops exporting.
+func Configure(endpoint, service string) (shutdown func(context.Context) error) {
+	if endpoint == "" {
+		current.Store(nil)
+		return func(context.Context) error { return nil }
+	}
+
+	e := &exporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	current.Store(e)
+	go e.run()
+
+	return func(ctx context.Context) error {
+		current.CompareAndSwap(e, nil)
+		close(e.done)
+		return e.export(ctx)
+	}
+}
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type contextKey struct{}
+
+func fromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(spanContext)
+	return sc, ok
+}
+
+// Span is an operation within a trace. A nil *Span is valid and records
+// nothing.
+type Span struct {
+	exporter *exporter
+	spanContext
+	parentID [8]byte
+
+	name  string
+	start time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []any
+	err   error
+}
+
+// Start starts a span named name as a child of the span in ctx, if any,
+// with attributes given as alternating keys and values like slog. The
+// returned context carries the new span.
+func Start(ctx context.Context, name string, args ...any) (context.Context, *Span) {
+	e := current.Load()
+	if e == nil {
+		return ctx, nil
+	}
+
+	return e.start(ctx, name, time.Now(), args)
+}
+
+// Record records a span named name that has already finished
+func Record(ctx context.Context, name string, start, end time.Time, args ...any) {
+	if e := current.Load(); e != nil {
+		_, span := e.start(ctx, name, start, args)
+		span.EndAt(end)
+	}
+}
+
+func (e *exporter) start(ctx context.Context, name string, start time.Time, args []any) (context.Context, *Span) {
+	span := &Span{
+		exporter: e,
+		name:     name,
+		start:    start,
+		attrs:    args,
+	}
+
+	if parent, ok := fromContext(ctx); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, contextKey{}, span.spanContext), span
+}
+
+// SetAttributes adds attributes given as alternating keys and values.
+func (s *Span) SetAttributes(args ...any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, args...)
+}
+
+// SetError marks the span as failed with err, if it isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End ends the span. Calls after the first do nothing.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at t. Calls after the first do nothing.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = t
+	s.mu.Unlock()
+
+	s.exporter.add(s)
+}
+
+// Inject sets the traceparent header of h to the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := fromContext(ctx); ok {
+		h.Set("traceparent", fmt.Sprintf("00-%x-%x-01", sc.traceID, sc.spanID))
+	}
+}
+
+// Extract returns a context carrying the span in the traceparent header of
+// h, so spans started from it continue the caller's trace. ctx is returned
+// unchanged if the header is missing or invalid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(h.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ctx
+	}
+
+	var sc spanContext
+	if len(parts[1]) != hex.EncodedLen(len(sc.traceID)) || len(parts[2]) != hex.EncodedLen(len(sc.spanID)) {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return ctx
+	}
+
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+type exporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+
+	flush chan struct{}
+	done  chan struct{}
+}
+
+func (e *exporter) add(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.spans) >= maxQueued {
+		return
+	}
+
+	e.spans = append(e.spans, s)
+	if len(e.spans) >= batchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.client.Timeout)
+		if err := e.export(ctx); err != nil {
+			slog.Debug("failed to export spans", "endpoint", e.endpoint, "error", err)
+		}
+		cancel()
+	}
+}
+
+// export sends queued spans to the collector. Spans are put back in the
+// queue if the collector can't be reached so they're retried.
+func (e *exporter) export(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.mu.Lock()
+		e.spans = append(spans, e.spans...)[:min(len(spans)+len(e.spans), maxQueued)]
+		e.mu.Unlock()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// exportRequest is an ExportTraceServiceRequest in the JSON encoding of
+// OTLP, where IDs are hex and 64 bit integers are strings
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (e *exporter) request(spans []*Span) exportRequest {
+	var rs resourceSpans
+	rs.Resource.Attributes = attributes([]any{"service.name", e.service})
+
+	var ss scopeSpans
+	ss.Scope.Name = "github.com/ollama/ollama"
+	for _, s := range spans {
+		s.mu.Lock()
+		out := span{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			out.Status = &status{Code: statusCodeError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+
+		ss.Spans = append(ss.Spans, out)
+	}
+
+	rs.ScopeSpans = []scopeSpans{ss}
+	return exportRequest{ResourceSpans: []resourceSpans{rs}}
+}
+
+// attributes converts alternating keys and values to OTLP attributes.
+// Values that aren't strings, booleans or numbers are formatted with fmt.
+func attributes(args []any) []keyValue {
+	var kvs []keyValue
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+
+		var v anyValue
+		switch a := args[i+1].(type) {
+		case string:
+			v.StringValue = &a
+		case bool:
+			v.BoolValue = &a
+		case int:
+			v.IntValue = ptr(strconv.FormatInt(int64(a), 10))
+		case int32:
+			v.IntValue = ptr(strconv.FormatInt(int64(a), 10))
+		case int64:
+			v.IntValue = ptr(strconv.FormatInt(a, 10))
+		case uint64:
+			v.IntValue = ptr(strconv.FormatUint(a, 10))
+		case float32:
+			v.DoubleValue = ptr(float64(a))
+		case float64:
+			v.DoubleValue = &a
+		case time.Duration:
+			v.DoubleValue = ptr(a.Seconds())
+		default:
+			v.StringValue = ptr(fmt.Sprint(a))
+		}
+
+		kvs = append(kvs, keyValue{Key: key, Value: v})
+	}
+
+	return kvs
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
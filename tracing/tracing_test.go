@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an in-process OTLP receiver that keeps the spans it's sent
+type collector struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	spans []span
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func (c *collector) byName() map[string]span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := make(map[string]span)
+	for _, s := range c.spans {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	shutdown := Configure(c.URL+"/v1/traces", "ollama-test")
+
+	ctx, parent := Start(context.Background(), "parent", "model", "llama3.2", "num_ctx", 8192)
+	_, child := Start(ctx, "child")
+	child.SetError(errors.New("out of memory"))
+	child.End()
+
+	start := time.Unix(1, 0)
+	Record(ctx, "recorded", start, start.Add(time.Second), "tokens", int32(3))
+	parent.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := c.byName()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %v", spans)
+	}
+
+	p, ch, r := spans["parent"], spans["child"], spans["recorded"]
+	if p.ParentSpanID != "" {
+		t.Errorf("expected parent to be a root span, got parent %s", p.ParentSpanID)
+	}
+	if ch.TraceID != p.TraceID || ch.ParentSpanID != p.SpanID {
+		t.Errorf("child isn't in parent's trace: %+v %+v", p, ch)
+	}
+	if r.ParentSpanID != p.SpanID {
+		t.Errorf("recorded span isn't a child of parent: %+v", r)
+	}
+
+	if ch.Status == nil || ch.Status.Code != statusCodeError || ch.Status.Message != "out of memory" {
+		t.Errorf("unexpected status %+v", ch.Status)
+	}
+
+	if r.StartTimeUnixNano != "1000000000" || r.EndTimeUnixNano != "2000000000" {
+		t.Errorf("unexpected times %s %s", r.StartTimeUnixNano, r.EndTimeUnixNano)
+	}
+
+	if len(p.Attributes) != 2 || *p.Attributes[0].Value.StringValue != "llama3.2" || *p.Attributes[1].Value.IntValue != "8192" {
+		t.Errorf("unexpected attributes %+v", p.Attributes)
+	}
+
+	// spans started after shutdown aren't recorded
+	if _, s := Start(context.Background(), "after"); s != nil {
+		t.Error("expected a nil span after shutdown")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	c := newCollector(t)
+	shutdown := Configure(c.URL+"/v1/traces", "ollama-test")
+
+	ctx, client := Start(context.Background(), "client")
+
+	h := make(http.Header)
+	Inject(ctx, h)
+
+	_, server := Start(Extract(context.Background(), h), "server")
+	server.End()
+	client.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := c.byName()
+	if spans["server"].TraceID != spans["client"].TraceID || spans["server"].ParentSpanID != spans["client"].SpanID {
+		t.Errorf("server span isn't a child of client span: %+v", spans)
+	}
+}
+
+func TestExtractInvalid(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c0000-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01",
+	} {
+		h := http.Header{"Traceparent": {traceparent}}
+		if _, ok := fromContext(Extract(context.Background(), h)); ok {
+			t.Errorf("%q: expected no span context", traceparent)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	Configure("", "ollama-test")
+
+	ctx, span := Start(context.Background(), "disabled")
+	if span != nil {
+		t.Fatal("expected a nil span")
+	}
+
+	// methods of a nil span do nothing
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+
+	h := make(http.Header)
+	Inject(ctx, h)
+	if h.Get("traceparent") != "" {
+		t.Errorf("unexpected traceparent %q", h.Get("traceparent"))
+	}
+}
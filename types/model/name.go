@@ -136,6 +136,27 @@ func ParseName(s string) Name {
 	return Merge(ParseNameBare(s), DefaultName())
 }
 
+// Aliases maps alias names to the names of the models they refer to.
+type Aliases interface {
+	// Lookup returns the name n refers to and true if n is an alias,
+	// otherwise it returns the zero Name and false.
+	Lookup(n Name) (Name, bool)
+}
+
+// ParseNameAliased is like [ParseName], but if the parsed name is an alias
+// in a, the name the alias refers to is returned instead. Aliases are not
+// resolved recursively, and a nil a resolves no aliases.
+func ParseNameAliased(s string, a Aliases) Name {
+	n := ParseName(s)
+	if a == nil || !n.IsValid() {
+		return n
+	}
+	if target, ok := a.Lookup(n); ok {
+		return target
+	}
+	return n
+}
+
 // ParseNameBare parses s as a name string and returns a Name. No merge with
 // [DefaultName] is performed.
 func ParseNameBare(s string) Name {
@@ -356,3 +356,40 @@ func TestIsValidNamespace(t *testing.T) {
 		})
 	}
 }
+
+type testAliases map[Name]Name
+
+func (a testAliases) Lookup(n Name) (Name, bool) {
+	target, ok := a[n]
+	return target, ok
+}
+
+func TestParseNameAliased(t *testing.T) {
+	aliases := testAliases{
+		ParseName("prod-chat"): ParseName("llama3.1:70b-q4"),
+		ParseName("a"):         ParseName("b"),
+		ParseName("b"):         ParseName("c"),
+	}
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"prod-chat", "registry.ollama.ai/library/llama3.1:70b-q4"},
+		{"prod-chat:latest", "registry.ollama.ai/library/llama3.1:70b-q4"},
+		{"llama3.1:70b-q4", "registry.ollama.ai/library/llama3.1:70b-q4"},
+		{"mistral", "registry.ollama.ai/library/mistral:latest"},
+		{"a", "registry.ollama.ai/library/b:latest"}, // not recursive
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := ParseNameAliased(tt.in, aliases).String(); got != tt.want {
+				t.Errorf("ParseNameAliased(%q) = %q; want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := ParseNameAliased("prod-chat", nil), ParseName("prod-chat"); got != want {
+		t.Errorf("ParseNameAliased(nil) = %v; want %v", got, want)
+	}
+}